@@ -8,7 +8,6 @@ import (
 	"github.com/webkimru/go-yandex-metrics/internal/app/agent/metrics"
 	pb "github.com/webkimru/go-yandex-metrics/internal/proto"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 	"log"
 	"net/http"
 	_ "net/http/pprof" // подключаем пакет pprof
@@ -26,6 +25,9 @@ var (
 
 var m metrics.Metric
 
+// r реестр метрик дополнительных коллекторов
+var r = metrics.NewRegistry()
+
 func main() {
 	fmt.Println("Build version:", buildVersion)
 	fmt.Println("Build date:", buildDate)
@@ -61,6 +63,7 @@ func main() {
 	}()
 
 	// настраиваем/инициализируем приложение
+	agent.Version = buildVersion
 	serverProtocol, rateLimit, err := agent.Setup()
 	if err != nil {
 		log.Fatal(err)
@@ -70,7 +73,7 @@ func main() {
 	var clientGRPC pb.MetricsClient
 	if serverProtocol == agent.GRPC {
 		// устанавливаем соединение с сервером GRPC
-		conn, err := grpc.NewClient("localhost:3200", grpc.WithTransportCredentials(insecure.NewCredentials()))
+		conn, err := grpc.NewClient(agent.GRPCAddress(), grpc.WithTransportCredentials(agent.GRPCCredentials()))
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -88,9 +91,13 @@ func main() {
 	wg.Add(1)
 	go agent.GetExtraMetrics(ctx, &wg, &m)
 
+	// получаем метрики дополнительных коллекторов
+	wg.Add(1)
+	go agent.GetCollectorMetrics(ctx, &wg, r)
+
 	// добавляем метрики в новую задачу
 	wg.Add(1)
-	go agent.AddMetricsToJob(ctx, &wg, &m, jobs)
+	go agent.AddMetricsToJob(ctx, &wg, &m, r, jobs)
 
 	// запускаем rateLimit воркеров для наших задач
 	for w := 1; w <= rateLimit; w++ {
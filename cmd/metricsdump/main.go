@@ -0,0 +1,119 @@
+// metricsdump выгружает метрики из хранилища сервера в NDJSON или CSV и загружает их обратно
+// в любое хранилище: PostgreSQL, хранилище в файлах с журналом или файл снимка хранилища в памяти.
+// Метрики переносятся вместе с тенантами.
+//
+//	metricsdump export -f /tmp/metrics-db.json -format ndjson -o metrics.ndjson
+//	metricsdump import -d postgres://... -format ndjson -i metrics.ndjson -counters overwrite
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/dump"
+)
+
+const usage = `usage: metricsdump export|import [flags]
+
+Storage is selected like in the server: -d, then -wal-dir, then -f.
+Run "metricsdump export -h" or "metricsdump import -h" for flags.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		log.Fatal(usage)
+	}
+	if err := run(context.Background(), os.Args[1], os.Args[2:]); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(ctx context.Context, command string, args []string) error {
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	var backend dump.Backend
+	flags.StringVar(&backend.DatabaseDSN, "d", "", "database dsn")
+	flags.StringVar(&backend.WALDir, "wal-dir", "", "file store directory with write-ahead log and snapshots")
+	flags.StringVar(&backend.File, "f", "", "in-memory store snapshot file")
+	format := flags.String("format", dump.FormatNDJSON, "format: ndjson, csv")
+
+	switch command {
+	case "export":
+		output := flags.String("o", "", "output file, stdout by default")
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+		return export(ctx, backend, *format, *output)
+
+	case "import":
+		input := flags.String("i", "", "input file, stdin by default")
+		counters := flags.String("counters", dump.CountersAdd, "counters and histograms: add to stored values or overwrite them")
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+		return load(ctx, backend, *format, *input, *counters)
+	}
+
+	return fmt.Errorf("unknown command %q\n%s", command, usage)
+}
+
+func export(ctx context.Context, backend dump.Backend, format, output string) (err error) {
+	src, err := dump.Open(ctx, backend, true)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	var w io.Writer = os.Stdout
+	if output != "" {
+		f, cerr := os.Create(output)
+		if cerr != nil {
+			return cerr
+		}
+		defer func() {
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}()
+		w = f
+	}
+
+	n, err := dump.Export(ctx, src, w, format)
+	if err != nil {
+		return err
+	}
+	log.Printf("exported %d metrics", n)
+
+	return nil
+}
+
+func load(ctx context.Context, backend dump.Backend, format, input, counters string) (err error) {
+	var r io.Reader = os.Stdin
+	if input != "" {
+		f, err := os.Open(input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	dst, err := dump.Open(ctx, backend, false)
+	if err != nil {
+		return err
+	}
+	// закрытие сохраняет загруженные метрики, поэтому его ошибка - ошибка загрузки
+	defer func() {
+		if cerr := dst.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	n, err := dump.Import(ctx, dst, r, format, counters)
+	log.Printf("imported %d metrics", n)
+
+	return err
+}
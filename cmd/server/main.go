@@ -31,6 +31,14 @@ func main() {
 	fmt.Println("Build date:", buildDate)
 	fmt.Println("Build commit:", buildCommit)
 
+	// подкоманда migrate меняет версию схемы PostgreSQL без запуска сервера
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := server.Migrate(context.Background(), os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	// при штатном завершении сервера все накопленные данные должны сохраняться
 	c := make(chan os.Signal, 1)
@@ -49,22 +57,23 @@ func main() {
 		ReadTimeout:       1 * time.Second,
 		ReadHeaderTimeout: 500 * time.Millisecond,
 		WriteTimeout:      500 * time.Millisecond,
+		TLSConfig:         server.TLSConfig(),
 	}
 	// gRPC Server
 	var gRPC *grpc.Server
 	go func() {
-		// определяем порт для сервера
-		listen, err := net.Listen("tcp", ":3200")
+		// определяем адрес для сервера
+		listen, err := net.Listen("tcp", server.GRPCAddress())
 		if err != nil {
 			log.Fatal(err)
 		}
 		// создаём gRPC-сервер без зарегистрированной службы
-		gRPC = grpc.NewServer()
+		gRPC = grpc.NewServer(mygrpc.ServerOptions()...)
 		// регистрируем сервис
 		pb.RegisterMetricsServer(gRPC, mygrpc.Repo)
 		reflection.Register(gRPC)
 		// получаем запросы gRPC
-		fmt.Println("Starting gRPC server on port 3200")
+		fmt.Println("Starting gRPC server on", server.GRPCAddress())
 		if err = gRPC.Serve(listen); err != nil {
 			log.Fatal(err)
 		}
@@ -86,7 +95,13 @@ func main() {
 
 	// стартуем сервер
 	logger.Log.Infof("Starting metric server on %s", *serverAddress)
-	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	if srv.TLSConfig != nil {
+		// сертификат берется из TLSConfig и перечитывается без перезапуска
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
 		logger.Log.Fatal(err)
 	}
 
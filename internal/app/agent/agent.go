@@ -3,30 +3,47 @@ package agent
 import (
 	"bytes"
 	"context"
-	"crypto/hmac"
 	randcrypto "crypto/rand"
 	"crypto/rsa"
-	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/mailru/easyjson"
 	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/mem"
+	"github.com/webkimru/go-yandex-metrics/internal/app/agent/collector"
 	"github.com/webkimru/go-yandex-metrics/internal/app/agent/config"
 	"github.com/webkimru/go-yandex-metrics/internal/app/agent/logger"
 	"github.com/webkimru/go-yandex-metrics/internal/app/agent/metrics"
 	pb "github.com/webkimru/go-yandex-metrics/internal/proto"
+	"github.com/webkimru/go-yandex-metrics/internal/security"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"io"
 	"math/rand"
 	"net/http"
 	"reflect"
 	"runtime"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
 var app config.AppConfig
 
+// Version версия агента, сообщаемая серверу.
+var Version = "N/A"
+
+// collectors дополнительные источники метрик, включенные в конфигурации
+var collectors []collector.Collector
+
+// transport HTTP-транспорт с настройками TLS, nil - транспорт по умолчанию.
+var transport http.RoundTripper
+
 func GetMetrics(ctx context.Context, wg *sync.WaitGroup, m *metrics.Metric) {
 	defer wg.Done()
 
@@ -41,6 +58,14 @@ func GetMetrics(ctx context.Context, wg *sync.WaitGroup, m *metrics.Metric) {
 			return
 		// ждем таймер
 		case <-ticker.C:
+			m.RandomValue = metrics.Gauge(rand.Float64())
+			m.PollCount++
+
+			// метрики рантайма собирает коллектор runtime/metrics без остановки мира
+			if app.Runtime.Enabled {
+				continue
+			}
+
 			runtime.ReadMemStats(&rt)
 			m.Alloc = metrics.Gauge(rt.Alloc)
 			m.BuckHashSys = metrics.Gauge(rt.BuckHashSys)
@@ -69,9 +94,6 @@ func GetMetrics(ctx context.Context, wg *sync.WaitGroup, m *metrics.Metric) {
 			m.StackSys = metrics.Gauge(rt.StackSys)
 			m.Sys = metrics.Gauge(rt.Sys)
 			m.TotalAlloc = metrics.Gauge(rt.TotalAlloc)
-
-			m.RandomValue = metrics.Gauge(rand.Float64())
-			m.PollCount++
 		}
 	}
 }
@@ -104,6 +126,32 @@ func GetExtraMetrics(ctx context.Context, wg *sync.WaitGroup, m *metrics.Metric)
 	}
 }
 
+// GetCollectorMetrics опрашивает дополнительные коллекторы и складывает метрики в реестр.
+func GetCollectorMetrics(ctx context.Context, wg *sync.WaitGroup, registry *metrics.Registry) {
+	defer wg.Done()
+
+	// будем собирать метрики каждые PollInterval секунд
+	ticker := time.NewTicker(time.Duration(app.PollInterval) * time.Second)
+
+	for {
+		select {
+		// ждем отмены контекста из main и выходим из функции
+		case <-ctx.Done():
+			return
+		// ждем таймер
+		case <-ticker.C:
+			for _, c := range collectors {
+				res, err := c.Collect(ctx)
+				if err != nil {
+					logger.Log.Errorf("failed to collect %s metrics: %v", c.Name(), err)
+				}
+				// частичный результат тоже сохраняем
+				registry.Update(res)
+			}
+		}
+	}
+}
+
 // Result структура, в которую добавили ошибку
 type Result struct {
 	Err error
@@ -123,11 +171,7 @@ func Worker(ctx context.Context, wg *sync.WaitGroup, jobs <-chan []metrics.Reque
 			return
 		// или читаем задачи
 		case job := <-jobs:
-			if app.ServerProtocol == GRPC {
-				err = SendThroughGRPC(ctx, job, clientGRPC)
-			} else {
-				err = Send(ctx, fmt.Sprintf("http://%s/updates/", app.ServerAddress), job)
-			}
+			err = SendJob(ctx, job, clientGRPC)
 			if err != nil {
 				result := Result{
 					Err: err,
@@ -138,7 +182,46 @@ func Worker(ctx context.Context, wg *sync.WaitGroup, jobs <-chan []metrics.Reque
 	}
 }
 
-func AddMetricsToJob(ctx context.Context, wg *sync.WaitGroup, metric *metrics.Metric, jobs chan []metrics.RequestMetric) {
+// backoff интервалы между повторными отправками батча: 1s, 3s, 5s.
+var backoff = []time.Duration{1 * time.Second, 3 * time.Second, 5 * time.Second}
+
+// ErrRetriable ошибка отправки, после которой батч можно отправить повторно.
+var ErrRetriable = errors.New("retriable error")
+
+// SendJob отправляет батч по настроенному протоколу и повторяет отправку при сетевых ошибках.
+// Все попытки идут с одним идентификатором батча, поэтому сервер не применит батч дважды,
+// если потерялся только ответ.
+func SendJob(ctx context.Context, job []metrics.RequestMetric, clientGRPC pb.MetricsClient) error {
+	batchID := NewBatchID()
+
+	for attempt := 0; ; attempt++ {
+		var err error
+		if app.ServerProtocol == GRPC {
+			err = SendThroughGRPC(ctx, job, batchID, clientGRPC)
+		} else {
+			err = Send(ctx, UpdatesURL(), job, batchID)
+		}
+		if err == nil || !errors.Is(err, ErrRetriable) || attempt == len(backoff) {
+			return err
+		}
+
+		logger.Log.Infof("failed to send batch=%s: %v, retrying in %s", batchID, err, backoff[attempt])
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff[attempt]):
+		}
+	}
+}
+
+// NewBatchID возвращает случайный идентификатор батча.
+func NewBatchID() string {
+	b := make([]byte, 16)
+	_, _ = randcrypto.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func AddMetricsToJob(ctx context.Context, wg *sync.WaitGroup, metric *metrics.Metric, registry *metrics.Registry, jobs chan []metrics.RequestMetric) {
 	defer wg.Done()
 
 	// будем добавлять задачи с метриками каждые app.ReportInterval секунд = отравка с данным интервалом
@@ -159,6 +242,10 @@ func AddMetricsToJob(ctx context.Context, wg *sync.WaitGroup, metric *metrics.Me
 			val := reflect.ValueOf(metric)
 			val = val.Elem()
 			for fieldIndex := 0; fieldIndex < val.NumField(); fieldIndex++ {
+				// поля runtime.MemStats не отправляем, если их заменяет коллектор runtime/metrics
+				if app.Runtime.Enabled && isMemStatsField(val.Type().Field(fieldIndex).Name) {
+					continue
+				}
 				field := val.Field(fieldIndex)
 				f := val.FieldByName(val.Type().Field(fieldIndex).Name)
 
@@ -178,13 +265,43 @@ func AddMetricsToJob(ctx context.Context, wg *sync.WaitGroup, metric *metrics.Me
 					})
 				}
 			}
+			// добавляем метрики дополнительных коллекторов
+			metricSlice = append(metricSlice, registry.Flush()...)
 			// пишем новую задачу в виде слайса метрик
 			jobs <- metricSlice
 		}
 	}
 }
 
-func Send(ctx context.Context, url string, request metrics.RequestMetricSlice) error {
+// isMemStatsField сообщает, скопировано ли поле метрики из runtime.MemStats.
+func isMemStatsField(name string) bool {
+	_, ok := reflect.TypeOf(runtime.MemStats{}).FieldByName(name)
+	return ok
+}
+
+// UpdatesURL возвращает адрес отправки батчей метрик: https, если соединение с сервером по TLS.
+func UpdatesURL() string {
+	scheme := "http"
+	if app.ClientTLS != nil {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s/updates/", scheme, app.ServerAddress)
+}
+
+// GRPCAddress возвращает адрес gRPC-сервера.
+func GRPCAddress() string {
+	return app.GRPCAddress
+}
+
+// GRPCCredentials возвращает настройки транспорта gRPC: TLS, если он настроен, иначе без шифрования.
+func GRPCCredentials() credentials.TransportCredentials {
+	if app.ClientTLS != nil {
+		return credentials.NewTLS(app.ClientTLS)
+	}
+	return insecure.NewCredentials()
+}
+
+func Send(ctx context.Context, url string, request metrics.RequestMetricSlice, batchID string) error {
 	data, err := easyjson.Marshal(request)
 	if err != nil {
 		return fmt.Errorf("failed to marshal request=%v, err=%w", request, err)
@@ -211,31 +328,57 @@ func Send(ctx context.Context, url string, request metrics.RequestMetricSlice) e
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Content-Encoding", "gzip")
 	req.Header.Set("X-Real-IP", app.RealIP)
-	// Encrypt data
+	req.Header.Set("X-Agent-ID", app.AgentID)
+	req.Header.Set("X-Agent-Version", Version)
+	req.Header.Set("X-Report-Interval", strconv.Itoa(app.ReportInterval))
+	if batchID != "" {
+		req.Header.Set("X-Batch-ID", batchID)
+	}
+	if app.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+app.Token)
+	}
+	if app.Tenant != "" {
+		req.Header.Set("X-Tenant-ID", app.Tenant)
+	}
+	// подписываем запрос алгоритмом HMAC, используя SHA-256; время и nonce защищают от повтора запроса
 	if app.SecretKey != "" {
-		// подписываем алгоритмом HMAC, используя SHA-256
-		h := hmac.New(sha256.New, []byte(app.SecretKey))
-		h.Write(data)
-		sign := h.Sum(nil)
-		req.Header.Set("HashSHA256", hex.EncodeToString(sign))
+		nonce, err := security.NewNonce()
+		if err != nil {
+			return fmt.Errorf("failed NewNonce()=%w", err)
+		}
+		timestamp := security.Timestamp(time.Now())
+		req.Header.Set(security.HeaderTimestamp, timestamp)
+		req.Header.Set(security.HeaderNonce, nonce)
+		if app.KeyID != "" {
+			req.Header.Set(security.HeaderKeyID, app.KeyID)
+		}
+		req.Header.Set(security.HeaderSignature, security.SignRequest([]byte(app.SecretKey), req.Method, req.URL.RequestURI(), data, timestamp, nonce))
 	}
 
-	client := &http.Client{}
+	client := &http.Client{Transport: transport}
 	resp, err := client.Do(req)
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %w", ErrRetriable, err)
 	}
 
-	if resp.StatusCode != http.StatusOK {
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+	case resp.StatusCode == http.StatusMultiStatus, resp.StatusCode == http.StatusUnprocessableEntity:
+		// сервер отклонил часть метрик батча, причины по каждой метрике - в теле ответа
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("server rejected metrics, status code %d: %s", resp.StatusCode, body)
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return fmt.Errorf("%w: status code %d", ErrRetriable, resp.StatusCode)
+	default:
 		return fmt.Errorf("expected status code 200, but got %d", resp.StatusCode)
 	}
 
-	defer resp.Body.Close()
-
 	return nil
 }
 
-func SendThroughGRPC(ctx context.Context, requests []metrics.RequestMetric, c pb.MetricsClient) error {
+func SendThroughGRPC(ctx context.Context, requests []metrics.RequestMetric, batchID string, c pb.MetricsClient) error {
 	var protoMetricSlice []*pb.RequestMetricBatch_RequestMetric
 	for _, request := range requests {
 		protoMetricSlice = append(protoMetricSlice, &pb.RequestMetricBatch_RequestMetric{
@@ -246,13 +389,40 @@ func SendThroughGRPC(ctx context.Context, requests []metrics.RequestMetric, c pb
 		})
 	}
 
+	// сведения об агенте для учета на сервере
+	ctx = metadata.AppendToOutgoingContext(ctx,
+		"x-real-ip", app.RealIP,
+		"x-agent-id", app.AgentID,
+		"x-agent-version", Version,
+		"x-report-interval", strconv.Itoa(app.ReportInterval),
+	)
+	if app.Token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+app.Token)
+	}
+	if app.Tenant != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "x-tenant-id", app.Tenant)
+	}
 	resp, err := c.UpdateBatchMetrics(ctx, &pb.RequestMetricBatch{
 		RequestMetrics: protoMetricSlice,
+		BatchId:        batchID,
 	})
-	if err != nil {
+	switch status.Code(err) {
+	case codes.OK:
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return fmt.Errorf("%w: %w", ErrRetriable, err)
+	default:
 		return err
 	}
 	if resp.Error != "" {
+		var rejected []string
+		for _, item := range resp.Items {
+			if item.Error != "" {
+				rejected = append(rejected, fmt.Sprintf("%s(%s): %s", item.Id, item.Type, item.Error))
+			}
+		}
+		if len(rejected) > 0 {
+			return fmt.Errorf("%s: %s", resp.Error, strings.Join(rejected, ", "))
+		}
 		return errors.New(resp.Error)
 	}
 
@@ -263,7 +433,7 @@ func ShutdownJobs(ctx context.Context, jobs chan []metrics.RequestMetric) {
 	logger.Log.Infof("Sending %d metric jobs...", len(jobs))
 
 	for job := range jobs {
-		err := Send(ctx, fmt.Sprintf("http://%s/updates/", app.ServerAddress), job)
+		err := Send(ctx, UpdatesURL(), job, NewBatchID())
 		if err != nil {
 			logger.Log.Errorln(err)
 		}
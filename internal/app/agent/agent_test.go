@@ -5,15 +5,20 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/webkimru/go-yandex-metrics/internal/app/agent/config"
 	"github.com/webkimru/go-yandex-metrics/internal/app/agent/metrics"
 	grpc2 "github.com/webkimru/go-yandex-metrics/internal/app/server/grpc"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/tenant"
 	"github.com/webkimru/go-yandex-metrics/internal/proto"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/resolver"
 	"google.golang.org/grpc/test/bufconn"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -63,12 +68,34 @@ DmD/4fpSztLiYzwu2F8UV+qx875El3UrI4u0SRMPH2SETiGJ/CtVTYUCAwEAAQ==
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := Send(context.Background(), tt.url, tt.metric)
+			err := Send(context.Background(), tt.url, tt.metric, NewBatchID())
 			assert.Error(t, err)
 		})
 	}
 }
 
+func TestSendJobRetry(t *testing.T) {
+	app = config.AppConfig{}
+	var batchIDs []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		batchIDs = append(batchIDs, r.Header.Get("X-Batch-ID"))
+		// ответ на первую попытку теряется
+		if len(batchIDs) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+	app.ServerAddress = strings.TrimPrefix(srv.URL, "http://")
+
+	err := SendJob(context.Background(), []metrics.RequestMetric{{ID: "PollCount", MType: "counter", Delta: 1}}, nil)
+	require.NoError(t, err)
+	require.Len(t, batchIDs, 2)
+	assert.NotEmpty(t, batchIDs[0])
+	assert.Equal(t, batchIDs[0], batchIDs[1])
+}
+
 func TestAddMetricsToJob(t *testing.T) {
 	t.Run("case: ticker", func(t *testing.T) {
 		app.PollInterval = 1
@@ -78,7 +105,7 @@ func TestAddMetricsToJob(t *testing.T) {
 		ctx, cancel := context.WithCancel(context.Background())
 		jobs := make(chan []metrics.RequestMetric, 1)
 		wg.Add(1)
-		go AddMetricsToJob(ctx, &wg, &metric, jobs)
+		go AddMetricsToJob(ctx, &wg, &metric, metrics.NewRegistry(), jobs)
 
 		time.Sleep(3 * time.Second)
 		cancel()
@@ -92,7 +119,7 @@ func TestAddMetricsToJob(t *testing.T) {
 		ctx, cancel := context.WithCancel(context.Background())
 		jobs := make(chan []metrics.RequestMetric, 1)
 		wg.Add(1)
-		go AddMetricsToJob(ctx, &wg, &metric, jobs)
+		go AddMetricsToJob(ctx, &wg, &metric, metrics.NewRegistry(), jobs)
 
 		time.Sleep(2 * time.Second)
 		cancel()
@@ -100,6 +127,8 @@ func TestAddMetricsToJob(t *testing.T) {
 }
 
 func TestSendThroughGRPC(t *testing.T) {
+	app.AgentID = "agent-1"
+	app.RealIP = "10.0.0.1"
 	listen := bufconn.Listen(1024 * 1024)
 	defer listen.Close()
 	srv := grpc.NewServer()
@@ -128,10 +157,17 @@ func TestSendThroughGRPC(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err = SendThroughGRPC(tt.ctx, tt.requests, tt.c)
+			err = SendThroughGRPC(tt.ctx, tt.requests, NewBatchID(), tt.c)
 			assert.NoError(t, err)
 		})
 	}
+
+	// сервер учитывает агента по metadata
+	agents := grpc2.Repo.Agents.List(tenant.Default, time.Now())
+	require.Len(t, agents, 1)
+	assert.Equal(t, app.AgentID, agents[0].ID)
+	assert.Equal(t, Version, agents[0].Version)
+	assert.Equal(t, 1, agents[0].Metrics)
 }
 
 func TestWorker(t *testing.T) {
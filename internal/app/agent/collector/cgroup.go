@@ -0,0 +1,355 @@
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/webkimru/go-yandex-metrics/internal/app/agent/metrics"
+)
+
+// DefaultCgroupRoot стандартная точка монтирования cgroupfs.
+const DefaultCgroupRoot = "/sys/fs/cgroup"
+
+// CgroupVersion версия иерархии cgroup.
+type CgroupVersion int
+
+const (
+	CgroupV1 CgroupVersion = iota + 1
+	CgroupV2
+)
+
+// в cgroup v1 отсутствие лимита выражается огромным числом, кратным размеру страницы
+const cgroupV1Unlimited = 1 << 62
+
+// Cgroup собирает метрики контейнера из cgroupfs: cpu, memory, io и pids.
+type Cgroup struct {
+	root    string
+	version CgroupVersion
+	delta   *Delta
+}
+
+// NewCgroup конструктор типа Cgroup. Версия cgroup определяется автоматически по содержимому root.
+func NewCgroup(root string) (*Cgroup, error) {
+	if root == "" {
+		root = DefaultCgroupRoot
+	}
+	version, err := DetectCgroupVersion(root)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Cgroup{
+		root:    root,
+		version: version,
+		delta:   NewDelta(),
+	}, nil
+}
+
+// DetectCgroupVersion определяет версию cgroup: в v2 в корне есть файл cgroup.controllers,
+// в v1 корень содержит каталоги контроллеров.
+func DetectCgroupVersion(root string) (CgroupVersion, error) {
+	if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err == nil {
+		return CgroupV2, nil
+	}
+	for _, controller := range []string{"memory", "cpu", "cpuacct", "cpu,cpuacct", "pids", "blkio"} {
+		if info, err := os.Stat(filepath.Join(root, controller)); err == nil && info.IsDir() {
+			return CgroupV1, nil
+		}
+	}
+
+	return 0, fmt.Errorf("cgroup hierarchy is not found in %s", root)
+}
+
+// Name возвращает имя коллектора.
+func (c *Cgroup) Name() string {
+	return "cgroup"
+}
+
+// Version возвращает определенную версию cgroup.
+func (c *Cgroup) Version() CgroupVersion {
+	return c.version
+}
+
+// Collect снимает текущие показания cgroup.
+func (c *Cgroup) Collect(_ context.Context) ([]metrics.RequestMetric, error) {
+	if c.version == CgroupV2 {
+		return c.collectV2()
+	}
+
+	return c.collectV1()
+}
+
+func (c *Cgroup) collectV2() ([]metrics.RequestMetric, error) {
+	var res []metrics.RequestMetric
+	var errs []error
+
+	// cpu
+	stat, err := readKeyValues(filepath.Join(c.root, "cpu.stat"))
+	errs = append(errs, err)
+	for key, name := range map[string]string{
+		"usage_usec":     "CgroupCPUUsageUsec",
+		"nr_periods":     "CgroupCPUPeriods",
+		"nr_throttled":   "CgroupCPUThrottledPeriods",
+		"throttled_usec": "CgroupCPUThrottledUsec",
+	} {
+		if v, ok := stat[key]; ok {
+			res = append(res, c.delta.Counter(name, v))
+		}
+	}
+
+	// memory
+	if v, ok, err := readUint(filepath.Join(c.root, "memory.current")); ok {
+		res = append(res, Gauge("CgroupMemoryCurrent", float64(v)))
+	} else {
+		errs = append(errs, err)
+	}
+	// значение max означает отсутствие лимита, такую метрику не отправляем
+	if v, ok, err := readUint(filepath.Join(c.root, "memory.max")); ok {
+		res = append(res, Gauge("CgroupMemoryMax", float64(v)))
+	} else {
+		errs = append(errs, err)
+	}
+	events, err := readKeyValues(filepath.Join(c.root, "memory.events"))
+	errs = append(errs, err)
+	if v, ok := events["oom"]; ok {
+		res = append(res, c.delta.Counter("CgroupMemoryOOM", v))
+	}
+	if v, ok := events["oom_kill"]; ok {
+		res = append(res, c.delta.Counter("CgroupMemoryOOMKill", v))
+	}
+
+	// io
+	io, found, err := readIOStatV2(filepath.Join(c.root, "io.stat"))
+	errs = append(errs, err)
+	if found {
+		res = append(res, c.ioCounters(io)...)
+	}
+
+	// pids
+	res, errs = c.appendPids(res, errs)
+
+	return res, errors.Join(errs...)
+}
+
+func (c *Cgroup) collectV1() ([]metrics.RequestMetric, error) {
+	var res []metrics.RequestMetric
+	var errs []error
+
+	// cpu
+	if dir, ok := c.controllerV1("cpuacct", "cpu,cpuacct", "cpu"); ok {
+		// cpuacct.usage содержит наносекунды, приводим к микросекундам как в v2
+		if v, ok, err := readUint(filepath.Join(dir, "cpuacct.usage")); ok {
+			res = append(res, c.delta.Counter("CgroupCPUUsageUsec", v/1000))
+		} else {
+			errs = append(errs, err)
+		}
+	}
+	if dir, ok := c.controllerV1("cpu", "cpu,cpuacct"); ok {
+		stat, err := readKeyValues(filepath.Join(dir, "cpu.stat"))
+		errs = append(errs, err)
+		if v, ok := stat["nr_periods"]; ok {
+			res = append(res, c.delta.Counter("CgroupCPUPeriods", v))
+		}
+		if v, ok := stat["nr_throttled"]; ok {
+			res = append(res, c.delta.Counter("CgroupCPUThrottledPeriods", v))
+		}
+		if v, ok := stat["throttled_time"]; ok {
+			res = append(res, c.delta.Counter("CgroupCPUThrottledUsec", v/1000))
+		}
+	}
+
+	// memory
+	if dir, ok := c.controllerV1("memory"); ok {
+		if v, ok, err := readUint(filepath.Join(dir, "memory.usage_in_bytes")); ok {
+			res = append(res, Gauge("CgroupMemoryCurrent", float64(v)))
+		} else {
+			errs = append(errs, err)
+		}
+		if v, ok, err := readUint(filepath.Join(dir, "memory.limit_in_bytes")); ok && v < cgroupV1Unlimited {
+			res = append(res, Gauge("CgroupMemoryMax", float64(v)))
+		} else {
+			errs = append(errs, err)
+		}
+		control, err := readKeyValues(filepath.Join(dir, "memory.oom_control"))
+		errs = append(errs, err)
+		if v, ok := control["oom_kill"]; ok {
+			res = append(res, c.delta.Counter("CgroupMemoryOOMKill", v))
+		}
+	}
+
+	// io
+	if dir, ok := c.controllerV1("blkio"); ok {
+		io := make(map[string]uint64, 4)
+		bytesStat, foundBytes, err := readIOStatV1(filepath.Join(dir, "blkio.throttle.io_service_bytes"))
+		errs = append(errs, err)
+		opsStat, foundOps, err := readIOStatV1(filepath.Join(dir, "blkio.throttle.io_serviced"))
+		errs = append(errs, err)
+		if foundBytes || foundOps {
+			io["rbytes"], io["wbytes"] = bytesStat["read"], bytesStat["write"]
+			io["rios"], io["wios"] = opsStat["read"], opsStat["write"]
+			res = append(res, c.ioCounters(io)...)
+		}
+	}
+
+	// pids
+	if _, ok := c.controllerV1("pids"); ok {
+		res, errs = c.appendPids(res, errs)
+	}
+
+	return res, errors.Join(errs...)
+}
+
+// controllerV1 возвращает каталог первого найденного контроллера cgroup v1.
+func (c *Cgroup) controllerV1(names ...string) (string, bool) {
+	for _, name := range names {
+		dir := filepath.Join(c.root, name)
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return dir, true
+		}
+	}
+
+	return "", false
+}
+
+func (c *Cgroup) ioCounters(io map[string]uint64) []metrics.RequestMetric {
+	return []metrics.RequestMetric{
+		c.delta.Counter("CgroupIOReadBytes", io["rbytes"]),
+		c.delta.Counter("CgroupIOWriteBytes", io["wbytes"]),
+		c.delta.Counter("CgroupIOReadOps", io["rios"]),
+		c.delta.Counter("CgroupIOWriteOps", io["wios"]),
+	}
+}
+
+func (c *Cgroup) appendPids(res []metrics.RequestMetric, errs []error) ([]metrics.RequestMetric, []error) {
+	dir := c.root
+	if c.version == CgroupV1 {
+		dir = filepath.Join(c.root, "pids")
+	}
+	if v, ok, err := readUint(filepath.Join(dir, "pids.current")); ok {
+		res = append(res, Gauge("CgroupPidsCurrent", float64(v)))
+	} else {
+		errs = append(errs, err)
+	}
+	if v, ok, err := readUint(filepath.Join(dir, "pids.max")); ok {
+		res = append(res, Gauge("CgroupPidsMax", float64(v)))
+	} else {
+		errs = append(errs, err)
+	}
+
+	return res, errs
+}
+
+// readUint читает файл с одним числом. Отсутствующий файл и значение max не считаются ошибкой,
+// но и значения не дают.
+func readUint(path string) (uint64, bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	s := strings.TrimSpace(string(data))
+	if s == "max" {
+		return 0, false, nil
+	}
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	return v, true, nil
+}
+
+// readKeyValues читает файлы формата "ключ значение" построчно (cpu.stat, memory.events и т.п.).
+func readKeyValues(path string) (map[string]uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	res := make(map[string]uint64)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		res[fields[0]] = v
+	}
+
+	return res, scanner.Err()
+}
+
+// readIOStatV2 суммирует io.stat по всем устройствам.
+// Формат строки: "8:0 rbytes=1 wbytes=2 rios=3 wios=4 dbytes=0 dios=0".
+func readIOStatV2(path string) (map[string]uint64, bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+
+	res := make(map[string]uint64, 4)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		for _, field := range fields[min(1, len(fields)):] {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				continue
+			}
+			v, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return nil, false, fmt.Errorf("failed to parse %s: %w", path, err)
+			}
+			res[key] += v
+		}
+	}
+
+	return res, true, scanner.Err()
+}
+
+// readIOStatV1 суммирует blkio-статистику cgroup v1 по всем устройствам.
+// Формат строки: "8:0 Read 4096", итоговая строка "Total 4096" пропускается.
+func readIOStatV1(path string) (map[string]uint64, bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+
+	res := make(map[string]uint64, 2)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		res[strings.ToLower(fields[1])] += v
+	}
+
+	return res, true, scanner.Err()
+}
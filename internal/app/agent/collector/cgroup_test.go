@@ -0,0 +1,107 @@
+package collector
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/webkimru/go-yandex-metrics/internal/app/agent/metrics"
+)
+
+func toMap(res []metrics.RequestMetric) map[string]metrics.RequestMetric {
+	m := make(map[string]metrics.RequestMetric, len(res))
+	for _, r := range res {
+		m[r.ID] = r
+	}
+	return m
+}
+
+func TestDetectCgroupVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		root    string
+		want    CgroupVersion
+		wantErr bool
+	}{
+		{"positive: v1", "testdata/cgroup/v1", CgroupV1, false},
+		{"positive: v2", "testdata/cgroup/v2", CgroupV2, false},
+		{"negative: not found", "testdata/none", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DetectCgroupVersion(tt.root)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestCgroupCollectV2(t *testing.T) {
+	c, err := NewCgroup("testdata/cgroup/v2")
+	require.NoError(t, err)
+
+	res, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	m := toMap(res)
+
+	assert.Equal(t, float64(1048576), m["CgroupMemoryCurrent"].Value)
+	assert.Equal(t, float64(5), m["CgroupPidsCurrent"].Value)
+	assert.Equal(t, float64(100), m["CgroupPidsMax"].Value)
+	// memory.max = max - лимита нет
+	assert.NotContains(t, m, "CgroupMemoryMax")
+	// первое наблюдение counter задает точку отсчета
+	assert.Equal(t, "counter", m["CgroupCPUUsageUsec"].MType)
+	assert.Equal(t, int64(0), m["CgroupCPUUsageUsec"].Delta)
+	assert.Contains(t, m, "CgroupIOReadBytes")
+	assert.Contains(t, m, "CgroupMemoryOOMKill")
+}
+
+func TestCgroupCollectV1(t *testing.T) {
+	c, err := NewCgroup("testdata/cgroup/v1")
+	require.NoError(t, err)
+
+	res, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	m := toMap(res)
+
+	assert.Equal(t, float64(2097152), m["CgroupMemoryCurrent"].Value)
+	assert.Equal(t, float64(7), m["CgroupPidsCurrent"].Value)
+	// огромный limit_in_bytes означает отсутствие лимита
+	assert.NotContains(t, m, "CgroupMemoryMax")
+	assert.NotContains(t, m, "CgroupPidsMax")
+	assert.Contains(t, m, "CgroupCPUThrottledUsec")
+	assert.Contains(t, m, "CgroupIOWriteOps")
+}
+
+func TestCgroupCounterDelta(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "cgroup.controllers"), []byte("cpu io\n"), 0644))
+	writeIO := func(rbytes string) {
+		require.NoError(t, os.WriteFile(filepath.Join(root, "io.stat"), []byte("8:0 rbytes="+rbytes+" wbytes=0 rios=0 wios=0\n"), 0644))
+	}
+
+	c, err := NewCgroup(root)
+	require.NoError(t, err)
+
+	writeIO("100")
+	_, err = c.Collect(context.Background())
+	require.NoError(t, err)
+
+	writeIO("150")
+	res, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(50), toMap(res)["CgroupIOReadBytes"].Delta)
+
+	// сброс счетчика источника
+	writeIO("20")
+	res, err = c.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(20), toMap(res)["CgroupIOReadBytes"].Delta)
+}
@@ -0,0 +1,65 @@
+// Package collector содержит дополнительные источники метрик агента.
+package collector
+
+import (
+	"context"
+	"sync"
+
+	"github.com/webkimru/go-yandex-metrics/internal/app/agent/metrics"
+)
+
+// Collector источник метрик - контракт.
+type Collector interface {
+	// Name возвращает имя коллектора для логов.
+	Name() string
+	// Collect снимает текущие показания.
+	Collect(ctx context.Context) ([]metrics.RequestMetric, error)
+}
+
+// Delta переводит накопительные значения в приращения между опросами.
+type Delta struct {
+	prev map[string]uint64
+	mu   sync.Mutex
+}
+
+// NewDelta конструктор типа Delta.
+func NewDelta() *Delta {
+	return &Delta{
+		prev: make(map[string]uint64),
+	}
+}
+
+// Counter возвращает метрику counter с приращением значения value с прошлого опроса.
+// Первое наблюдение задает точку отсчета и дает нулевое приращение,
+// при сбросе источника (значение уменьшилось) приращением считается само значение.
+func (d *Delta) Counter(name string, value uint64) metrics.RequestMetric {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var delta uint64
+	prev, ok := d.prev[name]
+	switch {
+	case !ok:
+		delta = 0
+	case value < prev:
+		delta = value
+	default:
+		delta = value - prev
+	}
+	d.prev[name] = value
+
+	return metrics.RequestMetric{ID: name, MType: "counter", Delta: int64(delta)}
+}
+
+// Forget удаляет точку отсчета, например, для завершившегося процесса.
+func (d *Delta) Forget(name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.prev, name)
+}
+
+// Gauge возвращает метрику gauge.
+func Gauge(name string, value float64) metrics.RequestMetric {
+	return metrics.RequestMetric{ID: name, MType: "gauge", Value: value}
+}
@@ -0,0 +1,95 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/webkimru/go-yandex-metrics/internal/app/agent/metrics"
+)
+
+// Disk собирает заполненность файловых систем по точкам монтирования и счетчики ввода-вывода устройств.
+type Disk struct {
+	mounts  *Filter
+	devices *Filter
+	delta   *Delta
+
+	// источники данных, подменяются в тестах
+	partitions func(ctx context.Context, all bool) ([]disk.PartitionStat, error)
+	usage      func(ctx context.Context, path string) (*disk.UsageStat, error)
+	ioCounters func(ctx context.Context, names ...string) (map[string]disk.IOCountersStat, error)
+}
+
+// NewDisk конструктор типа Disk.
+func NewDisk(mounts, devices *Filter) *Disk {
+	return &Disk{
+		mounts:     mounts,
+		devices:    devices,
+		delta:      NewDelta(),
+		partitions: disk.PartitionsWithContext,
+		usage:      disk.UsageWithContext,
+		ioCounters: disk.IOCountersWithContext,
+	}
+}
+
+// Name возвращает имя коллектора.
+func (d *Disk) Name() string {
+	return "disk"
+}
+
+// Collect снимает текущие показания файловых систем и устройств.
+func (d *Disk) Collect(ctx context.Context) ([]metrics.RequestMetric, error) {
+	var res []metrics.RequestMetric
+	var errs []error
+
+	partitions, err := d.partitions(ctx, false)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed Partitions()=%w", err))
+	}
+	seen := make(map[string]struct{}, len(partitions))
+	for _, p := range partitions {
+		if _, ok := seen[p.Mountpoint]; ok || !d.mounts.Match(p.Mountpoint) {
+			continue
+		}
+		seen[p.Mountpoint] = struct{}{}
+
+		u, err := d.usage(ctx, p.Mountpoint)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed Usage(%s)=%w", p.Mountpoint, err))
+			continue
+		}
+		label := Label(p.Mountpoint)
+		res = append(res,
+			Gauge("DiskTotalBytes_"+label, float64(u.Total)),
+			Gauge("DiskFreeBytes_"+label, float64(u.Free)),
+			Gauge("DiskUsedBytes_"+label, float64(u.Used)),
+			Gauge("DiskUsedPercent_"+label, u.UsedPercent),
+			Gauge("DiskInodesTotal_"+label, float64(u.InodesTotal)),
+			Gauge("DiskInodesFree_"+label, float64(u.InodesFree)),
+			Gauge("DiskInodesUsed_"+label, float64(u.InodesUsed)),
+		)
+	}
+
+	counters, err := d.ioCounters(ctx)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed IOCounters()=%w", err))
+	}
+	for name, c := range counters {
+		if !d.devices.Match(name) {
+			continue
+		}
+		label := Label(name)
+		res = append(res,
+			d.delta.Counter("DiskReadBytes_"+label, c.ReadBytes),
+			d.delta.Counter("DiskWriteBytes_"+label, c.WriteBytes),
+			d.delta.Counter("DiskReadOps_"+label, c.ReadCount),
+			d.delta.Counter("DiskWriteOps_"+label, c.WriteCount),
+			d.delta.Counter("DiskReadTimeMs_"+label, c.ReadTime),
+			d.delta.Counter("DiskWriteTimeMs_"+label, c.WriteTime),
+			d.delta.Counter("DiskIOTimeMs_"+label, c.IoTime),
+		)
+	}
+
+	return res, errors.Join(errs...)
+}
@@ -0,0 +1,141 @@
+package collector
+
+import (
+	"context"
+	"testing"
+
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/net"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/webkimru/go-yandex-metrics/internal/app/agent/metrics"
+)
+
+func TestFilter(t *testing.T) {
+	f, err := NewFilter([]string{`^/$`, `^/data`}, []string{`^/data/tmp`})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"/", true},
+		{"/data", true},
+		{"/data/tmp", false},
+		{"/boot", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, f.Match(tt.name))
+		})
+	}
+
+	_, err = NewFilter([]string{"("}, nil)
+	assert.Error(t, err)
+}
+
+func TestLabel(t *testing.T) {
+	// имена из допустимых символов не меняются
+	assert.Equal(t, "root", Label("/root"))
+	assert.Equal(t, "var_lib", Label("/var_lib"))
+	assert.Equal(t, "eth0_100", Label("eth0_100"))
+	// к замененным символам добавляется хеш исходного имени
+	assert.Regexp(t, `^root_[0-9a-f]{8}$`, Label("/"))
+	assert.Regexp(t, `^var_lib_docker_[0-9a-f]{8}$`, Label("/var/lib/docker"))
+	assert.Equal(t, Label("/var/lib/docker"), Label("/var/lib/docker"))
+
+	// разные имена получают разные суффиксы
+	for _, names := range [][]string{
+		{"/", "/root", "/_root"},
+		{"/var/lib", "/var_lib", "/var-lib"},
+		{"eth0.100", "eth0_100", "eth0-100"},
+	} {
+		labels := make(map[string]string, len(names))
+		for _, name := range names {
+			label := Label(name)
+			assert.NotContains(t, labels, label, "%s and %s", name, labels[label])
+			labels[label] = name
+		}
+	}
+}
+
+func TestDiskCollect(t *testing.T) {
+	mounts, err := NewFilter(nil, []string{`^/boot`})
+	require.NoError(t, err)
+	d := NewDisk(mounts, nil)
+	d.partitions = func(_ context.Context, _ bool) ([]disk.PartitionStat, error) {
+		return []disk.PartitionStat{{Mountpoint: "/"}, {Mountpoint: "/"}, {Mountpoint: "/boot"}}, nil
+	}
+	d.usage = func(_ context.Context, path string) (*disk.UsageStat, error) {
+		return &disk.UsageStat{Path: path, Total: 100, Used: 40, Free: 60, UsedPercent: 40, InodesTotal: 10}, nil
+	}
+	readBytes := uint64(1000)
+	d.ioCounters = func(_ context.Context, _ ...string) (map[string]disk.IOCountersStat, error) {
+		return map[string]disk.IOCountersStat{"sda": {ReadBytes: readBytes}}, nil
+	}
+
+	res, err := d.Collect(context.Background())
+	require.NoError(t, err)
+	m := toMap(res)
+	assert.Equal(t, float64(100), m["DiskTotalBytes_"+Label("/")].Value)
+	assert.Equal(t, float64(10), m["DiskInodesTotal_"+Label("/")].Value)
+	assert.NotContains(t, m, "DiskTotalBytes_boot")
+
+	readBytes = 1500
+	res, err = d.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(500), toMap(res)["DiskReadBytes_sda"].Delta)
+}
+
+func TestDiskLongMountpoint(t *testing.T) {
+	mounts := []disk.PartitionStat{
+		{Mountpoint: "/var/lib/docker/overlay2/3f1c9a7e5b2d4c6f8a0b1c2d3e4f5a6b/merged"},
+		{Mountpoint: "/var/lib/docker/overlay2/3f1c9a7e5b2d4c6f8a0b1c2d3e4f5a6c/merged"},
+	}
+	d := NewDisk(nil, nil)
+	d.partitions = func(_ context.Context, _ bool) ([]disk.PartitionStat, error) {
+		return mounts, nil
+	}
+	d.usage = func(_ context.Context, path string) (*disk.UsageStat, error) {
+		return &disk.UsageStat{Path: path, InodesTotal: 10}, nil
+	}
+	d.ioCounters = func(_ context.Context, _ ...string) (map[string]disk.IOCountersStat, error) {
+		return nil, nil
+	}
+
+	res, err := d.Collect(context.Background())
+	require.NoError(t, err)
+	registry := metrics.NewRegistry()
+	registry.Update(res)
+	flushed := registry.Flush()
+	// имена укорочены до предела сервера и не совпадают у разных точек монтирования
+	require.Len(t, flushed, len(res))
+	for _, m := range flushed {
+		assert.LessOrEqual(t, len(m.ID), metrics.MaxNameLength, m.ID)
+	}
+	short := metrics.FitName("DiskInodesTotal_" + Label(mounts[0].Mountpoint))
+	assert.Len(t, short, metrics.MaxNameLength)
+	assert.Equal(t, "DiskInodesTotal_var_lib_docker_overlay2_3", short[:len(short)-9])
+	assert.NotEqual(t, short, metrics.FitName("DiskInodesTotal_"+Label(mounts[1].Mountpoint)))
+	assert.Equal(t, "DiskInodesTotal_"+Label("/"), metrics.FitName("DiskInodesTotal_"+Label("/")))
+}
+
+func TestNetCollect(t *testing.T) {
+	interfaces, err := NewFilter(nil, []string{`^lo$`})
+	require.NoError(t, err)
+	n := NewNet(interfaces)
+	sent := uint64(10)
+	n.ioCounters = func(_ context.Context, _ bool) ([]net.IOCountersStat, error) {
+		return []net.IOCountersStat{{Name: "eth0", BytesSent: sent}, {Name: "lo", BytesSent: sent}}, nil
+	}
+
+	_, err = n.Collect(context.Background())
+	require.NoError(t, err)
+	sent = 25
+	res, err := n.Collect(context.Background())
+	require.NoError(t, err)
+	m := toMap(res)
+	assert.Equal(t, int64(15), m["NetBytesSent_eth0"].Delta)
+	assert.NotContains(t, m, "NetBytesSent_lo")
+}
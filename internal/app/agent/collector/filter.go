@@ -0,0 +1,91 @@
+package collector
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strings"
+)
+
+// rootLabel основа суффикса корневой точки монтирования.
+const rootLabel = "root"
+
+// Filter отбирает объекты (точки монтирования, устройства, интерфейсы) по регулярным выражениям.
+type Filter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+// NewFilter конструктор типа Filter. Пустой include пропускает все, кроме исключенных.
+func NewFilter(include, exclude []string) (*Filter, error) {
+	var f Filter
+	for _, pattern := range include {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile include pattern=%q: %w", pattern, err)
+		}
+		f.include = append(f.include, re)
+	}
+	for _, pattern := range exclude {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile exclude pattern=%q: %w", pattern, err)
+		}
+		f.exclude = append(f.exclude, re)
+	}
+
+	return &f, nil
+}
+
+// Match сообщает, проходит ли имя фильтр. Исключение важнее включения.
+func (f *Filter) Match(name string) bool {
+	if f == nil {
+		return true
+	}
+	for _, re := range f.exclude {
+		if re.MatchString(name) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, re := range f.include {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Label приводит имя объекта к суффиксу имени метрики: все, кроме букв, цифр и _, заменяется на _,
+// а корневая точка монтирования превращается в root. Если суффикс отличается от имени без крайних /,
+// к нему добавляется хеш исходного имени: /var/lib, /var_lib и /var-lib, eth0.100 и eth0_100,
+// / и /root получают разные суффиксы, и их метрики и приращения счетчиков не смешиваются.
+func Label(name string) string {
+	trimmed := strings.Trim(name, "/")
+	label := sanitize(trimmed)
+	if label == "" {
+		label = rootLabel
+	}
+	if label == trimmed {
+		return label
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name))
+
+	return fmt.Sprintf("%s_%08x", label, h.Sum32())
+}
+
+// sanitize заменяет на _ все, кроме букв, цифр и _.
+func sanitize(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
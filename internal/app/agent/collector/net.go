@@ -0,0 +1,60 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/shirou/gopsutil/v3/net"
+	"github.com/webkimru/go-yandex-metrics/internal/app/agent/metrics"
+)
+
+// Net собирает счетчики байт, пакетов и ошибок по сетевым интерфейсам.
+type Net struct {
+	interfaces *Filter
+	delta      *Delta
+
+	// источник данных, подменяется в тестах
+	ioCounters func(ctx context.Context, pernic bool) ([]net.IOCountersStat, error)
+}
+
+// NewNet конструктор типа Net.
+func NewNet(interfaces *Filter) *Net {
+	return &Net{
+		interfaces: interfaces,
+		delta:      NewDelta(),
+		ioCounters: net.IOCountersWithContext,
+	}
+}
+
+// Name возвращает имя коллектора.
+func (n *Net) Name() string {
+	return "net"
+}
+
+// Collect снимает текущие показания сетевых интерфейсов.
+func (n *Net) Collect(ctx context.Context) ([]metrics.RequestMetric, error) {
+	counters, err := n.ioCounters(ctx, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed IOCounters()=%w", err)
+	}
+
+	var res []metrics.RequestMetric
+	for _, c := range counters {
+		if !n.interfaces.Match(c.Name) {
+			continue
+		}
+		label := Label(c.Name)
+		res = append(res,
+			n.delta.Counter("NetBytesSent_"+label, c.BytesSent),
+			n.delta.Counter("NetBytesRecv_"+label, c.BytesRecv),
+			n.delta.Counter("NetPacketsSent_"+label, c.PacketsSent),
+			n.delta.Counter("NetPacketsRecv_"+label, c.PacketsRecv),
+			n.delta.Counter("NetErrIn_"+label, c.Errin),
+			n.delta.Counter("NetErrOut_"+label, c.Errout),
+			n.delta.Counter("NetDropIn_"+label, c.Dropin),
+			n.delta.Counter("NetDropOut_"+label, c.Dropout),
+		)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,228 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/shirou/gopsutil/v3/process"
+	"github.com/webkimru/go-yandex-metrics/internal/app/agent/metrics"
+)
+
+// ProcessSelector описывает группу отслеживаемых процессов.
+// Если задан PIDFile, берется процесс из файла, иначе все процессы, подходящие под Name и Cmdline.
+type ProcessSelector struct {
+	Label   string
+	PIDFile string
+	Name    *regexp.Regexp
+	Cmdline *regexp.Regexp
+}
+
+// NewProcessSelector конструктор типа ProcessSelector.
+func NewProcessSelector(label, pidFile, name, cmdline string) (ProcessSelector, error) {
+	s := ProcessSelector{Label: label, PIDFile: pidFile}
+	if label == "" {
+		return s, errors.New("process label is required")
+	}
+	if pidFile == "" && name == "" && cmdline == "" {
+		return s, fmt.Errorf("process %s: pid_file, name or cmdline is required", label)
+	}
+
+	var err error
+	if name != "" {
+		if s.Name, err = regexp.Compile(name); err != nil {
+			return s, fmt.Errorf("process %s: failed to compile name=%q: %w", label, name, err)
+		}
+	}
+	if cmdline != "" {
+		if s.Cmdline, err = regexp.Compile(cmdline); err != nil {
+			return s, fmt.Errorf("process %s: failed to compile cmdline=%q: %w", label, cmdline, err)
+		}
+	}
+
+	return s, nil
+}
+
+// Process собирает метрики выбранных процессов, суммируя их по метке селектора.
+type Process struct {
+	selectors []ProcessSelector
+	delta     *Delta
+	// процессы переиспользуются между опросами: от этого зависит расчет CPU percent
+	procs map[int32]*process.Process
+}
+
+// NewProcess конструктор типа Process.
+func NewProcess(selectors []ProcessSelector) *Process {
+	return &Process{
+		selectors: selectors,
+		delta:     NewDelta(),
+		procs:     make(map[int32]*process.Process),
+	}
+}
+
+// Name возвращает имя коллектора.
+func (p *Process) Name() string {
+	return "process"
+}
+
+// Collect снимает текущие показания процессов по каждому селектору.
+func (p *Process) Collect(ctx context.Context) ([]metrics.RequestMetric, error) {
+	var res []metrics.RequestMetric
+	var errs []error
+	// список всех процессов читаем не больше одного раза за опрос
+	var all []*process.Process
+	seen := make(map[int32]struct{})
+
+	for _, s := range p.selectors {
+		matched, err := p.match(ctx, s, &all)
+		if err != nil {
+			errs = append(errs, err)
+		}
+
+		label := Label(s.Label)
+		var cpu, rss, fds, threads float64
+		var readBytes, writeBytes int64
+		for _, proc := range matched {
+			seen[proc.Pid] = struct{}{}
+			// отдельные показатели могут быть недоступны (например, fd чужого пользователя),
+			// такие значения просто не попадают в сумму
+			if v, err := proc.PercentWithContext(ctx, 0); err == nil {
+				cpu += v
+			}
+			if v, err := proc.MemoryInfoWithContext(ctx); err == nil {
+				rss += float64(v.RSS)
+			}
+			if v, err := proc.NumFDsWithContext(ctx); err == nil {
+				fds += float64(v)
+			}
+			if v, err := proc.NumThreadsWithContext(ctx); err == nil {
+				threads += float64(v)
+			}
+			if v, err := proc.IOCountersWithContext(ctx); err == nil {
+				readBytes += p.delta.Counter(ioKey(label, proc.Pid, "read"), v.ReadBytes).Delta
+				writeBytes += p.delta.Counter(ioKey(label, proc.Pid, "write"), v.WriteBytes).Delta
+			}
+		}
+
+		var up float64
+		if len(matched) > 0 {
+			up = 1
+		}
+		res = append(res,
+			Gauge("ProcessUp_"+label, up),
+			Gauge("ProcessCount_"+label, float64(len(matched))),
+			Gauge("ProcessCPUPercent_"+label, cpu),
+			Gauge("ProcessRSSBytes_"+label, rss),
+			Gauge("ProcessOpenFDs_"+label, fds),
+			Gauge("ProcessThreads_"+label, threads),
+			metrics.RequestMetric{ID: "ProcessIOReadBytes_" + label, MType: "counter", Delta: readBytes},
+			metrics.RequestMetric{ID: "ProcessIOWriteBytes_" + label, MType: "counter", Delta: writeBytes},
+		)
+	}
+
+	// забываем завершившиеся процессы
+	for pid := range p.procs {
+		if _, ok := seen[pid]; !ok {
+			delete(p.procs, pid)
+			for _, s := range p.selectors {
+				p.delta.Forget(ioKey(Label(s.Label), pid, "read"))
+				p.delta.Forget(ioKey(Label(s.Label), pid, "write"))
+			}
+		}
+	}
+
+	return res, errors.Join(errs...)
+}
+
+// match возвращает процессы, подходящие под селектор.
+func (p *Process) match(ctx context.Context, s ProcessSelector, all *[]*process.Process) ([]*process.Process, error) {
+	var candidates []*process.Process
+
+	if s.PIDFile != "" {
+		pid, err := readPIDFile(s.PIDFile)
+		if errors.Is(err, os.ErrNotExist) {
+			// нет pid-файла - сервис не запущен
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		proc, err := p.process(ctx, pid)
+		if err != nil {
+			// процесса нет - это состояние down, а не ошибка
+			return nil, nil
+		}
+		candidates = []*process.Process{proc}
+	} else {
+		if *all == nil {
+			list, err := process.ProcessesWithContext(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed Processes()=%w", err)
+			}
+			*all = list
+		}
+		candidates = *all
+	}
+
+	var res []*process.Process
+	for _, proc := range candidates {
+		if s.Name != nil {
+			name, err := proc.NameWithContext(ctx)
+			if err != nil || !s.Name.MatchString(name) {
+				continue
+			}
+		}
+		if s.Cmdline != nil {
+			cmdline, err := proc.CmdlineWithContext(ctx)
+			if err != nil || !s.Cmdline.MatchString(cmdline) {
+				continue
+			}
+		}
+		cached, err := p.process(ctx, proc.Pid)
+		if err != nil {
+			continue
+		}
+		res = append(res, cached)
+	}
+
+	return res, nil
+}
+
+// process возвращает процесс из кеша или создает новый.
+func (p *Process) process(ctx context.Context, pid int32) (*process.Process, error) {
+	if proc, ok := p.procs[pid]; ok {
+		if running, err := proc.IsRunningWithContext(ctx); err == nil && running {
+			return proc, nil
+		}
+		delete(p.procs, pid)
+	}
+	proc, err := process.NewProcessWithContext(ctx, pid)
+	if err != nil {
+		return nil, err
+	}
+	p.procs[pid] = proc
+
+	return proc, nil
+}
+
+func readPIDFile(path string) (int32, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read pid file=%s: %w", path, err)
+	}
+	pid, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse pid file=%s: %w", path, err)
+	}
+
+	return int32(pid), nil
+}
+
+// ioKey ключ точки отсчета счетчика ввода-вывода: один процесс может попасть в несколько селекторов.
+func ioKey(label string, pid int32, direction string) string {
+	return fmt.Sprintf("%s/%d/%s", label, pid, direction)
+}
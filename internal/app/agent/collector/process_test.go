@@ -0,0 +1,62 @@
+package collector
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewProcessSelector(t *testing.T) {
+	tests := []struct {
+		name    string
+		label   string
+		pidFile string
+		regex   string
+		wantErr bool
+	}{
+		{"positive: name", "app", "", "^app$", false},
+		{"positive: pid file", "app", "/run/app.pid", "", false},
+		{"negative: without label", "", "", "^app$", true},
+		{"negative: without criteria", "app", "", "", true},
+		{"negative: invalid regex", "app", "", "(", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewProcessSelector(tt.label, tt.pidFile, tt.regex, "")
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestProcessCollect(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "test.pid")
+	require.NoError(t, os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())), 0644))
+
+	self, err := NewProcessSelector("self", pidFile, "", "")
+	require.NoError(t, err)
+	missing, err := NewProcessSelector("missing", filepath.Join(t.TempDir(), "none.pid"), "", "")
+	require.NoError(t, err)
+	byName, err := NewProcessSelector("by-name", "", `^collector\.test`, "")
+	require.NoError(t, err)
+
+	p := NewProcess([]ProcessSelector{self, missing, byName})
+	res, err := p.Collect(context.Background())
+	require.NoError(t, err)
+	m := toMap(res)
+
+	assert.Equal(t, float64(1), m["ProcessUp_self"].Value)
+	assert.Greater(t, m["ProcessRSSBytes_self"].Value, float64(0))
+	assert.Greater(t, m["ProcessThreads_self"].Value, float64(0))
+	assert.Equal(t, float64(0), m["ProcessUp_missing"].Value)
+	assert.Equal(t, float64(1), m["ProcessUp_"+Label("by-name")].Value)
+	assert.Equal(t, "counter", m["ProcessIOReadBytes_self"].MType)
+}
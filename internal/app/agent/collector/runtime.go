@@ -0,0 +1,153 @@
+package collector
+
+import (
+	"context"
+	"math"
+	rtmetrics "runtime/metrics"
+	"strings"
+
+	"github.com/webkimru/go-yandex-metrics/internal/app/agent/metrics"
+)
+
+// RuntimeQuantiles квантили, в которые сворачиваются гистограммы runtime/metrics.
+var RuntimeQuantiles = []struct {
+	Suffix string
+	Q      float64
+}{
+	{"p50", 0.5},
+	{"p90", 0.9},
+	{"p99", 0.99},
+}
+
+// Runtime собирает метрики Go-рантайма через runtime/metrics без остановки мира.
+// Накопительные значения отправляются приращениями counter, гистограммы (паузы GC,
+// задержки планировщика и т.п.) - квантилями gauge за интервал между опросами.
+type Runtime struct {
+	samples    []rtmetrics.Sample
+	cumulative []bool
+	delta      *Delta
+	// счетчики гистограмм прошлого опроса для расчета квантилей за интервал
+	histograms map[string][]uint64
+}
+
+// NewRuntime конструктор типа Runtime. Фильтр применяется к исходным именам, например /gc/pauses:seconds.
+func NewRuntime(filter *Filter) *Runtime {
+	r := &Runtime{
+		delta:      NewDelta(),
+		histograms: make(map[string][]uint64),
+	}
+	for _, d := range rtmetrics.All() {
+		if !filter.Match(d.Name) {
+			continue
+		}
+		r.samples = append(r.samples, rtmetrics.Sample{Name: d.Name})
+		r.cumulative = append(r.cumulative, d.Cumulative)
+	}
+
+	return r
+}
+
+// Name возвращает имя коллектора.
+func (r *Runtime) Name() string {
+	return "runtime"
+}
+
+// Collect снимает текущие показания рантайма.
+func (r *Runtime) Collect(_ context.Context) ([]metrics.RequestMetric, error) {
+	rtmetrics.Read(r.samples)
+
+	res := make([]metrics.RequestMetric, 0, len(r.samples))
+	for i, s := range r.samples {
+		name := RuntimeMetricName(s.Name)
+
+		switch s.Value.Kind() {
+		case rtmetrics.KindUint64:
+			if r.cumulative[i] {
+				res = append(res, r.delta.Counter(name, s.Value.Uint64()))
+			} else {
+				res = append(res, Gauge(name, float64(s.Value.Uint64())))
+			}
+
+		case rtmetrics.KindFloat64:
+			v := s.Value.Float64()
+			// накопительные значения в секундах переводим в целые микросекунды для counter
+			if r.cumulative[i] && strings.HasSuffix(name, "seconds") {
+				name = strings.TrimSuffix(name, "seconds") + "microseconds"
+				res = append(res, r.delta.Counter(name, uint64(v*1e6)))
+			} else {
+				res = append(res, Gauge(name, v))
+			}
+
+		case rtmetrics.KindFloat64Histogram:
+			h := s.Value.Float64Histogram()
+			counts := r.window(s.Name, h.Counts)
+			for _, q := range RuntimeQuantiles {
+				res = append(res, Gauge(name+"_"+q.Suffix, Quantile(q.Q, counts, h.Buckets)))
+			}
+
+		default:
+			// метрика не поддерживается текущей версией рантайма
+			continue
+		}
+	}
+
+	return res, nil
+}
+
+// window возвращает счетчики гистограммы за интервал с прошлого опроса.
+func (r *Runtime) window(name string, counts []uint64) []uint64 {
+	prev := r.histograms[name]
+	res := make([]uint64, len(counts))
+	for i, c := range counts {
+		if i < len(prev) && c >= prev[i] {
+			res[i] = c - prev[i]
+		} else {
+			res[i] = c
+		}
+	}
+	r.histograms[name] = append(prev[:0], counts...)
+
+	return res
+}
+
+// Quantile оценивает квантиль q по гистограмме с линейной интерполяцией внутри корзины.
+// buckets содержит границы корзин и на единицу длиннее counts. Пустая гистограмма дает 0.
+func Quantile(q float64, counts []uint64, buckets []float64) float64 {
+	var total uint64
+	for _, c := range counts {
+		total += c
+	}
+	if total == 0 {
+		return 0
+	}
+
+	rank := q * float64(total)
+	var cumulative float64
+	for i, c := range counts {
+		if c == 0 {
+			continue
+		}
+		prev := cumulative
+		cumulative += float64(c)
+		if cumulative < rank {
+			continue
+		}
+		lower, upper := buckets[i], buckets[i+1]
+		switch {
+		case math.IsInf(lower, -1):
+			return upper
+		case math.IsInf(upper, 1):
+			return lower
+		}
+		return lower + (upper-lower)*(rank-prev)/float64(c)
+	}
+
+	return buckets[len(buckets)-1]
+}
+
+// RuntimeMetricName приводит имя runtime/metrics к имени метрики сервиса:
+// /gc/heap/allocs:bytes -> Runtime_gc_heap_allocs_bytes. Имена runtime/metrics задает рантайм Go,
+// и после замены символов они не совпадают, поэтому хеш к ним не добавляется.
+func RuntimeMetricName(name string) string {
+	return "Runtime_" + sanitize(strings.Trim(name, "/"))
+}
@@ -0,0 +1,83 @@
+package collector
+
+import (
+	"context"
+	"math"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/webkimru/go-yandex-metrics/internal/app/agent/metrics"
+)
+
+func TestQuantile(t *testing.T) {
+	buckets := []float64{math.Inf(-1), 0, 10, 20, math.Inf(1)}
+	tests := []struct {
+		name   string
+		q      float64
+		counts []uint64
+		want   float64
+	}{
+		{"empty histogram", 0.5, []uint64{0, 0, 0, 0}, 0},
+		{"median inside bucket", 0.5, []uint64{0, 2, 2, 0}, 10},
+		{"interpolation", 0.25, []uint64{0, 4, 0, 0}, 2.5},
+		{"infinite upper bound", 0.99, []uint64{0, 0, 1, 1}, 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Quantile(tt.q, tt.counts, buckets))
+		})
+	}
+}
+
+func TestRuntimeMetricName(t *testing.T) {
+	assert.Equal(t, "Runtime_gc_heap_allocs_bytes", RuntimeMetricName("/gc/heap/allocs:bytes"))
+	assert.Equal(t, "Runtime_cpu_classes_user_cpu_seconds", RuntimeMetricName("/cpu/classes/user:cpu-seconds"))
+}
+
+func TestRuntimeCollect(t *testing.T) {
+	filter, err := NewFilter([]string{`^/gc/`, `^/sched/latencies`, `^/cpu/classes/user`}, nil)
+	require.NoError(t, err)
+	r := NewRuntime(filter)
+
+	_, err = r.Collect(context.Background())
+	require.NoError(t, err)
+	runtime.GC()
+	res, err := r.Collect(context.Background())
+	require.NoError(t, err)
+	m := toMap(res)
+
+	// накопительные значения - counter
+	assert.Equal(t, "counter", m["Runtime_gc_cycles_total_gc_cycles"].MType)
+	assert.GreaterOrEqual(t, m["Runtime_gc_cycles_total_gc_cycles"].Delta, int64(1))
+	assert.Equal(t, "counter", m["Runtime_cpu_classes_user_cpu_microseconds"].MType)
+	// мгновенные значения - gauge
+	assert.Equal(t, "gauge", m["Runtime_gc_heap_goal_bytes"].MType)
+	// гистограммы - квантили
+	for _, q := range []string{"p50", "p90", "p99"} {
+		assert.Contains(t, m, "Runtime_gc_pauses_seconds_"+q)
+		assert.Contains(t, m, "Runtime_sched_latencies_seconds_"+q)
+	}
+	// фильтр
+	assert.NotContains(t, m, "Runtime_memory_classes_total_bytes")
+}
+
+func TestRuntimeNameLength(t *testing.T) {
+	filter, err := NewFilter(nil, nil)
+	require.NoError(t, err)
+	r := NewRuntime(filter)
+
+	_, err = r.Collect(context.Background())
+	require.NoError(t, err)
+	res, err := r.Collect(context.Background())
+	require.NoError(t, err)
+	require.NotEmpty(t, res)
+	// длинные имена укорачиваются в реестре перед отправкой
+	registry := metrics.NewRegistry()
+	registry.Update(res)
+	for _, m := range registry.Flush() {
+		assert.LessOrEqual(t, len(m.ID), metrics.MaxNameLength, m.ID)
+	}
+}
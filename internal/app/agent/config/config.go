@@ -2,16 +2,76 @@ package config
 
 import (
 	"crypto/rsa"
+	"crypto/tls"
 )
 
+// TLSConfig настройки TLS соединения с сервером.
+type TLSConfig struct {
+	CAFile   string `json:"ca_file,omitempty"`   // CA для проверки сертификата сервера, пустой - системные CA
+	CertFile string `json:"cert_file,omitempty"` // сертификат агента для mTLS
+	KeyFile  string `json:"key_file,omitempty"`
+}
+
+// CgroupConfig настройки коллектора метрик контейнера из cgroupfs.
+type CgroupConfig struct {
+	Enabled bool   `json:"enabled"`
+	Root    string `json:"root,omitempty"`
+}
+
+// FilterConfig регулярные выражения для отбора объектов коллектора.
+type FilterConfig struct {
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// DiskConfig настройки коллектора файловых систем и дисков.
+type DiskConfig struct {
+	Enabled bool         `json:"enabled"`
+	Mounts  FilterConfig `json:"mounts"`
+	Devices FilterConfig `json:"devices"`
+}
+
+// NetConfig настройки коллектора сетевых интерфейсов.
+type NetConfig struct {
+	Enabled    bool         `json:"enabled"`
+	Interfaces FilterConfig `json:"interfaces"`
+}
+
+// ProcessConfig селектор процессов: pid-файл или регулярные выражения по имени и командной строке.
+type ProcessConfig struct {
+	Label   string `json:"label"`
+	PIDFile string `json:"pid_file,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Cmdline string `json:"cmdline,omitempty"`
+}
+
+// RuntimeConfig настройки коллектора runtime/metrics.
+// При включенном коллекторе выборка runtime.MemStats отключается.
+type RuntimeConfig struct {
+	Enabled bool         `json:"enabled"`
+	Metrics FilterConfig `json:"metrics"`
+}
+
 type AppConfig struct {
-	ServerProtocol string         `json:"protocol,omitempty"`
-	SecretKey      string         `json:"key,omitempty"`
-	ServerAddress  string         `json:"address,omitempty"`
-	CryptoKey      string         `json:"crypto_key,omitempty"`
-	PublicKeyPEM   *rsa.PublicKey `json:"-"`
-	RealIP         string         `json:"real_ip,omitempty"`
-	RateLimit      int            `json:"rate_limit,omitempty"`
-	PollInterval   int            `json:"poll_interval,omitempty"`
-	ReportInterval int            `json:"report_interval,omitempty"`
+	ServerProtocol string          `json:"protocol,omitempty"`
+	SecretKey      string          `json:"key,omitempty"`
+	KeyID          string          `json:"key_id,omitempty"`
+	ServerAddress  string          `json:"address,omitempty"`
+	GRPCAddress    string          `json:"grpc_address,omitempty"`
+	TLS            TLSConfig       `json:"tls"`
+	ClientTLS      *tls.Config     `json:"-"`
+	CryptoKey      string          `json:"crypto_key,omitempty"`
+	PublicKeyPEM   *rsa.PublicKey  `json:"-"`
+	RealIP         string          `json:"real_ip,omitempty"`
+	AgentID        string          `json:"agent_id,omitempty"`
+	Token          string          `json:"token,omitempty"`
+	Tenant         string          `json:"tenant,omitempty"`
+	RateLimit      int             `json:"rate_limit,omitempty"`
+	PollInterval   int             `json:"poll_interval,omitempty"`
+	ReportInterval int             `json:"report_interval,omitempty"`
+	Cgroup         CgroupConfig    `json:"cgroup"`
+	Disk           DiskConfig      `json:"disk"`
+	Net            NetConfig       `json:"net"`
+	Processes      []ProcessConfig `json:"processes,omitempty"`
+	Runtime        RuntimeConfig   `json:"runtime"`
 }
@@ -2,11 +2,14 @@ package agent
 
 import (
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"github.com/webkimru/go-yandex-metrics/internal/app/agent/collector"
 	"github.com/webkimru/go-yandex-metrics/internal/app/agent/logger"
 	"github.com/webkimru/go-yandex-metrics/internal/security"
 	"log"
+	"net/http"
 	"os"
 	"strconv"
 )
@@ -19,13 +22,21 @@ const (
 func Setup() (string, int, error) {
 	// задаем флаги для агента
 	serverAddress := flag.String("a", "", "server address")
+	grpcAddress := flag.String("grpc-address", "", "gRPC server address")
+	tlsCA := flag.String("tls-ca", "", "path to pem CA bundle to verify the server certificate")
+	tlsCert := flag.String("tls-cert", "", "path to pem agent certificate file")
+	tlsKey := flag.String("tls-key", "", "path to pem agent private key file")
 	reportInterval := flag.Int("r", 0, "report interval (in seconds)")
 	pollInterval := flag.Int("p", 0, "poll interval (in seconds)")
 	secretKey := flag.String("k", "", "secret key")
+	keyID := flag.String("key-id", "", "id of the signing key on the server")
 	rateLimit := flag.Int("l", 0, "rate limit (a number of workers)")
 	cryptoKey := flag.String("crypto-key", "", "path to pem public key file")
 	realIP := flag.String("i", "", "real ip")
 	serverProtocol := flag.String("s", "", "protocol: HTTP, GRPC")
+	agentID := flag.String("agent-id", "", "agent id reported to the server (default hostname)")
+	token := flag.String("token", "", "bearer token with write scope")
+	tenantID := flag.String("tenant", "", "tenant to send metrics to")
 	configuration := flag.String("c", "", "path to json configuration file")
 
 	// разбор командой строки
@@ -35,6 +46,18 @@ func Setup() (string, int, error) {
 	if envRunAddr := os.Getenv("ADDRESS"); envRunAddr != "" {
 		serverAddress = &envRunAddr
 	}
+	if envGRPCAddress := os.Getenv("GRPC_ADDRESS"); envGRPCAddress != "" {
+		grpcAddress = &envGRPCAddress
+	}
+	if envTLSCA := os.Getenv("TLS_CA_FILE"); envTLSCA != "" {
+		tlsCA = &envTLSCA
+	}
+	if envTLSCert := os.Getenv("TLS_CERT_FILE"); envTLSCert != "" {
+		tlsCert = &envTLSCert
+	}
+	if envTLSKey := os.Getenv("TLS_KEY_FILE"); envTLSKey != "" {
+		tlsKey = &envTLSKey
+	}
 	if envReportInterval := os.Getenv("REPORT_INTERVAL"); envReportInterval != "" {
 		ri, err := strconv.Atoi(envReportInterval)
 		if err != nil {
@@ -52,6 +75,9 @@ func Setup() (string, int, error) {
 	if envSecretKey := os.Getenv("KEY"); envSecretKey != "" {
 		secretKey = &envSecretKey
 	}
+	if envKeyID := os.Getenv("KEY_ID"); envKeyID != "" {
+		keyID = &envKeyID
+	}
 	if envRateLimit := os.Getenv("RATE_LIMIT"); envRateLimit != "" {
 		pi, err := strconv.Atoi(envRateLimit)
 		if err != nil {
@@ -68,6 +94,15 @@ func Setup() (string, int, error) {
 	if envServerProtocol := os.Getenv("SERVER_PROTOCOL"); envServerProtocol != "" {
 		serverProtocol = &envServerProtocol
 	}
+	if envAgentID := os.Getenv("AGENT_ID"); envAgentID != "" {
+		agentID = &envAgentID
+	}
+	if envToken := os.Getenv("TOKEN"); envToken != "" {
+		token = &envToken
+	}
+	if envTenant := os.Getenv("TENANT"); envTenant != "" {
+		tenantID = &envTenant
+	}
 	if envConfig := os.Getenv("CONFIG"); envConfig != "" {
 		configuration = &envConfig
 	}
@@ -94,6 +129,18 @@ func Setup() (string, int, error) {
 	if *serverAddress != "" {
 		app.ServerAddress = *serverAddress
 	}
+	if *grpcAddress != "" {
+		app.GRPCAddress = *grpcAddress
+	}
+	if *tlsCA != "" {
+		app.TLS.CAFile = *tlsCA
+	}
+	if *tlsCert != "" {
+		app.TLS.CertFile = *tlsCert
+	}
+	if *tlsKey != "" {
+		app.TLS.KeyFile = *tlsKey
+	}
 	if *reportInterval != 0 {
 		app.ReportInterval = *reportInterval
 	}
@@ -103,6 +150,9 @@ func Setup() (string, int, error) {
 	if *secretKey != "" {
 		app.SecretKey = *secretKey
 	}
+	if *keyID != "" {
+		app.KeyID = *keyID
+	}
 	if *rateLimit != 0 {
 		app.RateLimit = *rateLimit
 	}
@@ -115,11 +165,23 @@ func Setup() (string, int, error) {
 	if *serverProtocol != "" {
 		app.ServerProtocol = *serverProtocol
 	}
+	if *agentID != "" {
+		app.AgentID = *agentID
+	}
+	if *token != "" {
+		app.Token = *token
+	}
+	if *tenantID != "" {
+		app.Tenant = *tenantID
+	}
 	// обязательные настройки
 	if app.ServerAddress == "" {
 		app.ServerAddress = "localhost:8080"
 		logger.Log.Infof("server address is set to the default address - localhost:8080")
 	}
+	if app.GRPCAddress == "" {
+		app.GRPCAddress = "localhost:3200" // silent default
+	}
 	if app.RateLimit == 0 {
 		app.RateLimit = 1 // silent default
 		logger.Log.Infof("default rate limit is automatically set = %d", app.RateLimit)
@@ -136,6 +198,14 @@ func Setup() (string, int, error) {
 		app.RealIP = "127.0.0.1"
 		logger.Log.Infof("default real ip is automatically set = %d", app.RealIP)
 	}
+	if app.AgentID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			hostname = app.RealIP
+		}
+		app.AgentID = hostname
+		logger.Log.Infof("default agent id is automatically set = %s", app.AgentID)
+	}
 	if app.ServerProtocol == "" {
 		app.ServerProtocol = HTTP
 		logger.Log.Infof("default server protocol is automatically set = %s", app.ServerProtocol)
@@ -144,15 +214,35 @@ func Setup() (string, int, error) {
 	logger.Log.Infoln(
 		"Starting configuration:",
 		"ADDRESS", app.ServerAddress,
+		"GRPC_ADDRESS", app.GRPCAddress,
+		"TLS_CA_FILE", app.TLS.CAFile,
+		"TLS_CERT_FILE", app.TLS.CertFile,
+		"TLS_KEY_FILE", app.TLS.KeyFile,
 		"REPORT_INTERVAL", app.ReportInterval,
 		"POLL_INTERVAL", app.PollInterval,
 		"KEY", app.SecretKey,
+		"KEY_ID", app.KeyID,
 		"CRYPTO_KEY", app.CryptoKey,
 		"RATE_LIMIT", app.RateLimit,
 		"REAL_IP", app.RealIP,
 		"SERVER_PROTOCOL", app.ServerProtocol,
+		"AGENT_ID", app.AgentID,
 	)
 
+	// соединение с сервером по TLS, если задан CA сервера или сертификат агента
+	app.ClientTLS = nil
+	if app.TLS.CAFile != "" || app.TLS.CertFile != "" || app.TLS.KeyFile != "" {
+		tlsConfig, err := security.ClientTLSConfig(app.TLS.CAFile, app.TLS.CertFile, app.TLS.KeyFile)
+		if err != nil {
+			return "", 0, err
+		}
+		app.ClientTLS = tlsConfig
+	}
+	transport = nil
+	if app.ClientTLS != nil {
+		transport = &http.Transport{TLSClientConfig: app.ClientTLS}
+	}
+
 	// инициализация ключей ассиметричного шифрования
 	publicKey, err := security.GetPublicKeyPEM(app.CryptoKey)
 	if err != nil {
@@ -160,5 +250,71 @@ func Setup() (string, int, error) {
 	}
 	app.PublicKeyPEM = publicKey
 
+	// инициализация дополнительных коллекторов
+	collectors = newCollectors()
+
 	return app.ServerProtocol, app.RateLimit, nil
 }
+
+// newCollectors создает включенные в конфигурации коллекторы.
+// Коллектор, который не удалось создать, пропускается с ошибкой в логе.
+func newCollectors() []collector.Collector {
+	var res []collector.Collector
+
+	if app.Cgroup.Enabled {
+		c, err := collector.NewCgroup(app.Cgroup.Root)
+		if err != nil {
+			logger.Log.Errorf("failed NewCgroup()=%v", err)
+		} else {
+			logger.Log.Infof("cgroup v%d collector is enabled", c.Version())
+			res = append(res, c)
+		}
+	}
+
+	if app.Disk.Enabled {
+		mounts, errMounts := collector.NewFilter(app.Disk.Mounts.Include, app.Disk.Mounts.Exclude)
+		devices, errDevices := collector.NewFilter(app.Disk.Devices.Include, app.Disk.Devices.Exclude)
+		if err := errors.Join(errMounts, errDevices); err != nil {
+			logger.Log.Errorf("failed to configure disk collector: %v", err)
+		} else {
+			res = append(res, collector.NewDisk(mounts, devices))
+		}
+	}
+
+	if app.Net.Enabled {
+		interfaces, err := collector.NewFilter(app.Net.Interfaces.Include, app.Net.Interfaces.Exclude)
+		if err != nil {
+			logger.Log.Errorf("failed to configure net collector: %v", err)
+		} else {
+			res = append(res, collector.NewNet(interfaces))
+		}
+	}
+
+	if app.Runtime.Enabled {
+		filter, err := collector.NewFilter(app.Runtime.Metrics.Include, app.Runtime.Metrics.Exclude)
+		if err != nil {
+			logger.Log.Errorf("failed to configure runtime collector: %v", err)
+			// без коллектора возвращаемся к выборке runtime.MemStats
+			app.Runtime.Enabled = false
+		} else {
+			res = append(res, collector.NewRuntime(filter))
+		}
+	}
+
+	if len(app.Processes) > 0 {
+		var selectors []collector.ProcessSelector
+		for _, p := range app.Processes {
+			s, err := collector.NewProcessSelector(p.Label, p.PIDFile, p.Name, p.Cmdline)
+			if err != nil {
+				logger.Log.Errorf("failed NewProcessSelector()=%v", err)
+				continue
+			}
+			selectors = append(selectors, s)
+		}
+		if len(selectors) > 0 {
+			res = append(res, collector.NewProcess(selectors))
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+)
+
+// MaxNameLength максимальная длина имени метрики, которую принимает сервер.
+const MaxNameLength = 50
+
+// FitName укорачивает имя длиннее MaxNameLength: начало имени сохраняется, а конец заменяется
+// хешем полного имени, поэтому разные длинные имена, например с длинной точкой монтирования,
+// не совпадают после укорачивания.
+func FitName(name string) string {
+	if len(name) <= MaxNameLength {
+		return name
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	suffix := fmt.Sprintf("_%08x", h.Sum32())
+
+	return name[:MaxNameLength-len(suffix)] + suffix
+}
+
+// Registry хранит метрики, полученные от коллекторов, между опросом и отправкой.
+// Значения gauge замещаются, приращения counter накапливаются до следующей отправки.
+type Registry struct {
+	gauges   map[string]float64
+	counters map[string]int64
+	mu       sync.Mutex
+}
+
+// NewRegistry конструктор типа Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		gauges:   make(map[string]float64),
+		counters: make(map[string]int64),
+	}
+}
+
+// Update добавляет в реестр метрики очередного опроса. Длинные имена укорачиваются FitName,
+// иначе сервер отклонит весь батч с такой метрикой.
+func (r *Registry) Update(metrics []RequestMetric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, m := range metrics {
+		id := FitName(m.ID)
+		switch m.MType {
+		case "gauge":
+			r.gauges[id] = m.Value
+		case "counter":
+			r.counters[id] += m.Delta
+		}
+	}
+}
+
+// Flush возвращает накопленные метрики, отсортированные по имени, и обнуляет приращения counter.
+func (r *Registry) Flush() []RequestMetric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	res := make([]RequestMetric, 0, len(r.gauges)+len(r.counters))
+	for id, value := range r.gauges {
+		res = append(res, RequestMetric{ID: id, MType: "gauge", Value: value})
+	}
+	for id, delta := range r.counters {
+		res = append(res, RequestMetric{ID: id, MType: "counter", Delta: delta})
+		// счетчик остается в реестре, чтобы нулевое приращение тоже отправлялось
+		r.counters[id] = 0
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ID < res[j].ID
+	})
+
+	return res
+}
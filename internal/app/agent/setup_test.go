@@ -1,7 +1,9 @@
 package agent
 
 import (
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/config"
 	"github.com/webkimru/go-yandex-metrics/internal/app/server/grpc"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/inventory"
 	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories/store"
 	"os"
 	"testing"
@@ -11,7 +13,8 @@ func TestMain(m *testing.M) {
 	db := store.NewFakeStorage()
 
 	repoGRPC := grpc.NewRepo(db)
-	grpc.NewMetricHandlers(repoGRPC)
+	repoGRPC.Agents = inventory.NewRegistry(config.AgentsConfig{ReportInterval: 10, StaleIntervals: 3})
+	grpc.NewMetricHandlers(repoGRPC, &config.AppConfig{})
 
 	os.Exit(m.Run())
 }
@@ -0,0 +1,203 @@
+package alert
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/logger"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories"
+)
+
+// Состояния алерта.
+const (
+	StatePending  = "pending"  // условие выполняется меньше заданного времени for
+	StateFiring   = "firing"   // условие выполняется не меньше времени for
+	StateResolved = "resolved" // условие перестало выполняться
+)
+
+// Alert состояние правила, условие которого выполнялось.
+type Alert struct {
+	Rule       string            `json:"rule"`
+	Expr       string            `json:"expr"`
+	Metric     string            `json:"metric"`
+	MType      string            `json:"type"`
+	State      string            `json:"state"`
+	Value      float64           `json:"value"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	ActiveAt   time.Time         `json:"active_at"`
+	FiredAt    *time.Time        `json:"fired_at,omitempty"`
+	ResolvedAt *time.Time        `json:"resolved_at,omitempty"`
+}
+
+// Notifier получает переходы алертов между состояниями - контракт.
+type Notifier interface {
+	Notify(alerts ...Alert)
+}
+
+// sample значение счетчика на момент прошлого вычисления для rate().
+type sample struct {
+	value int64
+	at    time.Time
+}
+
+// Evaluator периодически вычисляет правила по метрикам хранилища.
+type Evaluator struct {
+	store    repositories.StoreRepository
+	rules    []Rule
+	notifier Notifier
+
+	mu      sync.Mutex
+	alerts  map[string]*Alert // активные алерты по имени правила
+	samples map[string]sample // прошлые значения счетчиков по имени правила
+}
+
+// NewEvaluator конструктор типа Evaluator. notifier может быть nil.
+func NewEvaluator(store repositories.StoreRepository, rules []Rule, notifier Notifier) *Evaluator {
+	return &Evaluator{
+		store:    store,
+		rules:    rules,
+		notifier: notifier,
+		alerts:   make(map[string]*Alert),
+		samples:  make(map[string]sample),
+	}
+}
+
+// Run вычисляет правила каждые interval до отмены контекста.
+func (e *Evaluator) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			e.Evaluate(ctx, now)
+		}
+	}
+}
+
+// Evaluate однократно вычисляет все правила на момент now и рассылает переходы состояний.
+func (e *Evaluator) Evaluate(ctx context.Context, now time.Time) {
+	e.mu.Lock()
+	var transitions []Alert
+	for i := range e.rules {
+		if a := e.evaluate(ctx, &e.rules[i], now); a != nil {
+			transitions = append(transitions, *a)
+		}
+	}
+	e.mu.Unlock()
+
+	if len(transitions) > 0 && e.notifier != nil {
+		e.notifier.Notify(transitions...)
+	}
+}
+
+// evaluate вычисляет правило и возвращает алерт, если его состояние изменилось.
+func (e *Evaluator) evaluate(ctx context.Context, rule *Rule, now time.Time) *Alert {
+	value, ok := e.value(ctx, rule, now)
+	if !ok {
+		// нет данных для вычисления - состояние не меняем
+		return nil
+	}
+
+	active := e.alerts[rule.Name]
+	if !rule.Match(value) {
+		if active == nil {
+			return nil
+		}
+		delete(e.alerts, rule.Name)
+		// разрешение рассылается только для сработавшего алерта, pending просто сбрасывается
+		if active.State != StateFiring {
+			return nil
+		}
+		active.State = StateResolved
+		active.Value = value
+		active.ResolvedAt = &now
+		return active
+	}
+
+	if active == nil {
+		active = &Alert{
+			Rule:     rule.Name,
+			Expr:     rule.Expr,
+			Metric:   rule.Metric,
+			MType:    rule.MType,
+			State:    StatePending,
+			Labels:   rule.Labels,
+			ActiveAt: now,
+		}
+		e.alerts[rule.Name] = active
+		if rule.For > 0 {
+			active.Value = value
+			res := *active
+			return &res
+		}
+	}
+	active.Value = value
+
+	if active.State == StatePending && now.Sub(active.ActiveAt) >= rule.For {
+		active.State = StateFiring
+		active.FiredAt = &now
+		res := *active
+		return &res
+	}
+
+	return nil
+}
+
+// value возвращает значение метрики правила, false - если данных пока нет.
+func (e *Evaluator) value(ctx context.Context, rule *Rule, now time.Time) (float64, bool) {
+	switch rule.MType {
+	case "gauge":
+		v, err := e.store.GetGauge(ctx, rule.Metric)
+		if err != nil {
+			logger.Log.Debugf("rule %s: %v", rule.Name, err)
+			return 0, false
+		}
+		return v, true
+
+	case "counter":
+		v, err := e.store.GetCounter(ctx, rule.Metric)
+		if err != nil {
+			logger.Log.Debugf("rule %s: %v", rule.Name, err)
+			return 0, false
+		}
+		if rule.Func != FuncRate {
+			return float64(v), true
+		}
+
+		prev, ok := e.samples[rule.Name]
+		e.samples[rule.Name] = sample{value: v, at: now}
+		seconds := now.Sub(prev.at).Seconds()
+		if !ok || seconds <= 0 {
+			return 0, false
+		}
+		// при сбросе счетчика приращением считается само значение
+		delta := v - prev.value
+		if delta < 0 {
+			delta = v
+		}
+		return float64(delta) / seconds, true
+	}
+
+	return 0, false
+}
+
+// Alerts возвращает активные алерты (pending и firing), упорядоченные по имени правила.
+func (e *Evaluator) Alerts() []Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	res := make([]Alert, 0, len(e.alerts))
+	for _, a := range e.alerts {
+		res = append(res, *a)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Rule < res[j].Rule
+	})
+
+	return res
+}
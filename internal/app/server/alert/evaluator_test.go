@@ -0,0 +1,165 @@
+package alert
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/models"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories/store"
+)
+
+type fakeNotifier struct {
+	mu     sync.Mutex
+	alerts []Alert
+}
+
+func (n *fakeNotifier) Notify(alerts ...Alert) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.alerts = append(n.alerts, alerts...)
+}
+
+func (n *fakeNotifier) states() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var res []string
+	for _, a := range n.alerts {
+		res = append(res, a.Rule+":"+a.State)
+	}
+	return res
+}
+
+func parseRules(t *testing.T, exprs map[string]string) []Rule {
+	var rules []Rule
+	for name, expr := range exprs {
+		rule := Rule{Name: name, Expr: expr}
+		require.NoError(t, rule.Parse())
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+func TestEvaluatorGauge(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMemStorage()
+	notifier := &fakeNotifier{}
+	e := NewEvaluator(db, parseRules(t, map[string]string{"HighHeap": "gauge HeapAlloc > 100 for 2m"}), notifier)
+	now := time.Now()
+
+	// нет данных
+	e.Evaluate(ctx, now)
+	assert.Empty(t, e.Alerts())
+
+	_, err := db.UpdateGauge(ctx, "HeapAlloc", 200)
+	require.NoError(t, err)
+	e.Evaluate(ctx, now)
+	require.Len(t, e.Alerts(), 1)
+	assert.Equal(t, StatePending, e.Alerts()[0].State)
+
+	e.Evaluate(ctx, now.Add(time.Minute))
+	assert.Equal(t, StatePending, e.Alerts()[0].State)
+
+	e.Evaluate(ctx, now.Add(2*time.Minute))
+	assert.Equal(t, StateFiring, e.Alerts()[0].State)
+	assert.Equal(t, float64(200), e.Alerts()[0].Value)
+
+	_, err = db.UpdateGauge(ctx, "HeapAlloc", 50)
+	require.NoError(t, err)
+	e.Evaluate(ctx, now.Add(3*time.Minute))
+	assert.Empty(t, e.Alerts())
+
+	assert.Equal(t, []string{"HighHeap:pending", "HighHeap:firing", "HighHeap:resolved"}, notifier.states())
+	assert.NotNil(t, notifier.alerts[2].ResolvedAt)
+}
+
+func TestEvaluatorPendingReset(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMemStorage()
+	notifier := &fakeNotifier{}
+	e := NewEvaluator(db, parseRules(t, map[string]string{"HighHeap": "gauge HeapAlloc > 100 for 2m"}), notifier)
+	now := time.Now()
+
+	_, err := db.UpdateGauge(ctx, "HeapAlloc", 200)
+	require.NoError(t, err)
+	e.Evaluate(ctx, now)
+	require.Len(t, e.Alerts(), 1)
+
+	// условие перестало выполняться до истечения for - алерт не срабатывал, resolved не рассылается
+	_, err = db.UpdateGauge(ctx, "HeapAlloc", 50)
+	require.NoError(t, err)
+	e.Evaluate(ctx, now.Add(time.Minute))
+	assert.Empty(t, e.Alerts())
+
+	assert.Equal(t, []string{"HighHeap:pending"}, notifier.states())
+}
+
+func TestEvaluatorCounterRate(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMemStorage()
+	notifier := &fakeNotifier{}
+	e := NewEvaluator(db, parseRules(t, map[string]string{"AgentSilent": "counter rate(PollCount) == 0"}), notifier)
+	now := time.Now()
+
+	_, err := db.UpdateCounter(ctx, "PollCount", 10)
+	require.NoError(t, err)
+	// первое вычисление только запоминает значение
+	e.Evaluate(ctx, now)
+	assert.Empty(t, e.Alerts())
+
+	_, err = db.UpdateCounter(ctx, "PollCount", 10)
+	require.NoError(t, err)
+	e.Evaluate(ctx, now.Add(10*time.Second))
+	assert.Empty(t, e.Alerts())
+
+	// счетчик не растет - правило без for срабатывает сразу
+	e.Evaluate(ctx, now.Add(20*time.Second))
+	require.Len(t, e.Alerts(), 1)
+	assert.Equal(t, StateFiring, e.Alerts()[0].State)
+
+	_, err = db.UpdateCounter(ctx, "PollCount", 1)
+	require.NoError(t, err)
+	e.Evaluate(ctx, now.Add(30*time.Second))
+	assert.Empty(t, e.Alerts())
+
+	assert.Equal(t, []string{"AgentSilent:firing", "AgentSilent:resolved"}, notifier.states())
+}
+
+// TestEvaluatorConcurrentUpdates вычисляет правила, пока метрики обновляются батчами.
+// Гонку чтения и записи хранилища ловит go test -race.
+func TestEvaluatorConcurrentUpdates(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMemStorage()
+	e := NewEvaluator(db, parseRules(t, map[string]string{
+		"HighHeap":    "gauge HeapAlloc > 100",
+		"AgentSilent": "counter rate(PollCount) == 0",
+	}), &fakeNotifier{})
+	now := time.Now()
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				delta, value := int64(1), float64(w*i)
+				_, err := db.UpdateBatchMetrics(ctx, models.Batch{Metrics: []models.Metrics{
+					{ID: "PollCount", MType: "counter", Delta: &delta},
+					{ID: "HeapAlloc", MType: "gauge", Value: &value},
+				}})
+				assert.NoError(t, err)
+			}
+		}(w)
+	}
+	for i := 0; i < 200; i++ {
+		e.Evaluate(ctx, now.Add(time.Duration(i)*time.Second))
+	}
+	wg.Wait()
+
+	v, err := db.GetCounter(ctx, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(800), v)
+}
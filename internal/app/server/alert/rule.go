@@ -0,0 +1,125 @@
+// Package alert вычисляет правила алертинга по метрикам хранилища и отправляет уведомления.
+package alert
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Функции над значением метрики.
+const (
+	FuncValue = ""     // текущее значение
+	FuncRate  = "rate" // скорость изменения в секунду между вычислениями
+)
+
+// Rule правило алертинга, например "gauge HeapAlloc > 1e9 for 2m"
+// или "counter rate(PollCount) == 0 for 5m".
+type Rule struct {
+	Name   string            `json:"name" yaml:"name"`
+	Expr   string            `json:"expr" yaml:"expr"`
+	Labels map[string]string `json:"labels,omitempty" yaml:"labels"`
+
+	// поля разобранного выражения
+	MType     string        `json:"-" yaml:"-"`
+	Func      string        `json:"-" yaml:"-"`
+	Metric    string        `json:"-" yaml:"-"`
+	Op        string        `json:"-" yaml:"-"`
+	Threshold float64       `json:"-" yaml:"-"`
+	For       time.Duration `json:"-" yaml:"-"`
+}
+
+// RulesFile содержимое файла с правилами.
+type RulesFile struct {
+	Rules []Rule `json:"rules" yaml:"rules"`
+}
+
+var exprPattern = regexp.MustCompile(
+	`^\s*(gauge|counter)\s+(?:(rate)\(\s*([^\s()]+)\s*\)|([^\s()<>=!]+))\s*(>=|<=|==|!=|>|<)\s*(\S+)(?:\s+for\s+(\S+))?\s*$`,
+)
+
+// Parse разбирает выражение правила.
+func (r *Rule) Parse() error {
+	m := exprPattern.FindStringSubmatch(r.Expr)
+	if m == nil {
+		return fmt.Errorf("rule %q: invalid expression %q", r.Name, r.Expr)
+	}
+
+	r.MType, r.Func, r.Metric, r.Op = m[1], m[2], m[3]+m[4], m[5]
+	if r.Func == FuncRate && r.MType != "counter" {
+		return fmt.Errorf("rule %q: rate() is supported only for counter", r.Name)
+	}
+	threshold, err := strconv.ParseFloat(m[6], 64)
+	if err != nil {
+		return fmt.Errorf("rule %q: invalid threshold %q", r.Name, m[6])
+	}
+	r.Threshold = threshold
+	r.For = 0
+	if m[7] != "" {
+		if r.For, err = time.ParseDuration(m[7]); err != nil {
+			return fmt.Errorf("rule %q: invalid duration %q", r.Name, m[7])
+		}
+	}
+
+	return nil
+}
+
+// Match сообщает, выполняется ли условие правила для значения.
+func (r *Rule) Match(v float64) bool {
+	switch r.Op {
+	case ">":
+		return v > r.Threshold
+	case ">=":
+		return v >= r.Threshold
+	case "<":
+		return v < r.Threshold
+	case "<=":
+		return v <= r.Threshold
+	case "==":
+		return v == r.Threshold
+	case "!=":
+		return v != r.Threshold
+	}
+	return false
+}
+
+// LoadRules читает правила из YAML- или JSON-файла (по расширению .json).
+func LoadRules(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed loading rules from file=%s: %w", path, err)
+	}
+
+	var file RulesFile
+	if filepath.Ext(path) == ".json" {
+		err = json.Unmarshal(data, &file)
+	} else {
+		err = yaml.Unmarshal(data, &file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed unmarshaling rules from file=%s: %w", path, err)
+	}
+
+	names := make(map[string]bool, len(file.Rules))
+	for i := range file.Rules {
+		rule := &file.Rules[i]
+		if rule.Name == "" {
+			return nil, fmt.Errorf("rule %d: name is required", i)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("rule %q: duplicate name", rule.Name)
+		}
+		names[rule.Name] = true
+		if err = rule.Parse(); err != nil {
+			return nil, err
+		}
+	}
+
+	return file.Rules, nil
+}
@@ -0,0 +1,71 @@
+package alert
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuleParse(t *testing.T) {
+	tests := []struct {
+		expr    string
+		want    Rule
+		wantErr bool
+	}{
+		{expr: "gauge HeapAlloc > 1e9 for 2m", want: Rule{MType: "gauge", Metric: "HeapAlloc", Op: ">", Threshold: 1e9, For: 2 * time.Minute}},
+		{expr: "counter rate(PollCount) == 0 for 5m", want: Rule{MType: "counter", Func: FuncRate, Metric: "PollCount", Op: "==", Threshold: 0, For: 5 * time.Minute}},
+		{expr: "counter PollCount>=10", want: Rule{MType: "counter", Metric: "PollCount", Op: ">=", Threshold: 10}},
+		{expr: "gauge rate(HeapAlloc) > 1", wantErr: true},
+		{expr: "histogram Latency > 1", wantErr: true},
+		{expr: "gauge HeapAlloc > big", wantErr: true},
+		{expr: "gauge HeapAlloc > 1 for ever", wantErr: true},
+		{expr: "gauge HeapAlloc", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			rule := Rule{Name: "test", Expr: tt.expr}
+			err := rule.Parse()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			tt.want.Name, tt.want.Expr = rule.Name, rule.Expr
+			assert.Equal(t, tt.want, rule)
+		})
+	}
+}
+
+func TestRuleMatch(t *testing.T) {
+	for op, want := range map[string][3]bool{
+		">":  {false, false, true},
+		">=": {false, true, true},
+		"<":  {true, false, false},
+		"<=": {true, true, false},
+		"==": {false, true, false},
+		"!=": {true, false, true},
+	} {
+		rule := Rule{Op: op, Threshold: 1}
+		assert.Equal(t, want, [3]bool{rule.Match(0), rule.Match(1), rule.Match(2)}, op)
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	rules, err := LoadRules("testdata/rules.yaml")
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	assert.Equal(t, "HighHeap", rules[0].Name)
+	assert.Equal(t, map[string]string{"severity": "warning"}, rules[0].Labels)
+	assert.Equal(t, FuncRate, rules[1].Func)
+	assert.Equal(t, 5*time.Minute, rules[1].For)
+
+	rules, err = LoadRules("testdata/rules.json")
+	require.NoError(t, err)
+	require.Len(t, rules, 1)
+	assert.Equal(t, 2*time.Minute, rules[0].For)
+
+	_, err = LoadRules("testdata/none.yaml")
+	assert.Error(t, err)
+}
@@ -0,0 +1,159 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/logger"
+)
+
+// backoff интервалы между повторными отправками уведомления: 1s, 3s, 5s.
+var backoff = []time.Duration{1 * time.Second, 3 * time.Second, 5 * time.Second}
+
+// Notification тело запроса вебхука: переходы алертов одной группы.
+type Notification struct {
+	GroupLabels map[string]string `json:"group_labels"`
+	Alerts      []Alert           `json:"alerts"`
+}
+
+// Webhook отправляет переходы алертов POST-запросом с JSON.
+// Переходы, накопленные за groupWait, объединяются в группы по меткам groupBy,
+// каждая группа отправляется отдельным запросом с повторами при ошибках.
+type Webhook struct {
+	url       string
+	groupWait time.Duration
+	groupBy   []string
+	backoff   []time.Duration
+	client    *http.Client
+	queue     chan Alert
+}
+
+// NewWebhook конструктор типа Webhook.
+func NewWebhook(url string, groupWait time.Duration, groupBy []string) *Webhook {
+	return &Webhook{
+		url:       url,
+		groupWait: groupWait,
+		groupBy:   groupBy,
+		backoff:   backoff,
+		client:    &http.Client{Timeout: 5 * time.Second},
+		queue:     make(chan Alert, 1000),
+	}
+}
+
+// Notify ставит переходы в очередь отправки, при переполнении очереди переход отбрасывается.
+func (w *Webhook) Notify(alerts ...Alert) {
+	for _, a := range alerts {
+		select {
+		case w.queue <- a:
+		default:
+			logger.Log.Errorf("alert webhook queue is full, dropping alert=%s state=%s", a.Rule, a.State)
+		}
+	}
+}
+
+// Run группирует и отправляет уведомления до отмены контекста.
+func (w *Webhook) Run(ctx context.Context) {
+	var pending []Alert
+	var flush <-chan time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case a := <-w.queue:
+			// первое уведомление группы запускает ожидание остальных
+			if len(pending) == 0 {
+				flush = time.After(w.groupWait)
+			}
+			pending = append(pending, a)
+		case <-flush:
+			for _, n := range w.group(pending) {
+				if err := w.send(ctx, n); err != nil {
+					logger.Log.Errorf("failed to send alert notification: %v", err)
+				}
+			}
+			pending, flush = nil, nil
+		}
+	}
+}
+
+// group объединяет алерты по значениям меток groupBy.
+func (w *Webhook) group(alerts []Alert) []Notification {
+	index := make(map[string]int)
+	var res []Notification
+	for _, a := range alerts {
+		labels := make(map[string]string, len(w.groupBy))
+		values := make([]string, 0, len(w.groupBy))
+		for _, l := range w.groupBy {
+			labels[l] = a.Labels[l]
+			values = append(values, a.Labels[l])
+		}
+		key := strings.Join(values, "\x00")
+		i, ok := index[key]
+		if !ok {
+			i = len(res)
+			index[key] = i
+			res = append(res, Notification{GroupLabels: labels})
+		}
+		res[i].Alerts = append(res[i].Alerts, a)
+	}
+	for _, n := range res {
+		sort.SliceStable(n.Alerts, func(i, j int) bool {
+			return n.Alerts[i].Rule < n.Alerts[j].Rule
+		})
+	}
+
+	return res
+}
+
+// send отправляет уведомление, повторяя попытку при сетевой ошибке или ответе 5xx.
+func (w *Webhook) send(ctx context.Context, n Notification) error {
+	data, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; ; attempt++ {
+		err = w.post(ctx, data)
+		if err == nil || attempt == len(w.backoff) {
+			return err
+		}
+
+		logger.Log.Infof("failed to send alert notification: %v, retrying in %s", err, w.backoff[attempt])
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(w.backoff[attempt]):
+		}
+	}
+}
+
+func (w *Webhook) post(ctx context.Context, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("webhook responded with status code %d", resp.StatusCode)
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		// запрос отклонен получателем, повтор не поможет
+		logger.Log.Errorf("webhook rejected notification with status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
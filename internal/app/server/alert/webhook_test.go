@@ -0,0 +1,63 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookGroupAndRetry(t *testing.T) {
+	var mu sync.Mutex
+	var attempts int
+	var received []Notification
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		// первая попытка завершается ошибкой и повторяется
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var n Notification
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&n))
+		received = append(received, n)
+	}))
+	defer srv.Close()
+
+	webhook := NewWebhook(srv.URL, 50*time.Millisecond, []string{"severity"})
+	webhook.backoff = []time.Duration{10 * time.Millisecond}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go webhook.Run(ctx)
+
+	webhook.Notify(
+		Alert{Rule: "HighHeap", State: StateFiring, Labels: map[string]string{"severity": "warning"}},
+		Alert{Rule: "AgentSilent", State: StateFiring, Labels: map[string]string{"severity": "critical"}},
+		Alert{Rule: "HighCPU", State: StatePending, Labels: map[string]string{"severity": "warning"}},
+	)
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received) == 2
+	}, 2*time.Second, 10*time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 3, attempts)
+	groups := map[string][]string{}
+	for _, n := range received {
+		for _, a := range n.Alerts {
+			groups[n.GroupLabels["severity"]] = append(groups[n.GroupLabels["severity"]], a.Rule)
+		}
+	}
+	assert.Equal(t, map[string][]string{"warning": {"HighCPU", "HighHeap"}, "critical": {"AgentSilent"}}, groups)
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrInvalidToken токен не передан, не найден или не совпал с сохраненным хешем.
+var ErrInvalidToken = errors.New("invalid token")
+
+// Authenticator проверяет, выдает и отзывает токены.
+type Authenticator struct {
+	store TokenStore
+}
+
+// New возвращает Authenticator поверх хранилища токенов.
+func New(store TokenStore) *Authenticator {
+	return &Authenticator{store: store}
+}
+
+// Authenticate находит токен по значению из запроса.
+func (a *Authenticator) Authenticate(ctx context.Context, raw string) (*Token, error) {
+	id, ok := tokenID(raw)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	t, err := a.store.Get(ctx, id)
+	if errors.Is(err, ErrTokenNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(HashToken(raw)), []byte(t.Hash)) != 1 {
+		return nil, ErrInvalidToken
+	}
+
+	return &t, nil
+}
+
+// Issue выдает новый токен и возвращает его значение: оно показывается один раз и нигде не хранится.
+// Токен с тенантом дает доступ только к метрикам этого тенанта.
+func (a *Authenticator) Issue(ctx context.Context, name string, scopes []string, prefix, tenant string) (string, Token, error) {
+	scopes, err := ParseScopes(scopes)
+	if err != nil {
+		return "", Token{}, err
+	}
+	id, raw, err := newRaw()
+	if err != nil {
+		return "", Token{}, err
+	}
+	t := Token{
+		ID:        id,
+		Name:      name,
+		Hash:      HashToken(raw),
+		Scopes:    scopes,
+		Prefix:    prefix,
+		Tenant:    tenant,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	if err = a.store.Add(ctx, t); err != nil {
+		return "", Token{}, err
+	}
+
+	return raw, t, nil
+}
+
+// Get возвращает выданный токен по идентификатору.
+func (a *Authenticator) Get(ctx context.Context, id string) (Token, error) {
+	return a.store.Get(ctx, id)
+}
+
+// Revoke отзывает токен.
+func (a *Authenticator) Revoke(ctx context.Context, id string) error {
+	return a.store.Revoke(ctx, id)
+}
+
+// List возвращает выданные токены.
+func (a *Authenticator) List(ctx context.Context) ([]Token, error) {
+	return a.store.List(ctx)
+}
+
+// FromAuthorization извлекает токен из заголовка или metadata вида "Bearer <token>".
+func FromAuthorization(authorization string) string {
+	raw, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok {
+		return ""
+	}
+	return raw
+}
+
+type tokenKey struct{}
+
+// WithToken сохраняет проверенный токен в контексте запроса.
+func WithToken(ctx context.Context, t *Token) context.Context {
+	return context.WithValue(ctx, tokenKey{}, t)
+}
+
+// FromContext возвращает токен запроса, nil - запрос без токена.
+func FromContext(ctx context.Context) *Token {
+	t, _ := ctx.Value(tokenKey{}).(*Token)
+	return t
+}
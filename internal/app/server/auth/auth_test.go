@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenScopes(t *testing.T) {
+	read := &Token{Scopes: []string{ScopeRead}, Prefix: "app."}
+	assert.True(t, read.Allows(ScopeRead))
+	assert.False(t, read.Allows(ScopeWrite))
+	assert.False(t, read.Allows(ScopeAdmin))
+	assert.True(t, read.AllowsMetric("app.requests"))
+	assert.False(t, read.AllowsMetric("PollCount"))
+
+	admin := &Token{Scopes: []string{ScopeAdmin}}
+	assert.True(t, admin.Allows(ScopeWrite))
+	assert.True(t, admin.AllowsMetric("PollCount"))
+
+	// запрос без токена ничем не ограничен
+	var none *Token
+	assert.True(t, none.Allows(ScopeAdmin))
+	assert.True(t, none.AllowsMetric("PollCount"))
+	assert.Empty(t, none.MetricPrefix())
+
+	// токен управляет токенами своего тенанта внутри своего префикса
+	scoped := &Token{Scopes: []string{ScopeAdmin}, Prefix: "app.", Tenant: "team_a"}
+	assert.True(t, scoped.Manages(Token{Prefix: "app.", Tenant: "team_a"}))
+	assert.True(t, scoped.Manages(Token{Prefix: "app.api.", Tenant: "team_a"}))
+	assert.False(t, scoped.Manages(Token{Tenant: "team_a"}))
+	assert.False(t, scoped.Manages(Token{Prefix: "app.", Tenant: "team_b"}))
+	assert.False(t, scoped.Manages(Token{Prefix: "app."}))
+	assert.True(t, admin.Manages(Token{Prefix: "app.", Tenant: "team_b"}))
+	assert.True(t, none.Manages(Token{}))
+
+	_, err := ParseScopes(nil)
+	assert.Error(t, err)
+	_, err = ParseScopes([]string{"read", "root"})
+	assert.Error(t, err)
+}
+
+func TestAuthenticator(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	store, err := NewFileStore(path)
+	require.NoError(t, err)
+	a := New(store)
+
+	raw, token, err := a.Issue(ctx, "agent", []string{ScopeWrite}, "host1.", "")
+	require.NoError(t, err)
+	assert.Equal(t, HashToken(raw), token.Hash)
+
+	got, err := a.Authenticate(ctx, raw)
+	require.NoError(t, err)
+	assert.Equal(t, token.ID, got.ID)
+	assert.Equal(t, "host1.", got.Prefix)
+
+	for _, bad := range []string{"", raw + "x", token.ID, "none.secret"} {
+		_, err = a.Authenticate(ctx, bad)
+		assert.ErrorIs(t, err, ErrInvalidToken, bad)
+	}
+
+	// в файле хранится только хеш, токены переживают перезапуск
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), raw)
+	reloaded, err := NewFileStore(path)
+	require.NoError(t, err)
+	_, err = New(reloaded).Authenticate(ctx, raw)
+	require.NoError(t, err)
+
+	require.NoError(t, a.Revoke(ctx, token.ID))
+	assert.ErrorIs(t, a.Revoke(ctx, token.ID), ErrTokenNotFound)
+	_, err = a.Authenticate(ctx, raw)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	list, err := a.List(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, list)
+}
+
+func TestFileStoreInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"tokens":[{"id":"a","hash":"b","scopes":["root"]}]}`), 0600))
+	_, err := NewFileStore(path)
+	assert.Error(t, err)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"tokens":[{"id":"a","scopes":["read"]}]}`), 0600))
+	_, err = NewFileStore(path)
+	assert.Error(t, err)
+}
@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// ErrTokenNotFound токена с таким идентификатором нет.
+var ErrTokenNotFound = errors.New("token not found")
+
+// TokenStore хранилище выданных токенов.
+type TokenStore interface {
+	List(ctx context.Context) ([]Token, error)
+	Get(ctx context.Context, id string) (Token, error)
+	Add(ctx context.Context, token Token) error
+	Revoke(ctx context.Context, id string) error
+}
+
+// tokensFile формат файла токенов.
+type tokensFile struct {
+	Tokens []Token `json:"tokens"`
+}
+
+// FileStore хранит токены в JSON-файле вида {"tokens": [...]}.
+// Файл читается при создании хранилища и перезаписывается целиком при выдаче и отзыве токенов.
+type FileStore struct {
+	mu     sync.Mutex
+	path   string
+	tokens map[string]Token
+}
+
+// NewFileStore загружает токены из файла. Отсутствующий файл - пустое хранилище.
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{path: path, tokens: make(map[string]Token)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var f tokensFile
+	if err = json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed unmarshaling tokens from file=%s: %w", path, err)
+	}
+	for _, t := range f.Tokens {
+		if t.ID == "" || t.Hash == "" {
+			return nil, fmt.Errorf("token without id or hash in file=%s", path)
+		}
+		if t.Scopes, err = ParseScopes(t.Scopes); err != nil {
+			return nil, fmt.Errorf("token id=%s: %w", t.ID, err)
+		}
+		s.tokens[t.ID] = t
+	}
+
+	return s, nil
+}
+
+// List возвращает токены в порядке выдачи.
+func (s *FileStore) List(_ context.Context) ([]Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.list(), nil
+}
+
+// Get возвращает токен по идентификатору.
+func (s *FileStore) Get(_ context.Context, id string) (Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tokens[id]
+	if !ok {
+		return Token{}, ErrTokenNotFound
+	}
+	return t, nil
+}
+
+// Add сохраняет новый токен.
+func (s *FileStore) Add(_ context.Context, token Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[token.ID] = token
+	if err := s.save(); err != nil {
+		delete(s.tokens, token.ID)
+		return err
+	}
+	return nil
+}
+
+// Revoke удаляет токен.
+func (s *FileStore) Revoke(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tokens[id]
+	if !ok {
+		return ErrTokenNotFound
+	}
+	delete(s.tokens, id)
+	if err := s.save(); err != nil {
+		s.tokens[id] = t
+		return err
+	}
+	return nil
+}
+
+func (s *FileStore) list() []Token {
+	res := make([]Token, 0, len(s.tokens))
+	for _, t := range s.tokens {
+		res = append(res, t)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if !res[i].CreatedAt.Equal(res[j].CreatedAt) {
+			return res[i].CreatedAt.Before(res[j].CreatedAt)
+		}
+		return res[i].ID < res[j].ID
+	})
+	return res
+}
+
+// save записывает файл через временный файл, чтобы при сбое не потерять ранее выданные токены.
+func (s *FileStore) save() error {
+	data, err := json.MarshalIndent(tokensFile{Tokens: s.list()}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -0,0 +1,115 @@
+// Package auth проверяет токены доступа к серверу: области действия read, write и admin
+// и необязательное ограничение по префиксу имени метрики.
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Области действия токена.
+const (
+	ScopeRead  = "read"  // чтение метрик, дашборд, поток обновлений
+	ScopeWrite = "write" // отправка метрик
+	ScopeAdmin = "admin" // удаление и сброс метрик, выдача и отзыв токенов; включает read и write
+)
+
+// Token выданный токен. Сам токен не хранится, хранится только его хеш.
+type Token struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name,omitempty"`
+	Hash      string    `json:"hash,omitempty"` // sha256 токена в hex
+	Scopes    []string  `json:"scopes"`
+	Prefix    string    `json:"prefix,omitempty"` // токену доступны только метрики с этим префиксом имени
+	Tenant    string    `json:"tenant,omitempty"` // токену доступны только метрики этого тенанта
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Allows сообщает, разрешена ли токену область действия. Токен admin разрешает все.
+// nil-токен (аутентификация выключена или пройдена административным ключом) разрешает все.
+func (t *Token) Allows(scope string) bool {
+	if t == nil {
+		return true
+	}
+	for _, s := range t.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsMetric сообщает, доступна ли токену метрика с заданным именем.
+func (t *Token) AllowsMetric(name string) bool {
+	return t == nil || strings.HasPrefix(name, t.Prefix)
+}
+
+// MetricPrefix возвращает префикс имени метрик, доступных токену.
+func (t *Token) MetricPrefix() string {
+	if t == nil {
+		return ""
+	}
+	return t.Prefix
+}
+
+// Manages сообщает, может ли токен видеть и отзывать другой токен: другой токен должен быть выдан
+// для того же тенанта и на метрики внутри префикса токена. Запрос без токена управляет любыми токенами.
+func (t *Token) Manages(other Token) bool {
+	if t == nil {
+		return true
+	}
+	if t.Tenant != "" && other.Tenant != t.Tenant {
+		return false
+	}
+	return strings.HasPrefix(other.Prefix, t.Prefix)
+}
+
+// TenantName возвращает тенанта, для которого выдан токен, "" - токен не привязан к тенанту.
+func (t *Token) TenantName() string {
+	if t == nil {
+		return ""
+	}
+	return t.Tenant
+}
+
+// ParseScopes проверяет список областей действия.
+func ParseScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("at least one scope is required")
+	}
+	res := make([]string, 0, len(scopes))
+	for _, s := range scopes {
+		s = strings.TrimSpace(s)
+		if s != ScopeRead && s != ScopeWrite && s != ScopeAdmin {
+			return nil, fmt.Errorf("unknown scope=%q", s)
+		}
+		res = append(res, s)
+	}
+	return res, nil
+}
+
+// HashToken возвращает хеш токена для хранения: sha256 в hex.
+func HashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRaw генерирует токен вида <id>.<secret>: по идентификатору токен находится в хранилище.
+func newRaw() (id, raw string, err error) {
+	buf := make([]byte, 8+24)
+	if _, err = rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	id = hex.EncodeToString(buf[:8])
+	return id, id + "." + hex.EncodeToString(buf[8:]), nil
+}
+
+// tokenID возвращает идентификатор из токена вида <id>.<secret>.
+func tokenID(raw string) (string, bool) {
+	id, secret, ok := strings.Cut(raw, ".")
+	return id, ok && id != "" && secret != ""
+}
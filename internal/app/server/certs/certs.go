@@ -0,0 +1,189 @@
+// Package certs загружает сертификат сервера и CA клиентских сертификатов и перечитывает их
+// при изменении файлов или по сигналу SIGHUP без перезапуска сервера.
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/logger"
+)
+
+// ErrNoClientCert клиент не предъявил сертификат.
+var ErrNoClientCert = errors.New("client certificate required")
+
+// Reloader хранит текущие сертификат сервера и CA клиентских сертификатов.
+type Reloader struct {
+	certFile string
+	keyFile  string
+	caFile   string // пустой - сертификат клиента не запрашивается
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	pool    *x509.CertPool
+	modTime time.Time // время изменения самого свежего из файлов
+}
+
+// New загружает сертификат и ключ сервера и, если задан caFile, CA клиентских сертификатов.
+func New(certFile, keyFile, caFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload перечитывает файлы. При ошибке продолжают использоваться загруженные ранее сертификаты.
+func (r *Reloader) Reload() error {
+	modTime, err := r.lastModified()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed LoadX509KeyPair()=%w", err)
+	}
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		ca, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return fmt.Errorf("failed ReadFile()=%w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return fmt.Errorf("no certificates found in %s", r.caFile)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cert, r.pool, r.modTime = &cert, pool, modTime
+
+	return nil
+}
+
+// Watch перечитывает файлы, если они изменились, раз в interval и по сигналу SIGHUP до отмены контекста.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			r.reload("SIGHUP")
+		case <-ticker.C:
+			modTime, err := r.lastModified()
+			if err != nil {
+				logger.Log.Errorf("failed to check certificates: %v", err)
+				continue
+			}
+			r.mu.RLock()
+			changed := modTime.After(r.modTime)
+			r.mu.RUnlock()
+			if changed {
+				r.reload("file change")
+			}
+		}
+	}
+}
+
+func (r *Reloader) reload(reason string) {
+	if err := r.Reload(); err != nil {
+		logger.Log.Errorf("failed to reload certificates on %s: %v", reason, err)
+		return
+	}
+	logger.Log.Infof("certificates reloaded on %s", reason)
+}
+
+// lastModified возвращает время изменения самого свежего из файлов.
+func (r *Reloader) lastModified() (time.Time, error) {
+	var last time.Time
+	for _, name := range []string{r.certFile, r.keyFile, r.caFile} {
+		if name == "" {
+			continue
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(last) {
+			last = info.ModTime()
+		}
+	}
+	return last, nil
+}
+
+// TLSConfig возвращает настройки TLS для HTTP и gRPC. Сертификат сервера и CA клиентских сертификатов
+// берутся при каждом рукопожатии, поэтому перечитанные файлы применяются к новым соединениям.
+func (r *Reloader) TLSConfig() *tls.Config {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			return r.cert, nil
+		},
+	}
+	if r.caFile != "" {
+		// сертификат проверяется вручную: пул CA может смениться после создания настроек
+		cfg.ClientAuth = tls.RequireAnyClientCert
+		cfg.VerifyPeerCertificate = r.verifyClient
+	}
+	return cfg
+}
+
+// verifyClient проверяет цепочку сертификата клиента по текущему пулу CA.
+func (r *Reloader) verifyClient(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return ErrNoClientCert
+	}
+	certs := make([]*x509.Certificate, 0, len(rawCerts))
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		certs = append(certs, cert)
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	r.mu.RLock()
+	roots := r.pool
+	r.mu.RUnlock()
+
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	return err
+}
+
+// Identity возвращает идентификатор агента по сертификату клиента: значение из identities
+// по полному субъекту или по CommonName, иначе сам CommonName.
+func Identity(cert *x509.Certificate, identities map[string]string) string {
+	if id, ok := identities[cert.Subject.String()]; ok {
+		return id
+	}
+	if id, ok := identities[cert.Subject.CommonName]; ok {
+		return id
+	}
+	return cert.Subject.CommonName
+}
@@ -0,0 +1,191 @@
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// issuer выпускает сертификаты для тестов.
+type issuer struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newCA(t *testing.T, name string) *issuer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &issuer{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue выпускает сертификат и возвращает его и ключ в PEM.
+func (ca *issuer) issue(t *testing.T, subject pkix.Name, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func write(t *testing.T, name string, data []byte) {
+	require.NoError(t, os.WriteFile(name, data, 0o600))
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key"), filepath.Join(dir, "ca.pem")
+
+	ca := newCA(t, "agents")
+	serverCert, serverKey := ca.issue(t, pkix.Name{CommonName: "server"}, x509.ExtKeyUsageServerAuth)
+	write(t, certFile, serverCert)
+	write(t, keyFile, serverKey)
+	write(t, caFile, ca.pem)
+
+	r, err := New(certFile, keyFile, caFile)
+	require.NoError(t, err)
+
+	var agent string
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		agent = Identity(req.TLS.PeerCertificates[0], map[string]string{"CN=host-1,O=team a": "agent-1"})
+	}))
+	// httptest.Server.StartTLS подставляет свой сертификат, поэтому TLS включается на listener
+	srv.Listener = tls.NewListener(srv.Listener, r.TLSConfig())
+	srv.Start()
+	defer srv.Close()
+	url := "https://" + srv.Listener.Addr().String()
+
+	client := func(ca *issuer, subject pkix.Name) *http.Client {
+		roots := x509.NewCertPool()
+		roots.AppendCertsFromPEM(ca.pem)
+		cfg := &tls.Config{RootCAs: roots}
+		if subject.CommonName != "" {
+			certPEM, keyPEM := ca.issue(t, subject, x509.ExtKeyUsageClientAuth)
+			cert, err := tls.X509KeyPair(certPEM, keyPEM)
+			require.NoError(t, err)
+			cfg.Certificates = []tls.Certificate{cert}
+		}
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+	}
+	get := func(c *http.Client) error {
+		resp, err := c.Get(url)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+
+	t.Run("client certificate", func(t *testing.T) {
+		require.NoError(t, get(client(ca, pkix.Name{CommonName: "host-1", Organization: []string{"team a"}})))
+		assert.Equal(t, "agent-1", agent)
+		require.NoError(t, get(client(ca, pkix.Name{CommonName: "host-2"})))
+		assert.Equal(t, "host-2", agent)
+	})
+	t.Run("without client certificate", func(t *testing.T) {
+		assert.Error(t, get(client(ca, pkix.Name{})))
+	})
+	t.Run("certificate of another CA", func(t *testing.T) {
+		other := newCA(t, "other")
+		c := client(other, pkix.Name{CommonName: "host-1"})
+		c.Transport.(*http.Transport).TLSClientConfig.RootCAs.AppendCertsFromPEM(ca.pem)
+		assert.Error(t, get(c))
+	})
+
+	t.Run("reload", func(t *testing.T) {
+		// новый CA и новый сертификат сервера применяются к новым соединениям без перезапуска
+		rotated := newCA(t, "rotated")
+		serverCert, serverKey := rotated.issue(t, pkix.Name{CommonName: "server"}, x509.ExtKeyUsageServerAuth)
+		write(t, certFile, serverCert)
+		write(t, keyFile, serverKey)
+		write(t, caFile, rotated.pem)
+		require.NoError(t, r.Reload())
+
+		assert.Error(t, get(client(ca, pkix.Name{CommonName: "host-1"})))
+		require.NoError(t, get(client(rotated, pkix.Name{CommonName: "host-3"})))
+		assert.Equal(t, "host-3", agent)
+	})
+
+	t.Run("broken files keep the loaded certificates", func(t *testing.T) {
+		write(t, keyFile, []byte("broken"))
+		assert.Error(t, r.Reload())
+		r.mu.RLock()
+		assert.NotNil(t, r.cert)
+		r.mu.RUnlock()
+	})
+}
+
+func TestReloaderWatch(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key")
+	ca := newCA(t, "agents")
+	serverCert, serverKey := ca.issue(t, pkix.Name{CommonName: "server"}, x509.ExtKeyUsageServerAuth)
+	write(t, certFile, serverCert)
+	write(t, keyFile, serverKey)
+
+	r, err := New(certFile, keyFile, "")
+	require.NoError(t, err)
+	assert.Equal(t, tls.NoClientCert, r.TLSConfig().ClientAuth)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Watch(ctx, 10*time.Millisecond)
+
+	current := func() string {
+		cert, err := r.TLSConfig().GetCertificate(nil)
+		require.NoError(t, err)
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		require.NoError(t, err)
+		return leaf.Subject.CommonName
+	}
+	assert.Equal(t, "server", current())
+
+	serverCert, serverKey = ca.issue(t, pkix.Name{CommonName: "renewed"}, x509.ExtKeyUsageServerAuth)
+	write(t, certFile, serverCert)
+	write(t, keyFile, serverKey)
+	// время изменения файлов на части файловых систем округляется до секунды
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, future, future))
+	require.NoError(t, os.Chtimes(keyFile, future, future))
+
+	assert.Eventually(t, func() bool { return current() == "renewed" }, time.Second, 10*time.Millisecond)
+}
@@ -2,6 +2,11 @@ package config
 
 import (
 	"crypto/rsa"
+
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/auth"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/certs"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/models"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/subnet"
 )
 
 type Store int
@@ -18,14 +23,152 @@ type RecorderConfig struct {
 	Restore  bool   `json:"restore"`
 }
 
+// WebhookConfig настройки отправки уведомлений алертинга.
+type WebhookConfig struct {
+	URL       string   `json:"url"`
+	GroupWait int      `json:"group_wait"` // секунды ожидания перед отправкой группы
+	GroupBy   []string `json:"group_by"`   // метки правил, по которым группируются уведомления
+}
+
+// AlertingConfig настройки алертинга.
+type AlertingConfig struct {
+	RulesFile string        `json:"rules_file"`
+	Interval  int           `json:"interval"` // секунды между вычислениями правил
+	Webhook   WebhookConfig `json:"webhook"`
+}
+
+// AgentsConfig настройки учета агентов.
+type AgentsConfig struct {
+	ReportInterval int `json:"report_interval"` // секунды, для агентов, не сообщивших свой интервал
+	StaleIntervals int `json:"stale_intervals"` // сколько интервалов отправки агент может молчать
+	TTL            int `json:"ttl"`             // секунды, после которых молчащий агент удаляется из реестра
+	MaxAgents      int `json:"max_agents"`      // сколько агентов хранит реестр
+}
+
+// DashboardConfig настройки графиков дашборда.
+type DashboardConfig struct {
+	Interval int `json:"interval"` // секунды между опросами хранилища
+	Points   int `json:"points"`   // сколько последних значений метрики хранится для графика
+}
+
+// StreamConfig настройки потока обновлений /api/v1/stream.
+type StreamConfig struct {
+	Buffer int `json:"buffer"` // сколько последних событий хранится для возобновления потока
+}
+
+// AuthConfig настройки аутентификации по токенам.
+type AuthConfig struct {
+	Enabled    bool   `json:"enabled"`
+	TokensFile string `json:"tokens_file"` // файл токенов, если метрики хранятся не в PostgreSQL
+}
+
+// TenantLimits ограничения тенанта, 0 - без ограничения.
+type TenantLimits struct {
+	MaxSeries int     `json:"max_series"` // сколько метрик может хранить тенант
+	Rate      float64 `json:"rate"`       // запросов в секунду
+	Burst     int     `json:"burst"`      // сколько запросов можно сделать подряд сверх rate
+}
+
+// TenantsConfig настройки изоляции тенантов.
+type TenantsConfig struct {
+	Enabled    bool                    `json:"enabled"`
+	Allowed    []string                `json:"allowed"`     // тенанты, которых можно выбрать заголовком без токена тенанта
+	MaxTenants int                     `json:"max_tenants"` // хранилищ тенантов, кроме тенанта по умолчанию
+	Limits     TenantLimits            `json:"limits"`      // ограничения по умолчанию
+	Overrides  map[string]TenantLimits `json:"overrides"`   // ограничения отдельных тенантов
+}
+
+// TLSConfig настройки TLS для HTTP и gRPC. Без сертификата сервер работает без TLS.
+type TLSConfig struct {
+	CertFile       string            `json:"cert_file"`
+	KeyFile        string            `json:"key_file"`
+	ClientCAFile   string            `json:"client_ca_file"`  // CA клиентских сертификатов, задан - сертификат клиента обязателен
+	Identities     map[string]string `json:"identities"`      // субъект или CommonName сертификата клиента -> идентификатор агента
+	ReloadInterval int               `json:"reload_interval"` // секунды между проверками изменения файлов
+}
+
+// SubnetsConfig ограничения доступа по адресу клиента. Подсети IPv4 и IPv6 в нотации CIDR или отдельные адреса.
+type SubnetsConfig struct {
+	Allow          []string `json:"allow"`           // разрешенные подсети в дополнение к trusted_subnet
+	Deny           []string `json:"deny"`            // запрещенные подсети, важнее разрешенных
+	TrustedProxies []string `json:"trusted_proxies"` // прокси, от которых принимаются X-Forwarded-For и X-Real-IP
+}
+
+// SigningConfig настройки подписи запросов HMAC-SHA256. Ключ KEY подходит для запросов без KeyID.
+// С заданным ключом запросы, изменяющие данные, без подписи отклоняются, если не включен AllowUnsigned.
+type SigningConfig struct {
+	Keys          map[string]string `json:"keys"`           // дополнительные ключи по KeyID для ротации без простоя
+	MaxSkew       int               `json:"max_skew"`       // секунды допустимого расхождения времени подписи
+	AllowUnsigned bool              `json:"allow_unsigned"` // принимать запросы без подписи
+}
+
+// Ключи, по которым считается ограничение частоты запросов клиента.
+const (
+	LimitByIP    = "ip"
+	LimitByAgent = "agent"
+	LimitByToken = "token"
+)
+
+// LimitsConfig ограничения запросов одного клиента, 0 - без ограничения.
+type LimitsConfig struct {
+	Rate                float64 `json:"rate"`                  // запросов в секунду
+	Burst               int     `json:"burst"`                 // сколько запросов можно сделать подряд сверх rate
+	Key                 string  `json:"key"`                   // ip, agent или token
+	MaxBodySize         int64   `json:"max_body_size"`         // байт в теле запроса как оно передано
+	MaxDecompressedSize int64   `json:"max_decompressed_size"` // байт в теле запроса после распаковки gzip
+}
+
+// Режимы fsync журнала файлового хранилища.
+const (
+	FsyncAlways   = "always"   // после каждой записи
+	FsyncInterval = "interval" // раз в fsync_interval секунд
+	FsyncNever    = "never"    // сброс на диск остается операционной системе
+)
+
+// WALConfig настройки файлового хранилища: журнал обновлений и периодические снимки.
+type WALConfig struct {
+	Dir              string `json:"dir"`               // каталог журнала и снимка, пустой - хранилище выключено
+	Fsync            string `json:"fsync"`             // always, interval или never
+	FsyncInterval    int    `json:"fsync_interval"`    // секунды между fsync в режиме interval
+	SnapshotInterval int    `json:"snapshot_interval"` // секунды между снимками
+	SnapshotRecords  int    `json:"snapshot_records"`  // записей журнала, после которых делается снимок
+}
+
+// PoolConfig настройки пула соединений PostgreSQL. У каждой схемы тенанта свой пул с такими же настройками.
+type PoolConfig struct {
+	MaxConns        int `json:"max_conns"`          // соединений в пуле
+	MinConns        int `json:"min_conns"`          // соединений, которые пул держит открытыми
+	MaxConnLifetime int `json:"max_conn_lifetime"`  // секунды, после которых соединение пересоздается
+	MaxConnIdleTime int `json:"max_conn_idle_time"` // секунды простоя, после которых лишнее соединение закрывается
+	StatementCache  int `json:"statement_cache"`    // подготовленных запросов в кэше каждого соединения
+}
+
 type AppConfig struct {
-	ServerProtocol string          `json:"protocol,omitempty"`
-	ServerAddress  string          `json:"address,omitempty"`
-	SecretKey      string          `json:"key,omitempty"`
-	CryptoKey      string          `json:"crypto_key,omitempty"`
-	PrivateKeyPEM  *rsa.PrivateKey `json:"-"`
-	TrustedSubnet  string          `json:"trusted_subnet,omitempty"`
-	DatabaseDSN    string          `json:"database_dsn,omitempty"`
-	FileStore      RecorderConfig  `json:"store_file"`
-	StorePriority  Store           `json:"-"`
+	ServerProtocol string              `json:"protocol,omitempty"`
+	ServerAddress  string              `json:"address,omitempty"`
+	GRPCAddress    string              `json:"grpc_address,omitempty"`
+	TLS            TLSConfig           `json:"tls"`
+	Certs          *certs.Reloader     `json:"-"`
+	SecretKey      string              `json:"key,omitempty"`
+	Signing        SigningConfig       `json:"signing"`
+	CryptoKey      string              `json:"crypto_key,omitempty"`
+	PrivateKeyPEM  *rsa.PrivateKey     `json:"-"`
+	TrustedSubnet  string              `json:"trusted_subnet,omitempty"`
+	Subnets        SubnetsConfig       `json:"subnets"`
+	SubnetPolicy   *subnet.Policy      `json:"-"`
+	AdminKey       string              `json:"admin_key,omitempty"`
+	Auth           AuthConfig          `json:"auth"`
+	Tokens         *auth.Authenticator `json:"-"`
+	Tenants        TenantsConfig       `json:"tenants"`
+	Limits         LimitsConfig        `json:"limits"`
+	BatchMode      models.BatchMode    `json:"batch_mode,omitempty"`
+	Alerting       AlertingConfig      `json:"alerting"`
+	Agents         AgentsConfig        `json:"agents"`
+	Dashboard      DashboardConfig     `json:"dashboard"`
+	Stream         StreamConfig        `json:"stream"`
+	DatabaseDSN    string              `json:"database_dsn,omitempty"`
+	DatabasePool   PoolConfig          `json:"database_pool"`
+	FileStore      RecorderConfig      `json:"store_file"`
+	WAL            WALConfig           `json:"wal"`
+	StorePriority  Store               `json:"-"`
 }
@@ -0,0 +1,164 @@
+package dump
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/config"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/file"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories/store"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories/store/pg"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories/store/wal"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/tenant"
+)
+
+// Store хранилище, открытое для выгрузки или загрузки. Close сохраняет загруженные метрики.
+type Store interface {
+	repositories.StoreRepository
+	io.Closer
+}
+
+// Backend хранилище сервера, как в его настройках: PostgreSQL, хранилище в файлах с журналом
+// или файл снимка хранилища в памяти. Используется первое заданное в этом порядке.
+type Backend struct {
+	DatabaseDSN string
+	WALDir      string
+	File        string
+}
+
+// Open открывает хранилище вместе с хранилищами тенантов: схемами metrics_<tenant> в PostgreSQL,
+// каталогами tenants/<tenant> хранилища в файлах или разделом tenants файла снимка. Тенант метрики
+// выбирается контекстом, как в сервере, а новые тенанты при загрузке создаются.
+// При readOnly файл снимка не перезаписывается при закрытии, а хранилище в файлах с журналом читается
+// без блокировки и изменения каталогов, в том числе каталогов работающего сервера.
+func Open(ctx context.Context, b Backend, readOnly bool) (Store, error) {
+	switch {
+	case b.DatabaseDSN != "":
+		def := &pg.Store{}
+		if err := def.Initialize(ctx, config.AppConfig{DatabaseDSN: b.DatabaseDSN}); err != nil {
+			return nil, err
+		}
+		names, err := def.Tenants(ctx)
+		if err != nil {
+			def.Close()
+			return nil, err
+		}
+		factory := func(ctx context.Context, name string) (repositories.StoreRepository, error) {
+			return pg.NewTenantStore(ctx, b.DatabaseDSN, name, config.PoolConfig{})
+		}
+		return openTenants(ctx, def, factory, names)
+
+	case b.WALDir != "":
+		open := func(dir string) (*wal.Store, error) {
+			if readOnly {
+				return wal.OpenReadOnly(dir)
+			}
+			return wal.Open(dir, config.WALConfig{Fsync: config.FsyncNever})
+		}
+		def, err := open(b.WALDir)
+		if err != nil {
+			return nil, err
+		}
+		names, err := wal.Tenants(b.WALDir)
+		if err != nil {
+			def.Close()
+			return nil, err
+		}
+		factory := func(_ context.Context, name string) (repositories.StoreRepository, error) {
+			return open(wal.TenantDir(b.WALDir, name))
+		}
+		return openTenants(ctx, def, factory, names)
+
+	case b.File != "":
+		return openSnapshot(b.File, readOnly)
+	}
+
+	return nil, errors.New("no storage: expected database dsn, wal directory or metrics file")
+}
+
+// tenantsStore хранилище тенанта по умолчанию и хранилища остальных тенантов.
+type tenantsStore struct {
+	*tenant.Store
+	def io.Closer
+}
+
+// openTenants открывает хранилища тенантов names и объединяет их с хранилищем тенанта по умолчанию.
+func openTenants(ctx context.Context, def Store, factory tenant.Factory, names []string) (*tenantsStore, error) {
+	s := &tenantsStore{Store: tenant.NewStore(def, factory, config.TenantsConfig{}), def: def}
+	for _, name := range names {
+		store, err := factory(ctx, name)
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("tenant %s: %w", name, err), s.Close())
+		}
+		s.Add(name, store)
+	}
+
+	return s, nil
+}
+
+// Close закрывает хранилища тенантов и хранилище тенанта по умолчанию.
+func (s *tenantsStore) Close() error {
+	return errors.Join(s.Store.Close(), s.def.Close())
+}
+
+// snapshotStore хранилища тенантов в памяти, загруженные из файла снимка сервера и сохраняемые в него при закрытии.
+type snapshotStore struct {
+	*tenant.Store
+	filename string
+	readOnly bool
+}
+
+func openSnapshot(filename string, readOnly bool) (*snapshotStore, error) {
+	res, err := file.Read(filename)
+	if err != nil {
+		return nil, err
+	}
+	if res == nil && readOnly {
+		return nil, errors.New("metrics file " + filename + " not found or empty")
+	}
+
+	def := store.NewMemStorage()
+	factory := func(context.Context, string) (repositories.StoreRepository, error) {
+		return store.NewMemStorage(), nil
+	}
+	s := &snapshotStore{Store: tenant.NewStore(def, factory, config.TenantsConfig{}), filename: filename, readOnly: readOnly}
+	if res != nil {
+		restore(def, *res)
+		for name, t := range res.Tenants {
+			ms := store.NewMemStorage()
+			restore(ms, t)
+			s.Add(name, ms)
+		}
+	}
+
+	return s, nil
+}
+
+// restore заполняет хранилище в памяти метриками из файла снимка.
+func restore(ms *store.MemStorage, res file.StructFile) {
+	if res.Counter != nil {
+		ms.Counter = res.Counter
+	}
+	if res.Gauge != nil {
+		ms.Gauge = res.Gauge
+	}
+	if res.Histogram != nil {
+		ms.Histogram = res.Histogram
+	}
+}
+
+// Close сохраняет метрики всех тенантов в файл снимка.
+func (s *snapshotStore) Close() error {
+	if s.readOnly {
+		return nil
+	}
+	all, err := s.GetAllMetrics(context.Background())
+	if err != nil {
+		return err
+	}
+
+	return file.Save(s.filename, all)
+}
@@ -0,0 +1,373 @@
+// Package dump выгружает метрики из хранилища в NDJSON или CSV и загружает их в любое хранилище
+// через StoreRepository, например для переноса метрик из памяти в PostgreSQL или наполнения
+// тестового окружения.
+package dump
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/models"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/tenant"
+)
+
+// Форматы выгрузки. Метрика тенанта, кроме тенанта по умолчанию, помечается его именем.
+const (
+	FormatNDJSON = "ndjson" // метрика в формате JSON API и поле tenant на каждой строке
+	FormatCSV    = "csv"    // колонки type, id, delta, value, histogram, tenant; гистограмма в JSON
+)
+
+// Режимы загрузки счетчиков и гистограмм. Gauge всегда заменяется.
+const (
+	CountersAdd       = "add"       // значение прибавляется к имеющемуся, как при отправке агентом
+	CountersOverwrite = "overwrite" // значение заменяет имеющееся
+)
+
+// pageSize сколько метрик читается из хранилища или пишется в него за раз.
+const pageSize = 1000
+
+// maxLineSize максимальный размер строки NDJSON.
+const maxLineSize = 1 << 20
+
+var csvHeader = []string{"type", "id", "delta", "value", "histogram"}
+
+// csvTenant колонка с тенантом. Ее может не быть: тогда все метрики загружаются в тенант по умолчанию.
+const csvTenant = "tenant"
+
+// record метрика тенанта в выгрузке. Тенант по умолчанию не указывается.
+type record struct {
+	models.Metrics
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// tenantLister хранилище с тенантами, например открытое Open.
+type tenantLister interface {
+	Tenants() []string
+}
+
+// Export выгружает все метрики хранилища в заданном формате и возвращает их количество.
+// Метрики читаются страницами по курсору, поэтому выгрузка не требует памяти под все метрики.
+// Если у хранилища есть тенанты, выгружаются метрики всех тенантов с пометкой тенанта.
+func Export(ctx context.Context, src repositories.StoreRepository, w io.Writer, format string) (int, error) {
+	write, flush, err := newEncoder(w, format)
+	if err != nil {
+		return 0, err
+	}
+
+	tenants := []string{tenant.Default}
+	if lister, ok := src.(tenantLister); ok {
+		tenants = lister.Tenants()
+	}
+	var count int
+	for _, name := range tenants {
+		n, err := exportTenant(tenant.WithTenant(ctx, name), src, name, write)
+		count += n
+		if err != nil {
+			return count, fmt.Errorf("tenant %s: %w", name, err)
+		}
+	}
+
+	return count, flush()
+}
+
+// exportTenant выгружает метрики тенанта из контекста.
+func exportTenant(ctx context.Context, src repositories.StoreRepository, name string, write func(record) error) (int, error) {
+	if name == tenant.Default {
+		name = ""
+	}
+	var count int
+	opts := models.ListOptions{Limit: pageSize}
+	for {
+		page, err := src.ListMetrics(ctx, opts)
+		if err != nil {
+			return count, err
+		}
+		for _, m := range page {
+			if err := write(record{Metrics: m, Tenant: name}); err != nil {
+				return count, err
+			}
+			count++
+		}
+		if len(page) < pageSize {
+			return count, nil
+		}
+		last := page[len(page)-1]
+		opts.After = &models.ListCursor{ID: last.ID, MType: last.MType}
+	}
+}
+
+// Import загружает метрики в заданном формате в хранилище и возвращает количество загруженных.
+// counters задает, прибавляются ли счетчики и гистограммы к имеющимся или заменяют их.
+// Метрики тенантов загружаются в хранилища тенантов, если они есть у хранилища, иначе это ошибка.
+// Метрики проверяются как в API; ошибка в строке прерывает загрузку, уже загруженные метрики остаются.
+// При замене ошибка записи оставляет счетчики и гистограммы прерванной страницы сброшенными,
+// поэтому после нее загрузку нужно повторить.
+func Import(ctx context.Context, dst repositories.StoreRepository, r io.Reader, format, counters string) (int, error) {
+	if counters != CountersAdd && counters != CountersOverwrite {
+		return 0, fmt.Errorf("unknown counters mode %q: expected %s or %s", counters, CountersAdd, CountersOverwrite)
+	}
+	read, err := newDecoder(r, format)
+	if err != nil {
+		return 0, err
+	}
+	_, hasTenants := dst.(tenantLister)
+
+	var count int
+	current := tenant.Default
+	batch := make([]models.Metrics, 0, pageSize)
+	flush := func() error {
+		if err := apply(tenant.WithTenant(ctx, current), dst, batch, counters); err != nil {
+			return fmt.Errorf("tenant %s: %w", current, err)
+		}
+		count += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+	for {
+		rec, err := read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return count, err
+		}
+		name := rec.Tenant
+		if name == "" {
+			name = tenant.Default
+		}
+		if name != tenant.Default && !hasTenants {
+			return count, fmt.Errorf("tenant %s: storage has no tenants", name)
+		}
+		// батч пишется в хранилище одного тенанта
+		if name != current && len(batch) > 0 {
+			if err := flush(); err != nil {
+				return count, err
+			}
+		}
+		current = name
+		batch = append(batch, rec.Metrics)
+		if len(batch) == pageSize {
+			if err := flush(); err != nil {
+				return count, err
+			}
+		}
+	}
+	if len(batch) > 0 {
+		if err := flush(); err != nil {
+			return count, err
+		}
+	}
+
+	return count, nil
+}
+
+// apply записывает метрики в хранилище одним батчем.
+// При замене повторы метрики в батче сводятся к последнему значению, а счетчики и гистограммы
+// сбрасываются отдельными вызовами до записи батча: если запись не удалась, они остаются сброшенными.
+func apply(ctx context.Context, dst repositories.StoreRepository, batch []models.Metrics, counters string) error {
+	if counters == CountersOverwrite {
+		batch = lastValues(batch)
+		for _, m := range batch {
+			var err error
+			switch m.MType {
+			case "counter":
+				err = dst.ResetCounter(ctx, m.ID)
+			case "histogram":
+				err = dst.DeleteMetric(ctx, m.MType, m.ID)
+			}
+			if err != nil && !errors.Is(err, repositories.ErrNotFound) {
+				return err
+			}
+		}
+	}
+
+	errs, err := dst.UpdateBatchMetrics(ctx, models.Batch{Mode: models.BatchTransactional, Metrics: batch})
+	if err != nil {
+		return err
+	}
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("%s %s: %w", batch[i].MType, batch[i].ID, err)
+		}
+	}
+
+	return nil
+}
+
+// lastValues оставляет последнее значение каждой метрики батча на месте ее последнего вхождения.
+func lastValues(batch []models.Metrics) []models.Metrics {
+	last := make(map[[2]string]int, len(batch))
+	for i, m := range batch {
+		last[[2]string{m.MType, m.ID}] = i
+	}
+	if len(last) == len(batch) {
+		return batch
+	}
+	res := make([]models.Metrics, 0, len(last))
+	for i, m := range batch {
+		if last[[2]string{m.MType, m.ID}] == i {
+			res = append(res, m)
+		}
+	}
+
+	return res
+}
+
+// newEncoder возвращает функции записи метрики и сброса буфера для формата.
+func newEncoder(w io.Writer, format string) (func(record) error, func() error, error) {
+	switch format {
+	case FormatNDJSON:
+		bw := bufio.NewWriter(w)
+		enc := json.NewEncoder(bw)
+		write := func(rec record) error {
+			return enc.Encode(rec)
+		}
+		return write, bw.Flush, nil
+
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(append(csvHeader, csvTenant)); err != nil {
+			return nil, nil, err
+		}
+		write := func(rec record) error {
+			m := rec.Metrics
+			row := []string{m.MType, m.ID, "", "", "", rec.Tenant}
+			if m.Delta != nil {
+				row[2] = strconv.FormatInt(*m.Delta, 10)
+			}
+			if m.Value != nil {
+				row[3] = strconv.FormatFloat(*m.Value, 'g', -1, 64)
+			}
+			if m.Histogram != nil {
+				data, err := json.Marshal(m.Histogram)
+				if err != nil {
+					return err
+				}
+				row[4] = string(data)
+			}
+			return cw.Write(row)
+		}
+		flush := func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+		return write, flush, nil
+	}
+
+	return nil, nil, fmt.Errorf("unknown format %q: expected %s or %s", format, FormatNDJSON, FormatCSV)
+}
+
+// newDecoder возвращает функцию чтения следующей проверенной метрики, io.EOF - метрики закончились.
+func newDecoder(r io.Reader, format string) (func() (record, error), error) {
+	switch format {
+	case FormatNDJSON:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(nil, maxLineSize)
+		var line int
+		return func() (record, error) {
+			for scanner.Scan() {
+				line++
+				if len(scanner.Bytes()) == 0 {
+					continue
+				}
+				var rec record
+				if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+					return rec, fmt.Errorf("line %d: %w", line, err)
+				}
+				if err := rec.validate(); err != nil {
+					return rec, fmt.Errorf("line %d: %w", line, err)
+				}
+				return rec, nil
+			}
+			if err := scanner.Err(); err != nil {
+				return record{}, err
+			}
+			return record{}, io.EOF
+		}, nil
+
+	case FormatCSV:
+		cr := csv.NewReader(r)
+		header, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return func() (record, error) { return record{}, io.EOF }, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		// колонки могут идти в любом порядке
+		columns := make(map[string]int, len(header))
+		for i, name := range header {
+			columns[name] = i
+		}
+		for _, name := range csvHeader {
+			if _, ok := columns[name]; !ok {
+				return nil, fmt.Errorf("csv header: missing column %q", name)
+			}
+		}
+		return func() (record, error) {
+			row, err := cr.Read()
+			if err != nil {
+				return record{}, err
+			}
+			line, _ := cr.FieldPos(0)
+			rec, err := parseRow(row, columns)
+			if err == nil {
+				err = rec.validate()
+			}
+			if err != nil {
+				return rec, fmt.Errorf("line %d: %w", line, err)
+			}
+			return rec, nil
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown format %q: expected %s or %s", format, FormatNDJSON, FormatCSV)
+}
+
+// parseRow разбирает строку CSV: пустые колонки значений не заполняются.
+func parseRow(row []string, columns map[string]int) (record, error) {
+	rec := record{Metrics: models.Metrics{MType: row[columns["type"]], ID: row[columns["id"]]}}
+	if i, ok := columns[csvTenant]; ok {
+		rec.Tenant = row[i]
+	}
+	if s := row[columns["delta"]]; s != "" {
+		delta, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return rec, fmt.Errorf("delta: %w", err)
+		}
+		rec.Delta = &delta
+	}
+	if s := row[columns["value"]]; s != "" {
+		value, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return rec, fmt.Errorf("value: %w", err)
+		}
+		rec.Value = &value
+	}
+	if s := row[columns["histogram"]]; s != "" {
+		var histogram models.Histogram
+		if err := json.Unmarshal([]byte(s), &histogram); err != nil {
+			return rec, fmt.Errorf("histogram: %w", err)
+		}
+		rec.Histogram = &histogram
+	}
+
+	return rec, nil
+}
+
+// validate проверяет метрику как в API и имя тенанта.
+func (rec record) validate() error {
+	if rec.Tenant != "" {
+		if err := tenant.Validate(rec.Tenant); err != nil {
+			return err
+		}
+	}
+	return rec.Metrics.Validate()
+}
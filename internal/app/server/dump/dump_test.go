@@ -0,0 +1,234 @@
+package dump
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/config"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/file"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/models"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories/store"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories/store/wal"
+)
+
+func seed(t *testing.T) *store.MemStorage {
+	ctx := context.Background()
+	ms := store.NewMemStorage()
+	// больше одной страницы, чтобы проверить выгрузку по курсору
+	for i := 0; i < pageSize+5; i++ {
+		_, err := ms.UpdateGauge(ctx, fmt.Sprintf("gauge.%04d", i), float64(i)+0.5)
+		require.NoError(t, err)
+	}
+	_, err := ms.UpdateCounter(ctx, "PollCount", 5)
+	require.NoError(t, err)
+	_, err = ms.UpdateHistogram(ctx, "Latency", models.Histogram{Bounds: []float64{0.1, 1}, Counts: []uint64{1, 2, 0}, Sum: 1.2, Count: 3})
+	require.NoError(t, err)
+	return ms
+}
+
+func TestExportImport(t *testing.T) {
+	for _, format := range []string{FormatNDJSON, FormatCSV} {
+		t.Run(format, func(t *testing.T) {
+			ctx := context.Background()
+			var buf bytes.Buffer
+			n, err := Export(ctx, seed(t), &buf, format)
+			require.NoError(t, err)
+			assert.Equal(t, pageSize+7, n)
+
+			dst := store.NewMemStorage()
+			_, err = dst.UpdateCounter(ctx, "PollCount", 10)
+			require.NoError(t, err)
+			data := buf.Bytes()
+
+			n, err = Import(ctx, dst, bytes.NewReader(data), format, CountersAdd)
+			require.NoError(t, err)
+			assert.Equal(t, pageSize+7, n)
+			counter, err := dst.GetCounter(ctx, "PollCount")
+			require.NoError(t, err)
+			assert.Equal(t, int64(15), counter)
+			gauge, err := dst.GetGauge(ctx, "gauge.1004")
+			require.NoError(t, err)
+			assert.Equal(t, 1004.5, gauge)
+			histogram, err := dst.GetHistogram(ctx, "Latency")
+			require.NoError(t, err)
+			assert.Equal(t, uint64(3), histogram.Count)
+
+			// повторная загрузка с заменой не удваивает счетчики и гистограммы
+			_, err = Import(ctx, dst, bytes.NewReader(data), format, CountersOverwrite)
+			require.NoError(t, err)
+			counter, err = dst.GetCounter(ctx, "PollCount")
+			require.NoError(t, err)
+			assert.Equal(t, int64(5), counter)
+			histogram, err = dst.GetHistogram(ctx, "Latency")
+			require.NoError(t, err)
+			assert.Equal(t, uint64(3), histogram.Count)
+		})
+	}
+}
+
+func TestImportOverwriteDuplicates(t *testing.T) {
+	ctx := context.Background()
+	dst := store.NewMemStorage()
+	_, err := dst.UpdateCounter(ctx, "PollCount", 10)
+	require.NoError(t, err)
+
+	// повторы метрики при замене сводятся к последнему значению, а не складываются
+	data := `{"id":"PollCount","type":"counter","delta":2}
+{"id":"Latency","type":"histogram","histogram":{"bounds":[1],"counts":[1,0],"sum":0.5,"count":1}}
+{"id":"PollCount","type":"counter","delta":3}
+{"id":"Latency","type":"histogram","histogram":{"bounds":[1],"counts":[0,2],"sum":4,"count":2}}`
+	n, err := Import(ctx, dst, strings.NewReader(data), FormatNDJSON, CountersOverwrite)
+	require.NoError(t, err)
+	assert.Equal(t, 4, n)
+	counter, err := dst.GetCounter(ctx, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), counter)
+	histogram, err := dst.GetHistogram(ctx, "Latency")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), histogram.Count)
+}
+
+func TestImportInvalid(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name   string
+		format string
+		data   string
+		want   string
+	}{
+		{"ndjson without value", FormatNDJSON, `{"id":"a","type":"gauge","value":1}` + "\n\n" + `{"id":"b","type":"gauge"}`, "line 3"},
+		{"ndjson broken json", FormatNDJSON, `{"id":`, "line 1"},
+		{"csv bad delta", FormatCSV, "type,id,delta,value,histogram\ncounter,a,x,,\n", "line 2: delta"},
+		{"csv missing column", FormatCSV, "type,id,value\n", "missing column"},
+		{"invalid tenant", FormatNDJSON, `{"id":"a","type":"gauge","value":1,"tenant":"Team A"}`, "line 1: invalid tenant"},
+		{"unknown format", "xml", "", "unknown format"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Import(ctx, store.NewMemStorage(), strings.NewReader(tt.data), tt.format, CountersAdd)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.want)
+		})
+	}
+
+	_, err := Import(ctx, store.NewMemStorage(), strings.NewReader(""), FormatNDJSON, "replace")
+	assert.Error(t, err)
+}
+
+func TestSnapshotBackend(t *testing.T) {
+	ctx := context.Background()
+	name := filepath.Join(t.TempDir(), "metrics.json")
+	backend := Backend{File: name}
+
+	_, err := Open(ctx, backend, true)
+	assert.Error(t, err)
+
+	require.NoError(t, file.Save(name, map[string]interface{}{
+		"counter": map[string]store.Counter{"PollCount": 1},
+		"tenants": map[string]file.StructFile{"team_a": {Gauge: map[string]store.Gauge{"Alloc": 2}}},
+	}))
+
+	s, err := Open(ctx, backend, false)
+	require.NoError(t, err)
+	_, err = Import(ctx, s, strings.NewReader(`{"id":"PollCount","type":"counter","delta":2}`+"\n"+
+		`{"id":"Alloc","type":"gauge","value":3,"tenant":"team_b"}`), FormatNDJSON, CountersAdd)
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	res, err := file.Read(name)
+	require.NoError(t, err)
+	assert.Equal(t, store.Counter(3), res.Counter["PollCount"])
+	// метрики тенантов в файле сохраняются, новые тенанты добавляются
+	assert.Equal(t, store.Gauge(2), res.Tenants["team_a"].Gauge["Alloc"])
+	assert.Equal(t, store.Gauge(3), res.Tenants["team_b"].Gauge["Alloc"])
+}
+
+func TestExportTenants(t *testing.T) {
+	ctx := context.Background()
+	name := filepath.Join(t.TempDir(), "metrics.json")
+	require.NoError(t, file.Save(name, map[string]interface{}{
+		"counter": map[string]store.Counter{"PollCount": 1},
+		"tenants": map[string]file.StructFile{"team_a": {Counter: map[string]store.Counter{"PollCount": 7}}},
+	}))
+
+	for _, format := range []string{FormatNDJSON, FormatCSV} {
+		t.Run(format, func(t *testing.T) {
+			src, err := Open(ctx, Backend{File: name}, true)
+			require.NoError(t, err)
+			defer src.Close()
+			var buf bytes.Buffer
+			n, err := Export(ctx, src, &buf, format)
+			require.NoError(t, err)
+			assert.Equal(t, 2, n)
+
+			// метрики тенантов попадают в хранилища своих тенантов
+			dir := t.TempDir()
+			dst, err := Open(ctx, Backend{WALDir: dir}, false)
+			require.NoError(t, err)
+			n, err = Import(ctx, dst, bytes.NewReader(buf.Bytes()), format, CountersAdd)
+			require.NoError(t, err)
+			assert.Equal(t, 2, n)
+			require.NoError(t, dst.Close())
+
+			tenants, err := wal.Tenants(dir)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"team_a"}, tenants)
+			restored, err := wal.OpenReadOnly(wal.TenantDir(dir, "team_a"))
+			require.NoError(t, err)
+			counter, err := restored.GetCounter(ctx, "PollCount")
+			require.NoError(t, err)
+			assert.Equal(t, int64(7), counter)
+
+			// хранилище без тенантов метрики тенантов не принимает
+			_, err = Import(ctx, store.NewMemStorage(), bytes.NewReader(buf.Bytes()), format, CountersAdd)
+			assert.ErrorContains(t, err, "team_a")
+		})
+	}
+}
+
+func TestWALBackend(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	backend := Backend{WALDir: dir}
+
+	// каталог открыт сервером: выгрузка читает его, а загрузка не может стать вторым владельцем
+	server, err := wal.Open(dir, config.WALConfig{Fsync: config.FsyncAlways})
+	require.NoError(t, err)
+	_, err = server.UpdateCounter(ctx, "PollCount", 1)
+	require.NoError(t, err)
+	serverTenant, err := wal.Open(wal.TenantDir(dir, "team_a"), config.WALConfig{Fsync: config.FsyncAlways})
+	require.NoError(t, err)
+	defer serverTenant.Close()
+	_, err = serverTenant.UpdateGauge(ctx, "Alloc", 2)
+	require.NoError(t, err)
+
+	src, err := Open(ctx, backend, true)
+	require.NoError(t, err)
+	var out strings.Builder
+	count, err := Export(ctx, src, &out, FormatNDJSON)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.Contains(t, out.String(), `"tenant":"team_a"`)
+	require.NoError(t, src.Close())
+
+	_, err = Open(ctx, backend, false)
+	assert.ErrorIs(t, err, wal.ErrLocked)
+
+	// журнал сервера не тронут выгрузкой
+	_, err = server.UpdateCounter(ctx, "PollCount", 2)
+	require.NoError(t, err)
+	require.NoError(t, server.Close())
+	restored, err := wal.Open(dir, config.WALConfig{Fsync: config.FsyncAlways})
+	require.NoError(t, err)
+	defer restored.Close()
+	counter, err := restored.GetCounter(ctx, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), counter)
+}
@@ -18,8 +18,8 @@ func WriterInitialize(a *config.AppConfig) error {
 }
 
 func FileWriter(ctx context.Context) {
-	// Если используется база данных в качестве хранилища, то ничего не делаем
-	if app.StorePriority == config.Database {
+	// Если используется база данных или хранилище в файлах с журналом, то ничего не делаем
+	if app.StorePriority != config.Memory {
 		return
 	}
 	// 1. Интервал времени в секундах, по истечении которого текущие показания сервера сохраняются на диск
@@ -38,18 +38,13 @@ func FileWriter(ctx context.Context) {
 }
 
 func SaveData(ctx context.Context) {
-	res, err := handlers.Repo.Store.GetAllMetrics(ctx)
-	if err != nil {
-		logger.Log.Errorln("failed to get the data from storage, GetAllMetrics() = ", err)
+	// хранилище в файлах с журналом сохраняет снимок само при закрытии
+	if app.StorePriority == config.File {
+		return
 	}
 
-	// записываем в файл
-	producer, err := file.NewProducer(app.FileStore.FilePath)
-	if err != nil {
-		logger.Log.Errorln(err)
-	}
-	if err := producer.WriteJSON(res); err != nil {
-		logger.Log.Errorln(err)
+	// записываем в файл под той же блокировкой, что и синхронные сохранения
+	if err := file.SaveMetrics(ctx, app.FileStore.FilePath, handlers.Repo.Store.GetAllMetrics); err != nil {
+		logger.Log.Errorln("failed to save the data to the file, SaveMetrics() =", err)
 	}
-	producer.Close()
 }
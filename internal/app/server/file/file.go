@@ -2,17 +2,48 @@ package file
 
 import (
 	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
 	"github.com/webkimru/go-yandex-metrics/internal/app/server/config"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/models"
 	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories/store"
-	"os"
 )
 
 var app *config.AppConfig
 
+// FormatVersion версия формата файла метрик.
+// Файл состоит из строки заголовка Header и строки с метриками в JSON.
+// Файлы без заголовка (версия 0) содержат только строку с метриками и читаются как раньше.
+const FormatVersion = 1
+
+// maxLineSize максимальный размер строки файла метрик.
+const maxLineSize = 512 << 20
+
+// ErrCorrupted файл метрик поврежден.
+var ErrCorrupted = errors.New("metrics file corrupted")
+
+// Header заголовок файла метрик.
+type Header struct {
+	Version   int       `json:"version"`
+	Checksum  string    `json:"checksum"`  // sha256 строки с метриками в hex
+	Timestamp time.Time `json:"timestamp"` // время сохранения
+}
+
 type StructFile struct {
-	Counter map[string]store.Counter
-	Gauge   map[string]store.Gauge
+	Counter   map[string]store.Counter
+	Gauge     map[string]store.Gauge
+	Histogram map[string]models.Histogram
+	// Tenants метрики остальных тенантов, метрики тенанта по умолчанию хранятся в полях выше
+	Tenants map[string]StructFile `json:",omitempty"`
 }
 
 func Initialize(a *config.AppConfig) error {
@@ -21,47 +52,145 @@ func Initialize(a *config.AppConfig) error {
 	return nil
 }
 
+// commitMu упорядочивает замену файла метрик: переименования файла в Previous и временного файла
+// в файл метрик одного сохранения не должны перемежаться с переименованиями другого.
+var commitMu sync.Mutex
+
+// Previous возвращает имя предыдущего снимка, в который переименовывается файл при сохранении нового.
+func Previous(filename string) string {
+	return filename + ".1"
+}
+
+// Producer пишет снимок метрик во временный файл. Файл метрик заменяется только в Commit,
+// поэтому сбой во время записи не портит ранее сохраненный снимок.
+// У каждого Producer свой временный файл в каталоге файла метрик, параллельные сохранения его не делят.
 type Producer struct {
-	file *os.File
+	filename string
+	file     *os.File
 	// добавляем Writer в Producer
 	writer *bufio.Writer
 }
 
 func NewProducer(filename string) (*Producer, error) {
-	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_SYNC, 0666)
+	file, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
 	if err != nil {
 		return nil, err
 	}
 
 	return &Producer{
-		file: file,
+		filename: filename,
+		file:     file,
 		// создаём новый Writer
 		writer: bufio.NewWriter(file),
 	}, nil
 }
 
+// WriteJSON пишет заголовок и метрики во временный файл.
 func (p *Producer) WriteJSON(metrics map[string]interface{}) error {
 	data, err := json.Marshal(&metrics)
 	if err != nil {
 		return err
 	}
-
-	// записываем в буфер
-	if _, err := p.writer.Write(data); err != nil {
+	sum := sha256.Sum256(data)
+	header, err := json.Marshal(Header{
+		Version:   FormatVersion,
+		Checksum:  hex.EncodeToString(sum[:]),
+		Timestamp: time.Now().UTC(),
+	})
+	if err != nil {
 		return err
 	}
 
-	// добавляем перенос строки
-	if err := p.writer.WriteByte('\n'); err != nil {
-		return err
+	// записываем в буфер заголовок и метрики, каждые с переносом строки
+	for _, line := range [][]byte{header, data} {
+		if _, err := p.writer.Write(line); err != nil {
+			return err
+		}
+		if err := p.writer.WriteByte('\n'); err != nil {
+			return err
+		}
 	}
 
 	// записываем буфер в файл
 	return p.writer.Flush()
 }
 
+// Commit сбрасывает временный файл на диск и атомарно заменяет им файл метрик.
+// Прежний файл метрик сохраняется как Previous для восстановления, если новый окажется поврежден.
+func (p *Producer) Commit() error {
+	if err := p.file.Sync(); err != nil {
+		return err
+	}
+	if err := p.file.Close(); err != nil {
+		return err
+	}
+	tmp := p.file.Name()
+	p.file = nil
+
+	commitMu.Lock()
+	defer commitMu.Unlock()
+
+	if err := os.Rename(p.filename, Previous(p.filename)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return errors.Join(err, os.Remove(tmp))
+	}
+	if err := os.Rename(tmp, p.filename); err != nil {
+		return errors.Join(err, os.Remove(tmp))
+	}
+
+	// переименование сохраняется на диске только после сброса каталога
+	dir, err := os.Open(filepath.Dir(p.filename))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
+}
+
+// Close удаляет временный файл, если снимок не был сохранен через Commit.
 func (p *Producer) Close() error {
-	return p.file.Close()
+	if p.file == nil {
+		return nil
+	}
+	err := p.file.Close()
+	tmp := p.file.Name()
+	p.file = nil
+
+	return errors.Join(err, os.Remove(tmp))
+}
+
+// Save сохраняет снимок метрик в файл.
+func Save(filename string, metrics map[string]interface{}) error {
+	producer, err := NewProducer(filename)
+	if err != nil {
+		return err
+	}
+	defer producer.Close()
+
+	if err := producer.WriteJSON(metrics); err != nil {
+		return err
+	}
+
+	return producer.Commit()
+}
+
+// saveMu упорядочивает сохранения снимка из хранилища: снимок, прочитанный позже, и заменяет файл позже.
+// Без него сохранение, прочитавшее метрики раньше, могло бы завершить Commit последним
+// и заменить более новый снимок.
+var saveMu sync.Mutex
+
+// SaveMetrics читает метрики через getAllMetrics и сохраняет их в файл. Чтение и замена файла
+// выполняются под одной блокировкой для всех сохранений сервера: синхронных и по интервалу.
+func SaveMetrics(ctx context.Context, filename string, getAllMetrics func(ctx context.Context) (map[string]interface{}, error)) error {
+	saveMu.Lock()
+	defer saveMu.Unlock()
+
+	res, err := getAllMetrics(ctx)
+	if err != nil {
+		return err
+	}
+
+	return Save(filename, res)
 }
 
 type Consumer struct {
@@ -71,18 +200,22 @@ type Consumer struct {
 }
 
 func NewConsumer(filename string) (*Consumer, error) {
-	file, err := os.OpenFile(filename, os.O_RDONLY|os.O_CREATE, 0666)
+	file, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
 
+	// создаём новый scanner, строка с метриками может быть больше буфера по умолчанию
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, maxLineSize)
+
 	return &Consumer{
-		file: file,
-		// создаём новый scanner
-		scanner: bufio.NewScanner(file),
+		file:    file,
+		scanner: scanner,
 	}, nil
 }
 
+// ReadJSON читает метрики и проверяет их по заголовку. Пустой файл - nil без ошибки.
 func (c *Consumer) ReadJSON() (*StructFile, error) {
 	// одиночное сканирование до следующей строки
 	if !c.scanner.Scan() {
@@ -91,10 +224,31 @@ func (c *Consumer) ReadJSON() (*StructFile, error) {
 	// читаем данные из scanner
 	data := c.scanner.Bytes()
 
+	var header Header
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupted, err)
+	}
+	// файл без заголовка: первая строка и есть метрики
+	if header.Version > 0 {
+		if header.Version > FormatVersion {
+			return nil, fmt.Errorf("unsupported metrics file version %d", header.Version)
+		}
+		if !c.scanner.Scan() {
+			if err := c.scanner.Err(); err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%w: no metrics after header", ErrCorrupted)
+		}
+		data = c.scanner.Bytes()
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != header.Checksum {
+			return nil, fmt.Errorf("%w: checksum mismatch", ErrCorrupted)
+		}
+	}
+
 	metrics := StructFile{}
-	err := json.Unmarshal(data, &metrics)
-	if err != nil {
-		return nil, err
+	if err := json.Unmarshal(data, &metrics); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupted, err)
 	}
 
 	return &metrics, nil
@@ -103,3 +257,17 @@ func (c *Consumer) ReadJSON() (*StructFile, error) {
 func (c *Consumer) Close() error {
 	return c.file.Close()
 }
+
+// Read читает метрики из файла. Отсутствующий или пустой файл - nil без ошибки.
+func Read(filename string) (*StructFile, error) {
+	consumer, err := NewConsumer(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer consumer.Close()
+
+	return consumer.ReadJSON()
+}
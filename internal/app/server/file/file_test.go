@@ -0,0 +1,201 @@
+package file
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/config"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories/store"
+)
+
+func metrics(counter int64) map[string]interface{} {
+	return map[string]interface{}{
+		"counter": map[string]store.Counter{"PollCount": store.Counter(counter)},
+		"gauge":   map[string]store.Gauge{"Alloc": 1.5},
+	}
+}
+
+// assertNoTemp проверяет, что рядом с файлом метрик не осталось временных файлов.
+func assertNoTemp(t *testing.T, name string) {
+	t.Helper()
+	tmp, err := filepath.Glob(name + ".*.tmp")
+	require.NoError(t, err)
+	assert.Empty(t, tmp)
+}
+
+func TestSave(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "metrics.json")
+
+	require.NoError(t, Save(name, metrics(1)))
+	require.NoError(t, Save(name, metrics(2)))
+
+	f, err := os.Open(name)
+	require.NoError(t, err)
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	require.True(t, scanner.Scan())
+	var header Header
+	require.NoError(t, json.Unmarshal(scanner.Bytes(), &header))
+	assert.Equal(t, FormatVersion, header.Version)
+	assert.Len(t, header.Checksum, 64)
+	assert.False(t, header.Timestamp.IsZero())
+
+	res, err := Read(name)
+	require.NoError(t, err)
+	assert.Equal(t, store.Counter(2), res.Counter["PollCount"])
+	assert.Equal(t, store.Gauge(1.5), res.Gauge["Alloc"])
+
+	// прежний снимок сохраняется при замене
+	prev, err := Read(Previous(name))
+	require.NoError(t, err)
+	assert.Equal(t, store.Counter(1), prev.Counter["PollCount"])
+	assertNoTemp(t, name)
+}
+
+func TestSaveConcurrent(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "metrics.json")
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(t, Save(name, metrics(int64(i))))
+		}(i)
+	}
+	wg.Wait()
+
+	// параллельные сохранения не портят ни файл метрик, ни предыдущий снимок
+	res, err := Read(name)
+	require.NoError(t, err)
+	require.NotNil(t, res)
+	prev, err := Read(Previous(name))
+	require.NoError(t, err)
+	require.NotNil(t, prev)
+	assertNoTemp(t, name)
+}
+
+func TestSaveMetricsOrder(t *testing.T) {
+	ctx := context.Background()
+	name := filepath.Join(t.TempDir(), "metrics.json")
+
+	entered, release := make(chan struct{}), make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- SaveMetrics(ctx, name, func(context.Context) (map[string]interface{}, error) {
+			close(entered)
+			<-release
+			return metrics(1), nil
+		})
+	}()
+	<-entered
+
+	// сохранение, начатое позже, ждет предыдущее и не заменяется его более старым снимком
+	go func() {
+		done <- SaveMetrics(ctx, name, func(context.Context) (map[string]interface{}, error) {
+			return metrics(2), nil
+		})
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	require.NoError(t, <-done)
+	require.NoError(t, <-done)
+
+	res, err := Read(name)
+	require.NoError(t, err)
+	assert.Equal(t, store.Counter(2), res.Counter["PollCount"])
+}
+
+func TestProducerWithoutCommit(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "metrics.json")
+	require.NoError(t, Save(name, metrics(1)))
+
+	// сбой до Commit: файл метрик не тронут, временный файл удален
+	producer, err := NewProducer(name)
+	require.NoError(t, err)
+	require.NoError(t, producer.WriteJSON(metrics(2)))
+	require.NoError(t, producer.Close())
+
+	res, err := Read(name)
+	require.NoError(t, err)
+	assert.Equal(t, store.Counter(1), res.Counter["PollCount"])
+	assertNoTemp(t, name)
+}
+
+func TestRead(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("missing file", func(t *testing.T) {
+		res, err := Read(filepath.Join(dir, "missing.json"))
+		require.NoError(t, err)
+		assert.Nil(t, res)
+	})
+	t.Run("file without header", func(t *testing.T) {
+		name := filepath.Join(dir, "legacy.json")
+		require.NoError(t, os.WriteFile(name, []byte(`{"counter":{"PollCount":3},"gauge":{"Alloc":2}}`+"\n"), 0o600))
+		res, err := Read(name)
+		require.NoError(t, err)
+		assert.Equal(t, store.Counter(3), res.Counter["PollCount"])
+	})
+	t.Run("unsupported version", func(t *testing.T) {
+		name := filepath.Join(dir, "future.json")
+		require.NoError(t, os.WriteFile(name, []byte(`{"version":99}`+"\n{}\n"), 0o600))
+		_, err := Read(name)
+		assert.Error(t, err)
+	})
+	t.Run("truncated file", func(t *testing.T) {
+		name := filepath.Join(dir, "truncated.json")
+		require.NoError(t, Save(name, metrics(1)))
+		data, err := os.ReadFile(name)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(name, data[:len(data)-10], 0o600))
+		_, err = Read(name)
+		assert.ErrorIs(t, err, ErrCorrupted)
+	})
+}
+
+func TestReader(t *testing.T) {
+	defer func(a *config.AppConfig) { app = a }(app)
+	name := filepath.Join(t.TempDir(), "metrics.json")
+	app = &config.AppConfig{FileStore: config.RecorderConfig{FilePath: name}}
+
+	res, err := Reader()
+	require.NoError(t, err)
+	assert.Nil(t, res)
+
+	require.NoError(t, Save(name, metrics(1)))
+	require.NoError(t, Save(name, metrics(2)))
+	res, err = Reader()
+	require.NoError(t, err)
+	assert.Equal(t, store.Counter(2), res.Counter["PollCount"])
+
+	// поврежденный снимок: метрики берутся из предыдущего
+	data, err := os.ReadFile(name)
+	require.NoError(t, err)
+	data[len(data)-5] ^= 0xff
+	require.NoError(t, os.WriteFile(name, data, 0o600))
+	res, err = Reader()
+	require.NoError(t, err)
+	assert.Equal(t, store.Counter(1), res.Counter["PollCount"])
+
+	// сбой между переименованиями: файла метрик нет, есть только предыдущий снимок
+	require.NoError(t, os.Remove(name))
+	res, err = Reader()
+	require.NoError(t, err)
+	assert.Equal(t, store.Counter(1), res.Counter["PollCount"])
+
+	// без предыдущего снимка поврежденный файл - ошибка
+	require.NoError(t, os.WriteFile(name, data, 0o600))
+	require.NoError(t, os.Remove(Previous(name)))
+	_, err = Reader()
+	assert.ErrorIs(t, err, ErrCorrupted)
+}
@@ -2,13 +2,14 @@ package file
 
 import (
 	"context"
+
 	"github.com/webkimru/go-yandex-metrics/internal/app/server/config"
 	"github.com/webkimru/go-yandex-metrics/internal/app/server/logger"
 )
 
 func SyncWriter(ctx context.Context, getAllMetrics func(ctx context.Context) (map[string]interface{}, error)) error {
-	// Если используется база данных в качестве хранилища, то ничего не делаем
-	if app.StorePriority == config.Database {
+	// Если используется база данных или хранилище в файлах с журналом, то ничего не делаем
+	if app.StorePriority != config.Memory {
 		return nil
 	}
 
@@ -22,35 +23,36 @@ func SyncWriter(ctx context.Context, getAllMetrics func(ctx context.Context) (ma
 		return nil
 	}
 
-	res, err := getAllMetrics(ctx)
-	if err != nil {
-		logger.Log.Errorln("failed to get the data from storage, GetAllMetrics() = ", err)
-	}
-
 	// записываем в файл
-	producer, err := NewProducer(app.FileStore.FilePath)
-	if err != nil {
-		return err
-	}
-	if err := producer.WriteJSON(res); err != nil {
-		return err
-	}
-	defer producer.Close()
-
-	return nil
+	return SaveMetrics(ctx, app.FileStore.FilePath, getAllMetrics)
 }
 
+// Reader читает метрики из файла. Если файл поврежден или отсутствует, например после сбоя
+// между переименованиями при сохранении, метрики читаются из предыдущего снимка.
 func Reader() (*StructFile, error) {
 	// читаем из файла
-	consumer, err := NewConsumer(app.FileStore.FilePath)
-	if err != nil {
-		return nil, err
+	res, err := Read(app.FileStore.FilePath)
+	if err == nil && res != nil {
+		return res, nil
 	}
-	res, err := consumer.ReadJSON()
 	if err != nil {
+		logger.Log.Errorf("failed to read metrics from file=%s: %v", app.FileStore.FilePath, err)
+	}
+
+	previous := Previous(app.FileStore.FilePath)
+	prev, prevErr := Read(previous)
+	if prevErr != nil {
+		logger.Log.Errorf("failed to read metrics from file=%s: %v", previous, prevErr)
+		if err != nil {
+			return nil, err
+		}
+		return nil, prevErr
+	}
+	// без предыдущего снимка поврежденный файл остается ошибкой
+	if prev == nil {
 		return nil, err
 	}
-	defer consumer.Close()
+	logger.Log.Warnf("metrics restored from previous snapshot file=%s", previous)
 
-	return res, nil
+	return prev, nil
 }
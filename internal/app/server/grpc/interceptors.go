@@ -0,0 +1,288 @@
+package grpc
+
+import (
+	"errors"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/auth"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/certs"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/config"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/logger"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/ratelimit"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/subnet"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/tenant"
+	"github.com/webkimru/go-yandex-metrics/internal/security"
+	"golang.org/x/net/context"
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// ServerOptions возвращает настройки gRPC-сервера: цепочку перехватчиков и ограничение размера сообщения.
+// gRPC проверяет размер сообщения после распаковки, поэтому используется Limits.MaxDecompressedSize.
+func ServerOptions() []gogrpc.ServerOption {
+	opts := []gogrpc.ServerOption{
+		gogrpc.ChainUnaryInterceptor(SubnetInterceptor, IdentityInterceptor, RateLimitInterceptor, TenantInterceptor, AuthInterceptor, AdminInterceptor),
+	}
+	if app == nil {
+		return opts
+	}
+	if app.Limits.MaxDecompressedSize > 0 {
+		opts = append(opts, gogrpc.MaxRecvMsgSize(int(app.Limits.MaxDecompressedSize)))
+	}
+	if app.Certs != nil {
+		opts = append(opts, gogrpc.Creds(credentials.NewTLS(app.Certs.TLSConfig())))
+	}
+	return opts
+}
+
+// IdentityInterceptor подставляет в metadata x-agent-id идентификатор агента из проверенного
+// сертификата клиента, чтобы учет агентов и ограничение частоты вызовов не доверяли присланному значению.
+func IdentityInterceptor(ctx context.Context, req interface{}, _ *gogrpc.UnaryServerInfo, handler gogrpc.UnaryHandler) (interface{}, error) {
+	id, ok := certIdentity(ctx)
+	if !ok {
+		return handler(ctx, req)
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	md = md.Copy()
+	md.Set("x-agent-id", id)
+
+	return handler(metadata.NewIncomingContext(ctx, md), req)
+}
+
+// certIdentity возвращает идентификатор агента из сертификата клиента, проверенного по client_ca_file.
+func certIdentity(ctx context.Context) (string, bool) {
+	if app == nil || app.TLS.ClientCAFile == "" {
+		return "", false
+	}
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.PeerCertificates) == 0 {
+		return "", false
+	}
+
+	return certs.Identity(info.State.PeerCertificates[0], app.TLS.Identities), true
+}
+
+// RateLimitInterceptor ограничивает частоту вызовов клиента. Клиент определяется по настройке Limits.Key:
+// по адресу соединения, по идентификатору агента из проверенного сертификата клиента или по проверенному
+// токену из authorization. Присланным metadata x-agent-id и authorization ключ не доверяет: без сертификата
+// или с неверным токеном клиентом считается адрес соединения.
+// Время до следующего разрешенного вызова передается в заголовке retry-after.
+func RateLimitInterceptor(ctx context.Context, req interface{}, _ *gogrpc.UnaryServerInfo, handler gogrpc.UnaryHandler) (interface{}, error) {
+	if Repo == nil || Repo.Limiter == nil {
+		return handler(ctx, req)
+	}
+	if app.Limits.Key == config.LimitByToken {
+		var err error
+		if ctx, err = verifyToken(ctx); err != nil {
+			logger.Log.Errorln("failed to check the token, Authenticate() =", err)
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	if ok, wait := Repo.Limiter.Allow(clientKey(ctx), time.Now()); !ok {
+		return nil, resourceExhausted(ctx, wait, "request rate limit exceeded")
+	}
+
+	return handler(ctx, req)
+}
+
+// clientKey возвращает ключ клиента для ограничения частоты вызовов.
+func clientKey(ctx context.Context) string {
+	switch app.Limits.Key {
+	case config.LimitByAgent:
+		if id, ok := certIdentity(ctx); ok {
+			return "agent:" + id
+		}
+	case config.LimitByToken:
+		if security.CheckBearer(incoming(ctx, "authorization"), app.AdminKey) {
+			return "token:admin"
+		}
+		// сам токен в памяти не хранится, только его идентификатор
+		if token := auth.FromContext(ctx); token != nil {
+			return "token:" + token.ID
+		}
+	}
+
+	return "ip:" + clientIP(ctx).String()
+}
+
+// SubnetInterceptor допускает вызовы только с адресов из разрешенных и не из запрещенных подсетей.
+// Адрес клиента берется из соединения, а metadata x-forwarded-for и x-real-ip учитываются,
+// только если вызов пришел от доверенного прокси.
+func SubnetInterceptor(ctx context.Context, req interface{}, _ *gogrpc.UnaryServerInfo, handler gogrpc.UnaryHandler) (interface{}, error) {
+	if app == nil || app.SubnetPolicy == nil {
+		return handler(ctx, req)
+	}
+
+	if ip := clientIP(ctx); !app.SubnetPolicy.Allowed(ip) {
+		return nil, status.Errorf(codes.PermissionDenied, "address %s is not allowed", ip)
+	}
+
+	return handler(ctx, req)
+}
+
+// clientIP возвращает адрес клиента с учетом доверенных прокси.
+func clientIP(ctx context.Context) netip.Addr {
+	var remoteAddr string
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		remoteAddr = p.Addr.String()
+	}
+	var forwardedFor []string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		forwardedFor = md.Get("x-forwarded-for")
+	}
+	var policy *subnet.Policy
+	if app != nil {
+		policy = app.SubnetPolicy
+	}
+	return policy.ClientIP(remoteAddr, forwardedFor, incoming(ctx, "x-real-ip"))
+}
+
+// resourceExhausted возвращает ошибку ResourceExhausted и передает клиенту заголовок retry-after в секундах.
+func resourceExhausted(ctx context.Context, wait time.Duration, format string, args ...interface{}) error {
+	if err := gogrpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.Itoa(ratelimit.RetryAfter(wait)))); err != nil {
+		logger.Log.Debugln("failed to set retry-after header, SetHeader() =", err)
+	}
+	return status.Errorf(codes.ResourceExhausted, format, args...)
+}
+
+// adminMethods методы, доступные только с административным ключом или токеном admin.
+var adminMethods = map[string]bool{
+	"DeleteMetric":  true,
+	"ResetCounter":  true,
+	"DeleteMetrics": true,
+}
+
+// AdminInterceptor проверяет административный ключ в metadata authorization: Bearer <key>
+// для методов удаления и сброса метрик. Вместо ключа можно передать токен в области действия admin.
+// Без настроенного ключа и токенов эти методы запрещены.
+func AdminInterceptor(ctx context.Context, req interface{}, info *gogrpc.UnaryServerInfo, handler gogrpc.UnaryHandler) (interface{}, error) {
+	if !adminMethods[methodName(info)] {
+		return handler(ctx, req)
+	}
+
+	if app == nil || (app.AdminKey == "" && app.Tokens == nil) {
+		return nil, status.Error(codes.PermissionDenied, "admin methods are disabled")
+	}
+
+	return authorize(ctx, req, handler, auth.ScopeAdmin)
+}
+
+// AuthInterceptor требует токен в области действия write для отправки метрик,
+// если включена аутентификация по токенам. Административные методы проверяет AdminInterceptor.
+func AuthInterceptor(ctx context.Context, req interface{}, info *gogrpc.UnaryServerInfo, handler gogrpc.UnaryHandler) (interface{}, error) {
+	if app == nil || app.Tokens == nil || adminMethods[methodName(info)] {
+		return handler(ctx, req)
+	}
+
+	return authorize(ctx, req, handler, auth.ScopeWrite)
+}
+
+// TenantInterceptor определяет тенанта вызова по токену или metadata x-tenant-id (тенант должен быть
+// разрешен или уже создан) и проверяет ограничение частоты запросов тенанта. Без включенной изоляции
+// тенантов все вызовы относятся к тенанту по умолчанию.
+func TenantInterceptor(ctx context.Context, req interface{}, _ *gogrpc.UnaryServerInfo, handler gogrpc.UnaryHandler) (interface{}, error) {
+	if app == nil || Repo == nil || Repo.Tenants == nil {
+		return handler(ctx, req)
+	}
+
+	// токен проверяется заранее, чтобы узнать его тенанта; неверный токен отклонит authorize
+	ctx, err := verifyToken(ctx)
+	if err != nil {
+		logger.Log.Errorln("failed to check the token, Authenticate() =", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	name, err := Repo.Tenants.Resolve(incoming(ctx, tenant.MetadataTenantID), auth.FromContext(ctx).TenantName())
+	if errors.Is(err, tenant.ErrForbidden) || errors.Is(err, tenant.ErrUnknownTenant) {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if ok, wait := Repo.Tenants.Allow(name, time.Now()); !ok {
+		return nil, resourceExhausted(ctx, wait, "tenant=%s request rate limit exceeded", name)
+	}
+
+	return handler(tenant.WithTenant(ctx, name), req)
+}
+
+// verifyToken проверяет токен из metadata до authorize и сохраняет его в контексте, если он еще не проверен.
+// Неверный токен не ошибка: вызов без проверенного токена отклонит authorize.
+func verifyToken(ctx context.Context) (context.Context, error) {
+	authorization := incoming(ctx, "authorization")
+	if app.Tokens == nil || auth.FromContext(ctx) != nil || security.CheckBearer(authorization, app.AdminKey) {
+		return ctx, nil
+	}
+	raw := auth.FromAuthorization(authorization)
+	if raw == "" {
+		return ctx, nil
+	}
+	token, err := app.Tokens.Authenticate(ctx, raw)
+	if errors.Is(err, auth.ErrInvalidToken) {
+		return ctx, nil
+	}
+	if err != nil {
+		return ctx, err
+	}
+
+	return auth.WithToken(ctx, token), nil
+}
+
+// authorize проверяет административный ключ или токен из metadata и сохраняет токен в контексте.
+func authorize(ctx context.Context, req interface{}, handler gogrpc.UnaryHandler, scope string) (interface{}, error) {
+	authorization := incoming(ctx, "authorization")
+	if security.CheckBearer(authorization, app.AdminKey) {
+		return handler(ctx, req)
+	}
+
+	// токен уже проверен при определении тенанта
+	token := auth.FromContext(ctx)
+	if token == nil {
+		raw := auth.FromAuthorization(authorization)
+		if app.Tokens == nil || raw == "" {
+			return nil, status.Error(codes.Unauthenticated, "invalid admin key or token")
+		}
+		var err error
+		token, err = app.Tokens.Authenticate(ctx, raw)
+		if errors.Is(err, auth.ErrInvalidToken) {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+		if err != nil {
+			logger.Log.Errorln("failed to check the token, Authenticate() =", err)
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+	if !token.Allows(scope) {
+		return nil, status.Errorf(codes.PermissionDenied, "token has no %s scope", scope)
+	}
+
+	return handler(auth.WithToken(ctx, token), req)
+}
+
+// incoming возвращает первое значение ключа metadata вызова.
+func incoming(ctx context.Context, key string) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(key); len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+// methodName возвращает имя метода без имени сервиса.
+func methodName(info *gogrpc.UnaryServerInfo) string {
+	return info.FullMethod[strings.LastIndex(info.FullMethod, "/")+1:]
+}
@@ -0,0 +1,180 @@
+package grpc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/auth"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/config"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/ratelimit"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories/store"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/subnet"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/tenant"
+	pb "github.com/webkimru/go-yandex-metrics/internal/proto"
+	"golang.org/x/net/context"
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestInterceptors(t *testing.T) {
+	tokens, err := auth.NewFileStore(filepath.Join(t.TempDir(), "tokens.json"))
+	require.NoError(t, err)
+	a := auth.New(tokens)
+	ctx := context.Background()
+	read, _, err := a.Issue(ctx, "reader", []string{auth.ScopeRead}, "", "")
+	require.NoError(t, err)
+	write, _, err := a.Issue(ctx, "agent", []string{auth.ScopeWrite}, "host1.", "")
+	require.NoError(t, err)
+	admin, _, err := a.Issue(ctx, "admin", []string{auth.ScopeAdmin}, "", "")
+	require.NoError(t, err)
+
+	defer func(c *config.AppConfig) { app = c }(app)
+	app = &config.AppConfig{Tokens: a}
+	s := NewRepo(store.NewMemStorage())
+	// цепочка как у сервера: сначала токен, затем административные методы
+	call := func(method, token string, req interface{}, handler gogrpc.UnaryHandler) error {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+		info := &gogrpc.UnaryServerInfo{FullMethod: "/metrics.Metrics/" + method}
+		_, err := AuthInterceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return AdminInterceptor(ctx, req, info, handler)
+		})
+		return err
+	}
+	update := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return s.UpdateBatchMetrics(ctx, req.(*pb.RequestMetricBatch))
+	}
+	batch := func(id string) *pb.RequestMetricBatch {
+		return &pb.RequestMetricBatch{RequestMetrics: []*pb.RequestMetricBatch_RequestMetric{{Id: id, Type: "counter", Delta: 1}}}
+	}
+	deleteMetrics := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return s.DeleteMetrics(ctx, req.(*pb.DeleteMetricsRequest))
+	}
+
+	assert.NoError(t, call("UpdateBatchMetrics", write, batch("host1.requests"), update))
+	assert.Equal(t, codes.PermissionDenied, status.Code(call("UpdateBatchMetrics", write, batch("host2.requests"), update)))
+	assert.Equal(t, codes.PermissionDenied, status.Code(call("UpdateBatchMetrics", read, batch("host1.requests"), update)))
+	assert.Equal(t, codes.Unauthenticated, status.Code(call("UpdateBatchMetrics", "", batch("host1.requests"), update)))
+
+	assert.Equal(t, codes.PermissionDenied, status.Code(call("DeleteMetrics", write, &pb.DeleteMetricsRequest{}, deleteMetrics)))
+	// удаление без отбора требует явного all
+	assert.Equal(t, codes.InvalidArgument, status.Code(call("DeleteMetrics", admin, &pb.DeleteMetricsRequest{}, deleteMetrics)))
+	assert.NoError(t, call("DeleteMetrics", admin, &pb.DeleteMetricsRequest{All: true}, deleteMetrics))
+}
+
+func TestTenantInterceptor(t *testing.T) {
+	defer func(c *config.AppConfig, r *MetricsServer) { app, Repo = c, r }(app, Repo)
+	app = &config.AppConfig{}
+	db := tenant.NewStore(store.NewMemStorage(), func(context.Context, string) (repositories.StoreRepository, error) {
+		return store.NewMemStorage(), nil
+	}, config.TenantsConfig{Enabled: true, Allowed: []string{"team_a", "team_b"}, Limits: config.TenantLimits{MaxSeries: 1}})
+	Repo = NewRepo(db)
+	Repo.Tenants = db
+
+	info := &gogrpc.UnaryServerInfo{FullMethod: "/metrics.Metrics/UpdateBatchMetrics"}
+	call := func(name, id string) error {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(tenant.MetadataTenantID, name))
+		req := &pb.RequestMetricBatch{RequestMetrics: []*pb.RequestMetricBatch_RequestMetric{{Id: id, Type: "counter", Delta: 1}}}
+		_, err := TenantInterceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return Repo.UpdateBatchMetrics(ctx, req.(*pb.RequestMetricBatch))
+		})
+		return err
+	}
+
+	require.NoError(t, call("team_a", "requests"))
+	require.NoError(t, call("team_b", "errors"))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(call("team_a", "errors")))
+	assert.Equal(t, codes.InvalidArgument, status.Code(call("Team A", "requests")))
+	assert.Equal(t, codes.PermissionDenied, status.Code(call("team_c", "requests")))
+
+	delta, err := db.GetCounter(tenant.WithTenant(context.Background(), "team_b"), "errors")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), delta)
+	_, err = db.GetCounter(context.Background(), "requests")
+	assert.Error(t, err)
+}
+
+func TestRateLimitInterceptor(t *testing.T) {
+	defer func(c *config.AppConfig, r *MetricsServer) { app, Repo = c, r }(app, Repo)
+	tokens, err := auth.NewFileStore(filepath.Join(t.TempDir(), "tokens.json"))
+	require.NoError(t, err)
+	a := auth.New(tokens)
+	host1, _, err := a.Issue(context.Background(), "host1", []string{auth.ScopeWrite}, "", "")
+	require.NoError(t, err)
+	host2, _, err := a.Issue(context.Background(), "host2", []string{auth.ScopeWrite}, "", "")
+	require.NoError(t, err)
+
+	info := &gogrpc.UnaryServerInfo{FullMethod: "/metrics.Metrics/UpdateBatchMetrics"}
+	call := func(cn string, pairs ...string) error {
+		p := &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234}}
+		if cn != "" {
+			p.AuthInfo = credentials.TLSInfo{State: tls.ConnectionState{
+				PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: cn}}},
+			}}
+		}
+		ctx := metadata.NewIncomingContext(peer.NewContext(context.Background(), p), metadata.Pairs(pairs...))
+		_, err := RateLimitInterceptor(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, nil
+		})
+		return err
+	}
+	setup := func(key string) {
+		app = &config.AppConfig{Limits: config.LimitsConfig{Key: key}, TLS: config.TLSConfig{ClientCAFile: "ca.pem"}, Tokens: a}
+		Repo = NewRepo(store.NewMemStorage())
+		Repo.Limiter = ratelimit.New(1, 1)
+	}
+
+	// агент из проверенного сертификата расходует свою корзину
+	setup(config.LimitByAgent)
+	require.NoError(t, call("host1"))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(call("host1")))
+	assert.NoError(t, call("host2"))
+
+	// присланный x-agent-id не дает новой корзины: ключом остается адрес
+	setup(config.LimitByAgent)
+	require.NoError(t, call("", "x-agent-id", "host1"))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(call("", "x-agent-id", "host2")))
+
+	// проверенные токены расходуют свои корзины, неверные - корзину адреса
+	setup(config.LimitByToken)
+	require.NoError(t, call("", "authorization", "Bearer "+host1))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(call("", "authorization", "Bearer "+host1)))
+	assert.NoError(t, call("", "authorization", "Bearer "+host2))
+	require.NoError(t, call("", "authorization", "Bearer a"))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(call("", "authorization", "Bearer b")))
+}
+
+func TestSubnetInterceptor(t *testing.T) {
+	defer func(c *config.AppConfig) { app = c }(app)
+	policy, err := subnet.New([]string{"10.0.0.0/8", "2001:db8::/32"}, []string{"10.0.1.0/24"}, []string{"192.168.0.1"})
+	require.NoError(t, err)
+	app = &config.AppConfig{SubnetPolicy: policy}
+
+	call := func(addr string, pairs ...string) error {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(addr), Port: 1234}})
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(pairs...))
+		_, err := SubnetInterceptor(ctx, nil, &gogrpc.UnaryServerInfo{}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, nil
+		})
+		return err
+	}
+
+	assert.NoError(t, call("10.0.0.1"))
+	assert.NoError(t, call("2001:db8::1"))
+	assert.Equal(t, codes.PermissionDenied, status.Code(call("10.0.1.1")))
+	assert.Equal(t, codes.PermissionDenied, status.Code(call("172.16.0.1")))
+	// metadata x-real-ip учитывается только от доверенного прокси
+	assert.Equal(t, codes.PermissionDenied, status.Code(call("172.16.0.1", "x-real-ip", "10.0.0.1")))
+	assert.NoError(t, call("192.168.0.1", "x-real-ip", "10.0.0.1"))
+	assert.NoError(t, call("192.168.0.1", "x-forwarded-for", "10.0.0.1"))
+}
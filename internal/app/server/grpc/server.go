@@ -1,13 +1,28 @@
 package grpc
 
 import (
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/auth"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/config"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/inventory"
 	"github.com/webkimru/go-yandex-metrics/internal/app/server/models"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/ratelimit"
 	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/tenant"
 	pb "github.com/webkimru/go-yandex-metrics/internal/proto"
 	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 var Repo *MetricsServer
+var app *config.AppConfig
 
 // MetricsServer поддерживает все необходимые методы сервера.
 type MetricsServer struct {
@@ -16,6 +31,12 @@ type MetricsServer struct {
 	pb.UnimplementedMetricsServer
 	// добавляем хранилище
 	Store repositories.StoreRepository
+	// Agents реестр агентов, присылающих метрики, nil - учет выключен.
+	Agents *inventory.Registry
+	// Tenants тенанты с их ограничениями, nil - изоляция тенантов выключена.
+	Tenants *tenant.Store
+	// Limiter ограничение частоты запросов клиента, nil - без ограничения.
+	Limiter *ratelimit.Limiter
 }
 
 func (s *MetricsServer) UpdateBatchMetrics(ctx context.Context, in *pb.RequestMetricBatch) (*pb.ResponseMetric, error) {
@@ -23,28 +44,215 @@ func (s *MetricsServer) UpdateBatchMetrics(ctx context.Context, in *pb.RequestMe
 	var metrics []models.Metrics
 
 	for _, request := range in.RequestMetrics {
-		metrics = append(metrics, models.Metrics{
+		metric := models.Metrics{
 			Delta: &request.Delta,
 			Value: &request.Value,
 			ID:    request.Id,
 			MType: request.Type,
-		})
+		}
+		if request.Type == "histogram" {
+			metric.Histogram = &models.Histogram{
+				Bounds: request.Bounds,
+				Counts: request.Counts,
+				Sum:    request.Sum,
+				Count:  request.Count,
+			}
+		}
+		metrics = append(metrics, metric)
 	}
 
-	err := s.Store.UpdateBatchMetrics(ctx, metrics)
-	if err != nil {
+	if err := checkPrefix(ctx, metrics); err != nil {
 		return nil, err
 	}
 
+	res, err := repositories.ApplyBatch(ctx, s.Store, models.Batch{
+		ID:      in.BatchId,
+		Mode:    batchMode(),
+		Metrics: metrics,
+	})
+	var verr *models.ValidationError
+	if errors.As(err, &verr) {
+		return nil, invalidArgument(err)
+	}
+	if err != nil {
+		return nil, storeError(err)
+	}
+	// агент учитывается по принятым метрикам, отклоненный или повторный батч его не отмечает
+	if res.Applied > 0 {
+		s.trackAgent(ctx, res.Applied)
+	}
+
+	response.Duplicate = res.Duplicate
+	response.Applied = int32(res.Applied)
+	response.Rejected = int32(res.Rejected)
+	for _, item := range res.Items {
+		response.Items = append(response.Items, &pb.BatchItemResult{
+			Index:  int32(item.Index),
+			Id:     item.ID,
+			Type:   item.MType,
+			Status: item.Status,
+			Error:  item.Error,
+		})
+	}
+	if res.Rejected > 0 {
+		response.Error = fmt.Sprintf("%d of %d metrics rejected", res.Rejected, len(res.Items))
+	}
+
 	return &response, nil
 }
 
+// trackAgent отмечает отправителя метрик в реестре агентов по metadata и адресу клиента,
+// определенному с учетом доверенных прокси.
+func (s *MetricsServer) trackAgent(ctx context.Context, metrics int) {
+	if s.Agents == nil {
+		return
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	s.Agents.Seen(inventory.FromMetadata(ctx, md, clientIP(ctx)), metrics, time.Now())
+}
+
+// batchMode возвращает настроенный режим применения батчей.
+func batchMode() models.BatchMode {
+	if app == nil || app.BatchMode == "" {
+		return models.BatchTransactional
+	}
+	return app.BatchMode
+}
+
+// DeleteMetric удаляет метрику заданного типа.
+func (s *MetricsServer) DeleteMetric(ctx context.Context, in *pb.DeleteMetricRequest) (*pb.ResponseMetric, error) {
+	if !isMetricType(in.Type) {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown metric type=%q", in.Type)
+	}
+	if !auth.FromContext(ctx).AllowsMetric(in.Id) {
+		return nil, status.Errorf(codes.PermissionDenied, "metric id=%q is outside of the token prefix", in.Id)
+	}
+	if err := s.Store.DeleteMetric(ctx, in.Type, in.Id); err != nil {
+		return nil, storeError(err)
+	}
+
+	return &pb.ResponseMetric{}, nil
+}
+
+// ResetCounter обнуляет счетчик Counter.
+func (s *MetricsServer) ResetCounter(ctx context.Context, in *pb.ResetCounterRequest) (*pb.ResponseMetric, error) {
+	if !auth.FromContext(ctx).AllowsMetric(in.Id) {
+		return nil, status.Errorf(codes.PermissionDenied, "metric id=%q is outside of the token prefix", in.Id)
+	}
+	if err := s.Store.ResetCounter(ctx, in.Id); err != nil {
+		return nil, storeError(err)
+	}
+
+	return &pb.ResponseMetric{}, nil
+}
+
+// DeleteMetrics удаляет метрики, отобранные по типу и регулярному выражению для имени.
+// Запрос без отбора по типу или имени требует явного all = true.
+func (s *MetricsServer) DeleteMetrics(ctx context.Context, in *pb.DeleteMetricsRequest) (*pb.DeleteMetricsResponse, error) {
+	if len(in.Types) == 0 && in.Regex == "" && !in.All {
+		return nil, status.Error(codes.InvalidArgument, "types or regex is required, use all to delete all metrics")
+	}
+	for _, t := range in.Types {
+		if !isMetricType(t) {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown metric type=%q", t)
+		}
+	}
+	if _, err := regexp.Compile(in.Regex); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid regex: %v", err)
+	}
+
+	deleted, err := s.Store.DeleteMetrics(ctx, models.MetricFilter{
+		Types:     in.Types,
+		NameRegex: in.Regex,
+		Prefix:    auth.FromContext(ctx).MetricPrefix(),
+	})
+	if err != nil {
+		return nil, storeError(err)
+	}
+
+	return &pb.DeleteMetricsResponse{Deleted: deleted}, nil
+}
+
+// invalidArgument переводит ошибку проверки метрик в статус InvalidArgument
+// с описанием некорректных полей в деталях errdetails.BadRequest.
+func invalidArgument(err error) error {
+	st := status.New(codes.InvalidArgument, err.Error())
+	var verr *models.ValidationError
+	if !errors.As(err, &verr) {
+		return st.Err()
+	}
+
+	if detailed, derr := st.WithDetails(fieldViolations(verr.Params)); derr == nil {
+		st = detailed
+	}
+
+	return st.Err()
+}
+
+// fieldViolations описывает некорректные поля метрик батча в деталях errdetails.BadRequest.
+func fieldViolations(params []models.InvalidParam) *errdetails.BadRequest {
+	br := &errdetails.BadRequest{}
+	for _, p := range params {
+		field := p.Name
+		if p.Index != nil {
+			field = fmt.Sprintf("requestMetrics[%d].%s", *p.Index, p.Name)
+		}
+		br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       field,
+			Description: p.Reason,
+		})
+	}
+
+	return br
+}
+
+// checkPrefix проверяет, что все метрики батча доступны токену запроса.
+func checkPrefix(ctx context.Context, metrics []models.Metrics) error {
+	token := auth.FromContext(ctx)
+	var params []models.InvalidParam
+	for i := range metrics {
+		if !token.AllowsMetric(metrics[i].ID) {
+			index := i
+			params = append(params, models.InvalidParam{
+				Name:   "id",
+				Reason: fmt.Sprintf("metric name must start with %q", token.MetricPrefix()),
+				Index:  &index,
+			})
+		}
+	}
+	if len(params) == 0 {
+		return nil
+	}
+
+	st := status.New(codes.PermissionDenied, (&models.ValidationError{Params: params}).Error())
+	if detailed, err := st.WithDetails(fieldViolations(params)); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+func isMetricType(t string) bool {
+	return t == "counter" || t == "gauge" || t == "histogram"
+}
+
+// storeError переводит ошибку хранилища в статус gRPC.
+func storeError(err error) error {
+	if errors.Is(err, repositories.ErrNotFound) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	if tenant.IsLimit(err) {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
 func NewRepo(repository repositories.StoreRepository) *MetricsServer {
 	return &MetricsServer{
 		Store: repository,
 	}
 }
 
-func NewMetricHandlers(r *MetricsServer) {
+func NewMetricHandlers(r *MetricsServer, a *config.AppConfig) {
 	Repo = r
+	app = a
 }
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/inventory"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/logger"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/subnet"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/tenant"
+)
+
+// agentsHTML шаблон страницы со списком агентов.
+var agentsHTML = template.Must(template.New("Agents").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Agents</title>
+    <link rel="stylesheet" href="/static/dashboard.css">
+</head>
+<body>
+    <header><h1><a href="/">Metrics</a> / Agents</h1></header>
+    <main>
+    <table>
+        <tr><th>ID</th><th>IP</th><th>Version</th><th>First seen</th><th>Last seen</th><th>Reports</th><th>Metrics</th><th>Status</th></tr>
+        {{range .}}
+        <tr>
+            <td>{{.ID}}</td><td>{{.IP}}</td><td>{{.Version}}</td>
+            <td>{{.FirstSeen.Format "2006-01-02 15:04:05"}}</td><td>{{.LastSeen.Format "2006-01-02 15:04:05"}}</td>
+            <td>{{.Reports}}</td><td>{{.Metrics}}</td><td>{{if .Stale}}stale{{else}}ok{{end}}</td>
+        </tr>
+        {{end}}
+    </table>
+    </main>
+</body>
+</html>
+`))
+
+// AgentsResponse ответ /api/v1/agents.
+type AgentsResponse struct {
+	Agents []inventory.Agent `json:"agents"`
+}
+
+// ListAgents выдает агентов, присылавших метрики: GET /api/v1/agents.
+// Параметр stale (true или false) отбирает устаревших или активных агентов.
+func (m *Repository) ListAgents(w http.ResponseWriter, r *http.Request) {
+	var stale *bool
+	if v := r.URL.Query().Get("stale"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			WriteProblem(w, r, http.StatusBadRequest, fmt.Errorf("invalid stale=%q", v))
+			return
+		}
+		stale = &b
+	}
+
+	response := AgentsResponse{Agents: []inventory.Agent{}}
+	for _, a := range m.agents(r.Context()) {
+		if stale == nil || a.Stale == *stale {
+			response.Agents = append(response.Agents, a)
+		}
+	}
+
+	w.Header().Set("Content-Type", ContentTypeJSON)
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Log.Errorln("failed to write the data to the connection, Encode() =", err)
+	}
+}
+
+// AgentsPage выдает список агентов в HTML.
+func (m *Repository) AgentsPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(http.StatusOK)
+	if err := agentsHTML.Execute(w, m.agents(r.Context())); err != nil {
+		logger.Log.Errorln("template execution error, Execute() = ", err)
+	}
+}
+
+// agents возвращает агентов тенанта запроса.
+func (m *Repository) agents(ctx context.Context) []inventory.Agent {
+	if m.Agents == nil {
+		return nil
+	}
+	return m.Agents.List(tenant.FromContext(ctx), time.Now())
+}
+
+// trackAgent отмечает отправителя метрик в реестре агентов. Адрес агента определяется
+// так же, как для проверки подсетей: X-Forwarded-For и X-Real-IP учитываются только от доверенного прокси.
+func (m *Repository) trackAgent(r *http.Request, metrics int) {
+	if m.Agents == nil {
+		return
+	}
+	var policy *subnet.Policy
+	if app != nil {
+		policy = app.SubnetPolicy
+	}
+	ip := policy.ClientIP(r.RemoteAddr, r.Header.Values("X-Forwarded-For"), r.Header.Get("X-Real-IP"))
+	m.Agents.Seen(inventory.FromRequest(r, ip), metrics, time.Now())
+}
@@ -0,0 +1,312 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/alert"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/auth"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/file"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/logger"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/models"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories"
+)
+
+const (
+	// DefaultListLimit размер страницы по умолчанию.
+	DefaultListLimit = 100
+	// MaxListLimit максимальный размер страницы.
+	MaxListLimit = 1000
+)
+
+// listFields поля метрики, доступные для выборки через параметр fields.
+var listFields = []string{"id", "type", "delta", "value", "histogram"}
+
+// ListResponse ответ /api/v1/metrics.
+type ListResponse struct {
+	Metrics    []map[string]interface{} `json:"metrics"`
+	NextCursor string                   `json:"next_cursor,omitempty"`
+}
+
+// ListMetrics выдает страницу метрик с фильтрацией, сортировкой и выбором полей.
+//
+// Параметры запроса:
+//   - type - типы метрик через запятую: gauge,counter,histogram;
+//   - name - шаблон имени с * и ?, либо regex - регулярное выражение для имени;
+//   - sort - name, -name, type или -type (по умолчанию name);
+//   - limit - размер страницы (по умолчанию 100, не больше 1000);
+//   - cursor - значение next_cursor предыдущей страницы;
+//   - fields - поля метрики через запятую: id,type,delta,value,histogram.
+func (m *Repository) ListMetrics(w http.ResponseWriter, r *http.Request) {
+	opts, fields, err := parseListQuery(r)
+	if err != nil {
+		WriteProblem(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	// запрашиваем на одну метрику больше, чтобы понять, есть ли следующая страница
+	limit := opts.Limit
+	opts.Limit++
+	res, err := m.Store.ListMetrics(r.Context(), opts)
+	if err != nil {
+		logger.Log.Errorln("failed to get the data from storage, ListMetrics() = ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var response ListResponse
+	if len(res) > limit {
+		res = res[:limit]
+		last := res[len(res)-1]
+		response.NextCursor = models.ListCursor{ID: last.ID, MType: last.MType}.Encode()
+	}
+	response.Metrics = make([]map[string]interface{}, 0, len(res))
+	for _, metric := range res {
+		response.Metrics = append(response.Metrics, selectFields(metric, fields))
+	}
+
+	w.Header().Set("Content-Type", ContentTypeJSON)
+	w.WriteHeader(http.StatusOK)
+	if err = json.NewEncoder(w).Encode(response); err != nil {
+		logger.Log.Errorln("failed to write the data to the connection, Encode() =", err)
+	}
+}
+
+// AlertsResponse ответ /api/v1/alerts.
+type AlertsResponse struct {
+	Alerts []alert.Alert `json:"alerts"`
+}
+
+// ListAlerts выдает активные алерты: GET /api/v1/alerts.
+// Параметр state (pending или firing) отбирает алерты в заданном состоянии.
+func (m *Repository) ListAlerts(w http.ResponseWriter, r *http.Request) {
+	state := r.URL.Query().Get("state")
+	if state != "" && state != alert.StatePending && state != alert.StateFiring {
+		WriteProblem(w, r, http.StatusBadRequest, fmt.Errorf("unknown state=%q", state))
+		return
+	}
+
+	response := AlertsResponse{Alerts: []alert.Alert{}}
+	if m.Alerts != nil {
+		for _, a := range m.Alerts.Alerts() {
+			if state == "" || a.State == state {
+				response.Alerts = append(response.Alerts, a)
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", ContentTypeJSON)
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Log.Errorln("failed to write the data to the connection, Encode() =", err)
+	}
+}
+
+// DeleteResponse ответ на массовое удаление метрик.
+type DeleteResponse struct {
+	Deleted int64 `json:"deleted"`
+}
+
+// DeleteMetric удаляет метрику: DELETE /api/v1/metrics/{metric}/{name}.
+func (m *Repository) DeleteMetric(w http.ResponseWriter, r *http.Request) {
+	mType, name := chi.URLParam(r, "metric"), chi.URLParam(r, "name")
+	if mType != Counter && mType != Gauge && mType != Histogram {
+		WriteProblem(w, r, http.StatusBadRequest, fmt.Errorf("unknown metric type=%q", mType))
+		return
+	}
+	if !allowMetric(r, name) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	err := m.Store.DeleteMetric(r.Context(), mType, name)
+	if errors.Is(err, repositories.ErrNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Log.Errorln("failed to delete the data from storage, DeleteMetric() = ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	m.syncFile(r)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ResetCounter обнуляет счетчик: POST /api/v1/metrics/counter/{name}/reset.
+func (m *Repository) ResetCounter(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if !allowMetric(r, name) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	err := m.Store.ResetCounter(r.Context(), name)
+	if errors.Is(err, repositories.ErrNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Log.Errorln("failed to reset the counter in storage, ResetCounter() = ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	m.syncFile(r)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteMetrics удаляет метрики по шаблону: DELETE /api/v1/metrics.
+// Параметры type, name и regex те же, что и у ListMetrics. Чтобы случайно не удалить все метрики,
+// запрос без отбора по имени или типу требует явного all=true.
+func (m *Repository) DeleteMetrics(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseFilter(r)
+	if err != nil {
+		WriteProblem(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if len(filter.Types) == 0 && filter.NameRegex == "" && r.URL.Query().Get("all") != "true" {
+		WriteProblem(w, r, http.StatusBadRequest, errors.New("type, name or regex is required, use all=true to delete all metrics"))
+		return
+	}
+
+	deleted, err := m.Store.DeleteMetrics(r.Context(), filter)
+	if err != nil {
+		logger.Log.Errorln("failed to delete the data from storage, DeleteMetrics() = ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	m.syncFile(r)
+
+	w.Header().Set("Content-Type", ContentTypeJSON)
+	w.WriteHeader(http.StatusOK)
+	if err = json.NewEncoder(w).Encode(DeleteResponse{Deleted: deleted}); err != nil {
+		logger.Log.Errorln("failed to write the data to the connection, Encode() =", err)
+	}
+}
+
+// syncFile сохраняет изменения в файл при синхронной записи.
+func (m *Repository) syncFile(r *http.Request) {
+	if err := file.SyncWriter(r.Context(), m.Store.GetAllMetrics); err != nil {
+		logger.Log.Errorln("failed to write the data to the file, SyncWriter() =", err)
+	}
+}
+
+// parseListQuery разбирает параметры запроса /api/v1/metrics.
+func parseListQuery(r *http.Request) (models.ListOptions, []string, error) {
+	q := r.URL.Query()
+	opts := models.ListOptions{
+		SortBy: models.SortByName,
+		Limit:  DefaultListLimit,
+	}
+
+	filter, err := parseFilter(r)
+	if err != nil {
+		return opts, nil, err
+	}
+	opts.MetricFilter = filter
+
+	if v := q.Get("sort"); v != "" {
+		opts.Desc = strings.HasPrefix(v, "-")
+		opts.SortBy = strings.TrimPrefix(v, "-")
+		if opts.SortBy != models.SortByName && opts.SortBy != models.SortByType {
+			return opts, nil, fmt.Errorf("unknown sort=%q", v)
+		}
+	}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > MaxListLimit {
+			return opts, nil, fmt.Errorf("limit must be between 1 and %d", MaxListLimit)
+		}
+		opts.Limit = limit
+	}
+
+	if v := q.Get("cursor"); v != "" {
+		cursor, err := models.DecodeListCursor(v)
+		if err != nil {
+			return opts, nil, err
+		}
+		opts.After = cursor
+	}
+
+	fields := listFields
+	if v := q.Get("fields"); v != "" {
+		fields = strings.Split(v, ",")
+		for _, f := range fields {
+			if !contains(listFields, f) {
+				return opts, nil, fmt.Errorf("unknown field=%q", f)
+			}
+		}
+	}
+
+	return opts, fields, nil
+}
+
+// parseFilter разбирает параметры отбора метрик type, name и regex.
+func parseFilter(r *http.Request) (models.MetricFilter, error) {
+	q := r.URL.Query()
+	// токен с префиксом видит только свои метрики
+	filter := models.MetricFilter{Prefix: auth.FromContext(r.Context()).MetricPrefix()}
+
+	if v := q.Get("type"); v != "" {
+		for _, t := range strings.Split(v, ",") {
+			if t != Gauge && t != Counter && t != Histogram {
+				return filter, fmt.Errorf("unknown metric type=%q", t)
+			}
+			filter.Types = append(filter.Types, t)
+		}
+	}
+
+	name, regex := q.Get("name"), q.Get("regex")
+	switch {
+	case name != "" && regex != "":
+		return filter, fmt.Errorf("name and regex can not be used together")
+	case name != "":
+		filter.NameRegex = models.GlobToRegex(name)
+	case regex != "":
+		if _, err := regexp.Compile(regex); err != nil {
+			return filter, fmt.Errorf("invalid regex: %w", err)
+		}
+		filter.NameRegex = regex
+	}
+
+	return filter, nil
+}
+
+// selectFields оставляет в метрике только запрошенные поля, пустые значения опускаются.
+func selectFields(metric models.Metrics, fields []string) map[string]interface{} {
+	res := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		switch {
+		case f == "id":
+			res[f] = metric.ID
+		case f == "type":
+			res[f] = metric.MType
+		case f == "delta" && metric.Delta != nil:
+			res[f] = *metric.Delta
+		case f == "value" && metric.Value != nil:
+			res[f] = *metric.Value
+		case f == "histogram" && metric.Histogram != nil:
+			res[f] = metric.Histogram
+		}
+	}
+
+	return res
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/config"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/inventory"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories/store"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/subnet"
+)
+
+func TestListMetrics(t *testing.T) {
+	db := store.NewMemStorage()
+	ctx := context.Background()
+	for _, name := range []string{"HeapAlloc", "HeapIdle", "Alloc", "Sys"} {
+		_, err := db.UpdateGauge(ctx, name, 1)
+		require.NoError(t, err)
+	}
+	_, err := db.UpdateCounter(ctx, "PollCount", 5)
+	require.NoError(t, err)
+
+	repo := NewRepo(db)
+	list := func(query string) (int, ListResponse) {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/metrics?"+query, nil)
+		w := httptest.NewRecorder()
+		repo.ListMetrics(w, r)
+		var res ListResponse
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+		}
+		return w.Code, res
+	}
+	ids := func(res ListResponse) []string {
+		var ids []string
+		for _, m := range res.Metrics {
+			ids = append(ids, m["id"].(string))
+		}
+		return ids
+	}
+
+	t.Run("all sorted by name", func(t *testing.T) {
+		code, res := list("")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, []string{"Alloc", "HeapAlloc", "HeapIdle", "PollCount", "Sys"}, ids(res))
+		assert.Empty(t, res.NextCursor)
+	})
+
+	t.Run("type filter and glob", func(t *testing.T) {
+		_, res := list("type=gauge&name=Heap*&sort=-name")
+		assert.Equal(t, []string{"HeapIdle", "HeapAlloc"}, ids(res))
+	})
+
+	t.Run("regex", func(t *testing.T) {
+		_, res := list("regex=^(Sys|Poll)")
+		assert.Equal(t, []string{"PollCount", "Sys"}, ids(res))
+	})
+
+	t.Run("cursor pagination", func(t *testing.T) {
+		var all []string
+		query := "limit=2"
+		for {
+			code, res := list(query)
+			require.Equal(t, http.StatusOK, code)
+			all = append(all, ids(res)...)
+			if res.NextCursor == "" {
+				break
+			}
+			query = "limit=2&cursor=" + res.NextCursor
+		}
+		assert.Equal(t, []string{"Alloc", "HeapAlloc", "HeapIdle", "PollCount", "Sys"}, all)
+	})
+
+	t.Run("sort by type", func(t *testing.T) {
+		_, res := list("sort=type&limit=1")
+		assert.Equal(t, []string{"PollCount"}, ids(res))
+	})
+
+	t.Run("fields", func(t *testing.T) {
+		_, res := list("type=counter&fields=id,delta")
+		require.Len(t, res.Metrics, 1)
+		assert.Equal(t, map[string]interface{}{"id": "PollCount", "delta": float64(5)}, res.Metrics[0])
+	})
+
+	t.Run("bad requests", func(t *testing.T) {
+		for _, query := range []string{"type=none", "regex=(", "name=a&regex=b", "sort=value", "limit=0", "cursor=@", "fields=none"} {
+			code, _ := list(query)
+			assert.Equal(t, http.StatusBadRequest, code, query)
+		}
+	})
+}
+
+func TestDeleteMetrics(t *testing.T) {
+	db := store.NewMemStorage()
+	ctx := context.Background()
+	for _, name := range []string{"HeapAlloc", "HeapIdle", "Alloc"} {
+		_, err := db.UpdateGauge(ctx, name, 1)
+		require.NoError(t, err)
+	}
+	_, err := db.UpdateCounter(ctx, "PollCount", 5)
+	require.NoError(t, err)
+
+	repo := NewRepo(db)
+	r := chi.NewRouter()
+	r.Delete("/api/v1/metrics", repo.DeleteMetrics)
+	r.Delete("/api/v1/metrics/{metric}/{name}", repo.DeleteMetric)
+	r.Post("/api/v1/metrics/counter/{name}/reset", repo.ResetCounter)
+	do := func(method, target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+		return w
+	}
+
+	t.Run("reset counter", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, do(http.MethodPost, "/api/v1/metrics/counter/PollCount/reset").Code)
+		value, err := db.GetCounter(ctx, "PollCount")
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), value)
+		assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/api/v1/metrics/counter/none/reset").Code)
+	})
+
+	t.Run("delete metric", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/api/v1/metrics/gauge/Alloc").Code)
+		_, err := db.GetGauge(ctx, "Alloc")
+		assert.Error(t, err)
+		assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/api/v1/metrics/gauge/Alloc").Code)
+		assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/api/v1/metrics/counter/HeapIdle").Code)
+		assert.Equal(t, http.StatusBadRequest, do(http.MethodDelete, "/api/v1/metrics/none/HeapIdle").Code)
+	})
+
+	t.Run("bulk delete requires filter", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, do(http.MethodDelete, "/api/v1/metrics").Code)
+		assert.Equal(t, http.StatusBadRequest, do(http.MethodDelete, "/api/v1/metrics?regex=(").Code)
+	})
+
+	t.Run("bulk delete by pattern", func(t *testing.T) {
+		w := do(http.MethodDelete, "/api/v1/metrics?type=gauge&name=Heap*")
+		require.Equal(t, http.StatusOK, w.Code)
+		var res DeleteResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+		assert.Equal(t, int64(2), res.Deleted)
+
+		w = do(http.MethodDelete, "/api/v1/metrics?all=true")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+		assert.Equal(t, int64(1), res.Deleted)
+	})
+}
+
+func TestListAgents(t *testing.T) {
+	repo := NewRepo(store.NewMemStorage())
+	repo.Agents = inventory.NewRegistry(config.AgentsConfig{ReportInterval: 10, StaleIntervals: 3})
+	r := chi.NewRouter()
+	r.Post("/updates/", repo.PostBatchMetrics)
+	r.Get("/api/v1/agents", repo.ListAgents)
+	r.Get("/agents", repo.AgentsPage)
+
+	// отклоненный батч агента не отмечает
+	req := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(`[{"id":"PollCount","type":"counter"}]`))
+	req.Header.Set("Content-Type", ContentTypeJSON)
+	req.Header.Set(inventory.HeaderAgentID, "host-2")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	require.NotEqual(t, http.StatusOK, w.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(`[{"id":"PollCount","type":"counter","delta":1},{"id":"Alloc","type":"gauge","value":2}]`))
+	req.Header.Set("Content-Type", ContentTypeJSON)
+	req.Header.Set(inventory.HeaderAgentID, "host-1")
+	req.Header.Set(inventory.HeaderAgentVersion, "v1.0.0")
+	// адрес агента берется из соединения: прокси не доверенный, заголовку не верим
+	req.Header.Set("X-Real-IP", "10.0.0.1")
+	req.RemoteAddr = "10.0.0.7:51000"
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	list := func(query string) (int, AgentsResponse) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/agents"+query, nil))
+		var res AgentsResponse
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+		}
+		return w.Code, res
+	}
+
+	code, res := list("")
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, res.Agents, 1)
+	assert.Equal(t, "host-1", res.Agents[0].ID)
+	assert.Equal(t, "10.0.0.7", res.Agents[0].IP)
+	assert.Equal(t, "v1.0.0", res.Agents[0].Version)
+	assert.Equal(t, 2, res.Agents[0].Metrics)
+	assert.False(t, res.Agents[0].Stale)
+
+	_, res = list("?stale=true")
+	assert.Empty(t, res.Agents)
+	code, _ = list("?stale=maybe")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/agents", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "host-1")
+
+	// от доверенного прокси адрес агента берется из X-Real-IP
+	policy, err := subnet.New(nil, nil, []string{"10.0.0.0/24"})
+	require.NoError(t, err)
+	app.SubnetPolicy = policy
+	defer func() { app.SubnetPolicy = nil }()
+	req = httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(`[{"id":"Alloc","type":"gauge","value":3}]`))
+	req.Header.Set("Content-Type", ContentTypeJSON)
+	req.Header.Set(inventory.HeaderAgentID, "host-1")
+	req.Header.Set("X-Real-IP", "192.168.1.10")
+	req.RemoteAddr = "10.0.0.7:51000"
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	_, res = list("")
+	require.Len(t, res.Agents, 1)
+	assert.Equal(t, "192.168.1.10", res.Agents[0].IP)
+}
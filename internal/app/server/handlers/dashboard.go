@@ -0,0 +1,304 @@
+package handlers
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/auth"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/history"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/logger"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/models"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/tenant"
+)
+
+// web шаблоны и статика дашборда, встроенные в бинарник: дашборд работает без внешних ресурсов.
+//
+//go:embed web
+var web embed.FS
+
+var dashboardHTML = template.Must(template.ParseFS(web, "web/*.html"))
+
+// Размеры графиков: строка списка метрик и страница метрики.
+const (
+	smallChartWidth  = 120
+	smallChartHeight = 24
+	largeChartWidth  = 600
+	largeChartHeight = 200
+)
+
+// dashboardMetric метрика в представлении дашборда.
+type dashboardMetric struct {
+	ID    string
+	MType string
+	Value string
+	Chart template.HTML
+}
+
+// dashboardGroup метрики одного типа, отсортированные по имени.
+type dashboardGroup struct {
+	Type    string
+	Metrics []dashboardMetric
+}
+
+// histogramBucket корзина гистограммы на странице метрики.
+type histogramBucket struct {
+	Bound string
+	Count uint64
+}
+
+// eventMetric метрика в событии обновления дашборда.
+type eventMetric struct {
+	Key   string        `json:"key"`
+	Value string        `json:"value"`
+	Chart template.HTML `json:"chart"`
+}
+
+// dashboardEvent событие обновления дашборда.
+type dashboardEvent struct {
+	Time    time.Time     `json:"time"`
+	Metrics []eventMetric `json:"metrics"`
+}
+
+// Static отдает стили и скрипты дашборда.
+func Static() http.Handler {
+	static, _ := fs.Sub(web, "web/static")
+	return http.StripPrefix("/static/", http.FileServer(http.FS(static)))
+}
+
+// LoginPage выдает страницу входа в дашборд: токен сохраняется в cookie access_token,
+// которую браузер передает с запросами страниц и EventSource.
+func (m *Repository) LoginPage(w http.ResponseWriter, _ *http.Request) {
+	m.render(w, "login.html", nil)
+}
+
+// Default выдает дашборд: метрики, сгруппированные по типу и отсортированные по имени, с графиками
+// последних значений. Параметр q отбирает метрики, в имени которых есть подстрока.
+func (m *Repository) Default(w http.ResponseWriter, r *http.Request) {
+	opts := models.ListOptions{SortBy: models.SortByName}
+	opts.Prefix = auth.FromContext(r.Context()).MetricPrefix()
+	res, err := m.Store.ListMetrics(r.Context(), opts)
+	if err != nil {
+		logger.Log.Errorln("failed to get the data from storage, ListMetrics() = ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	query := r.URL.Query().Get("q")
+	name := tenant.FromContext(r.Context())
+	groups := make([]dashboardGroup, 0, 3)
+	for _, mType := range []string{Counter, Gauge, Histogram} {
+		group := dashboardGroup{Type: mType}
+		for _, metric := range res {
+			if metric.MType != mType || !strings.Contains(strings.ToLower(metric.ID), strings.ToLower(query)) {
+				continue
+			}
+			group.Metrics = append(group.Metrics, m.dashboardMetric(name, metric, smallChartWidth, smallChartHeight))
+		}
+		if len(group.Metrics) > 0 {
+			groups = append(groups, group)
+		}
+	}
+
+	m.render(w, "index.html", map[string]interface{}{
+		"Query":  query,
+		"Groups": groups,
+	})
+}
+
+// MetricPage выдает страницу метрики с графиком и таблицей последних значений:
+// GET /dashboard/{metric}/{name}.
+func (m *Repository) MetricPage(w http.ResponseWriter, r *http.Request) {
+	metric, err := m.getMetric(r.Context(), chi.URLParam(r, "metric"), chi.URLParam(r, "name"))
+	if err != nil || !allowMetric(r, metric.ID) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var points []history.Point
+	if m.History != nil {
+		points = m.History.Points(tenant.FromContext(r.Context()), metric.MType, metric.ID)
+	}
+	// новые значения сверху
+	recent := make([]history.Point, len(points))
+	for i, p := range points {
+		recent[len(points)-1-i] = p
+	}
+	var buckets []histogramBucket
+	if h := metric.Histogram; h != nil {
+		for i, c := range h.Counts {
+			bound := "+Inf"
+			if i < len(h.Bounds) {
+				bound = strconv.FormatFloat(h.Bounds[i], 'g', -1, 64)
+			}
+			buckets = append(buckets, histogramBucket{Bound: bound, Count: c})
+		}
+	}
+
+	m.render(w, "metric.html", map[string]interface{}{
+		"Metric":    m.dashboardMetric(tenant.FromContext(r.Context()), metric, largeChartWidth, largeChartHeight),
+		"Histogram": buckets,
+		"Points":    recent,
+	})
+}
+
+// MetricChart выдает график последних значений метрики в SVG: GET /dashboard/{metric}/{name}/chart.svg.
+func (m *Repository) MetricChart(w http.ResponseWriter, r *http.Request) {
+	metric, err := m.getMetric(r.Context(), chi.URLParam(r, "metric"), chi.URLParam(r, "name"))
+	if err != nil || !allowMetric(r, metric.ID) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	chart := m.dashboardMetric(tenant.FromContext(r.Context()), metric, largeChartWidth, largeChartHeight).Chart
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write([]byte(chart)); err != nil {
+		logger.Log.Errorln("failed to write the data to the connection, Write() =", err)
+	}
+}
+
+// DashboardEvents рассылает обновления дашборда через Server-Sent Events после каждого опроса хранилища.
+func (m *Repository) DashboardEvents(w http.ResponseWriter, r *http.Request) {
+	if m.History == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	// поток живет дольше таймаута записи сервера
+	_ = rc.SetWriteDeadline(time.Time{})
+	name := tenant.FromContext(r.Context())
+	snapshots, cancel := m.History.Subscribe(name)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		logger.Log.Errorln("failed to flush the event stream, Flush() =", err)
+		return
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case snapshot := <-snapshots:
+			event := dashboardEvent{Time: snapshot.Time, Metrics: make([]eventMetric, 0, len(snapshot.Metrics))}
+			for _, metric := range snapshot.Metrics {
+				if !allowMetric(r, metric.ID) {
+					continue
+				}
+				dm := m.dashboardMetric(name, metric, smallChartWidth, smallChartHeight)
+				event.Metrics = append(event.Metrics, eventMetric{Key: dm.MType + "/" + dm.ID, Value: dm.Value, Chart: dm.Chart})
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				logger.Log.Errorln("failed to marshal the event, Marshal() =", err)
+				return
+			}
+			if _, err = fmt.Fprintf(w, "event: metrics\ndata: %s\n\n", data); err != nil {
+				return
+			}
+			if err = rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// getMetric возвращает метрику заданного типа из хранилища.
+func (m *Repository) getMetric(ctx context.Context, mType, id string) (models.Metrics, error) {
+	metric := models.Metrics{ID: id, MType: mType}
+	switch mType {
+	case Counter:
+		delta, err := m.Store.GetCounter(ctx, id)
+		metric.Delta = &delta
+		return metric, err
+	case Gauge:
+		value, err := m.Store.GetGauge(ctx, id)
+		metric.Value = &value
+		return metric, err
+	case Histogram:
+		histogram, err := m.Store.GetHistogram(ctx, id)
+		metric.Histogram = &histogram
+		return metric, err
+	}
+	return metric, fmt.Errorf("unknown metric type=%q", mType)
+}
+
+// dashboardMetric готовит метрику тенанта name к выводу с графиком заданного размера.
+func (m *Repository) dashboardMetric(name string, metric models.Metrics, width, height int) dashboardMetric {
+	var points []history.Point
+	if m.History != nil {
+		points = m.History.Points(name, metric.MType, metric.ID)
+	}
+	return dashboardMetric{
+		ID:    metric.ID,
+		MType: metric.MType,
+		Value: formatValue(metric),
+		Chart: svgChart(points, width, height, width >= largeChartWidth),
+	}
+}
+
+func (m *Repository) render(w http.ResponseWriter, name string, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if err := dashboardHTML.ExecuteTemplate(w, name, data); err != nil {
+		logger.Log.Errorln("template execution error, Execute() = ", err)
+	}
+}
+
+// formatValue возвращает значение метрики для вывода.
+func formatValue(metric models.Metrics) string {
+	switch {
+	case metric.Delta != nil && metric.MType == Counter:
+		return strconv.FormatInt(*metric.Delta, 10)
+	case metric.Value != nil && metric.MType == Gauge:
+		return strconv.FormatFloat(*metric.Value, 'f', -1, 64)
+	case metric.Histogram != nil:
+		return fmt.Sprintf("count=%d sum=%s", metric.Histogram.Count, strconv.FormatFloat(metric.Histogram.Sum, 'f', -1, 64))
+	}
+	return ""
+}
+
+// svgChart рисует значения ломаной линией в SVG размером width x height.
+// С labels на графике подписываются минимальное и максимальное значения.
+func svgChart(points []history.Point, width, height int, labels bool) template.HTML {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`, width, height, width, height)
+	if len(points) > 1 {
+		low, high := math.Inf(1), math.Inf(-1)
+		for _, p := range points {
+			low, high = math.Min(low, p.Value), math.Max(high, p.Value)
+		}
+		const pad = 2.0
+		xs := make([]string, 0, len(points))
+		for i, p := range points {
+			x := float64(i) * float64(width) / float64(len(points)-1)
+			y := float64(height) / 2
+			if high > low {
+				y = pad + (high-p.Value)/(high-low)*(float64(height)-2*pad)
+			}
+			xs = append(xs, strconv.FormatFloat(x, 'f', 1, 64)+","+strconv.FormatFloat(y, 'f', 1, 64))
+		}
+		fmt.Fprintf(&b, `<polyline points="%s"/>`, strings.Join(xs, " "))
+		if labels {
+			fmt.Fprintf(&b, `<text x="4" y="12">%s</text><text x="4" y="%d">%s</text>`,
+				strconv.FormatFloat(high, 'g', 6, 64), height-4, strconv.FormatFloat(low, 'g', 6, 64))
+		}
+	}
+	b.WriteString(`</svg>`)
+
+	return template.HTML(b.String())
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/history"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories/store"
+)
+
+func TestDashboard(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMemStorage()
+	repo := NewRepo(db)
+	repo.History = history.NewHistory(db, 10)
+	for i, value := range []float64{1, 3, 2} {
+		for _, name := range []string{"Sys", "HeapAlloc"} {
+			_, err := db.UpdateGauge(ctx, name, value)
+			require.NoError(t, err)
+		}
+		require.NoError(t, repo.History.Sample(ctx, time.Now().Add(time.Duration(i)*time.Second)))
+	}
+	_, err := db.UpdateCounter(ctx, "PollCount", 5)
+	require.NoError(t, err)
+
+	r := chi.NewRouter()
+	r.Get("/", repo.Default)
+	r.Get("/dashboard/events", repo.DashboardEvents)
+	r.Get("/dashboard/{metric}/{name}", repo.MetricPage)
+	r.Get("/dashboard/{metric}/{name}/chart.svg", repo.MetricChart)
+	r.Handle("/static/*", Static())
+	get := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w
+	}
+
+	t.Run("grouped and sorted", func(t *testing.T) {
+		w := get("/")
+		require.Equal(t, http.StatusOK, w.Code)
+		body := w.Body.String()
+		counter, heap, sys := strings.Index(body, "PollCount"), strings.Index(body, "HeapAlloc"), strings.Index(body, "Sys")
+		assert.True(t, counter < heap && heap < sys, "counter group first, gauges sorted by name")
+		assert.Contains(t, body, "<polyline")
+		assert.NotRegexp(t, `(src|href)="(https?:)?//`, body, "no external assets")
+	})
+
+	t.Run("search", func(t *testing.T) {
+		body := get("/?q=heap").Body.String()
+		assert.Contains(t, body, "HeapAlloc")
+		assert.NotContains(t, body, "PollCount")
+	})
+
+	t.Run("metric page", func(t *testing.T) {
+		w := get("/dashboard/gauge/HeapAlloc")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "<svg")
+		assert.Equal(t, http.StatusNotFound, get("/dashboard/gauge/none").Code)
+		assert.Equal(t, http.StatusNotFound, get("/dashboard/none/HeapAlloc").Code)
+	})
+
+	t.Run("chart", func(t *testing.T) {
+		w := get("/dashboard/gauge/Sys/chart.svg")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "image/svg+xml", w.Header().Get("Content-Type"))
+		assert.Contains(t, w.Body.String(), `<polyline points="0.0,198.0 300.0,2.0 600.0,100.0"/>`)
+	})
+
+	t.Run("static", func(t *testing.T) {
+		for _, name := range []string{"dashboard.css", "dashboard.js"} {
+			assert.Equal(t, http.StatusOK, get("/static/"+name).Code, name)
+		}
+	})
+
+	t.Run("events", func(t *testing.T) {
+		srv := httptest.NewServer(r)
+		defer srv.Close()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/dashboard/events", nil)
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+		require.NoError(t, repo.History.Sample(ctx, time.Now()))
+		reader := bufio.NewReader(resp.Body)
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, "event: metrics\n", line)
+		line, err = reader.ReadString('\n')
+		require.NoError(t, err)
+		var event dashboardEvent
+		require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event))
+		require.Len(t, event.Metrics, 3)
+		assert.Equal(t, "gauge/HeapAlloc", event.Metrics[0].Key)
+		assert.Equal(t, "2", event.Metrics[0].Value)
+	})
+}
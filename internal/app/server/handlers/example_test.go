@@ -19,18 +19,11 @@ func ExampleRepository_Default() {
 	}
 	m.Default(example.w, example.r)
 
-	// Output HTML:
+	// Output HTML: страница дашборда web/index.html - метрики по группам counter, gauge, histogram:
 	//
-	// <!DOCTYPE html>
-	// <html lang="en">
-	// <head>
-	//     <meta charset="UTF-8">
-	//     <meta name="viewport" content="width=device-width, initial-scale=1.0">
-	//     <title>Metrics</title>
-	// </head>
-	// <body>
-	//
-	//
-	// </body>
-	// </html>
+	// <tr class="metric" data-key="gauge/Alloc">
+	//     <td class="name"><a href="/dashboard/gauge/Alloc">Alloc</a></td>
+	//     <td class="value">123.45</td>
+	//     <td class="chart"><svg ...><polyline points="..."/></svg></td>
+	// </tr>
 }
@@ -1,8 +1,12 @@
 package handlers
 
 import (
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/alert"
 	"github.com/webkimru/go-yandex-metrics/internal/app/server/config"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/history"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/inventory"
 	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/stream"
 )
 
 // Repo - репозиторий испльзуется хендлерами.
@@ -12,6 +16,14 @@ var app *config.AppConfig
 // Repository описываем структуру репозитория для хендлеров.
 type Repository struct {
 	Store repositories.StoreRepository
+	// Alerts вычислитель правил алертинга, nil - алертинг выключен.
+	Alerts *alert.Evaluator
+	// Agents реестр агентов, присылающих метрики, nil - учет выключен.
+	Agents *inventory.Registry
+	// History последние значения метрик для дашборда, nil - без графиков и обновлений.
+	History *history.History
+	// Updates поток принятых обновлений метрик для /api/v1/stream, nil - поток выключен.
+	Updates *stream.Hub
 }
 
 // NewRepo создаем новый репозиторий.
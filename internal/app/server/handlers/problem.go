@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/logger"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/models"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/tenant"
+)
+
+// ContentTypeProblem тип содержимого ответа с описанием ошибки (RFC 7807).
+const ContentTypeProblem = "application/problem+json"
+
+// Problem описание ошибки запроса в формате RFC 7807.
+type Problem struct {
+	Type          string                `json:"type"`
+	Title         string                `json:"title"`
+	Status        int                   `json:"status"`
+	Detail        string                `json:"detail,omitempty"`
+	Instance      string                `json:"instance,omitempty"`
+	InvalidParams []models.InvalidParam `json:"invalid-params,omitempty"`
+}
+
+// WriteProblem отдает клиенту ошибку в формате application/problem+json.
+// Для ошибки проверки метрик в ответ попадает список некорректных полей,
+// при превышении допустимого размера тела запроса отдается 413.
+func WriteProblem(w http.ResponseWriter, r *http.Request, status int, err error) {
+	var maxBytes *http.MaxBytesError
+	if errors.As(err, &maxBytes) {
+		status = http.StatusRequestEntityTooLarge
+	}
+	problem := Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   err.Error(),
+		Instance: r.URL.Path,
+	}
+	var verr *models.ValidationError
+	if errors.As(err, &verr) {
+		problem.Detail = "invalid metrics"
+		problem.InvalidParams = verr.Params
+	}
+
+	w.Header().Set("Content-Type", ContentTypeProblem)
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(problem); err != nil {
+		logger.Log.Errorln("failed to write the data to the connection, Encode() =", err)
+	}
+}
+
+// invalidValue ошибка разбора значения метрики из адреса запроса.
+func invalidValue(field string, err error) error {
+	return &models.ValidationError{Params: []models.InvalidParam{{Name: field, Reason: err.Error()}}}
+}
+
+// seriesLimit отвечает 429, если тенант запроса хранит максимально допустимое количество метрик.
+func seriesLimit(w http.ResponseWriter, r *http.Request, err error) bool {
+	if !tenant.IsLimit(err) {
+		return false
+	}
+	WriteProblem(w, r, http.StatusTooManyRequests, err)
+	return true
+}
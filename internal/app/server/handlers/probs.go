@@ -6,8 +6,8 @@ import (
 	"net/http"
 )
 
-func (m *Repository) PingPostgreSQL(w http.ResponseWriter, _ *http.Request) {
-	err := pg.DB.Conn.Ping()
+func (m *Repository) PingPostgreSQL(w http.ResponseWriter, r *http.Request) {
+	err := pg.DB.Pool.Ping(r.Context())
 	if err != nil {
 		logger.Log.Errorln("failed to ping PostgreSQL, PingPostgreSQL() = ", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -2,75 +2,34 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"github.com/go-chi/chi/v5"
 	"github.com/webkimru/go-yandex-metrics/internal/app/server/file"
 	"github.com/webkimru/go-yandex-metrics/internal/app/server/logger"
 	"github.com/webkimru/go-yandex-metrics/internal/app/server/models"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories"
 	"github.com/webkimru/go-yandex-metrics/internal/utils"
-	"html/template"
 	"net/http"
 	"strconv"
 )
 
 const (
-	Gauge   = "gauge"
-	Counter = "counter"
+	Gauge     = "gauge"
+	Counter   = "counter"
+	Histogram = "histogram"
 
 	ContentTypeJSON = "application/json"
+	// HeaderBatchID заголовок с идентификатором батча для отбрасывания повторных отправок.
+	HeaderBatchID = "X-Batch-ID"
 )
 
-// Default выдает список всех метрик и их значения в HTML.
-func (m *Repository) Default(w http.ResponseWriter, r *http.Request) {
-	stringHTML := `<!DOCTYPE html>
-<html lang="en">
-<head>
-    <meta charset="UTF-8">
-    <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <title>Metrics</title>
-</head>
-<body>
-    {{range $k, $v := .counter}}
-    	{{$k}} {{$v}}<br>
-	{{end}}
-    {{range $k, $v := .gauge}}
-    	{{$k}} {{$v}}<br>
-	{{end}}
-</body>
-</html>
-`
-
-	res, err := m.Store.GetAllMetrics(r.Context())
-	if err != nil {
-		logger.Log.Errorln("failed to get the data from storage, GetAllMetrics() = ", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-
-	t := template.New("Metrics")
-	t, err = t.Parse(stringHTML)
-	if err != nil {
-		logger.Log.Errorln("HTML template is not parsed, Parse() = ", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "text/html")
-	w.WriteHeader(http.StatusOK)
-	err = t.Execute(w, res)
-	if err != nil {
-		logger.Log.Errorln("template execution error, Execute() = ", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-}
-
 // PostMetrics обрабатывает входящие метрики.
 func (m *Repository) PostMetrics(w http.ResponseWriter, r *http.Request) {
 	var metrics models.Metrics
 	// application/json
 	if r.Header.Get("Content-Type") == ContentTypeJSON {
 		if err := json.NewDecoder(r.Body).Decode(&metrics); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			WriteProblem(w, r, http.StatusBadRequest, err)
 			return
 		}
 	} else {
@@ -81,39 +40,44 @@ func (m *Repository) PostMetrics(w http.ResponseWriter, r *http.Request) {
 		case Counter:
 			value, err := utils.GetInt64ValueFromSting(chi.URLParam(r, "value"))
 			if err != nil {
-				logger.Log.Errorln(err)
-				w.WriteHeader(http.StatusBadRequest)
+				WriteProblem(w, r, http.StatusBadRequest, invalidValue("delta", err))
 				return
 			}
 			metrics.Delta = &value
 		case Gauge:
 			value, err := utils.GetFloat64ValueFromSting(chi.URLParam(r, "value"))
 			if err != nil {
-				logger.Log.Errorln(err)
-				w.WriteHeader(http.StatusBadRequest)
+				WriteProblem(w, r, http.StatusBadRequest, invalidValue("value", err))
 				return
 			}
 			metrics.Value = &value
 		}
 	}
 
-	// При попытке передать запрос с некорректным типом метрики возвращать `http.StatusBadRequest`.
-	if metrics.MType != Counter && metrics.MType != Gauge {
-		w.WriteHeader(http.StatusBadRequest)
+	// При попытке передать запрос с некорректным типом, именем или значением метрики возвращать `http.StatusBadRequest`.
+	if err := metrics.Validate(); err != nil {
+		WriteProblem(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if err := checkPrefix(r, metrics); err != nil {
+		WriteProblem(w, r, http.StatusForbidden, err)
 		return
 	}
 
-	// При попытке передать запрос с некорректным значением возвращать `http.StatusBadRequest`.
 	switch metrics.MType {
 	case Gauge:
 		// Обновление данных в хранилище.
 		res, err := m.Store.UpdateGauge(r.Context(), metrics.ID, *metrics.Value)
+		if seriesLimit(w, r, err) {
+			return
+		}
 		if err != nil {
 			logger.Log.Errorln("failed to update the data from storage, UpdateGauge() = ", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 		metrics.Value = &res
+		m.trackAgent(r, 1)
 		// Сохранение данных в файл.
 		if err := file.SyncWriter(r.Context(), m.Store.GetAllMetrics); err != nil {
 			logger.Log.Errorln("failed to write the data to the file, SyncWriter() =", err)
@@ -129,12 +93,16 @@ func (m *Repository) PostMetrics(w http.ResponseWriter, r *http.Request) {
 	case Counter:
 		// Обновление данных в хранилище.
 		res, err := m.Store.UpdateCounter(r.Context(), metrics.ID, *metrics.Delta)
+		if seriesLimit(w, r, err) {
+			return
+		}
 		if err != nil {
 			logger.Log.Errorln("failed to update the data from storage, UpdateCounter() = ", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 		metrics.Delta = &res
+		m.trackAgent(r, 1)
 		// Сохранение данных в файл.
 		if err := file.SyncWriter(r.Context(), m.Store.GetAllMetrics); err != nil {
 			logger.Log.Errorln("failed to write the data to the file, SyncWriter() =", err)
@@ -147,6 +115,31 @@ func (m *Repository) PostMetrics(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(http.StatusInternalServerError)
 		}
 
+	case Histogram:
+		// Обновление данных в хранилище.
+		res, err := m.Store.UpdateHistogram(r.Context(), metrics.ID, *metrics.Histogram)
+		if seriesLimit(w, r, err) {
+			return
+		}
+		if err != nil {
+			logger.Log.Errorln("failed to update the data from storage, UpdateHistogram() = ", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		metrics.Histogram = &res
+		m.trackAgent(r, 1)
+		// Сохранение данных в файл.
+		if err := file.SyncWriter(r.Context(), m.Store.GetAllMetrics); err != nil {
+			logger.Log.Errorln("failed to write the data to the file, SyncWriter() =", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		// Ответ клиенту.
+		if err := m.WriteResponseHistogram(w, r, metrics); err != nil {
+			logger.Log.Errorln("failed to write the data to the connection, WriteResponseHistogram() =", err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+
 	default:
 		w.WriteHeader(http.StatusBadRequest)
 	}
@@ -158,7 +151,7 @@ func (m *Repository) GetMetric(w http.ResponseWriter, r *http.Request) {
 	// application/json
 	if r.Header.Get("Content-Type") == ContentTypeJSON {
 		if err := json.NewDecoder(r.Body).Decode(&metrics); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			WriteProblem(w, r, http.StatusBadRequest, err)
 			return
 		}
 	} else {
@@ -166,6 +159,10 @@ func (m *Repository) GetMetric(w http.ResponseWriter, r *http.Request) {
 		metrics.MType = chi.URLParam(r, "metric")
 		metrics.ID = chi.URLParam(r, "name")
 	}
+	if !allowMetric(r, metrics.ID) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
 
 	switch metrics.MType {
 	case Counter:
@@ -196,6 +193,20 @@ func (m *Repository) GetMetric(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+	case Histogram:
+		res, err := m.Store.GetHistogram(r.Context(), metrics.ID)
+		if err != nil {
+			logger.Log.Infoln("failed to get the data from storage, GetHistogram() = ", err)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		metrics.Histogram = &res
+		if err := m.WriteResponseHistogram(w, r, metrics); err != nil {
+			logger.Log.Errorln("failed to write the data to the connection, WriteResponseHistogram() =", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
 	default:
 		w.WriteHeader(http.StatusNotFound)
 	}
@@ -248,6 +259,37 @@ func (m *Repository) WriteResponseGauge(w http.ResponseWriter, r *http.Request,
 	return nil
 }
 
+// WriteResponseHistogram отдает клиенту данные и статус по распределению Histogram.
+// У гистограммы нет скалярного значения, поэтому и в text/plain отдается JSON распределения.
+func (m *Repository) WriteResponseHistogram(w http.ResponseWriter, r *http.Request, metrics models.Metrics) error {
+	// application/json
+	if r.Header.Get("Content-Type") == ContentTypeJSON {
+		w.Header().Set("Content-Type", ContentTypeJSON)
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(metrics); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	// text/plain
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(metrics.Histogram); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// batchMode возвращает настроенный режим применения батчей.
+func batchMode() models.BatchMode {
+	if app == nil || app.BatchMode == "" {
+		return models.BatchTransactional
+	}
+	return app.BatchMode
+}
+
 // PostBatchMetrics обрабатывает входящие батчи данных с метриками.
 func (m *Repository) PostBatchMetrics(w http.ResponseWriter, r *http.Request) {
 	var metrics []models.Metrics
@@ -256,26 +298,59 @@ func (m *Repository) PostBatchMetrics(w http.ResponseWriter, r *http.Request) {
 	if r.Header.Get("Content-Type") == ContentTypeJSON {
 		// Приняли даныне по http в metrics.
 		if err := json.NewDecoder(r.Body).Decode(&metrics); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			WriteProblem(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if err := checkBatchPrefix(r, metrics); err != nil {
+			WriteProblem(w, r, http.StatusForbidden, err)
 			return
 		}
 
-		// Обновляем данные в хранилище.
-		err := m.Store.UpdateBatchMetrics(r.Context(), metrics)
+		// Проверяем и применяем батч в настроенном режиме.
+		res, err := repositories.ApplyBatch(r.Context(), m.Store, models.Batch{
+			ID:      r.Header.Get(HeaderBatchID),
+			Mode:    batchMode(),
+			Metrics: metrics,
+		})
+		var verr *models.ValidationError
+		if errors.As(err, &verr) {
+			WriteProblem(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if seriesLimit(w, r, err) {
+			return
+		}
 		if err != nil {
 			logger.Log.Errorln("failed to update the data from storage, UpdateBatchMetrics() = ", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
-		// Сохранение данных в файл.
-		if err = file.SyncWriter(r.Context(), m.Store.GetAllMetrics); err != nil {
-			logger.Log.Errorln("failed to write the data to the file, SyncWriter() =", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+		// Агент учитывается по принятым метрикам, отклоненный или повторный батч его не отмечает.
+		// Сохранение данных в файл. Повтор уже примененного батча подтверждается без изменений.
+		if res.Applied > 0 {
+			m.trackAgent(r, res.Applied)
+			if err = file.SyncWriter(r.Context(), m.Store.GetAllMetrics); err != nil {
+				logger.Log.Errorln("failed to write the data to the file, SyncWriter() =", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
 		}
 
-		w.WriteHeader(http.StatusOK)
+		// Ответ клиенту с результатом по каждой метрике:
+		// 200 - применены все, 207 - часть отклонена, 422 - отклонены все.
+		status := http.StatusOK
+		switch {
+		case res.Rejected > 0 && res.Applied > 0:
+			status = http.StatusMultiStatus
+		case res.Rejected > 0:
+			status = http.StatusUnprocessableEntity
+		}
+		w.Header().Set("Content-Type", ContentTypeJSON)
+		w.WriteHeader(status)
+		if err = json.NewEncoder(w).Encode(res); err != nil {
+			logger.Log.Errorln("failed to write the data to the connection, Encode() =", err)
+		}
 
 	} else {
 		w.WriteHeader(http.StatusBadRequest)
@@ -3,10 +3,14 @@ package handlers
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/models"
 	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories/store"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -74,6 +78,16 @@ func TestHandlers(t *testing.T) {
 		{"positive: batch", "/updates/", `[{"id":"someMetric","type":"counter","delta":10}]`, http.StatusOK},
 		{"positive: gauge", "/update/", `{"id":"someMetric","type":"gauge","value":1.23}`, http.StatusOK},
 		{"positive: counter", "/update/", `{"id":"someMetric","type":"counter","delta":123}`, http.StatusOK},
+		{"positive: histogram", "/update/", `{"id":"someMetric","type":"histogram","histogram":{"bounds":[1,2],"counts":[1,0,2],"sum":7,"count":3}}`, http.StatusOK},
+		{"positive: histogram value", "/value/", `{"id":"someMetric","type":"histogram"}`, http.StatusOK},
+		{"positive: batch histogram", "/updates/", `[{"id":"someMetric","type":"histogram","histogram":{"bounds":[1],"counts":[1,1],"sum":3,"count":2}}]`, http.StatusOK},
+		{"negative: histogram without value", "/update/", `{"id":"someMetric","type":"histogram"}`, http.StatusBadRequest},
+		{"negative: inconsistent histogram", "/update/", `{"id":"someMetric","type":"histogram","histogram":{"bounds":[1],"counts":[1],"sum":1,"count":1}}`, http.StatusBadRequest},
+		{"negative: batch inconsistent histogram", "/updates/", `[{"id":"someMetric","type":"histogram","histogram":{"bounds":[2,1],"counts":[0,0,0]}}]`, http.StatusBadRequest},
+		{"negative: gauge without value", "/update/", `{"id":"someMetric","type":"gauge"}`, http.StatusBadRequest},
+		{"negative: counter without delta", "/update/", `{"id":"someMetric","type":"counter"}`, http.StatusBadRequest},
+		{"negative: long name", "/update/", `{"id":"` + strings.Repeat("a", 51) + `","type":"counter","delta":1}`, http.StatusBadRequest},
+		{"negative: batch gauge without value", "/updates/", `[{"id":"someMetric","type":"counter","delta":10},{"id":"someMetric","type":"gauge"}]`, http.StatusBadRequest},
 	}
 
 	for _, tt := range testsContentTypeJSON {
@@ -148,3 +162,119 @@ func TestHandlersWithBadStorage(t *testing.T) {
 		})
 	}
 }
+
+func TestProblemResponse(t *testing.T) {
+	routes := getRoutes()
+	ts := httptest.NewServer(middleware(routes))
+	defer ts.Close()
+
+	body := `[{"id":"someMetric","type":"counter","delta":10},{"id":"some metric","type":"gauge"}]`
+	req, err := http.NewRequestWithContext(context.Background(), "POST", ts.URL+"/updates/", strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, ContentTypeProblem, resp.Header.Get("Content-Type"))
+	var problem Problem
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+	assert.Equal(t, http.StatusBadRequest, problem.Status)
+	assert.Equal(t, "/updates/", problem.Instance)
+	require.Len(t, problem.InvalidParams, 2)
+	for i, name := range []string{"id", "value"} {
+		assert.Equal(t, name, problem.InvalidParams[i].Name)
+		assert.Equal(t, 1, *problem.InvalidParams[i].Index)
+	}
+}
+
+func TestPostBatchMetricsModes(t *testing.T) {
+	defer func() { app.BatchMode = "" }()
+	repo := NewRepo(store.NewMemStorage())
+	post := func(body string) (int, models.BatchResult) {
+		r := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(body))
+		r.Header.Set("Content-Type", ContentTypeJSON)
+		w := httptest.NewRecorder()
+		repo.PostBatchMetrics(w, r)
+		var res models.BatchResult
+		if w.Header().Get("Content-Type") == ContentTypeJSON {
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+		}
+		return w.Code, res
+	}
+	valid := `{"id":"PollCount","type":"counter","delta":1}`
+	invalid := `{"id":"Alloc","type":"gauge"}`
+
+	t.Run("all applied", func(t *testing.T) {
+		code, res := post("[" + valid + "]")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, 1, res.Applied)
+		assert.Equal(t, models.ItemApplied, res.Items[0].Status)
+	})
+
+	t.Run("transactional rejects the whole batch", func(t *testing.T) {
+		app.BatchMode = models.BatchTransactional
+		code, _ := post("[" + valid + "," + invalid + "]")
+		assert.Equal(t, http.StatusBadRequest, code)
+		value, err := repo.Store.GetCounter(context.Background(), "PollCount")
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), value)
+	})
+
+	t.Run("best effort applies valid items", func(t *testing.T) {
+		app.BatchMode = models.BatchBestEffort
+		code, res := post("[" + valid + "," + invalid + "]")
+		assert.Equal(t, http.StatusMultiStatus, code)
+		assert.Equal(t, 1, res.Applied)
+		assert.Equal(t, 1, res.Rejected)
+		assert.Equal(t, models.ItemApplied, res.Items[0].Status)
+		assert.Equal(t, models.ItemRejected, res.Items[1].Status)
+		assert.Equal(t, "value: is required for gauge", res.Items[1].Error)
+		value, err := repo.Store.GetCounter(context.Background(), "PollCount")
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), value)
+	})
+
+	t.Run("best effort with all items rejected", func(t *testing.T) {
+		app.BatchMode = models.BatchBestEffort
+		code, res := post("[" + invalid + "]")
+		assert.Equal(t, http.StatusUnprocessableEntity, code)
+		assert.Equal(t, 1, res.Rejected)
+	})
+}
+
+func TestPostBatchMetricsDuplicate(t *testing.T) {
+	repo := NewRepo(store.NewMemStorage())
+	post := func(batchID string) (int, models.BatchResult) {
+		r := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(`[{"id":"PollCount","type":"counter","delta":1}]`))
+		r.Header.Set("Content-Type", ContentTypeJSON)
+		r.Header.Set(HeaderBatchID, batchID)
+		w := httptest.NewRecorder()
+		repo.PostBatchMetrics(w, r)
+		var res models.BatchResult
+		if w.Header().Get("Content-Type") == ContentTypeJSON {
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+		}
+		return w.Code, res
+	}
+
+	code, res := post("batch-1")
+	assert.Equal(t, http.StatusOK, code)
+	assert.False(t, res.Duplicate)
+
+	// повтор подтверждается, но не применяется
+	code, res = post("batch-1")
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, res.Duplicate)
+
+	code, _ = post("batch-2")
+	assert.Equal(t, http.StatusOK, code)
+
+	value, err := repo.Store.GetCounter(context.Background(), "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), value)
+
+	code, _ = post(strings.Repeat("a", models.MaxBatchIDLength+1))
+	assert.Equal(t, http.StatusBadRequest, code)
+}
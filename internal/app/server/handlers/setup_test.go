@@ -37,6 +37,7 @@ func getRoutes() http.Handler {
 	r.Get("/", Repo.Default)
 	r.Post("/updates/", Repo.PostBatchMetrics)
 	r.Post("/update/", Repo.PostMetrics)
+	r.Post("/value/", Repo.GetMetric)
 
 	return r
 }
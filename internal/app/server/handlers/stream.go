@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/logger"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/models"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/stream"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/tenant"
+	"golang.org/x/net/websocket"
+)
+
+// HeartbeatInterval интервал heartbeat в потоке /api/v1/stream.
+var HeartbeatInterval = 15 * time.Second
+
+// Типы сообщений потока.
+const (
+	streamMetric    = "metric"    // обновление метрики
+	streamGap       = "gap"       // часть событий после Last-Event-ID вытеснена из буфера или потеряна при перезапуске
+	streamHeartbeat = "heartbeat" // поток жив, обновлений нет
+)
+
+// streamWriter отправляет сообщения потока клиенту по SSE или WebSocket.
+type streamWriter interface {
+	send(kind string, event *stream.Event) error
+}
+
+// StreamMessage сообщение потока по WebSocket.
+type StreamMessage struct {
+	Type   string          `json:"type"`
+	ID     string          `json:"id,omitempty"`
+	Time   *time.Time      `json:"time,omitempty"`
+	Metric *models.Metrics `json:"metric,omitempty"`
+}
+
+// Stream рассылает принятые обновления метрик: GET /api/v1/stream.
+// По умолчанию поток отдается как Server-Sent Events, при запросе Upgrade: websocket - по WebSocket.
+//
+// Параметры запроса:
+//   - type, name, regex - отбор метрик, как у ListMetrics;
+//   - last_event_id (или заголовок Last-Event-ID) - возобновить поток после события с этим идентификатором
+//     вида <эпоха>-<номер>, пропущенные события отдаются из буфера последних событий тенанта.
+func (m *Repository) Stream(w http.ResponseWriter, r *http.Request) {
+	if m.Updates == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	filter, err := parseFilter(r)
+	if err != nil {
+		WriteProblem(w, r, http.StatusBadRequest, err)
+		return
+	}
+	var re *regexp.Regexp
+	if filter.NameRegex != "" {
+		re = regexp.MustCompile(filter.NameRegex)
+	}
+	match := func(metric models.Metrics) bool {
+		return filter.HasType(metric.MType) && filter.HasPrefix(metric.ID) && (re == nil || re.MatchString(metric.ID))
+	}
+
+	var lastID stream.EventID
+	v := r.Header.Get("Last-Event-ID")
+	if q := r.URL.Query().Get("last_event_id"); q != "" {
+		v = q
+	}
+	if v != "" {
+		if lastID, err = stream.ParseEventID(v); err != nil {
+			WriteProblem(w, r, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		m.streamWebSocket(w, r, lastID, match)
+		return
+	}
+	m.streamSSE(w, r, lastID, match)
+}
+
+// streamSSE отдает поток как Server-Sent Events.
+func (m *Repository) streamSSE(w http.ResponseWriter, r *http.Request, lastID stream.EventID, match func(models.Metrics) bool) {
+	rc := http.NewResponseController(w)
+	// поток живет дольше таймаута записи сервера
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	sse := &sseWriter{w: w, rc: rc}
+	m.serveStream(r.Context(), lastID, match, sse)
+}
+
+// streamWebSocket отдает поток по WebSocket сообщениями StreamMessage в JSON.
+func (m *Repository) streamWebSocket(w http.ResponseWriter, r *http.Request, lastID stream.EventID, match func(models.Metrics) bool) {
+	server := websocket.Server{
+		// поток читают и CLI-клиенты без заголовка Origin
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler: func(ws *websocket.Conn) {
+			// снимаем таймауты сервера, оставшиеся на перехваченном соединении
+			_ = ws.SetDeadline(time.Time{})
+			ctx, cancel := context.WithCancel(r.Context())
+			defer cancel()
+			// клиент ничего не присылает, чтение нужно, чтобы заметить закрытие соединения
+			go func() {
+				var msg []byte
+				for websocket.Message.Receive(ws, &msg) == nil {
+				}
+				cancel()
+			}()
+			m.serveStream(ctx, lastID, match, wsWriter{ws: ws})
+		},
+	}
+	server.ServeHTTP(w, r)
+}
+
+// serveStream отправляет события тенанта запроса после lastID, затем новые события и heartbeat до отключения клиента.
+func (m *Repository) serveStream(ctx context.Context, lastID stream.EventID, match func(models.Metrics) bool, sw streamWriter) {
+	sub := m.Updates.Subscribe(tenant.FromContext(ctx), lastID)
+	defer sub.Close()
+
+	if sub.Gap {
+		if err := sw.send(streamGap, nil); err != nil {
+			return
+		}
+	}
+	for i := range sub.Backlog {
+		if !match(sub.Backlog[i].Metric) {
+			continue
+		}
+		if err := sw.send(streamMetric, &sub.Backlog[i]); err != nil {
+			return
+		}
+	}
+	// сразу подтверждаем подключение, чтобы клиент не ждал первого события
+	if err := sw.send(streamHeartbeat, nil); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(HeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-sub.Events:
+			if !ok {
+				// клиент не успевал читать события, он возобновит поток по последнему идентификатору
+				logger.Log.Infoln("stream subscriber is too slow, disconnecting")
+				return
+			}
+			if !match(event.Metric) {
+				continue
+			}
+			if err := sw.send(streamMetric, &event); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if err := sw.send(streamHeartbeat, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// sseWriter пишет сообщения в формате text/event-stream.
+type sseWriter struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
+
+func (s *sseWriter) send(kind string, event *stream.Event) error {
+	var err error
+	switch kind {
+	case streamMetric:
+		var data []byte
+		if data, err = json.Marshal(event.Metric); err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(s.w, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, kind, data)
+	case streamGap:
+		_, err = fmt.Fprintf(s.w, "event: %s\ndata: {}\n\n", kind)
+	default:
+		// комментарий SSE не вызывает событий на клиенте, но держит соединение
+		_, err = fmt.Fprintf(s.w, ": %s\n\n", kind)
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.rc.Flush()
+}
+
+// wsWriter пишет сообщения StreamMessage в JSON по WebSocket.
+type wsWriter struct {
+	ws *websocket.Conn
+}
+
+func (s wsWriter) send(kind string, event *stream.Event) error {
+	msg := StreamMessage{Type: kind}
+	if event != nil {
+		msg.ID, msg.Time, msg.Metric = event.ID.String(), &event.Time, &event.Metric
+	}
+	return websocket.JSON.Send(s.ws, msg)
+}
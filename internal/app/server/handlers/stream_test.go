@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	mw "github.com/webkimru/go-yandex-metrics/internal/app/server/middleware"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories/store"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/stream"
+	"golang.org/x/net/websocket"
+)
+
+// readEvent читает сообщения SSE до первого события, пропуская heartbeat.
+func readEvent(t *testing.T, r *bufio.Reader) map[string]string {
+	for {
+		if msg := readSSE(t, r); msg["comment"] == "" {
+			return msg
+		}
+	}
+}
+
+// readSSE читает одно сообщение SSE и возвращает его поля.
+func readSSE(t *testing.T, r *bufio.Reader) map[string]string {
+	msg := make(map[string]string)
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return msg
+		}
+		if strings.HasPrefix(line, ": ") {
+			msg["comment"] = strings.TrimPrefix(line, ": ")
+			continue
+		}
+		field, value, _ := strings.Cut(line, ": ")
+		msg[field] = value
+	}
+}
+
+func TestStream(t *testing.T) {
+	defer func(d time.Duration) { HeartbeatInterval = d }(HeartbeatInterval)
+	HeartbeatInterval = 50 * time.Millisecond
+
+	ctx := context.Background()
+	hub := stream.NewHub(10)
+	db := stream.NewStore(store.NewMemStorage(), hub)
+	repo := NewRepo(db)
+	repo.Updates = hub
+
+	// поток должен работать за теми же middleware, что и остальные маршруты
+	r := chi.NewRouter()
+	r.Use(mw.WithLogging)
+	r.Use(mw.Gzip)
+	r.Get("/api/v1/stream", repo.Stream)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	connect := func(query, lastID string) (*bufio.Reader, func()) {
+		ctx, cancel := context.WithCancel(ctx)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/api/v1/stream"+query, nil)
+		require.NoError(t, err)
+		if lastID != "" {
+			req.Header.Set("Last-Event-ID", lastID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+		return bufio.NewReader(resp.Body), func() {
+			cancel()
+			resp.Body.Close()
+		}
+	}
+
+	// идентификаторы событий вида <эпоха>-<номер>, эпоха запоминается по первому событию
+	var epoch string
+	id := func(seq int) string { return fmt.Sprintf("%s-%d", epoch, seq) }
+
+	t.Run("sse with filter and heartbeat", func(t *testing.T) {
+		events, closeStream := connect("?name=Heap*", "")
+		defer closeStream()
+		assert.Equal(t, "heartbeat", readSSE(t, events)["comment"])
+
+		for _, name := range []string{"HeapAlloc", "Sys", "HeapAlloc"} {
+			_, err := db.UpdateGauge(ctx, name, 1)
+			require.NoError(t, err)
+		}
+		msg := readEvent(t, events)
+		var ok bool
+		epoch, ok = strings.CutSuffix(msg["id"], "-1")
+		require.True(t, ok, msg["id"])
+		assert.Equal(t, "metric", msg["event"])
+		assert.JSONEq(t, `{"id":"HeapAlloc","type":"gauge","value":1}`, msg["data"])
+		assert.Equal(t, id(3), readEvent(t, events)["id"])
+		// без обновлений приходит heartbeat
+		assert.Equal(t, "heartbeat", readSSE(t, events)["comment"])
+	})
+
+	t.Run("sse resume", func(t *testing.T) {
+		events, closeStream := connect("", id(1))
+		defer closeStream()
+		assert.Equal(t, id(2), readSSE(t, events)["id"])
+		assert.Equal(t, id(3), readSSE(t, events)["id"])
+		assert.Equal(t, "heartbeat", readSSE(t, events)["comment"])
+	})
+
+	t.Run("sse resume after restart", func(t *testing.T) {
+		// идентификатор прежнего процесса: номера начались заново, клиент узнает о разрыве
+		for _, lastID := range []string{"previous-2", "2"} {
+			events, closeStream := connect("?type=gauge", lastID)
+			assert.Equal(t, "gap", readSSE(t, events)["event"])
+			assert.Equal(t, id(1), readSSE(t, events)["id"])
+			closeStream()
+		}
+	})
+
+	t.Run("sse gap", func(t *testing.T) {
+		for i := 0; i < 10; i++ {
+			_, err := db.UpdateCounter(ctx, "PollCount", 1)
+			require.NoError(t, err)
+		}
+		events, closeStream := connect("?type=counter&last_event_id="+id(1), "")
+		defer closeStream()
+		assert.Equal(t, "gap", readSSE(t, events)["event"])
+		assert.Equal(t, id(4), readSSE(t, events)["id"])
+	})
+
+	t.Run("bad requests", func(t *testing.T) {
+		for _, query := range []string{"?regex=(", "?type=none", "?last_event_id=abc", "?last_event_id=-1"} {
+			resp, err := http.Get(srv.URL + "/api/v1/stream" + query)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+		}
+	})
+
+	t.Run("websocket", func(t *testing.T) {
+		ws, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/api/v1/stream?type=gauge", "", "http://localhost/")
+		require.NoError(t, err)
+		defer ws.Close()
+
+		var msg StreamMessage
+		require.NoError(t, websocket.JSON.Receive(ws, &msg))
+		assert.Equal(t, "heartbeat", msg.Type)
+
+		_, err = db.UpdateCounter(ctx, "PollCount", 1)
+		require.NoError(t, err)
+		_, err = db.UpdateGauge(ctx, "Alloc", 2.5)
+		require.NoError(t, err)
+		require.NoError(t, websocket.JSON.Receive(ws, &msg))
+		assert.Equal(t, "metric", msg.Type)
+		require.NotNil(t, msg.Metric)
+		assert.Equal(t, "Alloc", msg.Metric.ID)
+		assert.Equal(t, 2.5, *msg.Metric.Value)
+	})
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/auth"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/config"
+	mw "github.com/webkimru/go-yandex-metrics/internal/app/server/middleware"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories/store"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/tenant"
+)
+
+func TestTenants(t *testing.T) {
+	tokens, err := auth.NewFileStore(filepath.Join(t.TempDir(), "tokens.json"))
+	require.NoError(t, err)
+	app.AdminKey, app.Tokens = "secret", auth.New(tokens)
+	defer func() { app.AdminKey, app.Tokens = "", nil }()
+	mw.NewMiddleware(app)
+	defer mw.NewMiddleware(&config.AppConfig{})
+
+	db := tenant.NewStore(store.NewMemStorage(), func(context.Context, string) (repositories.StoreRepository, error) {
+		return store.NewMemStorage(), nil
+	}, config.TenantsConfig{Enabled: true, Limits: config.TenantLimits{MaxSeries: 2}})
+	mw.NewTenants(db)
+	defer mw.NewTenants(nil)
+
+	repo := NewRepo(db)
+	r := chi.NewRouter()
+	r.Use(mw.Tenant)
+	r.With(mw.Authorize(auth.ScopeWrite)).Post("/updates/", repo.PostBatchMetrics)
+	r.With(mw.Authorize(auth.ScopeRead)).Get("/api/v1/metrics", repo.ListMetrics)
+	r.With(mw.Admin).Get("/api/v1/tokens", repo.ListTokens)
+	r.With(mw.Admin).Post("/api/v1/tokens", repo.IssueToken)
+	do := func(method, target, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if body != "" {
+			req.Header.Set("Content-Type", ContentTypeJSON)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	issue := func(token, body string) TokenResponse {
+		w := do(http.MethodPost, "/api/v1/tokens", token, body)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var res TokenResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+		return res
+	}
+	list := func(token string) []string {
+		w := do(http.MethodGet, "/api/v1/metrics", token, "")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var res ListResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+		var ids []string
+		for _, m := range res.Metrics {
+			ids = append(ids, m["id"].(string))
+		}
+		return ids
+	}
+
+	teamAdmin := issue("secret", `{"name":"team a","scopes":["admin"],"tenant":"team_a"}`)
+	// администратор тенанта выдает токены только своего тенанта
+	agentA := issue(teamAdmin.Value, `{"name":"agent","scopes":["read","write"]}`)
+	assert.Equal(t, "team_a", agentA.Tenant)
+	assert.Equal(t, http.StatusForbidden, do(http.MethodPost, "/api/v1/tokens", teamAdmin.Value, `{"scopes":["read"],"tenant":"team_b"}`).Code)
+	agentB := issue("secret", `{"name":"agent","scopes":["read","write"],"tenant":"team_b"}`)
+
+	require.Equal(t, http.StatusOK, do(http.MethodPost, "/updates/", agentA.Value, `[{"id":"cpu","type":"gauge","value":1}]`).Code)
+	require.Equal(t, http.StatusOK, do(http.MethodPost, "/updates/", agentB.Value, `[{"id":"mem","type":"gauge","value":1}]`).Code)
+
+	assert.Equal(t, []string{"cpu"}, list(agentA.Value))
+	assert.Equal(t, []string{"mem"}, list(agentB.Value))
+	assert.Empty(t, list("secret"))
+
+	t.Run("tokens of the tenant only", func(t *testing.T) {
+		w := do(http.MethodGet, "/api/v1/tokens", teamAdmin.Value, "")
+		require.Equal(t, http.StatusOK, w.Code)
+		var res TokensResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+		assert.Len(t, res.Tokens, 2)
+		for _, token := range res.Tokens {
+			assert.Equal(t, "team_a", token.Tenant)
+		}
+	})
+
+	t.Run("series limit", func(t *testing.T) {
+		w := do(http.MethodPost, "/updates/", agentA.Value, `[{"id":"load","type":"gauge","value":1},{"id":"disk","type":"gauge","value":1}]`)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, ContentTypeProblem, w.Header().Get("Content-Type"))
+		assert.Equal(t, []string{"cpu"}, list(agentA.Value))
+		// лимит считается для каждого тенанта отдельно
+		assert.Equal(t, http.StatusOK, do(http.MethodPost, "/updates/", agentB.Value, `[{"id":"load","type":"gauge","value":1}]`).Code)
+	})
+}
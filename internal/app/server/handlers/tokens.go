@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/auth"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/logger"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/models"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/tenant"
+)
+
+// TokenRequest запрос на выдачу токена.
+type TokenRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	Prefix string   `json:"prefix"`
+	Tenant string   `json:"tenant"`
+}
+
+// TokenResponse выданный токен. Значение токена показывается только при выдаче.
+type TokenResponse struct {
+	auth.Token
+	Value string `json:"token"`
+}
+
+// TokensResponse ответ /api/v1/tokens.
+type TokensResponse struct {
+	Tokens []auth.Token `json:"tokens"`
+}
+
+// errTokensDisabled аутентификация по токенам выключена.
+var errTokensDisabled = errors.New("token authentication is disabled")
+
+// errTokenOutsideScope токен выдан для другого тенанта или на метрики вне префикса вызывающего.
+var errTokenOutsideScope = errors.New("token is outside of the caller tenant or prefix")
+
+// IssueToken выдает токен: POST /api/v1/tokens.
+func (m *Repository) IssueToken(w http.ResponseWriter, r *http.Request) {
+	if app == nil || app.Tokens == nil {
+		WriteProblem(w, r, http.StatusNotFound, errTokensDisabled)
+		return
+	}
+
+	var req TokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		WriteProblem(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if _, err := auth.ParseScopes(req.Scopes); err != nil {
+		WriteProblem(w, r, http.StatusBadRequest, err)
+		return
+	}
+	// токен с префиксом не может выдать токен на чужие метрики
+	if !allowMetric(r, req.Prefix) {
+		WriteProblem(w, r, http.StatusForbidden, fmt.Errorf("prefix must start with %q", auth.FromContext(r.Context()).MetricPrefix()))
+		return
+	}
+
+	// токен тенанта выдает токены только своего тенанта
+	if caller := auth.FromContext(r.Context()).TenantName(); caller != "" {
+		if req.Tenant != "" && req.Tenant != caller {
+			WriteProblem(w, r, http.StatusForbidden, tenant.ErrForbidden)
+			return
+		}
+		req.Tenant = caller
+	}
+	if req.Tenant != "" {
+		if err := tenant.Validate(req.Tenant); err != nil {
+			WriteProblem(w, r, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	raw, token, err := app.Tokens.Issue(r.Context(), req.Name, req.Scopes, req.Prefix, req.Tenant)
+	if err != nil {
+		logger.Log.Errorln("failed to issue the token, Issue() =", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	token.Hash = ""
+
+	w.Header().Set("Content-Type", ContentTypeJSON)
+	w.WriteHeader(http.StatusCreated)
+	if err = json.NewEncoder(w).Encode(TokenResponse{Token: token, Value: raw}); err != nil {
+		logger.Log.Errorln("failed to write the data to the connection, Encode() =", err)
+	}
+}
+
+// ListTokens выдает выданные токены без их значений и хешей: GET /api/v1/tokens.
+// Токену тенанта или токену с префиксом видны только токены его тенанта внутри его префикса.
+func (m *Repository) ListTokens(w http.ResponseWriter, r *http.Request) {
+	if app == nil || app.Tokens == nil {
+		WriteProblem(w, r, http.StatusNotFound, errTokensDisabled)
+		return
+	}
+
+	tokens, err := app.Tokens.List(r.Context())
+	if err != nil {
+		logger.Log.Errorln("failed to get the tokens from storage, List() =", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	response := TokensResponse{Tokens: make([]auth.Token, 0, len(tokens))}
+	caller := auth.FromContext(r.Context())
+	for _, t := range tokens {
+		if !caller.Manages(t) {
+			continue
+		}
+		t.Hash = ""
+		response.Tokens = append(response.Tokens, t)
+	}
+
+	w.Header().Set("Content-Type", ContentTypeJSON)
+	w.WriteHeader(http.StatusOK)
+	if err = json.NewEncoder(w).Encode(response); err != nil {
+		logger.Log.Errorln("failed to write the data to the connection, Encode() =", err)
+	}
+}
+
+// RevokeToken отзывает токен: DELETE /api/v1/tokens/{id}.
+// Токен тенанта или токен с префиксом отзывает только токены своего тенанта внутри своего префикса.
+func (m *Repository) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	if app == nil || app.Tokens == nil {
+		WriteProblem(w, r, http.StatusNotFound, errTokensDisabled)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	if caller := auth.FromContext(r.Context()); caller != nil {
+		token, err := app.Tokens.Get(r.Context(), id)
+		if errors.Is(err, auth.ErrTokenNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Log.Errorln("failed to get the token from storage, Get() =", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !caller.Manages(token) {
+			WriteProblem(w, r, http.StatusForbidden, errTokenOutsideScope)
+			return
+		}
+	}
+
+	err := app.Tokens.Revoke(r.Context(), id)
+	if errors.Is(err, auth.ErrTokenNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Log.Errorln("failed to revoke the token, Revoke() =", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// allowMetric сообщает, доступна ли метрика токену запроса.
+func allowMetric(r *http.Request, name string) bool {
+	return auth.FromContext(r.Context()).AllowsMetric(name)
+}
+
+// checkPrefix проверяет, что метрика доступна токену запроса.
+func checkPrefix(r *http.Request, metric models.Metrics) error {
+	if allowMetric(r, metric.ID) {
+		return nil
+	}
+	return &models.ValidationError{Params: []models.InvalidParam{{Name: "id", Reason: outsidePrefix(r)}}}
+}
+
+// checkBatchPrefix проверяет, что все метрики батча доступны токену запроса.
+func checkBatchPrefix(r *http.Request, metrics []models.Metrics) error {
+	var params []models.InvalidParam
+	for i := range metrics {
+		if !allowMetric(r, metrics[i].ID) {
+			index := i
+			params = append(params, models.InvalidParam{Name: "id", Reason: outsidePrefix(r), Index: &index})
+		}
+	}
+	if len(params) > 0 {
+		return &models.ValidationError{Params: params}
+	}
+	return nil
+}
+
+func outsidePrefix(r *http.Request) string {
+	return fmt.Sprintf("metric name must start with %q", auth.FromContext(r.Context()).MetricPrefix())
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/auth"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/config"
+	mw "github.com/webkimru/go-yandex-metrics/internal/app/server/middleware"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories/store"
+)
+
+func TestTokens(t *testing.T) {
+	tokens, err := auth.NewFileStore(filepath.Join(t.TempDir(), "tokens.json"))
+	require.NoError(t, err)
+	app.AdminKey, app.Tokens = "secret", auth.New(tokens)
+	defer func() { app.AdminKey, app.Tokens = "", nil }()
+	mw.NewMiddleware(app)
+	defer mw.NewMiddleware(&config.AppConfig{})
+
+	ctx := context.Background()
+	db := store.NewMemStorage()
+	for _, name := range []string{"host1.load", "host2.load"} {
+		_, err = db.UpdateGauge(ctx, name, 1)
+		require.NoError(t, err)
+	}
+	repo := NewRepo(db)
+	r := chi.NewRouter()
+	r.With(mw.Authorize(auth.ScopeWrite)).Post("/updates/", repo.PostBatchMetrics)
+	r.With(mw.Authorize(auth.ScopeRead)).Get("/value/{metric}/{name}", repo.GetMetric)
+	r.With(mw.Authorize(auth.ScopeRead)).Get("/api/v1/metrics", repo.ListMetrics)
+	r.Group(func(r chi.Router) {
+		r.Use(mw.Admin)
+		r.Delete("/api/v1/metrics", repo.DeleteMetrics)
+		r.Get("/api/v1/tokens", repo.ListTokens)
+		r.Post("/api/v1/tokens", repo.IssueToken)
+		r.Delete("/api/v1/tokens/{id}", repo.RevokeToken)
+	})
+	do := func(method, target, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if body != "" {
+			req.Header.Set("Content-Type", ContentTypeJSON)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	issue := func(token, body string) TokenResponse {
+		w := do(http.MethodPost, "/api/v1/tokens", token, body)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var res TokenResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+		return res
+	}
+
+	admin := issue("secret", `{"name":"ops","scopes":["admin"]}`)
+	assert.NotEmpty(t, admin.Value)
+	assert.Empty(t, admin.Hash)
+	agent := issue(admin.Value, `{"name":"host1","scopes":["read","write"],"prefix":"host1."}`)
+
+	t.Run("bad requests", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/v1/tokens", "secret", `{"scopes":[]}`).Code)
+		assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/v1/tokens", "secret", `{"scopes":["root"]}`).Code)
+		assert.Equal(t, http.StatusForbidden, do(http.MethodPost, "/api/v1/tokens", agent.Value, `{"scopes":["read"]}`).Code)
+	})
+
+	t.Run("list without hashes", func(t *testing.T) {
+		w := do(http.MethodGet, "/api/v1/tokens", admin.Value, "")
+		require.Equal(t, http.StatusOK, w.Code)
+		var res TokensResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+		require.Len(t, res.Tokens, 2)
+		for _, token := range res.Tokens {
+			assert.Empty(t, token.Hash)
+		}
+		assert.NotContains(t, w.Body.String(), agent.Value)
+	})
+
+	t.Run("prefix restriction", func(t *testing.T) {
+		w := do(http.MethodPost, "/updates/", agent.Value, `[{"id":"host1.cpu","type":"gauge","value":1}]`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		w = do(http.MethodPost, "/updates/", agent.Value, `[{"id":"host1.cpu","type":"gauge","value":1},{"id":"host2.cpu","type":"gauge","value":1}]`)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		var problem Problem
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&problem))
+		require.Len(t, problem.InvalidParams, 1)
+		assert.Equal(t, 1, *problem.InvalidParams[0].Index)
+		_, err := db.GetGauge(ctx, "host2.cpu")
+		assert.Error(t, err)
+
+		assert.Equal(t, http.StatusOK, do(http.MethodGet, "/value/gauge/host1.load", agent.Value, "").Code)
+		assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "/value/gauge/host2.load", agent.Value, "").Code)
+
+		w = do(http.MethodGet, "/api/v1/metrics", agent.Value, "")
+		require.Equal(t, http.StatusOK, w.Code)
+		var res ListResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+		assert.Len(t, res.Metrics, 2)
+		for _, metric := range res.Metrics {
+			assert.True(t, strings.HasPrefix(metric["id"].(string), "host1."))
+		}
+	})
+
+	t.Run("admin token with prefix deletes only its metrics", func(t *testing.T) {
+		scoped := issue(admin.Value, `{"scopes":["admin"],"prefix":"host2."}`)
+		w := do(http.MethodDelete, "/api/v1/metrics?all=true", scoped.Value, "")
+		require.Equal(t, http.StatusOK, w.Code)
+		var res DeleteResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+		assert.Equal(t, int64(1), res.Deleted)
+		_, err := db.GetGauge(ctx, "host1.load")
+		assert.NoError(t, err)
+	})
+
+	t.Run("admin token with prefix manages only its tokens", func(t *testing.T) {
+		scoped := issue(admin.Value, `{"name":"host2 ops","scopes":["admin"],"prefix":"host2."}`)
+		w := do(http.MethodGet, "/api/v1/tokens", scoped.Value, "")
+		require.Equal(t, http.StatusOK, w.Code)
+		var res TokensResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+		for _, token := range res.Tokens {
+			assert.True(t, strings.HasPrefix(token.Prefix, "host2."), token.Name)
+		}
+		assert.Equal(t, http.StatusForbidden, do(http.MethodDelete, "/api/v1/tokens/"+admin.ID, scoped.Value, "").Code)
+		assert.Equal(t, http.StatusForbidden, do(http.MethodDelete, "/api/v1/tokens/"+agent.ID, scoped.Value, "").Code)
+		assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/api/v1/tokens/"+scoped.ID, scoped.Value, "").Code)
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/api/v1/tokens/"+agent.ID, admin.Value, "").Code)
+		assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/api/v1/tokens/"+agent.ID, admin.Value, "").Code)
+		assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/api/v1/metrics", agent.Value, "").Code)
+	})
+}
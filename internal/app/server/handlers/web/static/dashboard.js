@@ -0,0 +1,82 @@
+// Обновление дашборда по событиям /dashboard/events (Server-Sent Events).
+(function () {
+    "use strict";
+
+    var page = document.body.dataset.page;
+    var status = document.getElementById("status");
+
+    // токен доступа сохраняется в cookie: браузер передает ее со страницами, графиками и EventSource,
+    // и токен не попадает в адреса и журналы запросов
+    var login = document.getElementById("login");
+    if (login) {
+        login.addEventListener("submit", function (e) {
+            e.preventDefault();
+            var secure = window.location.protocol === "https:" ? "; Secure" : "";
+            document.cookie = "access_token=" + encodeURIComponent(document.getElementById("token").value) +
+                "; Path=/; SameSite=Strict" + secure;
+            window.location.href = "/";
+        });
+        return;
+    }
+
+    // поиск по имени метрики без перезагрузки страницы
+    var search = document.getElementById("search");
+    if (search) {
+        var filter = function () {
+            var q = search.value.toLowerCase();
+            document.querySelectorAll("tr.metric").forEach(function (row) {
+                row.classList.toggle("hidden", row.dataset.key.toLowerCase().indexOf(q) < 0);
+            });
+        };
+        search.addEventListener("input", filter);
+        filter();
+    }
+
+    if (!window.EventSource) {
+        return;
+    }
+
+    var refreshChart = function (el, key) {
+        fetch("/dashboard/" + key + "/chart.svg")
+            .then(function (resp) { return resp.ok ? resp.text() : ""; })
+            .then(function (svg) { if (svg) { el.innerHTML = svg; } });
+    };
+
+    var events = new EventSource("/dashboard/events");
+    events.onopen = function () {
+        status.textContent = "live";
+        status.classList.add("live");
+    };
+    events.onerror = function () {
+        status.textContent = "offline";
+        status.classList.remove("live");
+    };
+    events.addEventListener("metrics", function (e) {
+        var data = JSON.parse(e.data);
+        if (page === "index") {
+            var rows = document.querySelectorAll("tr.metric");
+            // появились или пропали метрики - перерисовываем страницу целиком
+            if (rows.length !== data.metrics.length) {
+                window.location.reload();
+                return;
+            }
+            data.metrics.forEach(function (m) {
+                var row = document.querySelector('tr.metric[data-key="' + CSS.escape(m.key) + '"]');
+                if (!row) {
+                    window.location.reload();
+                    return;
+                }
+                row.querySelector(".value").textContent = m.value;
+                row.querySelector(".chart").innerHTML = m.chart;
+            });
+        } else if (page === "metric") {
+            var key = document.body.dataset.key;
+            data.metrics.forEach(function (m) {
+                if (m.key === key) {
+                    document.querySelector(".value").textContent = m.value;
+                    refreshChart(document.querySelector(".chart"), key);
+                }
+            });
+        }
+    });
+})();
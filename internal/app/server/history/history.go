@@ -0,0 +1,161 @@
+// Package history хранит последние значения метрик для графиков и рассылает их подписчикам.
+package history
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/logger"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/models"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/tenant"
+)
+
+// Point значение метрики в момент времени.
+// Для counter - накопленное значение, для gauge - значение, для histogram - среднее Sum/Count.
+type Point struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// Snapshot все метрики тенанта на момент опроса.
+type Snapshot struct {
+	Time    time.Time        `json:"time"`
+	Tenant  string           `json:"tenant"`
+	Metrics []models.Metrics `json:"metrics"`
+}
+
+type key struct {
+	tenant string
+	mType  string
+	id     string
+}
+
+// History периодически опрашивает хранилище и хранит по size последних значений каждой метрики.
+type History struct {
+	// Tenants возвращает опрашиваемых тенантов, nil - опрашивается только тенант по умолчанию.
+	Tenants func() []string
+
+	store repositories.StoreRepository
+	size  int
+
+	mu     sync.RWMutex
+	series map[key][]Point
+	subs   map[chan Snapshot]string // подписчик и его тенант
+}
+
+// NewHistory конструктор типа History.
+func NewHistory(store repositories.StoreRepository, size int) *History {
+	return &History{
+		store:  store,
+		size:   size,
+		series: make(map[key][]Point),
+		subs:   make(map[chan Snapshot]string),
+	}
+}
+
+// Run опрашивает хранилище сразу и затем каждые interval до отмены контекста.
+func (h *History) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	if err := h.Sample(ctx, time.Now()); err != nil {
+		logger.Log.Errorln("failed to sample metrics, Sample() =", err)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := h.Sample(ctx, now); err != nil {
+				logger.Log.Errorln("failed to sample metrics, Sample() =", err)
+			}
+		}
+	}
+}
+
+// Sample однократно снимает значения всех метрик каждого тенанта на момент now и рассылает
+// снимки подписчикам тенанта. Ряды удаленных из хранилища метрик отбрасываются.
+func (h *History) Sample(ctx context.Context, now time.Time) error {
+	tenants := []string{tenant.Default}
+	if h.Tenants != nil {
+		tenants = h.Tenants()
+	}
+	snapshots := make([]Snapshot, 0, len(tenants))
+	for _, name := range tenants {
+		metrics, err := h.store.ListMetrics(tenant.WithTenant(ctx, name), models.ListOptions{SortBy: models.SortByName})
+		if err != nil {
+			return err
+		}
+		snapshots = append(snapshots, Snapshot{Time: now, Tenant: name, Metrics: metrics})
+	}
+
+	h.mu.Lock()
+	series := make(map[key][]Point, len(h.series))
+	for _, snapshot := range snapshots {
+		for _, m := range snapshot.Metrics {
+			k := key{tenant: snapshot.Tenant, mType: m.MType, id: m.ID}
+			points := append(h.series[k], Point{Time: now, Value: Value(m)})
+			if len(points) > h.size {
+				points = points[len(points)-h.size:]
+			}
+			series[k] = points
+		}
+		for ch, name := range h.subs {
+			if name != snapshot.Tenant {
+				continue
+			}
+			// медленный подписчик получает только последний снимок
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- snapshot
+		}
+	}
+	h.series = series
+	h.mu.Unlock()
+
+	return nil
+}
+
+// Points возвращает последние значения метрики тенанта, от старых к новым.
+func (h *History) Points(tenant, mType, id string) []Point {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	points := h.series[key{tenant: tenant, mType: mType, id: id}]
+	res := make([]Point, len(points))
+	copy(res, points)
+
+	return res
+}
+
+// Subscribe подписывает на снимки метрик тенанта после каждого опроса.
+// Возвращаемая функция отменяет подписку.
+func (h *History) Subscribe(tenant string) (<-chan Snapshot, func()) {
+	ch := make(chan Snapshot, 1)
+	h.mu.Lock()
+	h.subs[ch] = tenant
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.subs, ch)
+		h.mu.Unlock()
+	}
+}
+
+// Value возвращает числовое значение метрики для графика.
+func Value(m models.Metrics) float64 {
+	switch {
+	case m.Delta != nil && m.MType == "counter":
+		return float64(*m.Delta)
+	case m.Value != nil && m.MType == "gauge":
+		return *m.Value
+	case m.Histogram != nil && m.Histogram.Count > 0:
+		return m.Histogram.Sum / float64(m.Histogram.Count)
+	}
+	return 0
+}
@@ -0,0 +1,81 @@
+package history
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/config"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/models"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories/store"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/tenant"
+)
+
+func TestHistorySample(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMemStorage()
+	h := NewHistory(db, 2)
+	snapshots, cancel := h.Subscribe(tenant.Default)
+	defer cancel()
+	now := time.Now()
+
+	for i := 1; i <= 3; i++ {
+		_, err := db.UpdateGauge(ctx, "Alloc", float64(i))
+		require.NoError(t, err)
+		require.NoError(t, h.Sample(ctx, now.Add(time.Duration(i)*time.Second)))
+	}
+	// хранятся только последние size значений
+	assert.Equal(t, []Point{
+		{Time: now.Add(2 * time.Second), Value: 2},
+		{Time: now.Add(3 * time.Second), Value: 3},
+	}, h.Points(tenant.Default, "gauge", "Alloc"))
+
+	// подписчик получает последний снимок
+	snapshot := <-snapshots
+	assert.Equal(t, now.Add(3*time.Second), snapshot.Time)
+	require.Len(t, snapshot.Metrics, 1)
+	assert.Equal(t, "Alloc", snapshot.Metrics[0].ID)
+
+	// ряд удаленной метрики отбрасывается
+	require.NoError(t, db.DeleteMetric(ctx, "gauge", "Alloc"))
+	require.NoError(t, h.Sample(ctx, now.Add(4*time.Second)))
+	assert.Empty(t, h.Points(tenant.Default, "gauge", "Alloc"))
+}
+
+func TestHistoryTenants(t *testing.T) {
+	ctx := context.Background()
+	db := tenant.NewStore(store.NewMemStorage(), func(context.Context, string) (repositories.StoreRepository, error) {
+		return store.NewMemStorage(), nil
+	}, config.TenantsConfig{Enabled: true})
+	h := NewHistory(db, 2)
+	h.Tenants = db.Tenants
+	snapshots, cancel := h.Subscribe("team_a")
+	defer cancel()
+
+	_, err := db.UpdateGauge(ctx, "Alloc", 1)
+	require.NoError(t, err)
+	_, err = db.UpdateGauge(tenant.WithTenant(ctx, "team_a"), "Alloc", 2)
+	require.NoError(t, err)
+	now := time.Now()
+	require.NoError(t, h.Sample(ctx, now))
+
+	// ряды тенантов хранятся раздельно, подписчик получает снимок только своего тенанта
+	assert.Equal(t, []Point{{Time: now, Value: 1}}, h.Points(tenant.Default, "gauge", "Alloc"))
+	assert.Equal(t, []Point{{Time: now, Value: 2}}, h.Points("team_a", "gauge", "Alloc"))
+	snapshot := <-snapshots
+	assert.Equal(t, "team_a", snapshot.Tenant)
+	require.Len(t, snapshot.Metrics, 1)
+	assert.Equal(t, 2.0, *snapshot.Metrics[0].Value)
+	assert.Empty(t, snapshots)
+}
+
+func TestValue(t *testing.T) {
+	delta, value := int64(5), 1.5
+	assert.Equal(t, float64(5), Value(models.Metrics{MType: "counter", Delta: &delta}))
+	assert.Equal(t, 1.5, Value(models.Metrics{MType: "gauge", Value: &value}))
+	assert.Equal(t, 2.5, Value(models.Metrics{MType: "histogram", Histogram: &models.Histogram{Sum: 5, Count: 2}}))
+	assert.Equal(t, float64(0), Value(models.Metrics{MType: "histogram", Histogram: &models.Histogram{}}))
+}
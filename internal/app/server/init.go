@@ -2,28 +2,50 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"net/http"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/alert"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/auth"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/certs"
 	"github.com/webkimru/go-yandex-metrics/internal/app/server/config"
 	"github.com/webkimru/go-yandex-metrics/internal/app/server/file"
 	"github.com/webkimru/go-yandex-metrics/internal/app/server/file/async"
 	"github.com/webkimru/go-yandex-metrics/internal/app/server/grpc"
 	"github.com/webkimru/go-yandex-metrics/internal/app/server/handlers"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/history"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/inventory"
 	"github.com/webkimru/go-yandex-metrics/internal/app/server/logger"
 	"github.com/webkimru/go-yandex-metrics/internal/app/server/middleware"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/models"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/ratelimit"
 	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories"
 	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories/store"
 	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories/store/pg"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories/store/wal"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/stream"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/subnet"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/tenant"
 	"github.com/webkimru/go-yandex-metrics/internal/security"
 )
 
 var app config.AppConfig
 
+// tenants хранилища тенантов, nil - изоляция тенантов выключена.
+var tenants *tenant.Store
+
+// fileStore хранилище в файлах, nil - метрики хранятся не в файлах.
+var fileStore *wal.Store
+
 const (
 	HTTP = "HTTP"
 	GRPC = "GRPC"
@@ -33,16 +55,44 @@ const (
 func Setup(ctx context.Context) (*string, error) {
 	// указываем имя флага, значение по умолчанию и описание
 	serverAddress := flag.String("a", "", "server address")
+	grpcAddress := flag.String("grpc-address", "", "gRPC server address")
+	tlsCert := flag.String("tls-cert", "", "path to pem server certificate file")
+	tlsKey := flag.String("tls-key", "", "path to pem server private key file")
+	tlsClientCA := flag.String("tls-client-ca", "", "path to pem CA bundle to verify client certificates")
 	// интервал времени в секундах, по истечении которого текущие показания сервера сохраняются на диск
 	// (по умолчанию 300 секунд, значение 0 делает запись синхронной)
 	storeInterval := flag.Int("i", 0, "store interval")
 	storeFilePath := flag.String("f", "", "file storage path")
 	storeRestore := flag.Bool("r", false, "restore saved data")
 	databaseDSN := flag.String("d", "", "database dsn")
+	dbMaxConns := flag.Int("db-max-conns", 0, "max number of PostgreSQL connections in pool")
+	dbMinConns := flag.Int("db-min-conns", 0, "min number of open PostgreSQL connections in pool")
+	walDir := flag.String("wal-dir", "", "file store directory with write-ahead log and snapshots")
+	walFsync := flag.String("wal-fsync", "", "write-ahead log fsync policy: always, interval, never")
 	secretKey := flag.String("k", "", "secret key")
+	signKeys := flag.String("sign-keys", "", "additional signing keys: id:key,id:key")
+	signMaxSkew := flag.Int("sign-max-skew", 0, "max signature timestamp skew in seconds")
+	signAllowUnsigned := flag.Bool("sign-allow-unsigned", false, "accept unsigned requests when a signing key is set")
 	cryptoKey := flag.String("crypto-key", "", "path to pem private key file")
-	trustedSubnet := flag.String("t", "", "trusted subnet")
+	trustedSubnet := flag.String("t", "", "trusted subnets, comma separated")
+	denySubnets := flag.String("deny-subnets", "", "denied subnets, comma separated")
+	trustedProxies := flag.String("trusted-proxies", "", "trusted proxies subnets, comma separated")
 	serverProtocol := flag.String("s", "", "protocol: HTTP, GRPC")
+	adminKey := flag.String("admin-key", "", "admin key for delete and reset operations")
+	batchMode := flag.String("batch-mode", "", "batch mode: transactional, best_effort")
+	alertRules := flag.String("alert-rules", "", "path to yaml or json alerting rules file")
+	alertWebhook := flag.String("alert-webhook", "", "alert notifications webhook url")
+	staleIntervals := flag.Int("stale-intervals", 0, "number of report intervals after which a silent agent is stale")
+	authEnabled := flag.Bool("auth", false, "enable token authentication")
+	tokensFile := flag.String("tokens-file", "", "path to json tokens file")
+	tenantsEnabled := flag.Bool("tenants", false, "enable tenant isolation")
+	tenantsAllowed := flag.String("tenants-allowed", "", "tenants selectable by header without tenant token, comma separated")
+	tenantMaxSeries := flag.Int("tenant-max-series", 0, "max number of metrics per tenant")
+	tenantRate := flag.Float64("tenant-rate", 0, "max requests per second per tenant")
+	rateLimit := flag.Float64("rate-limit", 0, "max requests per second per client")
+	rateLimitKey := flag.String("rate-limit-key", "", "rate limit key: ip, agent, token")
+	maxBodySize := flag.Int64("max-body-size", 0, "max request body size in bytes")
+	maxDecompressedSize := flag.Int64("max-decompressed-size", 0, "max decompressed request body size in bytes")
 	configuration := flag.String("c", "", "path to json configuration file")
 	// разбор командной строки
 	flag.Parse()
@@ -50,6 +100,18 @@ func Setup(ctx context.Context) (*string, error) {
 	if envRunAddr := os.Getenv("ADDRESS"); envRunAddr != "" {
 		serverAddress = &envRunAddr
 	}
+	if envGRPCAddress := os.Getenv("GRPC_ADDRESS"); envGRPCAddress != "" {
+		grpcAddress = &envGRPCAddress
+	}
+	if envTLSCert := os.Getenv("TLS_CERT_FILE"); envTLSCert != "" {
+		tlsCert = &envTLSCert
+	}
+	if envTLSKey := os.Getenv("TLS_KEY_FILE"); envTLSKey != "" {
+		tlsKey = &envTLSKey
+	}
+	if envTLSClientCA := os.Getenv("TLS_CLIENT_CA_FILE"); envTLSClientCA != "" {
+		tlsClientCA = &envTLSClientCA
+	}
 	if envStoreInterval := os.Getenv("STORE_INTERVAL"); envStoreInterval != "" {
 		si, err := strconv.Atoi(envStoreInterval)
 		if err != nil {
@@ -70,18 +132,138 @@ func Setup(ctx context.Context) (*string, error) {
 	if envDatabaseDSN := os.Getenv("DATABASE_DSN"); envDatabaseDSN != "" {
 		databaseDSN = &envDatabaseDSN
 	}
+	if envDBMaxConns := os.Getenv("DATABASE_MAX_CONNS"); envDBMaxConns != "" {
+		mc, err := strconv.Atoi(envDBMaxConns)
+		if err != nil {
+			return nil, err
+		}
+		dbMaxConns = &mc
+	}
+	if envDBMinConns := os.Getenv("DATABASE_MIN_CONNS"); envDBMinConns != "" {
+		mc, err := strconv.Atoi(envDBMinConns)
+		if err != nil {
+			return nil, err
+		}
+		dbMinConns = &mc
+	}
+	if envWALDir := os.Getenv("WAL_DIR"); envWALDir != "" {
+		walDir = &envWALDir
+	}
+	if envWALFsync := os.Getenv("WAL_FSYNC"); envWALFsync != "" {
+		walFsync = &envWALFsync
+	}
 	if envSecretKey := os.Getenv("KEY"); envSecretKey != "" {
 		secretKey = &envSecretKey
 	}
+	if envSignKeys := os.Getenv("SIGN_KEYS"); envSignKeys != "" {
+		signKeys = &envSignKeys
+	}
+	if envSignMaxSkew := os.Getenv("SIGN_MAX_SKEW"); envSignMaxSkew != "" {
+		sms, err := strconv.Atoi(envSignMaxSkew)
+		if err != nil {
+			return nil, err
+		}
+		signMaxSkew = &sms
+	}
+	if envSignAllowUnsigned := os.Getenv("SIGN_ALLOW_UNSIGNED"); envSignAllowUnsigned != "" {
+		sau, err := strconv.ParseBool(envSignAllowUnsigned)
+		if err != nil {
+			return nil, err
+		}
+		signAllowUnsigned = &sau
+	}
 	if envCryptoKey := os.Getenv("CRYPTO_KEY"); envCryptoKey != "" {
 		cryptoKey = &envCryptoKey
 	}
 	if envTrustedSubnet := os.Getenv("TRUSTED_SUBNET"); envTrustedSubnet != "" {
 		trustedSubnet = &envTrustedSubnet
 	}
+	if envDenySubnets := os.Getenv("DENY_SUBNETS"); envDenySubnets != "" {
+		denySubnets = &envDenySubnets
+	}
+	if envTrustedProxies := os.Getenv("TRUSTED_PROXIES"); envTrustedProxies != "" {
+		trustedProxies = &envTrustedProxies
+	}
 	if envServerProtocol := os.Getenv("SERVER_PROTOCOL"); envServerProtocol != "" {
 		serverProtocol = &envServerProtocol
 	}
+	if envAdminKey := os.Getenv("ADMIN_KEY"); envAdminKey != "" {
+		adminKey = &envAdminKey
+	}
+	if envBatchMode := os.Getenv("BATCH_MODE"); envBatchMode != "" {
+		batchMode = &envBatchMode
+	}
+	if envAlertRules := os.Getenv("ALERT_RULES"); envAlertRules != "" {
+		alertRules = &envAlertRules
+	}
+	if envAlertWebhook := os.Getenv("ALERT_WEBHOOK"); envAlertWebhook != "" {
+		alertWebhook = &envAlertWebhook
+	}
+	if envStaleIntervals := os.Getenv("STALE_INTERVALS"); envStaleIntervals != "" {
+		si, err := strconv.Atoi(envStaleIntervals)
+		if err != nil {
+			return nil, err
+		}
+		staleIntervals = &si
+	}
+	if envAuth := os.Getenv("AUTH"); envAuth != "" {
+		ae, err := strconv.ParseBool(envAuth)
+		if err != nil {
+			return nil, err
+		}
+		authEnabled = &ae
+	}
+	if envTokensFile := os.Getenv("TOKENS_FILE"); envTokensFile != "" {
+		tokensFile = &envTokensFile
+	}
+	if envTenants := os.Getenv("TENANTS"); envTenants != "" {
+		te, err := strconv.ParseBool(envTenants)
+		if err != nil {
+			return nil, err
+		}
+		tenantsEnabled = &te
+	}
+	if envTenantsAllowed := os.Getenv("TENANTS_ALLOWED"); envTenantsAllowed != "" {
+		tenantsAllowed = &envTenantsAllowed
+	}
+	if envTenantMaxSeries := os.Getenv("TENANT_MAX_SERIES"); envTenantMaxSeries != "" {
+		ms, err := strconv.Atoi(envTenantMaxSeries)
+		if err != nil {
+			return nil, err
+		}
+		tenantMaxSeries = &ms
+	}
+	if envTenantRate := os.Getenv("TENANT_RATE"); envTenantRate != "" {
+		tr, err := strconv.ParseFloat(envTenantRate, 64)
+		if err != nil {
+			return nil, err
+		}
+		tenantRate = &tr
+	}
+	if envRateLimit := os.Getenv("RATE_LIMIT"); envRateLimit != "" {
+		rl, err := strconv.ParseFloat(envRateLimit, 64)
+		if err != nil {
+			return nil, err
+		}
+		rateLimit = &rl
+	}
+	if envRateLimitKey := os.Getenv("RATE_LIMIT_KEY"); envRateLimitKey != "" {
+		rateLimitKey = &envRateLimitKey
+	}
+	if envMaxBodySize := os.Getenv("MAX_BODY_SIZE"); envMaxBodySize != "" {
+		mb, err := strconv.ParseInt(envMaxBodySize, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		maxBodySize = &mb
+	}
+	if envMaxDecompressedSize := os.Getenv("MAX_DECOMPRESSED_SIZE"); envMaxDecompressedSize != "" {
+		md, err := strconv.ParseInt(envMaxDecompressedSize, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		maxDecompressedSize = &md
+	}
 	if envConfig := os.Getenv("CONFIG"); envConfig != "" {
 		configuration = &envConfig
 	}
@@ -107,6 +289,18 @@ func Setup(ctx context.Context) (*string, error) {
 	if *serverAddress != "" {
 		app.ServerAddress = *serverAddress
 	}
+	if *grpcAddress != "" {
+		app.GRPCAddress = *grpcAddress
+	}
+	if *tlsCert != "" {
+		app.TLS.CertFile = *tlsCert
+	}
+	if *tlsKey != "" {
+		app.TLS.KeyFile = *tlsKey
+	}
+	if *tlsClientCA != "" {
+		app.TLS.ClientCAFile = *tlsClientCA
+	}
 	if *storeInterval != 0 {
 		app.FileStore.Interval = *storeInterval
 	}
@@ -119,18 +313,94 @@ func Setup(ctx context.Context) (*string, error) {
 	if *databaseDSN != "" {
 		app.DatabaseDSN = *databaseDSN
 	}
+	if *dbMaxConns != 0 {
+		app.DatabasePool.MaxConns = *dbMaxConns
+	}
+	if *dbMinConns != 0 {
+		app.DatabasePool.MinConns = *dbMinConns
+	}
+	if *walDir != "" {
+		app.WAL.Dir = *walDir
+	}
+	if *walFsync != "" {
+		app.WAL.Fsync = *walFsync
+	}
 	if *secretKey != "" {
 		app.SecretKey = *secretKey
 	}
+	if *signKeys != "" {
+		keys, err := parseSignKeys(*signKeys)
+		if err != nil {
+			return nil, err
+		}
+		app.Signing.Keys = keys
+	}
+	if *signMaxSkew != 0 {
+		app.Signing.MaxSkew = *signMaxSkew
+	}
+	if *signAllowUnsigned {
+		app.Signing.AllowUnsigned = *signAllowUnsigned
+	}
 	if *cryptoKey != "" {
 		app.CryptoKey = *cryptoKey
 	}
 	if *trustedSubnet != "" {
 		app.TrustedSubnet = *trustedSubnet
 	}
+	if *denySubnets != "" {
+		app.Subnets.Deny = subnet.Split(*denySubnets)
+	}
+	if *trustedProxies != "" {
+		app.Subnets.TrustedProxies = subnet.Split(*trustedProxies)
+	}
 	if *serverProtocol != "" {
 		app.ServerProtocol = *serverProtocol
 	}
+	if *adminKey != "" {
+		app.AdminKey = *adminKey
+	}
+	if *batchMode != "" {
+		app.BatchMode = models.BatchMode(*batchMode)
+	}
+	if *alertRules != "" {
+		app.Alerting.RulesFile = *alertRules
+	}
+	if *alertWebhook != "" {
+		app.Alerting.Webhook.URL = *alertWebhook
+	}
+	if *staleIntervals != 0 {
+		app.Agents.StaleIntervals = *staleIntervals
+	}
+	if *authEnabled {
+		app.Auth.Enabled = *authEnabled
+	}
+	if *tokensFile != "" {
+		app.Auth.TokensFile = *tokensFile
+	}
+	if *tenantsEnabled {
+		app.Tenants.Enabled = *tenantsEnabled
+	}
+	if *tenantsAllowed != "" {
+		app.Tenants.Allowed = strings.Split(*tenantsAllowed, ",")
+	}
+	if *tenantMaxSeries != 0 {
+		app.Tenants.Limits.MaxSeries = *tenantMaxSeries
+	}
+	if *tenantRate != 0 {
+		app.Tenants.Limits.Rate = *tenantRate
+	}
+	if *rateLimit != 0 {
+		app.Limits.Rate = *rateLimit
+	}
+	if *rateLimitKey != "" {
+		app.Limits.Key = *rateLimitKey
+	}
+	if *maxBodySize != 0 {
+		app.Limits.MaxBodySize = *maxBodySize
+	}
+	if *maxDecompressedSize != 0 {
+		app.Limits.MaxDecompressedSize = *maxDecompressedSize
+	}
 	// обязательные настройки
 	if app.ServerAddress == "" {
 		app.ServerAddress = "localhost:8080"
@@ -144,19 +414,146 @@ func Setup(ctx context.Context) (*string, error) {
 		app.ServerProtocol = HTTP
 		logger.Log.Infof("default server protocol is automatically set = %s", app.ServerProtocol)
 	}
+	if app.Agents.ReportInterval <= 0 {
+		app.Agents.ReportInterval = 10 // silent default
+	}
+	if app.Agents.StaleIntervals <= 0 {
+		app.Agents.StaleIntervals = 3 // silent default
+	}
+	if app.Agents.TTL <= 0 {
+		app.Agents.TTL = 86400 // silent default
+	}
+	if app.Agents.MaxAgents <= 0 {
+		app.Agents.MaxAgents = 10000 // silent default
+	}
+	if app.Dashboard.Interval <= 0 {
+		app.Dashboard.Interval = 5 // silent default
+	}
+	if app.Dashboard.Points <= 0 {
+		app.Dashboard.Points = 120 // silent default
+	}
+	if app.Stream.Buffer <= 0 {
+		app.Stream.Buffer = 1000 // silent default
+	}
+	if app.Auth.TokensFile == "" {
+		app.Auth.TokensFile = "/tmp/metrics-tokens.json" // silent default
+	}
+	if app.GRPCAddress == "" {
+		app.GRPCAddress = ":3200" // silent default
+	}
+	if app.TLS.ReloadInterval <= 0 {
+		app.TLS.ReloadInterval = 10 // silent default
+	}
+	if app.Signing.MaxSkew <= 0 {
+		app.Signing.MaxSkew = 300 // silent default
+	}
+	if app.Limits.Key == "" {
+		app.Limits.Key = config.LimitByIP // silent default
+	}
+	switch app.Limits.Key {
+	case config.LimitByIP, config.LimitByAgent, config.LimitByToken:
+	default:
+		return nil, fmt.Errorf("unknown rate limit key %q: expected ip, agent or token", app.Limits.Key)
+	}
+	if app.Limits.MaxBodySize <= 0 {
+		app.Limits.MaxBodySize = 10 << 20 // silent default
+	}
+	if app.Limits.MaxDecompressedSize <= 0 {
+		app.Limits.MaxDecompressedSize = 64 << 20 // silent default
+	}
+	for i, name := range app.Tenants.Allowed {
+		app.Tenants.Allowed[i] = strings.TrimSpace(name)
+		if err := tenant.Validate(app.Tenants.Allowed[i]); err != nil {
+			return nil, err
+		}
+	}
+	if app.Tenants.MaxTenants <= 0 {
+		app.Tenants.MaxTenants = 100 // silent default
+	}
+	if app.DatabasePool.MaxConns <= 0 {
+		app.DatabasePool.MaxConns = 10 // silent default
+	}
+	if app.DatabasePool.MinConns < 0 || app.DatabasePool.MinConns > app.DatabasePool.MaxConns {
+		return nil, fmt.Errorf("database pool min conns %d must be between 0 and max conns %d", app.DatabasePool.MinConns, app.DatabasePool.MaxConns)
+	}
+	if app.DatabasePool.MaxConnLifetime <= 0 {
+		app.DatabasePool.MaxConnLifetime = 3600 // silent default
+	}
+	if app.DatabasePool.MaxConnIdleTime <= 0 {
+		app.DatabasePool.MaxConnIdleTime = 300 // silent default
+	}
+	if app.DatabasePool.StatementCache <= 0 {
+		app.DatabasePool.StatementCache = 512 // silent default
+	}
+	if app.WAL.Fsync == "" {
+		app.WAL.Fsync = config.FsyncInterval // silent default
+	}
+	switch app.WAL.Fsync {
+	case config.FsyncAlways, config.FsyncInterval, config.FsyncNever:
+	default:
+		return nil, fmt.Errorf("unknown wal fsync policy %q: expected always, interval or never", app.WAL.Fsync)
+	}
+	if app.WAL.FsyncInterval <= 0 {
+		app.WAL.FsyncInterval = 1 // silent default
+	}
+	if app.WAL.SnapshotInterval <= 0 {
+		app.WAL.SnapshotInterval = 300 // silent default
+	}
+	if app.WAL.SnapshotRecords <= 0 {
+		app.WAL.SnapshotRecords = 10000 // silent default
+	}
+	mode, err := models.ParseBatchMode(string(app.BatchMode))
+	if err != nil {
+		return nil, err
+	}
+	app.BatchMode = mode
 
 	logger.Log.Infoln(
 		"Starting configuration:",
 		"ADDRESS", app.ServerAddress,
+		"GRPC_ADDRESS", app.GRPCAddress,
+		"TLS_CERT_FILE", app.TLS.CertFile,
+		"TLS_KEY_FILE", app.TLS.KeyFile,
+		"TLS_CLIENT_CA_FILE", app.TLS.ClientCAFile,
 		"STORE_INTERVAL", app.FileStore.Interval,
 		"FILE_STORAGE_PATH", app.FileStore.FilePath,
 		"RESTORE", app.FileStore.Restore,
 		"DATABASE_DSN", app.DatabaseDSN,
+		"DATABASE_MAX_CONNS", app.DatabasePool.MaxConns,
+		"DATABASE_MIN_CONNS", app.DatabasePool.MinConns,
+		"WAL_DIR", app.WAL.Dir,
+		"WAL_FSYNC", app.WAL.Fsync,
 		"KEY", app.SecretKey,
+		"SIGN_KEYS", signKeyIDs(app.Signing.Keys),
+		"SIGN_MAX_SKEW", app.Signing.MaxSkew,
+		"SIGN_ALLOW_UNSIGNED", app.Signing.AllowUnsigned,
 		"CRYPTO_KEY", app.CryptoKey,
 		"TRUSTED_SUBNET", app.TrustedSubnet,
+		"SUBNETS_ALLOW", app.Subnets.Allow,
+		"DENY_SUBNETS", app.Subnets.Deny,
+		"TRUSTED_PROXIES", app.Subnets.TrustedProxies,
+		"BATCH_MODE", app.BatchMode,
+		"ALERT_RULES", app.Alerting.RulesFile,
+		"ALERT_WEBHOOK", app.Alerting.Webhook.URL,
+		"STALE_INTERVALS", app.Agents.StaleIntervals,
+		"AUTH", app.Auth.Enabled,
+		"TOKENS_FILE", app.Auth.TokensFile,
+		"TENANTS", app.Tenants.Enabled,
+		"TENANTS_ALLOWED", app.Tenants.Allowed,
+		"TENANT_MAX_SERIES", app.Tenants.Limits.MaxSeries,
+		"TENANT_RATE", app.Tenants.Limits.Rate,
+		"RATE_LIMIT", app.Limits.Rate,
+		"RATE_LIMIT_KEY", app.Limits.Key,
+		"MAX_BODY_SIZE", app.Limits.MaxBodySize,
+		"MAX_DECOMPRESSED_SIZE", app.Limits.MaxDecompressedSize,
 	)
 
+	// подсети разбираются один раз при запуске
+	app.SubnetPolicy, err = subnet.New(append(subnet.Split(app.TrustedSubnet), app.Subnets.Allow...), app.Subnets.Deny, app.Subnets.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
 	// инициализация ключей шифрования
 	privateKey, err := security.GetPrivateKeyPEM(app.CryptoKey)
 	if err != nil {
@@ -165,6 +562,18 @@ func Setup(ctx context.Context) (*string, error) {
 	}
 	app.PrivateKeyPEM = privateKey
 
+	// сертификаты TLS перечитываются при изменении файлов и по SIGHUP
+	app.Certs = nil
+	if app.TLS.CertFile != "" || app.TLS.KeyFile != "" || app.TLS.ClientCAFile != "" {
+		if app.TLS.CertFile == "" || app.TLS.KeyFile == "" {
+			return nil, errors.New("TLS requires both certificate and private key files")
+		}
+		if app.Certs, err = certs.New(app.TLS.CertFile, app.TLS.KeyFile, app.TLS.ClientCAFile); err != nil {
+			return nil, err
+		}
+		go app.Certs.Watch(ctx, time.Duration(app.TLS.ReloadInterval)*time.Second)
+	}
+
 	// инициализируем хранение метрик в файле
 	if err := file.Initialize(&app); err != nil {
 		return nil, err
@@ -179,11 +588,17 @@ func Setup(ctx context.Context) (*string, error) {
 	// 3 - Memory
 	var storePriority config.Store
 	var db repositories.StoreRepository
+	fileStore = nil
 	switch {
 	case app.DatabaseDSN != "": // DB
 		storePriority = config.Database
 		db = &pg.Store{}
 
+	case app.WAL.Dir != "": // File
+		storePriority = config.File
+		fileStore = &wal.Store{}
+		db = fileStore
+
 	default: // in memory
 		storePriority = config.Memory
 		db = &store.MemStorage{}
@@ -193,41 +608,201 @@ func Setup(ctx context.Context) (*string, error) {
 		return nil, err
 	}
 	// загружать ранее сохранённые значения из указанного файла при старте сервера
+	var restored *file.StructFile
 	if app.FileStore.Restore && storePriority == config.Memory {
-		res, err := file.Reader()
-		if err != nil {
+		if restored, err = file.Reader(); err != nil {
 			return nil, err
 		}
 		// если не пустой файл
-		if res != nil {
-			db = &store.MemStorage{Counter: res.Counter, Gauge: res.Gauge}
+		if restored != nil {
+			db = &store.MemStorage{Counter: restored.Counter, Gauge: restored.Gauge, Histogram: restored.Histogram}
 		}
 	}
 
+	// у каждого тенанта свое хранилище: своя схема PostgreSQL или свой MemStorage
+	tenants = nil
+	if app.Tenants.Enabled {
+		factory := func(ctx context.Context, name string) (repositories.StoreRepository, error) {
+			switch storePriority {
+			case config.Database:
+				return pg.NewTenantStore(ctx, app.DatabaseDSN, name, app.DatabasePool)
+			case config.File:
+				return wal.Open(wal.TenantDir(app.WAL.Dir, name), app.WAL)
+			}
+			return store.NewMemStorage(), nil
+		}
+		tenants = tenant.NewStore(db, factory, app.Tenants)
+		if restored != nil {
+			for name, res := range restored.Tenants {
+				tenants.Add(name, &store.MemStorage{Counter: res.Counter, Gauge: res.Gauge, Histogram: res.Histogram})
+			}
+		}
+		// тенанты со схемой в PostgreSQL созданы ранее, их хранилища подключаются при первом обращении
+		if storePriority == config.Database {
+			names, err := pg.DB.Tenants(ctx)
+			if err != nil {
+				return nil, err
+			}
+			tenants.Provision(names...)
+		}
+		// хранилища тенантов в файлах восстанавливаются при запуске, чтобы тенанты были видны сразу
+		if storePriority == config.File {
+			names, err := wal.Tenants(app.WAL.Dir)
+			if err != nil {
+				return nil, err
+			}
+			for _, name := range names {
+				tenantStore, err := wal.Open(wal.TenantDir(app.WAL.Dir, name), app.WAL)
+				if err != nil {
+					return nil, err
+				}
+				tenants.Add(name, tenantStore)
+			}
+		}
+		db = tenants
+	}
+
+	// токены доступа хранятся рядом с метриками: в PostgreSQL или в файле
+	if app.Auth.Enabled {
+		var tokens auth.TokenStore
+		if storePriority == config.Database {
+			tokens = pg.NewTokenStore(pg.DB.Pool)
+		} else if tokens, err = auth.NewFileStore(app.Auth.TokensFile); err != nil {
+			return nil, err
+		}
+		app.Tokens = auth.New(tokens)
+	}
+
+	// принятые обновления метрик публикуются в поток /api/v1/stream
+	updates := stream.NewHub(app.Stream.Buffer)
+	db = stream.NewStore(db, updates)
+
+	// реестр агентов общий для HTTP и gRPC
+	agents := inventory.NewRegistry(app.Agents)
 	// инициализируем репозиторий хендлеров с указанным вариантом хранения
 	repo := handlers.NewRepo(db)
+	repo.Agents = agents
+	repo.Updates = updates
+	// запускаем сбор последних значений метрик для дашборда
+	repo.History = history.NewHistory(db, app.Dashboard.Points)
+	if tenants != nil {
+		repo.History.Tenants = tenants.Tenants
+	}
+	go repo.History.Run(ctx, time.Duration(app.Dashboard.Interval)*time.Second)
+	// запускаем вычисление правил алертинга; с изоляцией тенантов правила вычисляются для тенанта по умолчанию
+	if app.Alerting.RulesFile != "" {
+		if repo.Alerts, err = StartAlerting(ctx, db); err != nil {
+			return nil, err
+		}
+	}
 	// запоминаем вариант хранения
 	app.StorePriority = storePriority
 	// инициализируем
 	middleware.NewMiddleware(&app)
+	middleware.NewTenants(tenants)
+	// ограничение частоты запросов клиента общее для HTTP и gRPC
+	limiter := ratelimit.New(app.Limits.Rate, app.Limits.Burst)
+	middleware.NewRateLimiter(limiter)
 	// инициализвруем хендлеры для работы с репозиторием
 	handlers.NewHandlers(repo, &app)
 
 	repoGRPC := grpc.NewRepo(db)
-	grpc.NewMetricHandlers(repoGRPC)
+	repoGRPC.Agents = agents
+	repoGRPC.Tenants = tenants
+	repoGRPC.Limiter = limiter
+	grpc.NewMetricHandlers(repoGRPC, &app)
 
 	return &app.ServerAddress, nil
 }
 
-func Shutdown(ctx context.Context, srv *http.Server) {
-	if app.StorePriority == config.Database {
-		err := pg.DB.Conn.Close()
-		if err != nil {
-			logger.Log.Errorf("Faild pg.DB.Conn.Close(): %v", err)
+// TLSConfig возвращает настройки TLS HTTP-сервера, nil - сервер работает без TLS.
+func TLSConfig() *tls.Config {
+	if app.Certs == nil {
+		return nil
+	}
+	return app.Certs.TLSConfig()
+}
+
+// GRPCAddress возвращает адрес gRPC-сервера.
+func GRPCAddress() string {
+	return app.GRPCAddress
+}
+
+// parseSignKeys разбирает ключи подписи вида id:key,id:key.
+func parseSignKeys(s string) (map[string]string, error) {
+	keys := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		id, key, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok || id == "" || key == "" {
+			return nil, fmt.Errorf("invalid signing key %q: expected id:key", pair)
 		}
+		keys[id] = key
+	}
+	return keys, nil
+}
+
+// signKeyIDs возвращает идентификаторы ключей подписи для журнала, сами ключи не пишутся.
+func signKeyIDs(keys map[string]string) []string {
+	ids := make([]string, 0, len(keys))
+	for id := range keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// StartAlerting загружает правила алертинга и запускает их вычисление и отправку уведомлений
+// до отмены контекста.
+func StartAlerting(ctx context.Context, db repositories.StoreRepository) (*alert.Evaluator, error) {
+	rules, err := alert.LoadRules(app.Alerting.RulesFile)
+	if err != nil {
+		return nil, err
 	}
+	if app.Alerting.Interval <= 0 {
+		app.Alerting.Interval = 15
+	}
+	if app.Alerting.Webhook.GroupWait <= 0 {
+		app.Alerting.Webhook.GroupWait = 10
+	}
+
+	var notifier alert.Notifier
+	if app.Alerting.Webhook.URL != "" {
+		webhook := alert.NewWebhook(
+			app.Alerting.Webhook.URL,
+			time.Duration(app.Alerting.Webhook.GroupWait)*time.Second,
+			app.Alerting.Webhook.GroupBy,
+		)
+		go webhook.Run(ctx)
+		notifier = webhook
+	}
+
+	evaluator := alert.NewEvaluator(db, rules, notifier)
+	go evaluator.Run(ctx, time.Duration(app.Alerting.Interval)*time.Second)
+	logger.Log.Infof("loaded %d alerting rules from file=%s", len(rules), app.Alerting.RulesFile)
 
+	return evaluator, nil
+}
+
+func Shutdown(ctx context.Context, srv *http.Server) {
 	if err := srv.Shutdown(ctx); err != nil {
 		logger.Log.Fatalf("Server shutdown failed: %v", err)
 	}
+
+	if app.StorePriority == config.Database {
+		err := pg.DB.Close()
+		if err != nil {
+			logger.Log.Errorf("Faild pg.DB.Close(): %v", err)
+		}
+	}
+	if tenants != nil {
+		if err := tenants.Close(); err != nil {
+			logger.Log.Errorf("Faild tenants.Close(): %v", err)
+		}
+	}
+	// закрытие сохраняет снимок, поэтому выполняется после завершения запросов
+	if fileStore != nil {
+		if err := fileStore.Close(); err != nil {
+			logger.Log.Errorf("Faild fileStore.Close(): %v", err)
+		}
+	}
 }
@@ -0,0 +1,209 @@
+// Package inventory ведет учет агентов, присылающих метрики на сервер.
+package inventory
+
+import (
+	"context"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/config"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/tenant"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// HeaderAgentID заголовок с идентификатором агента.
+	HeaderAgentID = "X-Agent-ID"
+	// HeaderAgentVersion заголовок с версией агента.
+	HeaderAgentVersion = "X-Agent-Version"
+	// HeaderReportInterval заголовок с интервалом отправки метрик агентом в секундах.
+	HeaderReportInterval = "X-Report-Interval"
+)
+
+// Info сведения об отправителе метрик из заголовков запроса или metadata gRPC.
+type Info struct {
+	ID       string
+	IP       string
+	Version  string
+	Interval int    // интервал отправки в секундах, 0 - неизвестен
+	Tenant   string // тенант, в который агент отправляет метрики
+}
+
+// FromRequest извлекает сведения об агенте из HTTP-запроса. ip - адрес клиента, определенный
+// политикой доверенных прокси: присланному агентом X-Real-IP реестр не верит.
+// Без заголовка X-Agent-ID идентификатором служит адрес.
+func FromRequest(r *http.Request, ip netip.Addr) Info {
+	info := Info{
+		ID:      r.Header.Get(HeaderAgentID),
+		IP:      addrString(ip),
+		Version: r.Header.Get(HeaderAgentVersion),
+		Tenant:  tenant.FromContext(r.Context()),
+	}
+	info.Interval, _ = strconv.Atoi(r.Header.Get(HeaderReportInterval))
+
+	return info.withDefaults()
+}
+
+// FromMetadata извлекает сведения об агенте из metadata gRPC, ip - адрес клиента, определенный
+// политикой доверенных прокси. Тенант берется из контекста вызова.
+func FromMetadata(ctx context.Context, md metadata.MD, ip netip.Addr) Info {
+	get := func(key string) string {
+		if values := md.Get(key); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	info := Info{
+		ID:      get(HeaderAgentID),
+		IP:      addrString(ip),
+		Version: get(HeaderAgentVersion),
+		Tenant:  tenant.FromContext(ctx),
+	}
+	info.Interval, _ = strconv.Atoi(get(HeaderReportInterval))
+
+	return info.withDefaults()
+}
+
+func (i Info) withDefaults() Info {
+	if i.ID == "" {
+		i.ID = i.IP
+	}
+	return i
+}
+
+// addrString возвращает адрес без зоны IPv6, пустую строку - для неизвестного адреса.
+func addrString(ip netip.Addr) string {
+	if !ip.IsValid() {
+		return ""
+	}
+	return ip.Unmap().WithZone("").String()
+}
+
+// Agent запись об агенте.
+type Agent struct {
+	ID             string    `json:"id"`
+	Tenant         string    `json:"tenant"`
+	IP             string    `json:"ip"`
+	Version        string    `json:"version,omitempty"`
+	ReportInterval int       `json:"report_interval"` // секунды
+	FirstSeen      time.Time `json:"first_seen"`
+	LastSeen       time.Time `json:"last_seen"`
+	Reports        int64     `json:"reports"` // количество принятых отправок
+	Metrics        int       `json:"metrics"` // количество метрик в последней отправке
+	Stale          bool      `json:"stale"`
+}
+
+// agentKey агент тенанта: одинаковые идентификаторы агентов разных тенантов не пересекаются.
+type agentKey struct {
+	tenant string
+	id     string
+}
+
+// Registry реестр агентов.
+// Агент считается устаревшим, если от него не было метрик дольше staleIntervals его интервалов отправки.
+// Идентификатор агента присылает клиент, поэтому реестр ограничен: агент, молчащий дольше ttl, удаляется,
+// а при заполнении реестра до maxAgents место нового агента освобождает дольше всех молчащий.
+type Registry struct {
+	mu             sync.Mutex
+	agents         map[agentKey]*Agent
+	interval       int // интервал отправки по умолчанию для агентов, не сообщивших свой
+	staleIntervals int
+	ttl            time.Duration // 0 - без удаления
+	maxAgents      int           // 0 - без ограничения
+}
+
+// NewRegistry создает реестр агентов.
+func NewRegistry(cfg config.AgentsConfig) *Registry {
+	return &Registry{
+		agents:         make(map[agentKey]*Agent),
+		interval:       cfg.ReportInterval,
+		staleIntervals: cfg.StaleIntervals,
+		ttl:            time.Duration(cfg.TTL) * time.Second,
+		maxAgents:      cfg.MaxAgents,
+	}
+}
+
+// Seen отмечает принятую отправку metrics метрик агентом в момент now.
+func (r *Registry) Seen(info Info, metrics int, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if info.Tenant == "" {
+		info.Tenant = tenant.Default
+	}
+	key := agentKey{tenant: info.Tenant, id: info.ID}
+	a, ok := r.agents[key]
+	if !ok {
+		if r.maxAgents > 0 && len(r.agents) >= r.maxAgents {
+			r.expire(now)
+		}
+		if r.maxAgents > 0 && len(r.agents) >= r.maxAgents {
+			r.evictOldest()
+		}
+		a = &Agent{ID: info.ID, Tenant: info.Tenant, FirstSeen: now}
+		r.agents[key] = a
+	}
+	a.IP = info.IP
+	if info.Version != "" {
+		a.Version = info.Version
+	}
+	if info.Interval > 0 {
+		a.ReportInterval = info.Interval
+	}
+	a.LastSeen = now
+	a.Reports++
+	a.Metrics = metrics
+}
+
+// List возвращает агентов тенанта, отсортированных по идентификатору, с признаком устаревания на момент now.
+func (r *Registry) List(name string, now time.Time) []Agent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.expire(now)
+
+	res := make([]Agent, 0, len(r.agents))
+	for key, a := range r.agents {
+		if key.tenant != name {
+			continue
+		}
+		agent := *a
+		if agent.ReportInterval == 0 {
+			agent.ReportInterval = r.interval
+		}
+		silence := time.Duration(agent.ReportInterval*r.staleIntervals) * time.Second
+		agent.Stale = now.Sub(agent.LastSeen) > silence
+		res = append(res, agent)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+
+	return res
+}
+
+// expire удаляет агентов, молчащих дольше ttl. Вызывается под r.mu.
+func (r *Registry) expire(now time.Time) {
+	if r.ttl <= 0 {
+		return
+	}
+	for key, a := range r.agents {
+		if now.Sub(a.LastSeen) > r.ttl {
+			delete(r.agents, key)
+		}
+	}
+}
+
+// evictOldest удаляет дольше всех молчащего агента. Вызывается под r.mu.
+func (r *Registry) evictOldest() {
+	var oldest agentKey
+	var last time.Time
+	for key, a := range r.agents {
+		if last.IsZero() || a.LastSeen.Before(last) {
+			oldest, last = key, a.LastSeen
+		}
+	}
+	delete(r.agents, oldest)
+}
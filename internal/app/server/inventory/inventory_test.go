@@ -0,0 +1,94 @@
+package inventory
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/config"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/tenant"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestFromRequest(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/updates/", nil)
+	ip := netip.MustParseAddr("10.0.0.5")
+	assert.Equal(t, Info{ID: "10.0.0.5", IP: "10.0.0.5", Tenant: tenant.Default}, FromRequest(r, ip))
+
+	r.Header.Set(HeaderAgentID, "host-1")
+	r.Header.Set(HeaderAgentVersion, "v1.2.0")
+	r.Header.Set(HeaderReportInterval, "5")
+	// адрес из заголовка не учитывается: его определяет политика доверенных прокси
+	r.Header.Set("X-Real-IP", "192.168.1.10")
+	assert.Equal(t, Info{ID: "host-1", IP: "10.0.0.5", Version: "v1.2.0", Interval: 5, Tenant: tenant.Default}, FromRequest(r, ip))
+}
+
+func TestFromMetadata(t *testing.T) {
+	md := metadata.Pairs("x-agent-id", "host-2", "x-report-interval", "20", "x-real-ip", "192.168.1.10")
+	ctx := tenant.WithTenant(context.Background(), "team_a")
+	assert.Equal(t, Info{ID: "host-2", IP: "127.0.0.1", Interval: 20, Tenant: "team_a"}, FromMetadata(ctx, md, netip.MustParseAddr("127.0.0.1")))
+	assert.Equal(t, Info{Tenant: tenant.Default}, FromMetadata(context.Background(), nil, netip.Addr{}))
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry(config.AgentsConfig{ReportInterval: 10, StaleIntervals: 3})
+	now := time.Now()
+
+	r.Seen(Info{ID: "b", IP: "10.0.0.2", Version: "v1", Interval: 2}, 30, now)
+	r.Seen(Info{ID: "a", IP: "10.0.0.1"}, 10, now)
+	r.Seen(Info{ID: "a", IP: "10.0.0.3"}, 12, now.Add(time.Second))
+
+	agents := r.List(tenant.Default, now.Add(10*time.Second))
+	require.Len(t, agents, 2)
+	assert.Equal(t, Agent{
+		ID:             "a",
+		Tenant:         tenant.Default,
+		IP:             "10.0.0.3",
+		ReportInterval: 10,
+		FirstSeen:      now,
+		LastSeen:       now.Add(time.Second),
+		Reports:        2,
+		Metrics:        12,
+	}, agents[0])
+	// агент b молчит дольше трех своих интервалов
+	assert.Equal(t, "b", agents[1].ID)
+	assert.True(t, agents[1].Stale)
+	assert.Equal(t, "v1", agents[1].Version)
+
+	agents = r.List(tenant.Default, now.Add(40*time.Second))
+	assert.True(t, agents[0].Stale)
+
+	// агенты с одинаковым идентификатором в разных тенантах учитываются раздельно
+	r.Seen(Info{ID: "a", IP: "10.0.1.1", Tenant: "team_a"}, 5, now)
+	agents = r.List("team_a", now)
+	require.Len(t, agents, 1)
+	assert.Equal(t, "10.0.1.1", agents[0].IP)
+	assert.Equal(t, int64(1), agents[0].Reports)
+	assert.Len(t, r.List(tenant.Default, now), 2)
+}
+
+func TestRegistryBounded(t *testing.T) {
+	r := NewRegistry(config.AgentsConfig{ReportInterval: 10, StaleIntervals: 3, TTL: 60, MaxAgents: 2})
+	now := time.Now()
+
+	r.Seen(Info{ID: "a"}, 1, now)
+	r.Seen(Info{ID: "b"}, 1, now.Add(time.Second))
+	r.Seen(Info{ID: "a"}, 1, now.Add(2*time.Second))
+	// реестр заполнен: место нового агента освобождает дольше всех молчащий b
+	r.Seen(Info{ID: "c"}, 1, now.Add(3*time.Second))
+	agents := r.List(tenant.Default, now.Add(3*time.Second))
+	require.Len(t, agents, 2)
+	assert.Equal(t, "a", agents[0].ID)
+	assert.Equal(t, "c", agents[1].ID)
+
+	// агент, молчащий дольше ttl, удаляется
+	r.Seen(Info{ID: "c"}, 1, now.Add(61*time.Second))
+	agents = r.List(tenant.Default, now.Add(63*time.Second))
+	require.Len(t, agents, 1)
+	assert.Equal(t, "c", agents[0].ID)
+}
@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/auth"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/logger"
+	"github.com/webkimru/go-yandex-metrics/internal/security"
+)
+
+// Admin пропускает только запросы с административным ключом в заголовке Authorization: Bearer <key>
+// или с токеном в области действия admin. Без настроенного ключа и токенов административные операции запрещены.
+func Admin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.AdminKey == "" && app.Tokens == nil {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		authorize(w, r, next, auth.ScopeAdmin)
+	})
+}
+
+// Authorize пропускает запросы с токеном в заданной области действия, если включена аутентификация по токенам.
+// Токен передается в заголовке Authorization: Bearer <token> или, для дашборда и EventSource,
+// в cookie access_token. Административный ключ разрешает любые запросы.
+func Authorize(scope string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if app.Tokens == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			authorize(w, r, next, scope)
+		})
+	}
+}
+
+// authorize проверяет административный ключ или токен запроса и сохраняет токен в контексте.
+func authorize(w http.ResponseWriter, r *http.Request, next http.Handler, scope string) {
+	authorization := r.Header.Get("Authorization")
+	if security.CheckBearer(authorization, app.AdminKey) {
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	// токен уже проверен при определении тенанта
+	token := auth.FromContext(r.Context())
+	if token == nil {
+		raw := requestToken(r)
+		if app.Tokens == nil || raw == "" {
+			unauthorized(w, r, "Bearer")
+			return
+		}
+		var err error
+		token, err = app.Tokens.Authenticate(r.Context(), raw)
+		if errors.Is(err, auth.ErrInvalidToken) {
+			unauthorized(w, r, `Bearer error="invalid_token"`)
+			return
+		}
+		if err != nil {
+			logger.Log.Errorln("failed to check the token, Authenticate() =", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	if !token.Allows(scope) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+scope+`"`)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	next.ServeHTTP(w, r.WithContext(auth.WithToken(r.Context(), token)))
+}
+
+// verifyToken проверяет токен запроса до Authorize и сохраняет его в контексте, если он еще не проверен.
+// Неверный токен не ошибка: запрос без проверенного токена отклонит Authorize.
+func verifyToken(r *http.Request) (*http.Request, error) {
+	if app.Tokens == nil || auth.FromContext(r.Context()) != nil || security.CheckBearer(r.Header.Get("Authorization"), app.AdminKey) {
+		return r, nil
+	}
+	raw := requestToken(r)
+	if raw == "" {
+		return r, nil
+	}
+	token, err := app.Tokens.Authenticate(r.Context(), raw)
+	if errors.Is(err, auth.ErrInvalidToken) {
+		return r, nil
+	}
+	if err != nil {
+		return r, err
+	}
+
+	return r.WithContext(auth.WithToken(r.Context(), token)), nil
+}
+
+// tokenCookie cookie с токеном дашборда: браузер не передает заголовок Authorization со страницами и EventSource.
+const tokenCookie = "access_token"
+
+// requestToken возвращает токен из заголовка Authorization или из cookie дашборда. Cookie принимается
+// только в запросах на чтение: дашборд ничего не меняет, а изменения по cookie были бы уязвимы к CSRF.
+func requestToken(r *http.Request) string {
+	if raw := auth.FromAuthorization(r.Header.Get("Authorization")); raw != "" {
+		return raw
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return ""
+	}
+	cookie, err := r.Cookie(tokenCookie)
+	if err != nil {
+		return ""
+	}
+	raw, err := url.QueryUnescape(cookie.Value)
+	if err != nil {
+		return ""
+	}
+
+	return raw
+}
+
+// unauthorized отвечает 401, а браузер, запросивший страницу дашборда, отправляет на страницу входа.
+func unauthorized(w http.ResponseWriter, r *http.Request, challenge string) {
+	if r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+		http.Redirect(w, r, "/dashboard/login", http.StatusSeeOther)
+		return
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	w.WriteHeader(http.StatusUnauthorized)
+}
@@ -0,0 +1,134 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/auth"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/config"
+)
+
+func TestAdmin(t *testing.T) {
+	a := config.AppConfig{}
+	NewMiddleware(&a)
+
+	handler := Admin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name               string
+		adminKey           string
+		authorization      string
+		expectedStatusCode int
+	}{
+		{"positive: valid key", "secret", "Bearer secret", http.StatusOK},
+		{"negative: wrong key", "secret", "Bearer wrong", http.StatusUnauthorized},
+		{"negative: without header", "secret", "", http.StatusUnauthorized},
+		{"negative: not bearer", "secret", "secret", http.StatusUnauthorized},
+		{"negative: admin key is not set", "", "Bearer ", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app.AdminKey = tt.adminKey
+			r := httptest.NewRequest(http.MethodDelete, "/api/v1/metrics", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			assert.Equal(t, tt.expectedStatusCode, w.Code)
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	store, err := auth.NewFileStore(filepath.Join(t.TempDir(), "tokens.json"))
+	require.NoError(t, err)
+	tokens := auth.New(store)
+	ctx := context.Background()
+	read, _, err := tokens.Issue(ctx, "reader", []string{auth.ScopeRead}, "", "")
+	require.NoError(t, err)
+	write, _, err := tokens.Issue(ctx, "agent", []string{auth.ScopeWrite}, "host1.", "")
+	require.NoError(t, err)
+	admin, _, err := tokens.Issue(ctx, "admin", []string{auth.ScopeAdmin}, "", "")
+	require.NoError(t, err)
+
+	a := config.AppConfig{AdminKey: "secret", Tokens: tokens}
+	NewMiddleware(&a)
+
+	var prefix string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prefix = auth.FromContext(r.Context()).MetricPrefix()
+	})
+
+	tests := []struct {
+		name               string
+		handler            http.Handler
+		target             string
+		authorization      string
+		expectedStatusCode int
+	}{
+		{"positive: read token", Authorize(auth.ScopeRead)(next), "/", "Bearer " + read, http.StatusOK},
+		{"negative: token in query is ignored", Authorize(auth.ScopeRead)(next), "/?access_token=" + read, "", http.StatusUnauthorized},
+		{"positive: admin token implies write", Authorize(auth.ScopeWrite)(next), "/update/", "Bearer " + admin, http.StatusOK},
+		{"positive: admin key", Authorize(auth.ScopeWrite)(next), "/update/", "Bearer secret", http.StatusOK},
+		{"positive: admin token for admin methods", Admin(next), "/api/v1/metrics", "Bearer " + admin, http.StatusOK},
+		{"negative: read token can not write", Authorize(auth.ScopeWrite)(next), "/update/", "Bearer " + read, http.StatusForbidden},
+		{"negative: write token can not administer", Admin(next), "/api/v1/metrics", "Bearer " + write, http.StatusForbidden},
+		{"negative: without token", Authorize(auth.ScopeRead)(next), "/", "", http.StatusUnauthorized},
+		{"negative: unknown token", Authorize(auth.ScopeRead)(next), "/", "Bearer " + read + "x", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, tt.target, nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			tt.handler.ServeHTTP(w, r)
+			assert.Equal(t, tt.expectedStatusCode, w.Code)
+			if w.Code == http.StatusUnauthorized {
+				assert.NotEmpty(t, w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+
+	// дашборд передает токен в cookie, изменения по cookie не принимаются
+	cookie := &http.Cookie{Name: tokenCookie, Value: read}
+	r := httptest.NewRequest(http.MethodGet, "/dashboard/events", nil)
+	r.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	Authorize(auth.ScopeRead)(next).ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	r = httptest.NewRequest(http.MethodPost, "/update/", nil)
+	r.AddCookie(&http.Cookie{Name: tokenCookie, Value: admin})
+	w = httptest.NewRecorder()
+	Authorize(auth.ScopeWrite)(next).ServeHTTP(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// браузер без токена отправляется на страницу входа
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept", "text/html,application/xhtml+xml")
+	w = httptest.NewRecorder()
+	Authorize(auth.ScopeRead)(next).ServeHTTP(w, r)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/dashboard/login", w.Header().Get("Location"))
+
+	// префикс токена доступен обработчику
+	r = httptest.NewRequest(http.MethodPost, "/update/", nil)
+	r.Header.Set("Authorization", "Bearer "+write)
+	Authorize(auth.ScopeWrite)(next).ServeHTTP(httptest.NewRecorder(), r)
+	assert.Equal(t, "host1.", prefix)
+
+	// без включенных токенов запросы не проверяются
+	NewMiddleware(&config.AppConfig{})
+	w = httptest.NewRecorder()
+	Authorize(auth.ScopeRead)(next).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
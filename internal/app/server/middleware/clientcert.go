@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/certs"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/inventory"
+)
+
+// ClientCert подставляет в заголовок X-Agent-ID идентификатор агента из проверенного сертификата клиента,
+// чтобы учет агентов и ограничение частоты запросов не доверяли заголовку, присланному клиентом.
+func ClientCert(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := certIdentity(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		r.Header.Set(inventory.HeaderAgentID, id)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// certIdentity возвращает идентификатор агента из сертификата клиента, проверенного по client_ca_file.
+func certIdentity(r *http.Request) (string, bool) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 || app.TLS.ClientCAFile == "" {
+		return "", false
+	}
+
+	return certs.Identity(r.TLS.PeerCertificates[0], app.TLS.Identities), true
+}
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/config"
+)
+
+func TestClientCert(t *testing.T) {
+	NewMiddleware(&config.AppConfig{TLS: config.TLSConfig{
+		ClientCAFile: "ca.pem",
+		Identities:   map[string]string{"host-1": "agent-1"},
+	}})
+	defer NewMiddleware(&config.AppConfig{})
+
+	var got string
+	handler := ClientCert(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("X-Agent-ID")
+	}))
+	do := func(state *tls.ConnectionState) {
+		r := httptest.NewRequest(http.MethodPost, "/updates/", nil)
+		r.Header.Set("X-Agent-ID", "claimed")
+		r.TLS = state
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	cert := func(cn string) *tls.ConnectionState {
+		return &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: cn}}}}
+	}
+
+	do(cert("host-1"))
+	assert.Equal(t, "agent-1", got)
+	do(cert("host-2"))
+	assert.Equal(t, "host-2", got)
+	// без сертификата клиента остается присланный заголовок
+	do(nil)
+	assert.Equal(t, "claimed", got)
+}
@@ -20,7 +20,7 @@ func Decrypt(next http.Handler) http.Handler {
 		b, err := io.ReadAll(r.Body)
 		if err != nil {
 			logger.Log.Errorf("failed ReadAll()=%v", err)
-			w.WriteHeader(http.StatusInternalServerError)
+			readFailed(w, err)
 			return
 		}
 
@@ -37,6 +37,18 @@ func (c *compressWriter) WriteHeader(statusCode int) {
 	c.w.WriteHeader(statusCode)
 }
 
+// Flush досылает сжатые данные клиенту, нужен для потоковых ответов.
+func (c *compressWriter) Flush() {
+	_ = c.zw.Flush()
+	// оригинальный http.ResponseWriter может быть сам обернут другим middleware
+	_ = http.NewResponseController(c.w).Flush()
+}
+
+// Unwrap возвращает оригинальный http.ResponseWriter для http.ResponseController.
+func (c *compressWriter) Unwrap() http.ResponseWriter {
+	return c.w
+}
+
 // Close закрывает gzip.Writer и досылает все данные из буфера.
 func (c *compressWriter) Close() error {
 	return c.zw.Close()
@@ -76,6 +88,12 @@ func Gzip(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		contentTypes := []string{"application/json", "text/html"}
 
+		// соединение WebSocket перехватывается обработчиком, сжатие HTTP к нему не применяется
+		if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		if !strings.Contains(fmt.Sprint(contentTypes), r.Header.Get("Content-Type")) {
 			next.ServeHTTP(w, r)
 			return
@@ -107,8 +125,12 @@ func Gzip(next http.Handler) http.Handler {
 				w.WriteHeader(http.StatusInternalServerError)
 				return
 			}
-			// меняем тело запроса на новое
+			// меняем тело запроса на новое; размер распакованных данных ограничен,
+			// чтобы небольшое сжатое тело не распаковалось в гигабайты
 			r.Body = cr
+			if app != nil && app.Limits.MaxDecompressedSize > 0 {
+				r.Body = http.MaxBytesReader(w, cr, app.Limits.MaxDecompressedSize)
+			}
 			defer cr.Close()
 		}
 
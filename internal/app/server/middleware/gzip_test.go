@@ -79,3 +79,20 @@ func TestGzip(t *testing.T) {
 	})
 
 }
+
+func TestGzipFlush(t *testing.T) {
+	w := httptest.NewRecorder()
+	cw := newCompressWriter(w)
+	_, err := cw.Write([]byte("event: metrics\n\n"))
+	require.NoError(t, err)
+	// ResponseController находит Flush у обертки и досылает данные без закрытия потока
+	require.NoError(t, http.NewResponseController(cw).Flush())
+	require.True(t, w.Flushed)
+
+	zr, err := gzip.NewReader(bytes.NewReader(w.Body.Bytes()))
+	require.NoError(t, err)
+	buf := make([]byte, 16)
+	_, err = io.ReadFull(zr, buf)
+	require.NoError(t, err)
+	require.Equal(t, "event: metrics\n\n", string(buf))
+}
@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/auth"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/config"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/logger"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/ratelimit"
+	"github.com/webkimru/go-yandex-metrics/internal/security"
+)
+
+var limiter *ratelimit.Limiter
+
+// NewRateLimiter включает ограничение частоты запросов клиента.
+func NewRateLimiter(l *ratelimit.Limiter) {
+	limiter = l
+}
+
+// RateLimit ограничивает частоту запросов клиента. Клиент определяется по настройке Limits.Key:
+// по адресу клиента, по идентификатору агента из проверенного сертификата клиента или по проверенному токену.
+// Присланным заголовкам X-Agent-ID и Authorization ключ не доверяет: без сертификата или с неверным токеном
+// клиентом считается адрес клиента с учетом доверенных прокси.
+func RateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if limiter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if app.Limits.Key == config.LimitByToken {
+			var err error
+			if r, err = verifyToken(r); err != nil {
+				logger.Log.Errorln("failed to check the token, Authenticate() =", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+
+		if ok, wait := limiter.Allow(clientKey(r), time.Now()); !ok {
+			logger.Log.Infof("rate limit exceeded for %s", r.RemoteAddr)
+			tooManyRequests(w, wait)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientKey возвращает ключ клиента для ограничения частоты запросов.
+func clientKey(r *http.Request) string {
+	switch app.Limits.Key {
+	case config.LimitByAgent:
+		if id, ok := certIdentity(r); ok {
+			return "agent:" + id
+		}
+	case config.LimitByToken:
+		if security.CheckBearer(r.Header.Get("Authorization"), app.AdminKey) {
+			return "token:admin"
+		}
+		// сам токен в памяти не хранится, только его идентификатор
+		if token := auth.FromContext(r.Context()); token != nil {
+			return "token:" + token.ID
+		}
+	}
+
+	return "ip:" + clientIP(r).String()
+}
+
+// tooManyRequests отвечает 429 с заголовком Retry-After.
+func tooManyRequests(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(ratelimit.RetryAfter(wait)))
+	w.WriteHeader(http.StatusTooManyRequests)
+}
+
+// BodyLimit ограничивает размер тела запроса настройкой Limits.MaxBodySize: запрос с большим
+// Content-Length отклоняется сразу, а чтение тела без Content-Length прерывается на превышении.
+func BodyLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.Limits.MaxBodySize <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.ContentLength > app.Limits.MaxBodySize {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, app.Limits.MaxBodySize)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// readFailed отвечает на ошибку чтения тела запроса: 413, если превышен размер тела, иначе 500.
+func readFailed(w http.ResponseWriter, err error) {
+	var maxBytes *http.MaxBytesError
+	if errors.As(err, &maxBytes) {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+	w.WriteHeader(http.StatusInternalServerError)
+}
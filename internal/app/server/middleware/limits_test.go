@@ -0,0 +1,170 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/auth"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/config"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/ratelimit"
+)
+
+func TestRateLimit(t *testing.T) {
+	handler := RateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer NewRateLimiter(nil)
+	defer NewMiddleware(&config.AppConfig{})
+
+	tokens, err := auth.NewFileStore(filepath.Join(t.TempDir(), "tokens.json"))
+	require.NoError(t, err)
+	a := auth.New(tokens)
+	host1, _, err := a.Issue(context.Background(), "host1", []string{auth.ScopeWrite}, "", "")
+	require.NoError(t, err)
+	host2, _, err := a.Issue(context.Background(), "host2", []string{auth.ScopeWrite}, "", "")
+	require.NoError(t, err)
+	cert := func(cn string) *tls.ConnectionState {
+		return &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: cn}}}}
+	}
+
+	tests := []struct {
+		name   string
+		key    string
+		first  func(r *http.Request)
+		second func(r *http.Request)
+		// separate у второго клиента своя корзина
+		separate bool
+	}{
+		{
+			name:     "ip",
+			key:      config.LimitByIP,
+			first:    func(r *http.Request) { r.RemoteAddr = "10.0.0.1:1234" },
+			second:   func(r *http.Request) { r.RemoteAddr = "10.0.0.2:1234" },
+			separate: true,
+		},
+		{
+			name:     "agent from certificate",
+			key:      config.LimitByAgent,
+			first:    func(r *http.Request) { r.TLS = cert("host1") },
+			second:   func(r *http.Request) { r.TLS = cert("host2") },
+			separate: true,
+		},
+		{
+			name:   "claimed agent",
+			key:    config.LimitByAgent,
+			first:  func(r *http.Request) { r.Header.Set("X-Agent-ID", "host1") },
+			second: func(r *http.Request) { r.Header.Set("X-Agent-ID", "host2") },
+		},
+		{
+			name:     "token",
+			key:      config.LimitByToken,
+			first:    func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+host1) },
+			second:   func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+host2) },
+			separate: true,
+		},
+		{
+			name:   "invalid token",
+			key:    config.LimitByToken,
+			first:  func(r *http.Request) { r.Header.Set("Authorization", "Bearer a") },
+			second: func(r *http.Request) { r.Header.Set("Authorization", "Bearer b") },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			NewMiddleware(&config.AppConfig{
+				Limits: config.LimitsConfig{Key: tt.key},
+				TLS:    config.TLSConfig{ClientCAFile: "ca.pem"},
+				Tokens: a,
+			})
+			NewRateLimiter(ratelimit.New(1, 1))
+			do := func(prepare func(r *http.Request)) *httptest.ResponseRecorder {
+				r := httptest.NewRequest(http.MethodPost, "/updates/", nil)
+				prepare(r)
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, r)
+				return w
+			}
+
+			assert.Equal(t, http.StatusOK, do(tt.first).Code)
+			w := do(tt.first)
+			assert.Equal(t, http.StatusTooManyRequests, w.Code)
+			assert.Equal(t, "1", w.Header().Get("Retry-After"))
+			// другой проверенный клиент расходует свою корзину, а смена присланного заголовка не помогает
+			if tt.separate {
+				assert.Equal(t, http.StatusOK, do(tt.second).Code)
+			} else {
+				assert.Equal(t, http.StatusTooManyRequests, do(tt.second).Code)
+			}
+		})
+	}
+}
+
+func TestBodyLimit(t *testing.T) {
+	NewMiddleware(&config.AppConfig{Limits: config.LimitsConfig{MaxBodySize: 16, MaxDecompressedSize: 64}})
+	defer NewMiddleware(&config.AppConfig{})
+
+	var read int
+	handler := BodyLimit(Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		read = len(b)
+		if err != nil {
+			readFailed(w, err)
+		}
+	})))
+	do := func(body io.Reader, contentLength int64, compressed bool) int {
+		read = 0
+		r := httptest.NewRequest(http.MethodPost, "/updates/", body)
+		r.ContentLength = contentLength
+		r.Header.Set("Content-Type", "application/json")
+		if compressed {
+			r.Header.Set("Content-Encoding", "gzip")
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+	gzipped := func(data string) *bytes.Buffer {
+		buf := bytes.NewBuffer(nil)
+		zw := gzip.NewWriter(buf)
+		_, err := zw.Write([]byte(data))
+		require.NoError(t, err)
+		require.NoError(t, zw.Close())
+		return buf
+	}
+
+	t.Run("small body", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, do(strings.NewReader(`[]`), 2, false))
+		assert.Equal(t, 2, read)
+	})
+	t.Run("large content length", func(t *testing.T) {
+		assert.Equal(t, http.StatusRequestEntityTooLarge, do(strings.NewReader(strings.Repeat("1", 32)), 32, false))
+		assert.Zero(t, read)
+	})
+	t.Run("large body without content length", func(t *testing.T) {
+		assert.Equal(t, http.StatusRequestEntityTooLarge, do(strings.NewReader(strings.Repeat("1", 32)), -1, false))
+	})
+	t.Run("gzip within limits", func(t *testing.T) {
+		NewMiddleware(&config.AppConfig{Limits: config.LimitsConfig{MaxBodySize: 64, MaxDecompressedSize: 64}})
+		body := gzipped(strings.Repeat("1", 32))
+		require.LessOrEqual(t, body.Len(), 64)
+		assert.Equal(t, http.StatusOK, do(body, int64(body.Len()), true))
+		assert.Equal(t, 32, read)
+	})
+	t.Run("gzip bomb", func(t *testing.T) {
+		body := gzipped(strings.Repeat("1", 1<<20))
+		require.LessOrEqual(t, body.Len(), 64<<10)
+		NewMiddleware(&config.AppConfig{Limits: config.LimitsConfig{MaxBodySize: 64 << 10, MaxDecompressedSize: 64}})
+		assert.Equal(t, http.StatusRequestEntityTooLarge, do(body, int64(body.Len()), true))
+		assert.LessOrEqual(t, read, 64)
+	})
+}
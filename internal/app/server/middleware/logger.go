@@ -1,11 +1,17 @@
 package middleware
 
 import (
+	"bufio"
 	"github.com/webkimru/go-yandex-metrics/internal/app/server/logger"
+	"net"
 	"net/http"
+	"net/url"
 	"time"
 )
 
+// secretParams параметры запроса, значения которых не попадают в журнал, например токены из старых ссылок.
+var secretParams = []string{"access_token", "token", "key"}
+
 type (
 	// берём структуру для хранения сведений об ответе
 	responseData struct {
@@ -33,6 +39,17 @@ func (r *loggingResponseWriter) WriteHeader(statusCode int) {
 	r.responseData.status = statusCode // захватываем код статуса
 }
 
+// Unwrap возвращает оригинальный http.ResponseWriter для http.ResponseController.
+func (r *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Hijack передает соединение обработчику WebSocket.
+func (r *loggingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	r.responseData.status = http.StatusSwitchingProtocols
+	return http.NewResponseController(r.ResponseWriter).Hijack()
+}
+
 func WithLogging(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
@@ -50,7 +67,7 @@ func WithLogging(next http.Handler) http.Handler {
 		duration := time.Since(start)
 
 		logger.Log.Infoln(
-			"uri", r.RequestURI,
+			"uri", redactURI(r.URL),
 			"method", r.Method,
 			"status", responseData.status, // получаем перехваченный код статуса ответа
 			"duration", duration,
@@ -58,3 +75,23 @@ func WithLogging(next http.Handler) http.Handler {
 		)
 	})
 }
+
+// redactURI возвращает путь и параметры запроса для журнала со скрытыми значениями secretParams.
+func redactURI(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.RequestURI()
+	}
+	query := u.Query()
+	redacted := false
+	for _, name := range secretParams {
+		if query.Has(name) {
+			query.Set(name, "REDACTED")
+			redacted = true
+		}
+	}
+	if !redacted {
+		return u.RequestURI()
+	}
+
+	return u.EscapedPath() + "?" + query.Encode()
+}
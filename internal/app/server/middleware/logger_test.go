@@ -4,7 +4,10 @@ import (
 	"golang.org/x/net/context"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestWithLogging(t *testing.T) {
@@ -14,3 +17,17 @@ func TestWithLogging(t *testing.T) {
 	h := WithLogging(testHandler)
 	h.ServeHTTP(w, req)
 }
+
+func TestRedactURI(t *testing.T) {
+	tests := map[string]string{
+		"/":                                  "/",
+		"/?q=alloc":                          "/?q=alloc",
+		"/?access_token=secret&q=alloc":      "/?access_token=REDACTED&q=alloc",
+		"/dashboard/events?access_token=abc": "/dashboard/events?access_token=REDACTED",
+	}
+	for uri, want := range tests {
+		u, err := url.ParseRequestURI(uri)
+		assert.NoError(t, err)
+		assert.Equal(t, want, redactURI(u))
+	}
+}
@@ -1,9 +1,16 @@
 package middleware
 
-import "github.com/webkimru/go-yandex-metrics/internal/app/server/config"
+import (
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/config"
+	"github.com/webkimru/go-yandex-metrics/internal/security"
+)
 
 var app *config.AppConfig
 
+// nonces nonce подписанных запросов для защиты от повтора.
+var nonces *security.NonceCache
+
 func NewMiddleware(a *config.AppConfig) {
 	app = a
+	nonces = security.NewNonceCache(2 * maxSkew())
 }
@@ -2,57 +2,215 @@ package middleware
 
 import (
 	"bytes"
-	"crypto/hmac"
-	"crypto/sha256"
 	"encoding/hex"
-	"github.com/webkimru/go-yandex-metrics/internal/app/server/logger"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/logger"
+	"github.com/webkimru/go-yandex-metrics/internal/security"
 )
 
-// WithSign проверяем полученный и вычесленный хеш
+// defaultMaxSkew допустимое расхождение времени подписи, если оно не задано в настройках.
+const defaultMaxSkew = 5 * time.Minute
+
+// signWriter копит ответ, чтобы передать подпись тела в заголовке до самого тела.
+// Потоковый ответ, который сбрасывается клиенту по частям, отдается без подписи.
+type signWriter struct {
+	w         http.ResponseWriter
+	key       []byte
+	status    int
+	buf       bytes.Buffer
+	streaming bool
+}
+
+func (s *signWriter) Header() http.Header {
+	return s.w.Header()
+}
+
+func (s *signWriter) WriteHeader(statusCode int) {
+	if s.streaming {
+		s.w.WriteHeader(statusCode)
+		return
+	}
+	if s.status == 0 {
+		s.status = statusCode
+	}
+}
+
+func (s *signWriter) Write(p []byte) (int, error) {
+	if s.streaming {
+		return s.w.Write(p)
+	}
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.buf.Write(p)
+}
+
+// Flush переключает ответ в потоковый режим: накопленное отдается клиенту без подписи.
+func (s *signWriter) Flush() {
+	if !s.streaming {
+		s.streaming = true
+		s.w.Header().Del(security.HeaderSignature)
+		s.send()
+	}
+	_ = http.NewResponseController(s.w).Flush()
+}
+
+// Unwrap возвращает оригинальный http.ResponseWriter для http.ResponseController.
+func (s *signWriter) Unwrap() http.ResponseWriter {
+	return s.w
+}
+
+// Close подписывает накопленное тело и отдает ответ клиенту.
+func (s *signWriter) Close() {
+	if s.streaming {
+		return
+	}
+	s.w.Header().Set(security.HeaderSignature, security.SignBody(s.key, s.buf.Bytes()))
+	s.send()
+}
+
+func (s *signWriter) send() {
+	if s.status != 0 {
+		s.w.WriteHeader(s.status)
+	}
+	if s.buf.Len() > 0 {
+		if _, err := s.w.Write(s.buf.Bytes()); err != nil {
+			logger.Log.Errorln("failed to write the response, Write()=", err)
+		}
+		s.buf.Reset()
+	}
+}
+
+// WithSign проверяет подпись запроса и подписывает тело ответа.
+// Подпись HMAC-SHA256 в заголовке HashSHA256 охватывает метод, путь, sha256 тела, время X-Timestamp и X-Nonce;
+// ключ выбирается по заголовку KeyID, без него используется ключ KEY. Запрос с устаревшим временем
+// или повторным nonce отклоняется. Запрос, изменяющий данные, без подписи отклоняется, если прием
+// неподписанных запросов не разрешен явно; GET и HEAD дашборда браузер подписать не может.
+// Ответ подписывается тем же ключом, что и запрос.
 func WithSign(next http.Handler) http.Handler {
 	// получаем Handler приведением типа http.HandlerFunc
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if app.SecretKey == "" {
+		if app.SecretKey == "" && len(app.Signing.Keys) == 0 {
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		// При наличии ключа на этапе формирования ответа сервер должен вычислять хеш
-		// и передавать его в HTTP-заголовке ответа с именем `HashSHA256`
-		b, err := io.ReadAll(r.Body)
-		if err != nil {
-			logger.Log.Errorln("failed to read body, ReadAll()=", err)
-			w.WriteHeader(http.StatusInternalServerError)
+		keyID := r.Header.Get(security.HeaderKeyID)
+		key, ok := signingKey(keyID)
+		if !ok {
+			logger.Log.Infof("unknown signing key id=%q", keyID)
+			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
-		r.Body = io.NopCloser(bytes.NewReader(b))
-		h := hmac.New(sha256.New, []byte(app.SecretKey))
-		h.Write(b)
-		sign := h.Sum(nil)
-		w.Header().Set("HashSHA256", hex.EncodeToString(sign))
 
-		//  При наличии ключа во время обработки запроса сервер должен проверять
-		//  соответствие полученного и вычисленного хеша.
-		receivedHash := r.Header.Get("HashSHA256")
-		if receivedHash == "" {
-			next.ServeHTTP(w, r)
+		receivedHash := r.Header.Get(security.HeaderSignature)
+		if receivedHash == "" && !app.Signing.AllowUnsigned && !safeMethod(r.Method) {
+			logger.Log.Infoln("Request without sign")
+			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
-		hash, err := hex.DecodeString(receivedHash)
-		if err != nil {
-			logger.Log.Errorln("failed to decode, DecodeString()=", err)
-			w.WriteHeader(http.StatusInternalServerError)
+		if receivedHash != "" {
+			b, err := io.ReadAll(r.Body)
+			if err != nil {
+				logger.Log.Errorln("failed to read body, ReadAll()=", err)
+				readFailed(w, err)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(b))
+
+			if status := checkRequestSign(r, key, receivedHash, b); status != http.StatusOK {
+				w.WriteHeader(status)
+				return
+			}
+		}
+
+		// WebSocket перехватывает соединение, подписывать нечего
+		if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			next.ServeHTTP(w, r)
 			return
 		}
-		// При несовпадении сервер должен отбрасывать полученные данные и возвращать `http.StatusBadRequest`.
-		if !hmac.Equal(sign, hash) {
-			logger.Log.Infoln("Wrong sign")
-			w.WriteHeader(http.StatusBadRequest)
+		// без ключа KEY ответ на неподписанный запрос подписать нечем
+		if len(key) == 0 {
+			next.ServeHTTP(w, r)
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		if keyID != "" {
+			w.Header().Set(security.HeaderKeyID, keyID)
+		}
+		sw := &signWriter{w: w, key: key}
+		defer sw.Close()
+
+		next.ServeHTTP(sw, r)
 	})
 }
+
+// safeMethod сообщает, что запрос только читает данные.
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead
+}
+
+// checkRequestSign проверяет подпись, время и nonce запроса и возвращает код ответа.
+func checkRequestSign(r *http.Request, key []byte, receivedHash string, body []byte) int {
+	if len(key) == 0 {
+		logger.Log.Infoln("Request is signed, but there is no default key")
+		return http.StatusBadRequest
+	}
+	if _, err := hex.DecodeString(receivedHash); err != nil {
+		logger.Log.Errorln("failed to decode, DecodeString()=", err)
+		return http.StatusInternalServerError
+	}
+
+	timestamp := r.Header.Get(security.HeaderTimestamp)
+	nonce := r.Header.Get(security.HeaderNonce)
+	if timestamp == "" || nonce == "" {
+		logger.Log.Infoln("Sign without timestamp or nonce")
+		return http.StatusBadRequest
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		logger.Log.Infoln("Wrong sign timestamp", timestamp)
+		return http.StatusBadRequest
+	}
+	now := time.Now()
+	if skew := now.Sub(time.Unix(ts, 0)); skew > maxSkew() || skew < -maxSkew() {
+		logger.Log.Infoln("Expired sign, timestamp", timestamp)
+		return http.StatusBadRequest
+	}
+
+	// При несовпадении сервер должен отбрасывать полученные данные и возвращать `http.StatusBadRequest`.
+	expected := security.SignRequest(key, r.Method, r.URL.RequestURI(), body, timestamp, nonce)
+	if !security.CheckSignature(receivedHash, expected) {
+		logger.Log.Infoln("Wrong sign")
+		return http.StatusBadRequest
+	}
+	// nonce запоминается только после проверки подписи, иначе чужие запросы занимали бы кэш
+	if !nonces.Use(r.Header.Get(security.HeaderKeyID)+":"+nonce, now) {
+		logger.Log.Infoln("Replayed request, nonce", nonce)
+		return http.StatusBadRequest
+	}
+
+	return http.StatusOK
+}
+
+// signingKey возвращает ключ подписи по KeyID: пустой KeyID - ключ KEY.
+func signingKey(keyID string) ([]byte, bool) {
+	if keyID == "" {
+		return []byte(app.SecretKey), true
+	}
+	key, ok := app.Signing.Keys[keyID]
+	return []byte(key), ok
+}
+
+// maxSkew возвращает допустимое расхождение времени подписи.
+func maxSkew() time.Duration {
+	if app == nil || app.Signing.MaxSkew <= 0 {
+		return defaultMaxSkew
+	}
+	return time.Duration(app.Signing.MaxSkew) * time.Second
+}
@@ -2,16 +2,16 @@ package middleware
 
 import (
 	"bytes"
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/hex"
 	"errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/webkimru/go-yandex-metrics/internal/app/server/config"
+	"github.com/webkimru/go-yandex-metrics/internal/security"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func TestWithSign(t *testing.T) {
@@ -32,10 +32,10 @@ func TestWithSign(t *testing.T) {
 		assert.NoError(t, err)
 
 		r.Body = io.NopCloser(bytes.NewReader(b))
-		h := hmac.New(sha256.New, []byte(app.SecretKey))
-		h.Write(b)
-		sign := h.Sum(nil)
-		r.Header.Set("HashSHA256", hex.EncodeToString(sign))
+		timestamp := security.Timestamp(time.Now())
+		r.Header.Set(security.HeaderTimestamp, timestamp)
+		r.Header.Set(security.HeaderNonce, "valid")
+		r.Header.Set("HashSHA256", security.SignRequest([]byte(app.SecretKey), "POST", "/", b, timestamp, "valid"))
 
 		resp, err := http.DefaultClient.Do(r)
 		assert.NoError(t, err)
@@ -70,6 +70,7 @@ func TestWithSign(t *testing.T) {
 		r.Header.Set("HashSHA256", "")
 		resp, err := http.DefaultClient.Do(r)
 		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
 		defer resp.Body.Close()
 	})
 
@@ -110,6 +111,104 @@ func TestWithSignWithBadReader(t *testing.T) {
 
 }
 
+func TestWithSignReplay(t *testing.T) {
+	NewMiddleware(&config.AppConfig{
+		SecretKey: "old",
+		Signing:   config.SigningConfig{Keys: map[string]string{"2024": "new"}, MaxSkew: 60},
+	})
+	defer NewMiddleware(&config.AppConfig{})
+
+	handler := WithSign(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"status":"ok"}`))
+	}))
+	body := []byte(`[{"id":"cpu","type":"gauge","value":1}]`)
+	do := func(prepare func(r *http.Request)) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(body))
+		prepare(r)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	sign := func(keyID, key, method, uri string, ts time.Time, nonce string) func(r *http.Request) {
+		return func(r *http.Request) {
+			timestamp := security.Timestamp(ts)
+			if keyID != "" {
+				r.Header.Set(security.HeaderKeyID, keyID)
+			}
+			r.Header.Set(security.HeaderTimestamp, timestamp)
+			r.Header.Set(security.HeaderNonce, nonce)
+			r.Header.Set(security.HeaderSignature, security.SignRequest([]byte(key), method, uri, body, timestamp, nonce))
+		}
+	}
+	now := time.Now()
+
+	t.Run("response signature", func(t *testing.T) {
+		w := do(sign("", "old", http.MethodPost, "/updates/", now, "n1"))
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, `{"status":"ok"}`, w.Body.String())
+		assert.Equal(t, security.SignBody([]byte("old"), w.Body.Bytes()), w.Header().Get(security.HeaderSignature))
+	})
+	t.Run("replay", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, do(sign("", "old", http.MethodPost, "/updates/", now, "n1")).Code)
+	})
+	t.Run("rotated key", func(t *testing.T) {
+		w := do(sign("2024", "new", http.MethodPost, "/updates/", now, "n1"))
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "2024", w.Header().Get(security.HeaderKeyID))
+		assert.Equal(t, security.SignBody([]byte("new"), w.Body.Bytes()), w.Header().Get(security.HeaderSignature))
+	})
+
+	tests := []struct {
+		name    string
+		prepare func(r *http.Request)
+	}{
+		{"wrong key for key id", sign("2024", "old", http.MethodPost, "/updates/", now, "n2")},
+		{"unknown key id", sign("2023", "old", http.MethodPost, "/updates/", now, "n3")},
+		{"another path", sign("", "old", http.MethodPost, "/update/", now, "n4")},
+		{"another method", sign("", "old", http.MethodPut, "/updates/", now, "n5")},
+		{"expired", sign("", "old", http.MethodPost, "/updates/", now.Add(-2*time.Minute), "n6")},
+		{"from the future", sign("", "old", http.MethodPost, "/updates/", now.Add(2*time.Minute), "n7")},
+		{"without nonce", sign("", "old", http.MethodPost, "/updates/", now, "")},
+		{"without timestamp", func(r *http.Request) {
+			sign("", "old", http.MethodPost, "/updates/", now, "n8")(r)
+			r.Header.Del(security.HeaderTimestamp)
+		}},
+		{"body only signature", func(r *http.Request) {
+			r.Header.Set(security.HeaderSignature, security.SignBody([]byte("old"), body))
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, http.StatusBadRequest, do(tt.prepare).Code)
+		})
+	}
+
+	t.Run("unsigned", func(t *testing.T) {
+		// без подписи, времени и nonce запрос отклоняется: повтор нельзя выдать за неподписанный запрос
+		assert.Equal(t, http.StatusBadRequest, do(func(*http.Request) {}).Code)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		app.Signing.AllowUnsigned = true
+		defer func() { app.Signing.AllowUnsigned = false }()
+		assert.Equal(t, http.StatusOK, do(func(*http.Request) {}).Code)
+	})
+
+	t.Run("streaming response is not signed", func(t *testing.T) {
+		handler := WithSign(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("data: 1\n\n"))
+			_ = http.NewResponseController(w).Flush()
+			_, _ = w.Write([]byte("data: 2\n\n"))
+		}))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/stream", nil))
+		assert.Equal(t, "data: 1\n\ndata: 2\n\n", w.Body.String())
+		assert.Empty(t, w.Header().Get(security.HeaderSignature))
+		assert.True(t, w.Flushed)
+	})
+}
+
 type errReader int
 
 func (errReader) Read(p []byte) (n int, err error) {
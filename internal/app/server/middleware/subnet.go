@@ -1,28 +1,25 @@
 package middleware
 
 import (
-	"github.com/webkimru/go-yandex-metrics/internal/app/server/logger"
-	"net"
 	"net/http"
+	"net/netip"
+
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/logger"
 )
 
-// TrustedSubnet allow requests by client IP from trusted subnet
+// TrustedSubnet допускает запросы только с адресов из разрешенных и не из запрещенных подсетей.
+// Адрес клиента берется из соединения, а X-Forwarded-For и X-Real-IP учитываются,
+// только если запрос пришел от доверенного прокси.
 func TrustedSubnet(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if app.TrustedSubnet == "" {
+		if app.SubnetPolicy == nil {
 			next.ServeHTTP(w, r)
 			return
 		}
-		_, subnet, err := net.ParseCIDR(app.TrustedSubnet)
-		if err != nil {
-			logger.Log.Errorln("failed ParseCIDR()=", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		ip := net.ParseIP(r.Header.Get("X-Real-IP"))
 
-		if !subnet.Contains(ip) {
+		ip := clientIP(r)
+		if !app.SubnetPolicy.Allowed(ip) {
+			logger.Log.Infof("request from %s (%s) is forbidden by subnets", ip, r.RemoteAddr)
 			w.WriteHeader(http.StatusForbidden)
 			return
 		}
@@ -30,3 +27,8 @@ func TrustedSubnet(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// clientIP возвращает адрес клиента с учетом доверенных прокси.
+func clientIP(r *http.Request) netip.Addr {
+	return app.SubnetPolicy.ClientIP(r.RemoteAddr, r.Header.Values("X-Forwarded-For"), r.Header.Get("X-Real-IP"))
+}
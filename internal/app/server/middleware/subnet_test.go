@@ -1,9 +1,10 @@
 package middleware
 
 import (
-	"bytes"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/webkimru/go-yandex-metrics/internal/app/server/config"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/subnet"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -15,34 +16,55 @@ func TestTrustedSubnet(t *testing.T) {
 	NewMiddleware(app)
 
 	handler := TrustedSubnet(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
-	srv := httptest.NewServer(handler)
-	defer srv.Close()
 
 	tests := []struct {
 		name               string
-		trustedSubnet      string
+		allow              []string
+		deny               []string
+		proxies            []string
+		remoteAddr         string
+		forwardedFor       string
 		realIP             string
 		expectedStatusCode int
 	}{
-		{"positive: valid ip", "127.0.0.0/8", "127.0.0.1", http.StatusOK},
-		{"negative: invalid ip", "192.168.1.0/32", "192.168.0.1", http.StatusForbidden},
-		{"positive: without subnet", "", "", http.StatusOK},
-		{"negative: invalid subnet", "none", "", http.StatusInternalServerError},
+		{"positive: valid ip", []string{"127.0.0.0/8"}, nil, nil, "127.0.0.1:1234", "", "", http.StatusOK},
+		{"negative: invalid ip", []string{"192.168.1.0/32"}, nil, nil, "192.168.0.1:1234", "", "", http.StatusForbidden},
+		{"positive: without subnet", nil, nil, nil, "192.168.0.1:1234", "", "", http.StatusOK},
+		{"positive: one of subnets", []string{"10.0.0.0/8", "192.168.0.0/16"}, nil, nil, "192.168.0.1:1234", "", "", http.StatusOK},
+		{"positive: ipv6", []string{"2001:db8::/32"}, nil, nil, "[2001:db8::1]:1234", "", "", http.StatusOK},
+		{"negative: ipv6", []string{"2001:db8::/32"}, nil, nil, "[2001:db9::1]:1234", "", "", http.StatusForbidden},
+		{"positive: ipv4-mapped ipv6", []string{"10.0.0.0/8"}, nil, nil, "[::ffff:10.0.0.1]:1234", "", "", http.StatusOK},
+		{"negative: denied inside allowed", []string{"10.0.0.0/8"}, []string{"10.0.1.0/24"}, nil, "10.0.1.5:1234", "", "", http.StatusForbidden},
+		{"negative: denied without allowed", nil, []string{"10.0.1.0/24"}, nil, "10.0.1.5:1234", "", "", http.StatusForbidden},
+		{"negative: spoofed X-Real-IP", []string{"127.0.0.0/8"}, nil, nil, "192.168.0.1:1234", "", "127.0.0.1", http.StatusForbidden},
+		{"negative: spoofed X-Forwarded-For", []string{"127.0.0.0/8"}, nil, nil, "192.168.0.1:1234", "127.0.0.1", "", http.StatusForbidden},
+		{"positive: X-Real-IP from trusted proxy", []string{"127.0.0.0/8"}, nil, []string{"192.168.0.1"}, "192.168.0.1:1234", "", "127.0.0.1", http.StatusOK},
+		{"positive: X-Forwarded-For from trusted proxy", []string{"127.0.0.0/8"}, nil, []string{"192.168.0.0/24"}, "192.168.0.1:1234", "127.0.0.1, 192.168.0.2", "", http.StatusOK},
+		{"negative: X-Forwarded-For with untrusted hop", []string{"127.0.0.0/8"}, nil, []string{"192.168.0.0/24"}, "192.168.0.1:1234", "127.0.0.1, 10.0.0.1", "", http.StatusForbidden},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			app.TrustedSubnet = tt.trustedSubnet
-			r := httptest.NewRequest("POST", srv.URL, bytes.NewReader([]byte("")))
+			policy, err := subnet.New(tt.allow, tt.deny, tt.proxies)
+			require.NoError(t, err)
+			app.SubnetPolicy = policy
+			r := httptest.NewRequest("POST", "/updates/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwardedFor != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			}
 			if tt.realIP != "" {
 				r.Header.Set("X-Real-IP", tt.realIP)
 			}
-			r.RequestURI = ""
 
-			resp, err := http.DefaultClient.Do(r)
-			assert.NoError(t, err)
-			assert.Equal(t, tt.expectedStatusCode, resp.StatusCode)
-			defer resp.Body.Close()
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			assert.Equal(t, tt.expectedStatusCode, w.Code)
 		})
 	}
+
+	t.Run("negative: invalid subnet", func(t *testing.T) {
+		_, err := subnet.New([]string{"none"}, nil, nil)
+		assert.Error(t, err)
+	})
 }
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/auth"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/logger"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/tenant"
+)
+
+var tenants *tenant.Store
+
+// NewTenants включает изоляцию тенантов.
+func NewTenants(t *tenant.Store) {
+	tenants = t
+}
+
+// Tenant определяет тенанта запроса и проверяет его ограничение частоты запросов.
+// Тенант берется из токена, выданного для тенанта, иначе из заголовка X-Tenant-ID, если такой тенант
+// разрешен или уже создан, иначе используется тенант по умолчанию. Без включенной изоляции тенантов
+// все запросы относятся к тенанту по умолчанию.
+func Tenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tenants == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// токен проверяется заранее, чтобы узнать его тенанта; неверный токен отклонит Authorize
+		r, err := verifyToken(r)
+		if err != nil {
+			logger.Log.Errorln("failed to check the token, Authenticate() =", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		ctx := r.Context()
+
+		name, err := tenants.Resolve(r.Header.Get(tenant.HeaderTenantID), auth.FromContext(ctx).TenantName())
+		if errors.Is(err, tenant.ErrForbidden) || errors.Is(err, tenant.ErrUnknownTenant) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if ok, wait := tenants.Allow(name, time.Now()); !ok {
+			tooManyRequests(w, wait)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(tenant.WithTenant(ctx, name)))
+	})
+}
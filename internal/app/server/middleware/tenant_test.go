@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/auth"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/config"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories/store"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/tenant"
+)
+
+func TestTenant(t *testing.T) {
+	tokenStore, err := auth.NewFileStore(filepath.Join(t.TempDir(), "tokens.json"))
+	require.NoError(t, err)
+	tokens := auth.New(tokenStore)
+	ctx := context.Background()
+	teamA, _, err := tokens.Issue(ctx, "team a agent", []string{auth.ScopeRead, auth.ScopeWrite}, "", "team_a")
+	require.NoError(t, err)
+	shared, _, err := tokens.Issue(ctx, "shared", []string{auth.ScopeRead}, "", "")
+	require.NoError(t, err)
+
+	NewMiddleware(&config.AppConfig{AdminKey: "secret", Tokens: tokens})
+	defer NewMiddleware(&config.AppConfig{})
+	NewTenants(tenant.NewStore(store.NewMemStorage(), func(context.Context, string) (repositories.StoreRepository, error) {
+		return store.NewMemStorage(), nil
+	}, config.TenantsConfig{
+		Enabled:   true,
+		Allowed:   []string{"team_b", "team_c", "limited"},
+		Overrides: map[string]config.TenantLimits{"limited": {Rate: 1, Burst: 1}},
+	}))
+	defer NewTenants(nil)
+
+	var got string
+	handler := Tenant(Authorize(auth.ScopeRead)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = tenant.FromContext(r.Context())
+	})))
+
+	tests := []struct {
+		name               string
+		authorization      string
+		header             string
+		expectedStatusCode int
+		expectedTenant     string
+	}{
+		{"positive: tenant from token", "Bearer " + teamA, "", http.StatusOK, "team_a"},
+		{"positive: same tenant in header", "Bearer " + teamA, "team_a", http.StatusOK, "team_a"},
+		{"positive: shared token selects tenant by header", "Bearer " + shared, "team_b", http.StatusOK, "team_b"},
+		{"positive: admin key selects tenant by header", "Bearer secret", "team_c", http.StatusOK, "team_c"},
+		{"positive: default tenant", "Bearer " + shared, "", http.StatusOK, tenant.Default},
+		{"negative: token of another tenant", "Bearer " + teamA, "team_b", http.StatusForbidden, ""},
+		{"negative: invalid tenant", "Bearer secret", "Team B", http.StatusBadRequest, ""},
+		{"negative: unknown tenant is not created by header", "Bearer secret", "team_d", http.StatusForbidden, ""},
+		{"negative: invalid token", "Bearer " + teamA + "x", "", http.StatusUnauthorized, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = ""
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Authorization", tt.authorization)
+			if tt.header != "" {
+				r.Header.Set(tenant.HeaderTenantID, tt.header)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			assert.Equal(t, tt.expectedStatusCode, w.Code)
+			assert.Equal(t, tt.expectedTenant, got)
+		})
+	}
+
+	t.Run("rate limit", func(t *testing.T) {
+		do := func() *httptest.ResponseRecorder {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Authorization", "Bearer secret")
+			r.Header.Set(tenant.HeaderTenantID, "limited")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			return w
+		}
+		assert.Equal(t, http.StatusOK, do().Code)
+		w := do()
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	})
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories/store/pg"
+)
+
+// Migrate выполняет подкоманду migrate без запуска сервера:
+//
+//	migrate [-d dsn] [-to version] [up]    применяет миграции до последней версии или до -to
+//	migrate [-d dsn] [-to version] down    откатывает последнюю миграцию или до версии -to
+//	migrate [-d dsn] version               выводит версии схем
+//
+// Мигрируются схема метрик и существующие схемы тенантов.
+func Migrate(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	databaseDSN := flags.String("d", "", "database dsn")
+	to := flags.Int("to", -1, "target schema version")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if envDatabaseDSN := os.Getenv("DATABASE_DSN"); envDatabaseDSN != "" {
+		databaseDSN = &envDatabaseDSN
+	}
+	if *databaseDSN == "" {
+		return errors.New("migrate requires database dsn: -d or DATABASE_DSN")
+	}
+	command := flags.Arg(0)
+	if command == "" {
+		command = "up"
+	}
+	switch command {
+	case "up", "down", "version":
+	default:
+		return fmt.Errorf("unknown migrate command %q: expected up, down or version", command)
+	}
+
+	db, err := pg.OpenDB(*databaseDSN, pg.Schema)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	schemas, err := pg.Schemas(ctx, db)
+	if err != nil {
+		return err
+	}
+	migrations, err := pg.Migrations()
+	if err != nil {
+		return err
+	}
+
+	for _, schema := range schemas {
+		version, err := pg.Version(ctx, db, schema)
+		if err != nil {
+			return err
+		}
+
+		target := *to
+		switch command {
+		case "version":
+			fmt.Println(schema, version)
+			continue
+		case "up":
+			if target < 0 {
+				target = pg.Latest
+			}
+		case "down":
+			// по умолчанию откатывается одна последняя миграция
+			if target < 0 {
+				target = 0
+				for _, m := range migrations {
+					if m.Version < version {
+						target = m.Version
+					}
+				}
+			}
+		}
+
+		if err := pg.Migrate(ctx, db, schema, target); err != nil {
+			return err
+		}
+		if version, err = pg.Version(ctx, db, schema); err != nil {
+			return err
+		}
+		fmt.Println(schema, version)
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+)
+
+// BatchMode режим применения батча метрик.
+type BatchMode string
+
+const (
+	// BatchTransactional батч применяется целиком или не применяется совсем.
+	BatchTransactional BatchMode = "transactional"
+	// BatchBestEffort применяются все корректные метрики, остальные отклоняются.
+	BatchBestEffort BatchMode = "best_effort"
+)
+
+// ParseBatchMode разбирает режим применения батча, пустое значение - транзакционный режим.
+func ParseBatchMode(s string) (BatchMode, error) {
+	switch BatchMode(s) {
+	case "", BatchTransactional:
+		return BatchTransactional, nil
+	case BatchBestEffort:
+		return BatchBestEffort, nil
+	}
+	return "", fmt.Errorf("unknown batch mode=%q, expected %s or %s", s, BatchTransactional, BatchBestEffort)
+}
+
+// MaxBatchIDLength максимальная длина идентификатора батча.
+const MaxBatchIDLength = 64
+
+// Batch батч метрик с режимом применения и необязательным идентификатором,
+// по которому отбрасываются повторные отправки того же батча.
+type Batch struct {
+	ID      string
+	Mode    BatchMode
+	Metrics []Metrics
+}
+
+// ValidateBatchID проверяет идентификатор батча, пустой идентификатор отключает дедупликацию.
+func ValidateBatchID(id string) error {
+	var reason string
+	switch {
+	case len(id) > MaxBatchIDLength:
+		reason = fmt.Sprintf("must be at most %d characters", MaxBatchIDLength)
+	case id != "" && !namePattern.MatchString(id):
+		reason = "must contain only letters, digits, '_', '.' and '-'"
+	default:
+		return nil
+	}
+	return &ValidationError{Params: []InvalidParam{{Name: "batch_id", Reason: reason}}}
+}
+
+// ErrBatchRolledBack метрика не применена, так как батч отменен из-за ошибки в другой метрике.
+var ErrBatchRolledBack = errors.New("batch rolled back")
+
+const (
+	ItemApplied  = "applied"
+	ItemRejected = "rejected"
+)
+
+// BatchItemResult результат применения одной метрики батча.
+type BatchItemResult struct {
+	Index  int    `json:"index"`
+	ID     string `json:"id"`
+	MType  string `json:"type"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// BatchResult результат применения батча по каждой метрике.
+// Повторно полученный батч не применяется и отмечается как Duplicate без результатов по метрикам.
+type BatchResult struct {
+	Applied   int               `json:"applied"`
+	Rejected  int               `json:"rejected"`
+	Duplicate bool              `json:"duplicate,omitempty"`
+	Items     []BatchItemResult `json:"items"`
+}
+
+// NewBatchResult возвращает результат, в котором все метрики батча применены.
+func NewBatchResult(metrics []Metrics) *BatchResult {
+	res := &BatchResult{
+		Applied: len(metrics),
+		Items:   make([]BatchItemResult, len(metrics)),
+	}
+	for i, m := range metrics {
+		res.Items[i] = BatchItemResult{Index: i, ID: m.ID, MType: m.MType, Status: ItemApplied}
+	}
+
+	return res
+}
+
+// Reject отмечает метрику с индексом i отклоненной.
+func (r *BatchResult) Reject(i int, err error) {
+	item := &r.Items[i]
+	if item.Status == ItemApplied {
+		r.Applied--
+		r.Rejected++
+	}
+	item.Status = ItemRejected
+	if item.Error == "" {
+		item.Error = err.Error()
+	} else {
+		item.Error += "; " + err.Error()
+	}
+}
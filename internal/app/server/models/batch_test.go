@@ -0,0 +1,30 @@
+package models
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchResult(t *testing.T) {
+	res := NewBatchResult([]Metrics{{ID: "a", MType: "gauge"}, {ID: "b", MType: "counter"}})
+	assert.Equal(t, 2, res.Applied)
+
+	res.Reject(1, errors.New("first"))
+	res.Reject(1, errors.New("second"))
+	assert.Equal(t, 1, res.Applied)
+	assert.Equal(t, 1, res.Rejected)
+	assert.Equal(t, BatchItemResult{Index: 1, ID: "b", MType: "counter", Status: ItemRejected, Error: "first; second"}, res.Items[1])
+}
+
+func TestParseBatchMode(t *testing.T) {
+	for s, want := range map[string]BatchMode{"": BatchTransactional, "transactional": BatchTransactional, "best_effort": BatchBestEffort} {
+		mode, err := ParseBatchMode(s)
+		require.NoError(t, err)
+		assert.Equal(t, want, mode)
+	}
+	_, err := ParseBatchMode("partial")
+	assert.Error(t, err)
+}
@@ -0,0 +1,93 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Histogram распределение значений метрики типа histogram.
+// Bounds - верхние границы корзин по возрастанию, Counts - количество наблюдений в корзинах,
+// последний элемент Counts - корзина (Bounds[len-1], +Inf).
+type Histogram struct {
+	Bounds []float64 `json:"bounds"`
+	Counts []uint64  `json:"counts"`
+	Sum    float64   `json:"sum"`
+	Count  uint64    `json:"count"`
+}
+
+// Validate проверяет согласованность гистограммы.
+func (h Histogram) Validate() error {
+	if len(h.Counts) != len(h.Bounds)+1 {
+		return fmt.Errorf("histogram counts length must be bounds length + 1, got %d and %d", len(h.Counts), len(h.Bounds))
+	}
+	for i, b := range h.Bounds {
+		if math.IsNaN(b) || math.IsInf(b, 0) {
+			return fmt.Errorf("histogram bound %d must be finite", i)
+		}
+		if i > 0 && b <= h.Bounds[i-1] {
+			return errors.New("histogram bounds must be strictly increasing")
+		}
+	}
+	if math.IsNaN(h.Sum) || math.IsInf(h.Sum, 0) {
+		return errors.New("histogram sum must be finite")
+	}
+	var total uint64
+	for _, c := range h.Counts {
+		total += c
+	}
+	if total != h.Count {
+		return fmt.Errorf("histogram count=%d does not match sum of counts=%d", h.Count, total)
+	}
+
+	return nil
+}
+
+// Merge складывает две гистограммы. При совпадающих границах счетчики складываются поэлементно,
+// иначе результат строится на объединении границ: наблюдения каждой корзины попадают в корзину
+// объединения с той же верхней границей, поэтому накопленные счетчики на исходных границах сохраняются.
+func (h Histogram) Merge(o Histogram) Histogram {
+	bounds := mergeBounds(h.Bounds, o.Bounds)
+	res := Histogram{
+		Bounds: bounds,
+		Counts: make([]uint64, len(bounds)+1),
+		Sum:    h.Sum + o.Sum,
+		Count:  h.Count + o.Count,
+	}
+	for _, src := range []Histogram{h, o} {
+		for i, c := range src.Counts {
+			if i == len(src.Bounds) {
+				res.Counts[len(bounds)] += c
+				continue
+			}
+			res.Counts[sort.SearchFloat64s(bounds, src.Bounds[i])] += c
+		}
+	}
+
+	return res
+}
+
+// mergeBounds возвращает отсортированное объединение границ без повторов.
+func mergeBounds(a, b []float64) []float64 {
+	res := make([]float64, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		var v float64
+		switch {
+		case j == len(b) || (i < len(a) && a[i] < b[j]):
+			v = a[i]
+			i++
+		case i == len(a) || b[j] < a[i]:
+			v = b[j]
+			j++
+		default:
+			v = a[i]
+			i++
+			j++
+		}
+		res = append(res, v)
+	}
+
+	return res
+}
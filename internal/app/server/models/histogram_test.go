@@ -0,0 +1,57 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogramValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		h       Histogram
+		wantErr bool
+	}{
+		{"positive", Histogram{Bounds: []float64{1, 2}, Counts: []uint64{1, 0, 2}, Sum: 7, Count: 3}, false},
+		{"negative: counts length", Histogram{Bounds: []float64{1, 2}, Counts: []uint64{1, 0}, Count: 1}, true},
+		{"negative: bounds order", Histogram{Bounds: []float64{2, 1}, Counts: []uint64{0, 0, 0}}, true},
+		{"negative: count mismatch", Histogram{Bounds: []float64{1}, Counts: []uint64{1, 1}, Count: 1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.h.Validate()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	t.Run("same bounds", func(t *testing.T) {
+		a := Histogram{Bounds: []float64{1, 2}, Counts: []uint64{1, 2, 3}, Sum: 10, Count: 6}
+		b := Histogram{Bounds: []float64{1, 2}, Counts: []uint64{1, 1, 1}, Sum: 5, Count: 3}
+		res := a.Merge(b)
+		assert.Equal(t, []float64{1, 2}, res.Bounds)
+		assert.Equal(t, []uint64{2, 3, 4}, res.Counts)
+		assert.Equal(t, float64(15), res.Sum)
+		assert.Equal(t, uint64(9), res.Count)
+		assert.NoError(t, res.Validate())
+	})
+
+	t.Run("different bounds", func(t *testing.T) {
+		a := Histogram{Bounds: []float64{1, 3}, Counts: []uint64{1, 1, 1}, Count: 3}
+		b := Histogram{Bounds: []float64{2, 3}, Counts: []uint64{2, 2, 2}, Count: 6}
+		res := a.Merge(b)
+		assert.Equal(t, []float64{1, 2, 3}, res.Bounds)
+		assert.Equal(t, []uint64{1, 2, 3, 3}, res.Counts)
+		assert.NoError(t, res.Validate())
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		b := Histogram{Bounds: []float64{1}, Counts: []uint64{1, 1}, Sum: 3, Count: 2}
+		assert.Equal(t, b, Histogram{}.Merge(b))
+	})
+}
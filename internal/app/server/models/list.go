@@ -0,0 +1,119 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	SortByName = "name"
+	SortByType = "type"
+)
+
+// ListCursor позиция последней выданной метрики для постраничной выборки.
+type ListCursor struct {
+	ID    string `json:"id"`
+	MType string `json:"type"`
+}
+
+// Encode возвращает непрозрачное строковое представление курсора.
+func (c ListCursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeListCursor разбирает курсор, полученный от клиента.
+func DecodeListCursor(s string) (*ListCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor: %w", err)
+	}
+	var c ListCursor
+	if err = json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("invalid cursor: %w", err)
+	}
+
+	return &c, nil
+}
+
+// MetricFilter отбор метрик по типу и имени.
+type MetricFilter struct {
+	Types     []string // типы метрик, пусто - все
+	NameRegex string   // регулярное выражение для имени, пусто - все
+	Prefix    string   // обязательный префикс имени, пусто - все
+}
+
+// ListOptions параметры выборки метрик.
+type ListOptions struct {
+	MetricFilter
+	SortBy string      // SortByName (по умолчанию) или SortByType
+	Desc   bool        // сортировка по убыванию
+	After  *ListCursor // выдавать метрики после курсора
+	Limit  int         // максимальное количество метрик
+}
+
+// GlobToRegex переводит шаблон вида Heap* или CPU? в регулярное выражение.
+func GlobToRegex(glob string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+
+	return b.String()
+}
+
+// key возвращает ключ сортировки метрики.
+func (o ListOptions) key(id, mType string) [2]string {
+	if o.SortBy == SortByType {
+		return [2]string{mType, id}
+	}
+	return [2]string{id, mType}
+}
+
+// Less сообщает, идет ли метрика a раньше b в заданном порядке.
+func (o ListOptions) Less(a, b Metrics) bool {
+	ka, kb := o.key(a.ID, a.MType), o.key(b.ID, b.MType)
+	less := ka[0] < kb[0] || (ka[0] == kb[0] && ka[1] < kb[1])
+	if o.Desc {
+		return !less && ka != kb
+	}
+	return less
+}
+
+// IsAfterCursor сообщает, идет ли метрика после курсора в заданном порядке.
+func (o ListOptions) IsAfterCursor(m Metrics) bool {
+	if o.After == nil {
+		return true
+	}
+	return o.Less(Metrics{ID: o.After.ID, MType: o.After.MType}, m)
+}
+
+// HasPrefix сообщает, начинается ли имя с префикса фильтра.
+func (f MetricFilter) HasPrefix(name string) bool {
+	return strings.HasPrefix(name, f.Prefix)
+}
+
+// HasType сообщает, входит ли тип в фильтр.
+func (f MetricFilter) HasType(mType string) bool {
+	if len(f.Types) == 0 {
+		return true
+	}
+	for _, t := range f.Types {
+		if t == mType {
+			return true
+		}
+	}
+	return false
+}
@@ -3,8 +3,9 @@ package models
 // DTO
 
 type Metrics struct {
-	Delta *int64   `json:"delta,omitempty"` // значение метрики в случае передачи counter
-	Value *float64 `json:"value,omitempty"` // значение метрики в случае передачи gauge
-	ID    string   `json:"id"`              // имя метрики
-	MType string   `json:"type"`            // параметр, принимающий значение gauge или counter
+	Delta     *int64     `json:"delta,omitempty"`     // значение метрики в случае передачи counter
+	Value     *float64   `json:"value,omitempty"`     // значение метрики в случае передачи gauge
+	Histogram *Histogram `json:"histogram,omitempty"` // значение метрики в случае передачи histogram
+	ID        string     `json:"id"`                  // имя метрики
+	MType     string     `json:"type"`                // параметр, принимающий значение gauge, counter или histogram
 }
@@ -0,0 +1,105 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+)
+
+// MaxNameLength максимальная длина имени метрики, совпадает с размером колонки name в PostgreSQL.
+const MaxNameLength = 50
+
+// namePattern допустимые символы имени метрики.
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9_.\-]+$`)
+
+// InvalidParam описание некорректного поля запроса.
+// Index указывает на метрику в батче и не заполняется для одиночной метрики.
+type InvalidParam struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+	Index  *int   `json:"index,omitempty"`
+}
+
+// ValidationError ошибка проверки входящих метрик со списком всех некорректных полей.
+type ValidationError struct {
+	Params []InvalidParam
+}
+
+func (e *ValidationError) Error() string {
+	res := make([]string, 0, len(e.Params))
+	for _, p := range e.Params {
+		if p.Index != nil {
+			res = append(res, fmt.Sprintf("[%d].%s: %s", *p.Index, p.Name, p.Reason))
+			continue
+		}
+		res = append(res, fmt.Sprintf("%s: %s", p.Name, p.Reason))
+	}
+	return strings.Join(res, "; ")
+}
+
+// Validate проверяет имя, тип и наличие значения метрики соответствующего типа.
+func (m Metrics) Validate() error {
+	if params := m.invalidParams(); len(params) > 0 {
+		return &ValidationError{Params: params}
+	}
+	return nil
+}
+
+// ValidateBatch проверяет все метрики батча и возвращает ошибки с индексами метрик.
+func ValidateBatch(metrics []Metrics) error {
+	var params []InvalidParam
+	for i := range metrics {
+		for _, p := range metrics[i].invalidParams() {
+			index := i
+			p.Index = &index
+			params = append(params, p)
+		}
+	}
+	if len(params) > 0 {
+		return &ValidationError{Params: params}
+	}
+	return nil
+}
+
+func (m Metrics) invalidParams() []InvalidParam {
+	var params []InvalidParam
+	add := func(name, reason string) {
+		params = append(params, InvalidParam{Name: name, Reason: reason})
+	}
+
+	switch {
+	case m.ID == "":
+		add("id", "is required")
+	case len(m.ID) > MaxNameLength:
+		add("id", fmt.Sprintf("must be at most %d characters", MaxNameLength))
+	case !namePattern.MatchString(m.ID):
+		add("id", "must contain only letters, digits, '_', '.' and '-'")
+	}
+
+	switch m.MType {
+	case "gauge":
+		switch {
+		case m.Value == nil:
+			add("value", "is required for gauge")
+		case math.IsNaN(*m.Value) || math.IsInf(*m.Value, 0):
+			add("value", "must be finite")
+		}
+	case "counter":
+		if m.Delta == nil {
+			add("delta", "is required for counter")
+		}
+	case "histogram":
+		if m.Histogram == nil {
+			add("histogram", "is required for histogram")
+		} else if err := m.Histogram.Validate(); err != nil {
+			add("histogram", err.Error())
+		}
+	case "":
+		add("type", "is required")
+	default:
+		add("type", fmt.Sprintf("unknown metric type %q", m.MType))
+	}
+
+	return params
+}
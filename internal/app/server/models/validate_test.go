@@ -0,0 +1,75 @@
+package models
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsValidate(t *testing.T) {
+	delta := int64(1)
+	value := 1.5
+	nan := math.NaN()
+	inf := math.Inf(1)
+
+	tests := []struct {
+		name   string
+		metric Metrics
+		fields []string
+	}{
+		{"valid gauge", Metrics{ID: "Alloc", MType: "gauge", Value: &value}, nil},
+		{"valid counter", Metrics{ID: "Poll.Count-1", MType: "counter", Delta: &delta}, nil},
+		{"valid histogram", Metrics{ID: "Latency", MType: "histogram", Histogram: &Histogram{Bounds: []float64{1}, Counts: []uint64{0, 1}, Count: 1}}, nil},
+		{"gauge without value", Metrics{ID: "Alloc", MType: "gauge"}, []string{"value"}},
+		{"counter without delta", Metrics{ID: "PollCount", MType: "counter"}, []string{"delta"}},
+		{"histogram without value", Metrics{ID: "Latency", MType: "histogram"}, []string{"histogram"}},
+		{"inconsistent histogram", Metrics{ID: "Latency", MType: "histogram", Histogram: &Histogram{Counts: []uint64{1}}}, []string{"histogram"}},
+		{"NaN", Metrics{ID: "Alloc", MType: "gauge", Value: &nan}, []string{"value"}},
+		{"Inf", Metrics{ID: "Alloc", MType: "gauge", Value: &inf}, []string{"value"}},
+		{"empty name", Metrics{MType: "gauge", Value: &value}, []string{"id"}},
+		{"long name", Metrics{ID: strings.Repeat("a", MaxNameLength+1), MType: "gauge", Value: &value}, []string{"id"}},
+		{"bad charset", Metrics{ID: "Alloc bytes", MType: "gauge", Value: &value}, []string{"id"}},
+		{"unknown type", Metrics{ID: "Alloc", MType: "summary"}, []string{"type"}},
+		{"all fields", Metrics{ID: "Alloc/bytes"}, []string{"id", "type"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.metric.Validate()
+			if tt.fields == nil {
+				assert.NoError(t, err)
+				return
+			}
+			var verr *ValidationError
+			require.True(t, errors.As(err, &verr))
+			var fields []string
+			for _, p := range verr.Params {
+				fields = append(fields, p.Name)
+				assert.Nil(t, p.Index)
+			}
+			assert.Equal(t, tt.fields, fields)
+		})
+	}
+}
+
+func TestValidateBatch(t *testing.T) {
+	delta := int64(1)
+	err := ValidateBatch([]Metrics{
+		{ID: "PollCount", MType: "counter", Delta: &delta},
+		{ID: "Alloc", MType: "gauge"},
+		{ID: "", MType: "counter", Delta: &delta},
+	})
+	var verr *ValidationError
+	require.True(t, errors.As(err, &verr))
+	require.Len(t, verr.Params, 2)
+	assert.Equal(t, "value", verr.Params[0].Name)
+	assert.Equal(t, 1, *verr.Params[0].Index)
+	assert.Equal(t, "id", verr.Params[1].Name)
+	assert.Equal(t, 2, *verr.Params[1].Index)
+	assert.Equal(t, "[1].value: is required for gauge; [2].id: is required", err.Error())
+
+	assert.NoError(t, ValidateBatch([]Metrics{{ID: "PollCount", MType: "counter", Delta: &delta}}))
+}
@@ -0,0 +1,88 @@
+// Package ratelimit ограничивает частоту запросов клиентов алгоритмом token bucket.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepInterval как часто из памяти удаляются корзины клиентов, переставших присылать запросы.
+const sweepInterval = time.Minute
+
+// bucket корзина токенов клиента.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter ограничивает частоту запросов каждого клиента: в среднем rate запросов в секунду
+// и до burst запросов подряд.
+type Limiter struct {
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// New создает Limiter. rate <= 0 отключает ограничение, burst < 1 приравнивается к rate, но не меньше 1.
+func New(rate float64, burst int) *Limiter {
+	b := float64(burst)
+	if b < 1 {
+		b = math.Max(rate, 1)
+	}
+	return &Limiter{
+		rate:    rate,
+		burst:   b,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow расходует токен клиента key в момент now. Если токенов нет, возвращает false
+// и время, через которое появится следующий токен.
+func (l *Limiter) Allow(key string, now time.Time) (bool, time.Duration) {
+	if l == nil || l.rate <= 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(l.burst, b.tokens+elapsed.Seconds()*l.rate)
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+
+	return true, 0
+}
+
+// sweep удаляет корзины, которые успели заполниться: такой клиент не отличается от нового.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// RetryAfter округляет время ожидания до целых секунд для заголовка Retry-After.
+func RetryAfter(wait time.Duration) int {
+	return int(math.Max(1, math.Ceil(wait.Seconds())))
+}
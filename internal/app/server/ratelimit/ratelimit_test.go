@@ -0,0 +1,54 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter(t *testing.T) {
+	l := New(2, 3)
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		ok, _ := l.Allow("a", now)
+		assert.True(t, ok, i)
+	}
+	ok, wait := l.Allow("a", now)
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+	assert.Equal(t, 1, RetryAfter(wait))
+
+	// у каждого клиента своя корзина
+	ok, _ = l.Allow("b", now)
+	assert.True(t, ok)
+
+	// за полсекунды при rate=2 восстанавливается один запрос
+	ok, _ = l.Allow("a", now.Add(500*time.Millisecond))
+	assert.True(t, ok)
+	ok, _ = l.Allow("a", now.Add(500*time.Millisecond))
+	assert.False(t, ok)
+
+	// заполнившиеся корзины удаляются из памяти
+	l.Allow("c", now.Add(2*sweepInterval))
+	assert.Len(t, l.buckets, 1)
+}
+
+func TestLimiterDisabled(t *testing.T) {
+	var l *Limiter
+	ok, _ := l.Allow("a", time.Now())
+	assert.True(t, ok)
+
+	l = New(0, 0)
+	for i := 0; i < 100; i++ {
+		ok, _ = l.Allow("a", time.Now())
+		assert.True(t, ok)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	assert.Equal(t, 1, RetryAfter(0))
+	assert.Equal(t, 1, RetryAfter(100*time.Millisecond))
+	assert.Equal(t, 3, RetryAfter(2100*time.Millisecond))
+}
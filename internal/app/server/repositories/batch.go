@@ -0,0 +1,66 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/models"
+)
+
+const (
+	// BatchIDTTL сколько хранится идентификатор примененного батча.
+	BatchIDTTL = 10 * time.Minute
+	// BatchIDCacheSize максимальное количество идентификаторов батчей в памяти.
+	BatchIDCacheSize = 100000
+)
+
+// ErrDuplicateBatch батч с таким идентификатором уже применен.
+var ErrDuplicateBatch = errors.New("batch already applied")
+
+// ApplyBatch проверяет метрики батча и применяет их в хранилище в заданном режиме.
+// В транзакционном режиме некорректная метрика отклоняет весь батч с ошибкой *models.ValidationError,
+// в режиме best effort некорректные метрики отклоняются по отдельности, остальные применяются.
+// Батч с уже примененным идентификатором подтверждается без повторного применения.
+func ApplyBatch(ctx context.Context, store StoreRepository, batch models.Batch) (*models.BatchResult, error) {
+	if err := models.ValidateBatchID(batch.ID); err != nil {
+		return nil, err
+	}
+	metrics := batch.Metrics
+	res := models.NewBatchResult(metrics)
+
+	var verr *models.ValidationError
+	if err := models.ValidateBatch(metrics); errors.As(err, &verr) {
+		if batch.Mode != models.BatchBestEffort {
+			return nil, err
+		}
+		for _, p := range verr.Params {
+			res.Reject(*p.Index, fmt.Errorf("%s: %s", p.Name, p.Reason))
+		}
+	}
+
+	valid := make([]models.Metrics, 0, res.Applied)
+	index := make([]int, 0, res.Applied)
+	for i := range metrics {
+		if res.Items[i].Status == models.ItemApplied {
+			valid = append(valid, metrics[i])
+			index = append(index, i)
+		}
+	}
+
+	errs, err := store.UpdateBatchMetrics(ctx, models.Batch{ID: batch.ID, Mode: batch.Mode, Metrics: valid})
+	if errors.Is(err, ErrDuplicateBatch) {
+		return &models.BatchResult{Duplicate: true}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i, err := range errs {
+		if err != nil {
+			res.Reject(index[i], err)
+		}
+	}
+
+	return res, nil
+}
@@ -2,18 +2,32 @@ package repositories
 
 import (
 	"context"
+	"errors"
+
 	"github.com/webkimru/go-yandex-metrics/internal/app/server/config"
 	"github.com/webkimru/go-yandex-metrics/internal/app/server/models"
 )
 
+// ErrNotFound метрика отсутствует в хранилище.
+var ErrNotFound = errors.New("metric not found")
+
 // StoreRepository интерфейс хранилища всего сервиса - контракт.
 // Ниже описываем, все, что он должен уметь делать - методы.
 type StoreRepository interface {
 	Initialize(ctx context.Context, app config.AppConfig) error
 	UpdateCounter(ctx context.Context, name string, value int64) (int64, error)
 	UpdateGauge(ctx context.Context, name string, value float64) (float64, error)
-	UpdateBatchMetrics(ctx context.Context, metrics []models.Metrics) error
+	UpdateHistogram(ctx context.Context, name string, value models.Histogram) (models.Histogram, error)
+	// UpdateBatchMetrics возвращает ошибки по каждой метрике батча (nil - метрика применена)
+	// и общую ошибку хранилища, при которой батч не применен. Для батча с уже примененным
+	// идентификатором возвращает ErrDuplicateBatch.
+	UpdateBatchMetrics(ctx context.Context, batch models.Batch) ([]error, error)
 	GetCounter(ctx context.Context, metric string) (int64, error)
 	GetGauge(ctx context.Context, metric string) (float64, error)
+	GetHistogram(ctx context.Context, metric string) (models.Histogram, error)
 	GetAllMetrics(ctx context.Context) (map[string]interface{}, error)
+	ListMetrics(ctx context.Context, opts models.ListOptions) ([]models.Metrics, error)
+	DeleteMetric(ctx context.Context, mType, name string) error
+	ResetCounter(ctx context.Context, name string) error
+	DeleteMetrics(ctx context.Context, filter models.MetricFilter) (int64, error)
 }
@@ -0,0 +1,56 @@
+package store
+
+import "time"
+
+// batchCache ограниченный по размеру и времени жизни набор идентификаторов примененных батчей.
+// При переполнении вытесняются самые старые идентификаторы.
+type batchCache struct {
+	ttl   time.Duration
+	size  int
+	seen  map[string]time.Time
+	order []string // идентификаторы в порядке добавления
+}
+
+func newBatchCache(ttl time.Duration, size int) *batchCache {
+	return &batchCache{
+		ttl:  ttl,
+		size: size,
+		seen: make(map[string]time.Time),
+	}
+}
+
+// contains сообщает, применялся ли батч с идентификатором id в пределах времени жизни.
+func (c *batchCache) contains(id string, now time.Time) bool {
+	t, ok := c.seen[id]
+	return ok && now.Sub(t) < c.ttl
+}
+
+// add запоминает идентификатор примененного батча.
+func (c *batchCache) add(id string, now time.Time) {
+	c.evict(now)
+	if len(c.order) >= c.size {
+		delete(c.seen, c.order[0])
+		c.order = c.order[1:]
+	}
+	c.seen[id] = now
+	c.order = append(c.order, id)
+}
+
+// evict удаляет идентификаторы с истекшим временем жизни.
+func (c *batchCache) evict(now time.Time) {
+	for len(c.order) > 0 && now.Sub(c.seen[c.order[0]]) >= c.ttl {
+		delete(c.seen, c.order[0])
+		c.order = c.order[1:]
+	}
+}
+
+// entries возвращает идентификаторы с временем применения, у которых не истекло время жизни.
+func (c *batchCache) entries(now time.Time) map[string]time.Time {
+	res := make(map[string]time.Time, len(c.order))
+	for id, t := range c.seen {
+		if now.Sub(t) < c.ttl {
+			res[id] = t
+		}
+	}
+	return res
+}
@@ -0,0 +1,32 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchCache(t *testing.T) {
+	now := time.Now()
+	c := newBatchCache(time.Minute, 2)
+
+	c.add("a", now)
+	assert.True(t, c.contains("a", now))
+	assert.False(t, c.contains("b", now))
+
+	// время жизни истекло
+	assert.False(t, c.contains("a", now.Add(time.Minute)))
+
+	// при переполнении вытесняется самый старый
+	c.add("b", now.Add(time.Second))
+	c.add("c", now.Add(2*time.Second))
+	assert.False(t, c.contains("a", now.Add(2*time.Second)))
+	assert.True(t, c.contains("b", now.Add(2*time.Second)))
+	assert.True(t, c.contains("c", now.Add(2*time.Second)))
+
+	// просроченные удаляются при добавлении
+	c.add("d", now.Add(time.Minute+time.Second))
+	assert.Len(t, c.order, 2)
+	assert.Equal(t, []string{"c", "d"}, c.order)
+}
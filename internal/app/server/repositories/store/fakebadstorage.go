@@ -22,6 +22,10 @@ func (f *FakeBadStorage) UpdateGauge(_ context.Context, _ string, _ float64) (fl
 	return 0, fmt.Errorf("err")
 }
 
+func (f *FakeBadStorage) UpdateHistogram(_ context.Context, _ string, value models.Histogram) (models.Histogram, error) {
+	return models.Histogram{}, fmt.Errorf("err")
+}
+
 func (f *FakeBadStorage) GetCounter(_ context.Context, _ string) (int64, error) {
 	return 0, fmt.Errorf("err")
 }
@@ -30,14 +34,34 @@ func (f *FakeBadStorage) GetGauge(_ context.Context, _ string) (float64, error)
 	return 0, fmt.Errorf("err")
 }
 
+func (f *FakeBadStorage) GetHistogram(_ context.Context, _ string) (models.Histogram, error) {
+	return models.Histogram{}, fmt.Errorf("err")
+}
+
 func (f *FakeBadStorage) GetAllMetrics(_ context.Context) (map[string]interface{}, error) {
 	return nil, fmt.Errorf("err")
 }
 
-func (f *FakeBadStorage) UpdateBatchMetrics(_ context.Context, _ []models.Metrics) error {
+func (f *FakeBadStorage) ListMetrics(_ context.Context, _ models.ListOptions) ([]models.Metrics, error) {
+	return nil, fmt.Errorf("err")
+}
+
+func (f *FakeBadStorage) DeleteMetric(_ context.Context, _, _ string) error {
+	return fmt.Errorf("err")
+}
+
+func (f *FakeBadStorage) ResetCounter(_ context.Context, _ string) error {
 	return fmt.Errorf("err")
 }
 
+func (f *FakeBadStorage) DeleteMetrics(_ context.Context, _ models.MetricFilter) (int64, error) {
+	return 0, fmt.Errorf("err")
+}
+
+func (f *FakeBadStorage) UpdateBatchMetrics(_ context.Context, _ models.Batch) ([]error, error) {
+	return nil, fmt.Errorf("err")
+}
+
 func (f *FakeBadStorage) Initialize(_ context.Context, _ config.AppConfig) error {
 	return fmt.Errorf("err")
 }
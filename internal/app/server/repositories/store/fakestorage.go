@@ -21,6 +21,10 @@ func (f *FakeStorage) UpdateGauge(_ context.Context, _ string, _ float64) (float
 	return 0, nil
 }
 
+func (f *FakeStorage) UpdateHistogram(_ context.Context, _ string, value models.Histogram) (models.Histogram, error) {
+	return value, nil
+}
+
 func (f *FakeStorage) GetCounter(_ context.Context, _ string) (int64, error) {
 	return 0, nil
 }
@@ -29,14 +33,34 @@ func (f *FakeStorage) GetGauge(_ context.Context, _ string) (float64, error) {
 	return 0, nil
 }
 
+func (f *FakeStorage) GetHistogram(_ context.Context, _ string) (models.Histogram, error) {
+	return models.Histogram{}, nil
+}
+
 func (f *FakeStorage) GetAllMetrics(_ context.Context) (map[string]interface{}, error) {
 	return nil, nil
 }
 
-func (f *FakeStorage) UpdateBatchMetrics(_ context.Context, _ []models.Metrics) error {
+func (f *FakeStorage) ListMetrics(_ context.Context, _ models.ListOptions) ([]models.Metrics, error) {
+	return nil, nil
+}
+
+func (f *FakeStorage) DeleteMetric(_ context.Context, _, _ string) error {
 	return nil
 }
 
+func (f *FakeStorage) ResetCounter(_ context.Context, _ string) error {
+	return nil
+}
+
+func (f *FakeStorage) DeleteMetrics(_ context.Context, _ models.MetricFilter) (int64, error) {
+	return 0, nil
+}
+
+func (f *FakeStorage) UpdateBatchMetrics(_ context.Context, batch models.Batch) ([]error, error) {
+	return make([]error, len(batch.Metrics)), nil
+}
+
 func (f *FakeStorage) Initialize(_ context.Context, _ config.AppConfig) error {
 	return nil
 }
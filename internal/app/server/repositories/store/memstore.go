@@ -6,7 +6,11 @@ import (
 	"fmt"
 	"github.com/webkimru/go-yandex-metrics/internal/app/server/config"
 	"github.com/webkimru/go-yandex-metrics/internal/app/server/models"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories"
+	"regexp"
+	"sort"
 	"sync"
+	"time"
 )
 
 type Counter int64
@@ -14,16 +18,20 @@ type Gauge float64
 
 // MemStorage описывает структуру хранилища в памяти.
 type MemStorage struct {
-	Counter map[string]Counter
-	Gauge   map[string]Gauge
-	mu      sync.Mutex
+	Counter   map[string]Counter
+	Gauge     map[string]Gauge
+	Histogram map[string]models.Histogram
+	batches   *batchCache
+	mu        sync.Mutex
 }
 
 // NewMemStorage конструктур типа MemStorage.
 func NewMemStorage() *MemStorage {
 	return &MemStorage{
-		Counter: make(map[string]Counter, 1),
-		Gauge:   make(map[string]Gauge, 31),
+		Counter:   make(map[string]Counter, 1),
+		Gauge:     make(map[string]Gauge, 31),
+		Histogram: make(map[string]models.Histogram),
+		batches:   newBatchCache(repositories.BatchIDTTL, repositories.BatchIDCacheSize),
 	}
 }
 
@@ -48,8 +56,29 @@ func (ms *MemStorage) UpdateGauge(ctx context.Context, name string, value float6
 	return float64(ms.Gauge[name]), nil
 }
 
+// UpdateHistogram объединяет полученное распределение с накопленным в поле Histogram.
+func (ms *MemStorage) UpdateHistogram(ctx context.Context, name string, value models.Histogram) (models.Histogram, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	return ms.updateHistogram(name, value), nil
+}
+
+func (ms *MemStorage) updateHistogram(name string, value models.Histogram) models.Histogram {
+	// хранилище могло быть восстановлено из файла без гистограмм
+	if ms.Histogram == nil {
+		ms.Histogram = make(map[string]models.Histogram)
+	}
+	ms.Histogram[name] = ms.Histogram[name].Merge(value)
+
+	return ms.Histogram[name]
+}
+
 // GetCounter возращает значение счетчика Counter.
 func (ms *MemStorage) GetCounter(ctx context.Context, metric string) (int64, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
 	value, ok := ms.Counter[metric]
 	if !ok {
 		return 0, fmt.Errorf("%s does not exists", metric)
@@ -59,6 +88,9 @@ func (ms *MemStorage) GetCounter(ctx context.Context, metric string) (int64, err
 
 // GetGauge возращает значение счетчика Gauge.
 func (ms *MemStorage) GetGauge(ctx context.Context, metric string) (float64, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
 	value, ok := ms.Gauge[metric]
 	if !ok {
 		return 0, fmt.Errorf("%s does not exists", metric)
@@ -66,17 +98,195 @@ func (ms *MemStorage) GetGauge(ctx context.Context, metric string) (float64, err
 	return float64(value), nil
 }
 
-// GetAllMetrics возращает мапку счетчиков Counter и Gauge.
+// GetHistogram возращает распределение Histogram.
+func (ms *MemStorage) GetHistogram(ctx context.Context, metric string) (models.Histogram, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	value, ok := ms.Histogram[metric]
+	if !ok {
+		return models.Histogram{}, fmt.Errorf("%s does not exists", metric)
+	}
+	return value, nil
+}
+
+// GetAllMetrics возращает мапку счетчиков Counter, Gauge и Histogram.
+// Возвращаются копии, снятые под блокировкой: их можно читать, пока хранилище изменяется.
 func (ms *MemStorage) GetAllMetrics(ctx context.Context) (map[string]interface{}, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	counters := make(map[string]Counter, len(ms.Counter))
+	for name, v := range ms.Counter {
+		counters[name] = v
+	}
+	gauges := make(map[string]Gauge, len(ms.Gauge))
+	for name, v := range ms.Gauge {
+		gauges[name] = v
+	}
+	histograms := make(map[string]models.Histogram, len(ms.Histogram))
+	for name, v := range ms.Histogram {
+		histograms[name] = v
+	}
+
 	all := make(map[string]interface{}, 30)
-	all["counter"] = ms.Counter
-	all["gauge"] = ms.Gauge
+	all["counter"] = counters
+	all["gauge"] = gauges
+	all["histogram"] = histograms
 
 	return all, nil
 }
 
-// UpdateBatchMetrics обновляет значение метрик Gauge и Counter по входящему батчу.
-func (ms *MemStorage) UpdateBatchMetrics(ctx context.Context, metrics []models.Metrics) error {
+// ListMetrics возвращает страницу метрик, отобранных по типу и имени, в заданном порядке.
+func (ms *MemStorage) ListMetrics(ctx context.Context, opts models.ListOptions) ([]models.Metrics, error) {
+	var re *regexp.Regexp
+	if opts.NameRegex != "" {
+		var err error
+		if re, err = regexp.Compile(opts.NameRegex); err != nil {
+			return nil, err
+		}
+	}
+	match := func(id, mType string) bool {
+		m := models.Metrics{ID: id, MType: mType}
+		return opts.HasType(mType) && opts.HasPrefix(id) && (re == nil || re.MatchString(id)) && opts.IsAfterCursor(m)
+	}
+
+	ms.mu.Lock()
+	var res []models.Metrics
+	for id, v := range ms.Counter {
+		if match(id, "counter") {
+			delta := int64(v)
+			res = append(res, models.Metrics{ID: id, MType: "counter", Delta: &delta})
+		}
+	}
+	for id, v := range ms.Gauge {
+		if match(id, "gauge") {
+			value := float64(v)
+			res = append(res, models.Metrics{ID: id, MType: "gauge", Value: &value})
+		}
+	}
+	for id, v := range ms.Histogram {
+		if match(id, "histogram") {
+			histogram := v
+			res = append(res, models.Metrics{ID: id, MType: "histogram", Histogram: &histogram})
+		}
+	}
+	ms.mu.Unlock()
+
+	sort.Slice(res, func(i, j int) bool {
+		return opts.Less(res[i], res[j])
+	})
+	if opts.Limit > 0 && len(res) > opts.Limit {
+		res = res[:opts.Limit]
+	}
+
+	return res, nil
+}
+
+// DeleteMetric удаляет метрику заданного типа.
+func (ms *MemStorage) DeleteMetric(ctx context.Context, mType, name string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var ok bool
+	switch mType {
+	case "counter":
+		if _, ok = ms.Counter[name]; ok {
+			delete(ms.Counter, name)
+		}
+	case "gauge":
+		if _, ok = ms.Gauge[name]; ok {
+			delete(ms.Gauge, name)
+		}
+	case "histogram":
+		if _, ok = ms.Histogram[name]; ok {
+			delete(ms.Histogram, name)
+		}
+	}
+	if !ok {
+		return fmt.Errorf("%s %s: %w", mType, name, repositories.ErrNotFound)
+	}
+
+	return nil
+}
+
+// ResetCounter обнуляет счетчик Counter.
+func (ms *MemStorage) ResetCounter(ctx context.Context, name string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.Counter[name]; !ok {
+		return fmt.Errorf("counter %s: %w", name, repositories.ErrNotFound)
+	}
+	ms.Counter[name] = 0
+
+	return nil
+}
+
+// DeleteMetrics удаляет метрики, отобранные по типу и имени, и возвращает их количество.
+func (ms *MemStorage) DeleteMetrics(ctx context.Context, filter models.MetricFilter) (int64, error) {
+	var re *regexp.Regexp
+	if filter.NameRegex != "" {
+		var err error
+		if re, err = regexp.Compile(filter.NameRegex); err != nil {
+			return 0, err
+		}
+	}
+	match := func(name string) bool {
+		return filter.HasPrefix(name) && (re == nil || re.MatchString(name))
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var deleted int64
+	if filter.HasType("counter") {
+		for name := range ms.Counter {
+			if match(name) {
+				delete(ms.Counter, name)
+				deleted++
+			}
+		}
+	}
+	if filter.HasType("gauge") {
+		for name := range ms.Gauge {
+			if match(name) {
+				delete(ms.Gauge, name)
+				deleted++
+			}
+		}
+	}
+	if filter.HasType("histogram") {
+		for name := range ms.Histogram {
+			if match(name) {
+				delete(ms.Histogram, name)
+				deleted++
+			}
+		}
+	}
+
+	return deleted, nil
+}
+
+// UpdateBatchMetrics обновляет значение метрик Gauge, Counter и Histogram по входящему батчу.
+// Обновление в памяти не может завершиться ошибкой, поэтому режим применения не важен.
+// Идентификаторы примененных батчей хранятся в памяти ограниченное время.
+func (ms *MemStorage) UpdateBatchMetrics(ctx context.Context, batch models.Batch) ([]error, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	now := time.Now()
+	if batch.ID != "" {
+		// хранилище могло быть восстановлено из файла без вызова Initialize
+		if ms.batches == nil {
+			ms.batches = newBatchCache(repositories.BatchIDTTL, repositories.BatchIDCacheSize)
+		}
+		if ms.batches.contains(batch.ID, now) {
+			return nil, repositories.ErrDuplicateBatch
+		}
+	}
+
+	metrics := batch.Metrics
 	for i := range metrics {
 		switch metrics[i].MType {
 		case "gauge":
@@ -84,15 +294,68 @@ func (ms *MemStorage) UpdateBatchMetrics(ctx context.Context, metrics []models.M
 
 		case "counter":
 			ms.Counter[metrics[i].ID] += Counter(*metrics[i].Delta)
+
+		case "histogram":
+			ms.updateHistogram(metrics[i].ID, *metrics[i].Histogram)
 		}
 	}
 
-	return nil
+	if batch.ID != "" {
+		ms.batches.add(batch.ID, now)
+	}
+
+	return make([]error, len(metrics)), nil
+}
+
+// AppliedBatch сообщает, применялся ли батч с идентификатором id в пределах времени жизни.
+func (ms *MemStorage) AppliedBatch(id string) bool {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	return ms.batches != nil && ms.batches.contains(id, time.Now())
+}
+
+// AppliedBatches возвращает идентификаторы примененных батчей со временем применения, у которых
+// не истекло время жизни. Хранилище с журналом сохраняет их в снимок.
+func (ms *MemStorage) AppliedBatches() map[string]time.Time {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if ms.batches == nil {
+		return nil
+	}
+	return ms.batches.entries(time.Now())
+}
+
+// RestoreBatches запоминает идентификаторы примененных батчей с исходным временем применения,
+// чтобы повтор батча после восстановления хранилища отклонялся в пределах того же времени жизни.
+func (ms *MemStorage) RestoreBatches(batches map[string]time.Time) {
+	ids := make([]string, 0, len(batches))
+	for id := range batches {
+		ids = append(ids, id)
+	}
+	// кэш вытесняет идентификаторы в порядке добавления
+	sort.Slice(ids, func(i, j int) bool { return batches[ids[i]].Before(batches[ids[j]]) })
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if ms.batches == nil {
+		ms.batches = newBatchCache(repositories.BatchIDTTL, repositories.BatchIDCacheSize)
+	}
+	now := time.Now()
+	for _, id := range ids {
+		if now.Sub(batches[id]) < ms.batches.ttl {
+			ms.batches.add(id, batches[id])
+		}
+	}
 }
 
 func (ms *MemStorage) Initialize(ctx context.Context, _ config.AppConfig) error {
 	ms.Counter = make(map[string]Counter, 1)
 	ms.Gauge = make(map[string]Gauge, 31)
+	ms.Histogram = make(map[string]models.Histogram)
+	ms.batches = newBatchCache(repositories.BatchIDTTL, repositories.BatchIDCacheSize)
 
 	return nil
 }
@@ -0,0 +1,172 @@
+package pg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/models"
+)
+
+// Многострочные upsert: строки передаются массивами по колонкам и разворачиваются unnest,
+// поэтому запрос не зависит от размера батча и подготавливается один раз на соединение.
+const (
+	upsertCounters = `
+		INSERT INTO counters (name, delta)
+			SELECT * FROM unnest($1::text[], $2::bigint[])
+			ON CONFLICT (name) DO
+				UPDATE SET delta = counters.delta + excluded.delta
+	`
+	upsertGauges = `
+		INSERT INTO gauges (name, value)
+			SELECT * FROM unnest($1::text[], $2::double precision[])
+			ON CONFLICT (name) DO
+				UPDATE SET value = excluded.value
+	`
+	upsertHistograms = `
+		INSERT INTO histograms (name, bounds, counts, sum, count)
+			SELECT name, bounds::jsonb, counts::jsonb, sum, count
+			FROM unnest($1::text[], $2::text[], $3::text[], $4::double precision[], $5::bigint[])
+				AS h(name, bounds, counts, sum, count)
+			ON CONFLICT (name) DO
+				UPDATE SET bounds = excluded.bounds, counts = excluded.counts, sum = excluded.sum, count = excluded.count
+	`
+)
+
+// bulk метрики батча, сведенные по имени: многострочный upsert не может изменить одну строку дважды.
+type bulk struct {
+	counters   map[string]int64
+	gauges     map[string]float64
+	histograms map[string]models.Histogram
+}
+
+// collectMetrics сводит метрики батча так же, как их последовательное применение: счетчики складываются,
+// для gauge остается последнее значение, гистограммы объединяются.
+func collectMetrics(metrics []models.Metrics) (bulk, error) {
+	res := bulk{
+		counters:   make(map[string]int64),
+		gauges:     make(map[string]float64),
+		histograms: make(map[string]models.Histogram),
+	}
+	for _, m := range metrics {
+		switch {
+		case m.MType == "counter" && m.Delta != nil:
+			res.counters[m.ID] += *m.Delta
+		case m.MType == "gauge" && m.Value != nil:
+			res.gauges[m.ID] = *m.Value
+		case m.MType == "histogram" && m.Histogram != nil:
+			res.histograms[m.ID] = res.histograms[m.ID].Merge(*m.Histogram)
+		default:
+			return bulk{}, fmt.Errorf("invalid metric type=%s id=%s", m.MType, m.ID)
+		}
+	}
+
+	return res, nil
+}
+
+// sortedNames возвращает имена метрик по возрастанию: строки блокируются в одном порядке
+// во всех транзакциях, и параллельные батчи не попадают во взаимную блокировку.
+func sortedNames[V any](values map[string]V) []string {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// upsertMetrics записывает метрики батча многострочными upsert, отправленными одним pgx.Batch.
+// Накопленные гистограммы предварительно блокируются и объединяются с полученными.
+func upsertMetrics(ctx context.Context, tx pgx.Tx, metrics []models.Metrics) error {
+	rows, err := collectMetrics(metrics)
+	if err != nil {
+		return err
+	}
+
+	batch := &pgx.Batch{}
+	if len(rows.counters) > 0 {
+		names := sortedNames(rows.counters)
+		deltas := make([]int64, len(names))
+		for i, name := range names {
+			deltas[i] = rows.counters[name]
+		}
+		batch.Queue(upsertCounters, names, deltas)
+	}
+	if len(rows.gauges) > 0 {
+		names := sortedNames(rows.gauges)
+		values := make([]float64, len(names))
+		for i, name := range names {
+			values[i] = rows.gauges[name]
+		}
+		batch.Queue(upsertGauges, names, values)
+	}
+	if len(rows.histograms) > 0 {
+		names := sortedNames(rows.histograms)
+		current, err := lockHistograms(ctx, tx, names)
+		if err != nil {
+			return err
+		}
+		bounds := make([]string, len(names))
+		counts := make([]string, len(names))
+		sums := make([]float64, len(names))
+		totals := make([]int64, len(names))
+		for i, name := range names {
+			h := current[name].Merge(rows.histograms[name])
+			data, err := json.Marshal(h.Bounds)
+			if err != nil {
+				return err
+			}
+			bounds[i] = string(data)
+			if data, err = json.Marshal(h.Counts); err != nil {
+				return err
+			}
+			counts[i] = string(data)
+			sums[i], totals[i] = h.Sum, int64(h.Count)
+		}
+		batch.Queue(upsertHistograms, names, bounds, counts, sums, totals)
+	}
+	if batch.Len() == 0 {
+		return nil
+	}
+
+	return tx.SendBatch(ctx, batch).Close()
+}
+
+// lockHistograms блокирует до конца транзакции и возвращает накопленные гистограммы с заданными именами.
+func lockHistograms(ctx context.Context, tx pgx.Tx, names []string) (map[string]models.Histogram, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT name, bounds, counts, sum, count FROM histograms
+		WHERE name = ANY($1) ORDER BY name FOR UPDATE
+	`, names)
+	if err != nil {
+		return nil, err
+	}
+
+	// Не забываем закрыть курсор после завершения работы с данными.
+	defer rows.Close()
+
+	res := make(map[string]models.Histogram, len(names))
+	for rows.Next() {
+		var name string
+		var h models.Histogram
+		var bounds, counts []byte
+		var count int64
+		if err = rows.Scan(&name, &bounds, &counts, &h.Sum, &count); err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(bounds, &h.Bounds); err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(counts, &h.Counts); err != nil {
+			return nil, err
+		}
+		h.Count = uint64(count)
+		res[name] = h
+	}
+
+	// Необходимо проверить ошибки уровня курсора.
+	return res, rows.Err()
+}
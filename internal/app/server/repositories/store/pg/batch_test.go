@@ -0,0 +1,128 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/config"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/models"
+)
+
+func TestCollectMetrics(t *testing.T) {
+	delta := func(v int64) *int64 { return &v }
+	value := func(v float64) *float64 { return &v }
+
+	rows, err := collectMetrics([]models.Metrics{
+		{ID: "PollCount", MType: "counter", Delta: delta(2)},
+		{ID: "Alloc", MType: "gauge", Value: value(1.5)},
+		{ID: "PollCount", MType: "counter", Delta: delta(3)},
+		{ID: "Alloc", MType: "gauge", Value: value(2.5)},
+		{ID: "Latency", MType: "histogram", Histogram: &models.Histogram{Bounds: []float64{1}, Counts: []uint64{1, 0}, Sum: 0.5, Count: 1}},
+		{ID: "Latency", MType: "histogram", Histogram: &models.Histogram{Bounds: []float64{1}, Counts: []uint64{0, 2}, Sum: 5, Count: 2}},
+	})
+	require.NoError(t, err)
+	// повторы сводятся как при последовательном применении
+	assert.Equal(t, map[string]int64{"PollCount": 5}, rows.counters)
+	assert.Equal(t, map[string]float64{"Alloc": 2.5}, rows.gauges)
+	assert.Equal(t, models.Histogram{Bounds: []float64{1}, Counts: []uint64{1, 2}, Sum: 5.5, Count: 3}, rows.histograms["Latency"])
+
+	for _, m := range []models.Metrics{
+		{ID: "PollCount", MType: "counter"},
+		{ID: "Alloc", MType: "gauge"},
+		{ID: "Latency", MType: "histogram"},
+		{ID: "Alloc", MType: "summary", Value: value(1)},
+	} {
+		_, err = collectMetrics([]models.Metrics{m})
+		assert.Error(t, err, m.MType)
+	}
+}
+
+func TestSortedNames(t *testing.T) {
+	assert.Equal(t, []string{"a", "b", "c"}, sortedNames(map[string]int{"c": 1, "a": 2, "b": 3}))
+}
+
+// benchmarkBatch возвращает батч из n метрик: поровну счетчиков и gauge, каждая десятая - гистограмма.
+func benchmarkBatch(n int) []models.Metrics {
+	metrics := make([]models.Metrics, n)
+	for i := range metrics {
+		name := fmt.Sprintf("metric.%05d", i)
+		switch {
+		case i%10 == 0:
+			metrics[i] = models.Metrics{ID: name, MType: "histogram", Histogram: &models.Histogram{
+				Bounds: []float64{0.1, 1, 10}, Counts: []uint64{1, 2, 3, 0}, Sum: 20, Count: 6,
+			}}
+		case i%2 == 0:
+			delta := int64(i)
+			metrics[i] = models.Metrics{ID: name, MType: "counter", Delta: &delta}
+		default:
+			value := float64(i) + 0.5
+			metrics[i] = models.Metrics{ID: name, MType: "gauge", Value: &value}
+		}
+	}
+
+	return metrics
+}
+
+func BenchmarkCollectMetrics(b *testing.B) {
+	metrics := benchmarkBatch(10000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := collectMetrics(metrics); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkUpdateBatchMetrics сравнивает запись батча из 10000 метрик многострочными upsert
+// и по одной метрике. Нужна СУБД: TEST_DATABASE_DSN=postgres://... go test -bench UpdateBatchMetrics -run ^$
+// Таблицы создаются в отдельной схеме, которая удаляется после замера.
+func BenchmarkUpdateBatchMetrics(b *testing.B) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		b.Skip("TEST_DATABASE_DSN is not set")
+	}
+	ctx := context.Background()
+
+	s := &Store{Schema: "bench_metrics"}
+	var err error
+	s.Pool, err = OpenPool(ctx, dsn, s.Schema, config.PoolConfig{})
+	require.NoError(b, err)
+	defer s.Close()
+	require.NoError(b, s.migrate(ctx))
+	defer s.Pool.Exec(ctx, `DROP SCHEMA bench_metrics CASCADE`)
+
+	metrics := benchmarkBatch(10000)
+
+	b.Run("bulk", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			errs, err := s.UpdateBatchMetrics(ctx, models.Batch{Mode: models.BatchTransactional, Metrics: metrics})
+			require.NoError(b, err)
+			for _, err := range errs {
+				require.NoError(b, err)
+			}
+		}
+		b.ReportMetric(float64(len(metrics)*b.N)/b.Elapsed().Seconds(), "metrics/s")
+	})
+
+	// прежний способ записи: отдельный запрос на каждую метрику
+	b.Run("per_metric", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			err := pgx.BeginFunc(ctx, s.Pool, func(tx pgx.Tx) error {
+				for _, m := range metrics {
+					if err := updateMetric(ctx, tx, m); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			require.NoError(b, err)
+		}
+		b.ReportMetric(float64(len(metrics)*b.N)/b.Elapsed().Seconds(), "metrics/s")
+	})
+}
@@ -3,115 +3,80 @@ package pg
 import (
 	"context"
 	"database/sql"
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/config"
 	"github.com/webkimru/go-yandex-metrics/internal/app/server/logger"
 	"time"
 )
 
-// Bootstrap подготавливает БД к работе, создавая необходимые таблицы и индексы
-func Bootstrap(ctx context.Context, conn *sql.DB) error {
-	// запускаем транзакцию
-	tx, err := conn.BeginTx(ctx, nil)
+// Schema схема метрик без изоляции тенантов и таблицы токенов.
+const Schema = "metrics"
+
+// OpenPool открывает пул соединений pgx, в котором таблицы без схемы ищутся в схеме schema.
+// Запросы подготавливаются один раз на соединение и берутся из кэша; нулевые настройки пула - значения pgx.
+func OpenPool(ctx context.Context, dsn, schema string, pool config.PoolConfig) (*pgxpool.Pool, error) {
+	cfg, err := pgxpool.ParseConfig(dsn)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	cfg.ConnConfig.RuntimeParams["search_path"] = schema
+	cfg.ConnConfig.DefaultQueryExecMode = pgx.QueryExecModeCacheStatement
+	if pool.StatementCache > 0 {
+		cfg.ConnConfig.StatementCacheCapacity = pool.StatementCache
+	}
+	if pool.MaxConns > 0 {
+		cfg.MaxConns = int32(pool.MaxConns)
+	}
+	if pool.MinConns > 0 {
+		cfg.MinConns = int32(pool.MinConns)
+	}
+	if pool.MaxConnLifetime > 0 {
+		cfg.MaxConnLifetime = time.Duration(pool.MaxConnLifetime) * time.Second
+	}
+	if pool.MaxConnIdleTime > 0 {
+		cfg.MaxConnIdleTime = time.Duration(pool.MaxConnIdleTime) * time.Second
 	}
 
-	// в случае неуспешного коммита все изменения транзакции будут отменены
-	defer tx.Rollback()
-
-	// создаем схему
-	tx.ExecContext(ctx, `CREATE SCHEMA IF NOT EXISTS metrics`)
-	tx.ExecContext(ctx, `SET search_path TO metrics`)
-
-	// создаём таблицу counter и необходимые индексы
-	tx.ExecContext(ctx, `
-		CREATE TABLE IF NOT EXISTS counters (
-			id BIGSERIAL PRIMARY KEY,
-			name VARCHAR(50) NOT NULL,
-			delta BIGINT NOT NULL,
-			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
-			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
-		)
-	`)
-	tx.ExecContext(ctx, `CREATE UNIQUE INDEX IF NOT EXISTS metric_idx ON counters (name)`)
-
-	// создаём таблицу counter и необходимые индексы
-	tx.ExecContext(ctx, `
-		CREATE TABLE IF NOT EXISTS gauges (
-			id BIGSERIAL PRIMARY KEY,
-			name VARCHAR(50) NOT NULL,
-			value DOUBLE PRECISION NOT NULL,
-			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
-			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
-		)
-	`)
-	tx.ExecContext(ctx, `CREATE UNIQUE INDEX IF NOT EXISTS gauge_idx ON gauges (name)`)
-
-	// триггер для поля updated_at
-	tx.ExecContext(ctx, `
-		CREATE OR REPLACE FUNCTION updated_at()
-		RETURNS TRIGGER AS $$
-		BEGIN
-			NEW.updated_at = now();
-			RETURN NEW;
-		END;
-		$$ language 'plpgsql';
-	`)
-	tx.ExecContext(ctx, `
-		DO
-		$$BEGIN
-			CREATE TRIGGER gauges_updated_at
-				BEFORE UPDATE
-				ON
-					metrics.gauges
-				FOR EACH ROW
-			EXECUTE PROCEDURE updated_at();
-		EXCEPTION
-		   WHEN duplicate_object THEN
-			  NULL;
-		END;$$;
-	`)
-	tx.ExecContext(ctx, `
-		DO
-		$$BEGIN
-			CREATE TRIGGER counters_updated_at
-				BEFORE UPDATE
-				ON
-					metrics.counters
-				FOR EACH ROW
-			EXECUTE PROCEDURE updated_at();
-		EXCEPTION
-		   WHEN duplicate_object THEN
-			  NULL;
-		END;$$;
-	`)
+	p, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err = p.Ping(ctx); err != nil {
+		p.Close()
+		return nil, err
+	}
 
-	// коммитим транзакцию
-	return tx.Commit()
+	return p, nil
 }
 
-func OpenDB(dsn string) (*sql.DB, error) {
-	db, err := sql.Open("pgx", dsn)
+// OpenDB открывает пул соединений database/sql, в котором таблицы без схемы ищутся в схеме schema.
+func OpenDB(dsn, schema string) (*sql.DB, error) {
+	config, err := pgx.ParseConfig(dsn)
 	if err != nil {
 		return nil, err
 	}
+	config.RuntimeParams["search_path"] = schema
+	db := stdlib.OpenDB(*config)
 
 	err = db.Ping()
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
 
 	return db, nil
 }
 
-func ConnectToDB(dsn string) (*sql.DB, error) {
+func ConnectToDB(ctx context.Context, dsn, schema string, pool config.PoolConfig) (*pgxpool.Pool, error) {
 	// ретраи на переподключени к базе при старте
 	// 1s, 3s, 5s
 	backoff := [3]int{1, 3, 5}
 	var cnt = 0
 
 	for {
-		connection, err := OpenDB(dsn)
+		connection, err := OpenPool(ctx, dsn, schema, pool)
 		if err != nil {
 			logger.Log.Infoln("Postgres not yet ready...")
 			cnt++
@@ -0,0 +1,280 @@
+package pg
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/logger"
+)
+
+// Latest версия схемы с применением всех миграций.
+const Latest = -1
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationName имя файла миграции: версия, название и направление.
+var migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// schemaDirective первая строка файла миграции, ограничивающая ее одной схемой.
+const schemaDirective = "-- schema:"
+
+// Migration изменение схемы с номером версии.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+	// Schema схема, к которой применяется миграция, например общая таблица токенов только в Schema.
+	// Пусто - все схемы метрик, в том числе схемы тенантов.
+	Schema string
+}
+
+// appliesTo сообщает, меняет ли миграция схему schema. Неприменимая миграция только отмечается.
+func (m Migration) appliesTo(schema string) bool {
+	return m.Schema == "" || m.Schema == schema
+}
+
+// Migrations возвращает встроенные миграции по возрастанию версии.
+func Migrations() ([]Migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := migrationName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %s", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		data, err := migrationFiles.ReadFile("migrations/" + entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has different names %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(data)
+			m.Schema = directiveSchema(m.Up)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	res := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s must have both up and down files", m.Version, m.Name)
+		}
+		res = append(res, *m)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Version < res[j].Version
+	})
+
+	return res, nil
+}
+
+// directiveSchema возвращает схему из первой строки вида "-- schema: metrics".
+func directiveSchema(sql string) string {
+	line, _, _ := strings.Cut(sql, "\n")
+	if schema, ok := strings.CutPrefix(line, schemaDirective); ok {
+		return strings.TrimSpace(schema)
+	}
+	return ""
+}
+
+// Migrate приводит схему schema к версии target, Latest - к последней: применяет недостающие миграции
+// по возрастанию версии или откатывает лишние по убыванию. Каждая миграция выполняется в своей транзакции
+// и отмечается в таблице schema_migrations схемы. Серверы, одновременно мигрирующие одну схему,
+// ждут друг друга на advisory lock.
+func Migrate(ctx context.Context, db *sql.DB, schema string, target int) error {
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+	if target == Latest && len(migrations) > 0 {
+		target = migrations[len(migrations)-1].Version
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// блокировка сессии, поэтому все запросы идут через одно соединение conn
+	key := lockKey(schema)
+	if _, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, key); err != nil {
+		return fmt.Errorf("failed to lock migrations of schema %s: %w", schema, err)
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, key)
+
+	identifier := pgx.Identifier{schema}.Sanitize()
+	if _, err = conn.ExecContext(ctx, `CREATE SCHEMA IF NOT EXISTS `+identifier); err != nil {
+		return err
+	}
+	if _, err = conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS `+identifier+`.schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name VARCHAR(100) NOT NULL,
+			applied_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)
+	`); err != nil {
+		return err
+	}
+
+	applied, err := appliedVersions(ctx, conn, identifier)
+	if err != nil {
+		return err
+	}
+	known := make(map[int]bool, len(migrations))
+	for _, m := range migrations {
+		known[m.Version] = true
+	}
+	for version := range applied {
+		if !known[version] {
+			return fmt.Errorf("schema %s has unknown migration %d: database is newer than this server", schema, version)
+		}
+	}
+
+	for _, m := range migrations {
+		if m.Version <= target && !applied[m.Version] {
+			if err := migrate(ctx, conn, schema, m, true); err != nil {
+				return err
+			}
+		}
+	}
+	for i := len(migrations) - 1; i >= 0; i-- {
+		if m := migrations[i]; m.Version > target && applied[m.Version] {
+			if err := migrate(ctx, conn, schema, m, false); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// migrate применяет (up) или откатывает миграцию в транзакции вместе с отметкой в schema_migrations.
+func migrate(ctx context.Context, conn *sql.Conn, schema string, m Migration, up bool) error {
+	direction, query := "up", m.Up
+	if !up {
+		direction, query = "down", m.Down
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// в случае неуспешного коммита все изменения транзакции будут отменены
+	defer tx.Rollback()
+
+	identifier := pgx.Identifier{schema}.Sanitize()
+	if _, err = tx.ExecContext(ctx, `SET LOCAL search_path TO `+identifier); err != nil {
+		return err
+	}
+	if m.appliesTo(schema) {
+		if _, err = tx.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("migration %d_%s %s failed in schema %s: %w", m.Version, m.Name, direction, schema, err)
+		}
+	}
+	if up {
+		_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.Version, m.Name)
+	} else {
+		_, err = tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, m.Version)
+	}
+	if err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	logger.Log.Infof("migration %d_%s %s applied in schema %s", m.Version, m.Name, direction, schema)
+
+	return nil
+}
+
+func appliedVersions(ctx context.Context, conn *sql.Conn, identifier string) (map[int]bool, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version FROM `+identifier+`.schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+
+	// Не забываем закрыть курсор после завершения работы с данными.
+	defer rows.Close()
+
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var version int
+		if err = rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+
+	return applied, rows.Err()
+}
+
+// Version возвращает версию схемы schema - последнюю примененную миграцию, 0 - миграций нет.
+func Version(ctx context.Context, db *sql.DB, schema string) (int, error) {
+	identifier := pgx.Identifier{schema}.Sanitize()
+	// схема, созданная до появления миграций, еще без таблицы schema_migrations
+	var exists bool
+	if err := db.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, identifier+".schema_migrations").Scan(&exists); err != nil || !exists {
+		return 0, err
+	}
+	var version sql.NullInt64
+	if err := db.QueryRowContext(ctx, `SELECT max(version) FROM `+identifier+`.schema_migrations`).Scan(&version); err != nil {
+		return 0, err
+	}
+
+	return int(version.Int64), nil
+}
+
+// Schemas возвращает схему Schema и существующие схемы тенантов.
+func Schemas(ctx context.Context, db *sql.DB) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT schema_name FROM information_schema.schemata
+		WHERE schema_name LIKE $1 ORDER BY schema_name
+	`, Schema+`\_%`)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	res := []string{Schema}
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		res = append(res, name)
+	}
+
+	return res, rows.Err()
+}
+
+// lockKey ключ advisory lock миграций схемы: разные схемы мигрируются независимо.
+func lockKey(schema string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("go-yandex-metrics/migrate/" + schema))
+	return int64(h.Sum64())
+}
@@ -0,0 +1,51 @@
+package pg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrations(t *testing.T) {
+	migrations, err := Migrations()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	for i, m := range migrations {
+		// версии идут по порядку без пропусков
+		assert.Equal(t, i+1, m.Version)
+		assert.NotEmpty(t, m.Name)
+		assert.NotEmpty(t, m.Up)
+		assert.NotEmpty(t, m.Down)
+	}
+
+	tokens := migrations[2]
+	assert.Equal(t, "tokens", tokens.Name)
+	assert.Equal(t, Schema, tokens.Schema)
+	assert.True(t, tokens.appliesTo(Schema))
+	assert.False(t, tokens.appliesTo(Schema+"_team_a"))
+	assert.True(t, migrations[0].appliesTo(Schema+"_team_a"))
+}
+
+func TestDirectiveSchema(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want string
+	}{
+		{"directive", "-- schema: metrics\nCREATE TABLE t ();", "metrics"},
+		{"without directive", "CREATE TABLE t ();", ""},
+		{"directive not on first line", "CREATE TABLE t ();\n-- schema: metrics", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, directiveSchema(tt.sql))
+		})
+	}
+}
+
+func TestLockKey(t *testing.T) {
+	assert.Equal(t, lockKey(Schema), lockKey(Schema))
+	assert.NotEqual(t, lockKey(Schema), lockKey(Schema+"_team_a"))
+}
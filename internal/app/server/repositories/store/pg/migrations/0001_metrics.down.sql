@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS histograms;
+DROP TABLE IF EXISTS gauges;
+DROP TABLE IF EXISTS counters;
+DROP FUNCTION IF EXISTS updated_at();
@@ -0,0 +1,46 @@
+-- таблицы метрик; IF NOT EXISTS - схема могла быть создана до появления миграций
+CREATE TABLE IF NOT EXISTS counters (
+	id BIGSERIAL PRIMARY KEY,
+	name VARCHAR(50) NOT NULL,
+	delta BIGINT NOT NULL,
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+	updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS metric_idx ON counters (name);
+
+CREATE TABLE IF NOT EXISTS gauges (
+	id BIGSERIAL PRIMARY KEY,
+	name VARCHAR(50) NOT NULL,
+	value DOUBLE PRECISION NOT NULL,
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+	updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS gauge_idx ON gauges (name);
+
+CREATE TABLE IF NOT EXISTS histograms (
+	id BIGSERIAL PRIMARY KEY,
+	name VARCHAR(50) NOT NULL,
+	bounds JSONB NOT NULL,
+	counts JSONB NOT NULL,
+	sum DOUBLE PRECISION NOT NULL,
+	count BIGINT NOT NULL,
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+	updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS histogram_idx ON histograms (name);
+
+-- триггер для поля updated_at
+CREATE OR REPLACE FUNCTION updated_at()
+RETURNS TRIGGER AS $$
+BEGIN
+	NEW.updated_at = now();
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS gauges_updated_at ON gauges;
+CREATE TRIGGER gauges_updated_at BEFORE UPDATE ON gauges FOR EACH ROW EXECUTE PROCEDURE updated_at();
+DROP TRIGGER IF EXISTS counters_updated_at ON counters;
+CREATE TRIGGER counters_updated_at BEFORE UPDATE ON counters FOR EACH ROW EXECUTE PROCEDURE updated_at();
+DROP TRIGGER IF EXISTS histograms_updated_at ON histograms;
+CREATE TRIGGER histograms_updated_at BEFORE UPDATE ON histograms FOR EACH ROW EXECUTE PROCEDURE updated_at();
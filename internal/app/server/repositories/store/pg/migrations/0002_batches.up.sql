@@ -0,0 +1,6 @@
+-- идентификаторы примененных батчей для отбрасывания повторов
+CREATE TABLE IF NOT EXISTS batches (
+	id VARCHAR(64) PRIMARY KEY,
+	applied_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS batch_applied_idx ON batches (applied_at);
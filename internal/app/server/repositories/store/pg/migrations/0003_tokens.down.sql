@@ -0,0 +1,2 @@
+-- schema: metrics
+DROP TABLE IF EXISTS tokens;
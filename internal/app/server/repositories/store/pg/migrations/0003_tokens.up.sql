@@ -0,0 +1,12 @@
+-- schema: metrics
+-- токены доступа: хранится только хеш токена, таблица общая для всех тенантов
+CREATE TABLE IF NOT EXISTS tokens (
+	id VARCHAR(32) PRIMARY KEY,
+	name VARCHAR(100) NOT NULL DEFAULT '',
+	hash VARCHAR(64) NOT NULL,
+	scopes VARCHAR(50) NOT NULL,
+	prefix VARCHAR(50) NOT NULL DEFAULT '',
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+-- таблица могла быть создана до появления тенантов
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS tenant VARCHAR(32) NOT NULL DEFAULT '';
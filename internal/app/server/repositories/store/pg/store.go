@@ -3,108 +3,174 @@ package pg
 
 import (
 	"context"
-	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
 	"github.com/webkimru/go-yandex-metrics/internal/app/server/config"
 	"github.com/webkimru/go-yandex-metrics/internal/app/server/logger"
 	"github.com/webkimru/go-yandex-metrics/internal/app/server/models"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories"
+	"strings"
 )
 
 var DB *Store
 
 // Store реализует интерфейс store.StoreRepository и позволяет взаимодействовать с СУБД PostgreSQL.
 type Store struct {
-	// Поле conn содержит объект соединения с СУБД.
-	Conn *sql.DB
+	// Pool пул соединений с СУБД, подготовленные запросы кэшируются в каждом соединении.
+	Pool *pgxpool.Pool
+	// Schema схема таблиц метрик, по умолчанию pg.Schema.
+	Schema string
 }
 
 // NewStore возвращает новый экземпляр PostgreSQL-хранилища.
-func NewStore(conn *sql.DB) *Store {
-	return &Store{Conn: conn}
+func NewStore(pool *pgxpool.Pool) *Store {
+	return &Store{Pool: pool}
 }
 
 // UpdateCounter обновляет поле Counter с использованием конструкции INSERT INTO ... ON CONFLICT ... UPDATE.
 func (s *Store) UpdateCounter(ctx context.Context, name string, value int64) (int64, error) {
-	stmt, err := s.Conn.PrepareContext(ctx, `
-		INSERT INTO metrics.counters (name, delta) VALUES($1, $2)
-			ON CONFLICT (name) DO
-		    	UPDATE SET delta = metrics.counters.delta + $2 RETURNING delta
-	`)
-	if err != nil {
-		return 0, err
-	}
-
 	var res int64
-	err = stmt.QueryRowContext(ctx, name, value).Scan(&res)
+	err := s.Pool.QueryRow(ctx, `
+		INSERT INTO counters (name, delta) VALUES($1, $2)
+			ON CONFLICT (name) DO
+		    	UPDATE SET delta = counters.delta + $2 RETURNING delta
+	`, name, value).Scan(&res)
 	if err != nil {
 		return 0, err
 	}
-	defer stmt.Close()
 
 	return res, nil
 }
 
 // UpdateGauge обновляет поле Gauge с использованием конструкции INSERT INTO ... ON CONFLICT ... UPDATE.
 func (s *Store) UpdateGauge(ctx context.Context, name string, value float64) (float64, error) {
-	stmt, err := s.Conn.PrepareContext(ctx, `
-		INSERT INTO metrics.gauges (name, value) VALUES($1, $2)
+	var res float64
+	err := s.Pool.QueryRow(ctx, `
+		INSERT INTO gauges (name, value) VALUES($1, $2)
 			ON CONFLICT (name) DO
 		    	UPDATE SET value = $2 RETURNING value
-	`)
+	`, name, value).Scan(&res)
 	if err != nil {
 		return 0, err
 	}
 
-	var res float64
-	err = stmt.QueryRowContext(ctx, name, value).Scan(&res)
+	return res, nil
+}
+
+// querier общий набор методов *pgxpool.Pool и pgx.Tx.
+type querier interface {
+	Exec(ctx context.Context, query string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, query string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, query string, args ...any) pgx.Row
+}
+
+// UpdateHistogram объединяет полученное распределение с накопленным.
+// Строка блокируется на время слияния, чтобы параллельные агенты не потеряли наблюдения.
+func (s *Store) UpdateHistogram(ctx context.Context, name string, value models.Histogram) (models.Histogram, error) {
+	tx, err := s.Pool.Begin(ctx)
 	if err != nil {
-		return 0, err
+		return models.Histogram{}, err
 	}
-	defer stmt.Close()
 
-	return res, nil
+	defer tx.Rollback(ctx)
+
+	res, err := updateHistogram(ctx, tx, name, value)
+	if err != nil {
+		return models.Histogram{}, err
+	}
+
+	return res, tx.Commit(ctx)
 }
 
-// GetCounter возращает значение счетчика Counter.
-func (s *Store) GetCounter(ctx context.Context, metric string) (int64, error) {
-	stmt, err := s.Conn.PrepareContext(ctx, `
-		SELECT delta FROM metrics.counters
-		WHERE name = $1
-	`)
+func updateHistogram(ctx context.Context, q querier, name string, value models.Histogram) (models.Histogram, error) {
+	current, err := getHistogram(ctx, q, name, true)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return models.Histogram{}, err
+	}
+	res := current.Merge(value)
+
+	bounds, err := json.Marshal(res.Bounds)
 	if err != nil {
-		return 0, err
+		return models.Histogram{}, err
+	}
+	counts, err := json.Marshal(res.Counts)
+	if err != nil {
+		return models.Histogram{}, err
+	}
+	_, err = q.Exec(ctx, `
+		INSERT INTO histograms (name, bounds, counts, sum, count) VALUES($1, $2, $3, $4, $5)
+			ON CONFLICT (name) DO
+				UPDATE SET bounds = $2, counts = $3, sum = $4, count = $5
+	`, name, bounds, counts, res.Sum, int64(res.Count))
+	if err != nil {
+		return models.Histogram{}, err
 	}
 
+	return res, nil
+}
+
+// GetCounter возращает значение счетчика Counter.
+func (s *Store) GetCounter(ctx context.Context, metric string) (int64, error) {
 	var res int64
-	err = stmt.QueryRowContext(ctx, metric).Scan(&res)
+	err := s.Pool.QueryRow(ctx, `
+		SELECT delta FROM counters
+		WHERE name = $1
+	`, metric).Scan(&res)
 	if err != nil {
 		return 0, err
 	}
-	defer stmt.Close()
 
 	return res, nil
 }
 
 // GetGauge возращает значение счетчика Gauge.
 func (s *Store) GetGauge(ctx context.Context, metric string) (float64, error) {
-	stmt, err := s.Conn.PrepareContext(ctx, `
-		SELECT value FROM metrics.gauges
+	var res float64
+	err := s.Pool.QueryRow(ctx, `
+		SELECT value FROM gauges
 		WHERE name = $1
-	`)
+	`, metric).Scan(&res)
 	if err != nil {
 		return 0, err
 	}
 
-	var res float64
-	err = stmt.QueryRowContext(ctx, metric).Scan(&res)
-	if err != nil {
-		return 0, err
+	return res, nil
+}
+
+// GetHistogram возращает распределение Histogram.
+func (s *Store) GetHistogram(ctx context.Context, metric string) (models.Histogram, error) {
+	return getHistogram(ctx, s.Pool, metric, false)
+}
+
+func getHistogram(ctx context.Context, q querier, metric string, forUpdate bool) (models.Histogram, error) {
+	query := `SELECT bounds, counts, sum, count FROM histograms WHERE name = $1`
+	if forUpdate {
+		query += ` FOR UPDATE`
 	}
-	defer stmt.Close()
+
+	var res models.Histogram
+	var bounds, counts []byte
+	var count int64
+	if err := q.QueryRow(ctx, query, metric).Scan(&bounds, &counts, &res.Sum, &count); err != nil {
+		return models.Histogram{}, err
+	}
+	if err := json.Unmarshal(bounds, &res.Bounds); err != nil {
+		return models.Histogram{}, err
+	}
+	if err := json.Unmarshal(counts, &res.Counts); err != nil {
+		return models.Histogram{}, err
+	}
+	res.Count = uint64(count)
 
 	return res, nil
 }
 
-// GetAllMetrics возращает мапку счетчиков Counter и Gauge.
+// GetAllMetrics возращает мапку счетчиков Counter, Gauge и Histogram.
 func (s *Store) GetAllMetrics(ctx context.Context) (map[string]interface{}, error) {
 	all := make(map[string]interface{}, 30)
 
@@ -122,6 +188,13 @@ func (s *Store) GetAllMetrics(ctx context.Context) (map[string]interface{}, erro
 	}
 	all["counter"] = counter
 
+	// histogram
+	histogram, err := s.GetHistogramMetrics(ctx)
+	if err != nil {
+		return nil, err
+	}
+	all["histogram"] = histogram
+
 	return all, nil
 }
 
@@ -130,12 +203,7 @@ func (s *Store) GetGaugeMetrics(ctx context.Context) (map[string]float64, error)
 	// По умолчанию до 30 метрик данного типа.
 	gauges := make(map[string]float64, 30)
 
-	stmt, err := s.Conn.PrepareContext(ctx, `SELECT name, value FROM metrics.gauges`)
-	if err != nil {
-		return nil, err
-	}
-
-	rows, err := stmt.QueryContext(ctx)
+	rows, err := s.Pool.Query(ctx, `SELECT name, value FROM gauges`)
 	if err != nil {
 		return nil, err
 	}
@@ -167,12 +235,7 @@ func (s *Store) GetCounterMetrics(ctx context.Context) (map[string]int64, error)
 	// По умолчанию 1 метрика данного типа.
 	counters := make(map[string]int64, 1)
 
-	stmt, err := s.Conn.PrepareContext(ctx, `SELECT name, delta FROM metrics.counters`)
-	if err != nil {
-		return nil, err
-	}
-
-	rows, err := stmt.QueryContext(ctx)
+	rows, err := s.Pool.Query(ctx, `SELECT name, delta FROM counters`)
 	if err != nil {
 		return nil, err
 	}
@@ -199,54 +262,420 @@ func (s *Store) GetCounterMetrics(ctx context.Context) (map[string]int64, error)
 	return counters, nil
 }
 
-// UpdateBatchMetrics обновляет значение метрик Gauge и Counter по входящему батчу.
-func (s *Store) UpdateBatchMetrics(ctx context.Context, metrics []models.Metrics) error {
-	tx, err := s.Conn.BeginTx(ctx, nil)
+// GetHistogramMetrics возращает все метрики Histogram и их значения в виде мапки
+func (s *Store) GetHistogramMetrics(ctx context.Context) (map[string]models.Histogram, error) {
+	histograms := make(map[string]models.Histogram)
+
+	rows, err := s.Pool.Query(ctx, `SELECT name, bounds, counts, sum, count FROM histograms`)
+	if err != nil {
+		return nil, err
+	}
+
+	// Не забываем закрыть курсор после завершения работы с данными.
+	defer rows.Close()
+
+	// Считываем записи.
+	for rows.Next() {
+		var idx string
+		var h models.Histogram
+		var bounds, counts []byte
+		var count int64
+		if err = rows.Scan(&idx, &bounds, &counts, &h.Sum, &count); err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(bounds, &h.Bounds); err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(counts, &h.Counts); err != nil {
+			return nil, err
+		}
+		h.Count = uint64(count)
+		histograms[idx] = h
+	}
+
+	// Необходимо проверить ошибки уровня курсора.
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return histograms, nil
+}
+
+// listSources подзапросы выборки по типам метрик с общим набором колонок.
+var listSources = map[string]string{
+	"counter": `SELECT 'counter' AS type, name, delta, NULL::DOUBLE PRECISION AS value,
+		NULL::JSONB AS bounds, NULL::JSONB AS counts, NULL::DOUBLE PRECISION AS sum, NULL::BIGINT AS count
+		FROM counters`,
+	"gauge":     `SELECT 'gauge', name, NULL, value, NULL, NULL, NULL, NULL FROM gauges`,
+	"histogram": `SELECT 'histogram', name, NULL, NULL, bounds, counts, sum, count FROM histograms`,
+}
+
+// ListMetrics возвращает страницу метрик, отобранных по типу и имени, в заданном порядке.
+// Фильтрация, сортировка и постраничная выборка по курсору выполняются на стороне СУБД.
+func (s *Store) ListMetrics(ctx context.Context, opts models.ListOptions) ([]models.Metrics, error) {
+	var sources []string
+	for _, t := range []string{"counter", "gauge", "histogram"} {
+		if opts.HasType(t) {
+			sources = append(sources, listSources[t])
+		}
+	}
+	if len(sources) == 0 {
+		return nil, nil
+	}
+
+	var where []string
+	var args []any
+	if opts.NameRegex != "" {
+		args = append(args, opts.NameRegex)
+		where = append(where, fmt.Sprintf("name ~ $%d", len(args)))
+	}
+	if opts.Prefix != "" {
+		args = append(args, opts.Prefix)
+		where = append(where, fmt.Sprintf("starts_with(name, $%d)", len(args)))
+	}
+	// сравнение строк побайтово (COLLATE "C"), как и при сортировке в памяти
+	key := `name COLLATE "C", type COLLATE "C"`
+	if opts.SortBy == models.SortByType {
+		key = `type COLLATE "C", name COLLATE "C"`
+	}
+	op, order := ">", "ASC"
+	if opts.Desc {
+		op, order = "<", "DESC"
+	}
+	if opts.After != nil {
+		first, second := opts.After.ID, opts.After.MType
+		if opts.SortBy == models.SortByType {
+			first, second = second, first
+		}
+		args = append(args, first, second)
+		where = append(where, fmt.Sprintf("(%s) %s ($%d, $%d)", key, op, len(args)-1, len(args)))
+	}
+
+	query := "SELECT type, name, delta, value, bounds, counts, sum, count FROM (" +
+		strings.Join(sources, " UNION ALL ") + ") m"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY " + strings.ReplaceAll(key, ",", " "+order+",") + " " + order
+	if opts.Limit > 0 {
+		args = append(args, opts.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := s.Pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Не забываем закрыть курсор после завершения работы с данными.
+	defer rows.Close()
+
+	var res []models.Metrics
+	for rows.Next() {
+		var m models.Metrics
+		var delta, count *int64
+		var value, sum *float64
+		var bounds, counts []byte
+		if err = rows.Scan(&m.MType, &m.ID, &delta, &value, &bounds, &counts, &sum, &count); err != nil {
+			return nil, err
+		}
+		switch m.MType {
+		case "counter":
+			m.Delta = delta
+		case "gauge":
+			m.Value = value
+		case "histogram":
+			if sum == nil || count == nil {
+				return nil, fmt.Errorf("histogram %s: null sum or count", m.ID)
+			}
+			h := models.Histogram{Sum: *sum, Count: uint64(*count)}
+			if err = json.Unmarshal(bounds, &h.Bounds); err != nil {
+				return nil, err
+			}
+			if err = json.Unmarshal(counts, &h.Counts); err != nil {
+				return nil, err
+			}
+			m.Histogram = &h
+		}
+		res = append(res, m)
+	}
+
+	// Необходимо проверить ошибки уровня курсора.
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// tables таблицы хранения по типам метрик.
+var tables = map[string]string{
+	"counter":   "counters",
+	"gauge":     "gauges",
+	"histogram": "histograms",
+}
+
+// DeleteMetric удаляет метрику заданного типа.
+func (s *Store) DeleteMetric(ctx context.Context, mType, name string) error {
+	table, ok := tables[mType]
+	if !ok {
+		return fmt.Errorf("unknown metric type=%s", mType)
+	}
+
+	res, err := s.Pool.Exec(ctx, `DELETE FROM `+table+` WHERE name = $1`, name)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(res, mType, name)
+}
+
+// ResetCounter обнуляет счетчик Counter.
+func (s *Store) ResetCounter(ctx context.Context, name string) error {
+	res, err := s.Pool.Exec(ctx, `UPDATE counters SET delta = 0 WHERE name = $1`, name)
 	if err != nil {
 		return err
 	}
 
-	defer tx.Rollback()
+	return checkAffected(res, "counter", name)
+}
+
+func checkAffected(res pgconn.CommandTag, mType, name string) error {
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%s %s: %w", mType, name, repositories.ErrNotFound)
+	}
+
+	return nil
+}
+
+// DeleteMetrics удаляет метрики, отобранные по типу и имени, и возвращает их количество.
+// Удаление из всех таблиц выполняется в одной транзакции.
+func (s *Store) DeleteMetrics(ctx context.Context, filter models.MetricFilter) (int64, error) {
+	tx, err := s.Pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	defer tx.Rollback(ctx)
+
+	var deleted int64
+	for _, t := range []string{"counter", "gauge", "histogram"} {
+		if !filter.HasType(t) {
+			continue
+		}
+		var where []string
+		var args []any
+		if filter.NameRegex != "" {
+			args = append(args, filter.NameRegex)
+			where = append(where, fmt.Sprintf("name ~ $%d", len(args)))
+		}
+		if filter.Prefix != "" {
+			args = append(args, filter.Prefix)
+			where = append(where, fmt.Sprintf("starts_with(name, $%d)", len(args)))
+		}
+		query := `DELETE FROM ` + tables[t]
+		if len(where) > 0 {
+			query += " WHERE " + strings.Join(where, " AND ")
+		}
+		res, err := tx.Exec(ctx, query, args...)
+		if err != nil {
+			return 0, err
+		}
+		deleted += res.RowsAffected()
+	}
+
+	return deleted, tx.Commit(ctx)
+}
+
+// UpdateBatchMetrics обновляет значение метрик Gauge, Counter и Histogram по входящему батчу.
+// Метрики записываются многострочными upsert, по одному на тип метрики, отправленными за один обмен с СУБД.
+// Ошибку многострочного upsert нельзя отнести к метрике, поэтому после нее батч применяется по одной метрике:
+// в транзакционном режиме ошибка любой метрики отменяет весь батч, в режиме best effort каждая
+// метрика применяется внутри своей точки сохранения, и ошибка откатывает только ее.
+// Идентификатор батча записывается в той же транзакции, поэтому параллельный повтор
+// дождется ее завершения и будет отброшен.
+func (s *Store) UpdateBatchMetrics(ctx context.Context, batch models.Batch) ([]error, error) {
+	tx, err := s.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback(ctx)
+
+	if batch.ID != "" {
+		if err = rememberBatch(ctx, tx, batch.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err = tx.Exec(ctx, `SAVEPOINT bulk`); err != nil {
+		return nil, err
+	}
+	if err = upsertMetrics(ctx, tx, batch.Metrics); err == nil {
+		return make([]error, len(batch.Metrics)), tx.Commit(ctx)
+	}
+	logger.Log.Infof("bulk upsert of %d metrics failed, applying them one by one: %v", len(batch.Metrics), err)
+	if _, err = tx.Exec(ctx, `ROLLBACK TO SAVEPOINT bulk`); err != nil {
+		return nil, err
+	}
+
+	metrics, mode := batch.Metrics, batch.Mode
 
+	errs := make([]error, len(metrics))
 	for i := range metrics {
-		switch metrics[i].MType {
-		case "gauge":
-			_, err = tx.ExecContext(ctx, `
-				INSERT INTO metrics.gauges (name, value) VALUES($1, $2)
-					ON CONFLICT (name) DO
-						UPDATE SET value = $2 RETURNING value
-			`, metrics[i].ID, metrics[i].Value)
-			if err != nil {
-				logger.Log.Errorln(err)
+		if mode == models.BatchBestEffort {
+			if _, err = tx.Exec(ctx, `SAVEPOINT item`); err != nil {
+				return nil, err
 			}
+		}
 
-		case "counter":
-			_, err = tx.ExecContext(ctx, `
-				INSERT INTO metrics.counters (name, delta) VALUES($1, $2)
-					ON CONFLICT (name) DO
-						UPDATE SET delta = metrics.counters.delta + $2 RETURNING delta
-			`, metrics[i].ID, metrics[i].Delta)
-			if err != nil {
-				logger.Log.Errorln(err)
+		if err = updateMetric(ctx, tx, metrics[i]); err == nil {
+			if mode == models.BatchBestEffort {
+				if _, err = tx.Exec(ctx, `RELEASE SAVEPOINT item`); err != nil {
+					return nil, err
+				}
 			}
+			continue
+		}
+
+		logger.Log.Errorln(err)
+		errs[i] = err
+		if mode == models.BatchTransactional {
+			for j := range errs {
+				if errs[j] == nil {
+					errs[j] = models.ErrBatchRolledBack
+				}
+			}
+			return errs, nil
+		}
+		if _, err = tx.Exec(ctx, `ROLLBACK TO SAVEPOINT item`); err != nil {
+			return nil, err
 		}
 	}
 
-	return tx.Commit()
+	return errs, tx.Commit(ctx)
+}
+
+// rememberBatch записывает идентификатор батча, удаляя идентификаторы с истекшим временем жизни.
+// Для уже записанного идентификатора возвращает repositories.ErrDuplicateBatch.
+func rememberBatch(ctx context.Context, tx pgx.Tx, id string) error {
+	_, err := tx.Exec(ctx, `
+		DELETE FROM batches WHERE applied_at < NOW() - $1 * INTERVAL '1 second'
+	`, repositories.BatchIDTTL.Seconds())
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.Exec(ctx, `INSERT INTO batches (id) VALUES($1) ON CONFLICT (id) DO NOTHING`, id)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return repositories.ErrDuplicateBatch
+	}
+
+	return nil
+}
+
+// updateMetric обновляет одну метрику батча.
+func updateMetric(ctx context.Context, q querier, metric models.Metrics) error {
+	var err error
+	switch metric.MType {
+	case "gauge":
+		_, err = q.Exec(ctx, `
+			INSERT INTO gauges (name, value) VALUES($1, $2)
+				ON CONFLICT (name) DO
+					UPDATE SET value = $2
+		`, metric.ID, metric.Value)
+
+	case "counter":
+		_, err = q.Exec(ctx, `
+			INSERT INTO counters (name, delta) VALUES($1, $2)
+				ON CONFLICT (name) DO
+					UPDATE SET delta = counters.delta + $2
+		`, metric.ID, metric.Delta)
+
+	case "histogram":
+		if metric.Histogram == nil {
+			return fmt.Errorf("histogram %s: empty value", metric.ID)
+		}
+		_, err = updateHistogram(ctx, q, metric.ID, *metric.Histogram)
+
+	default:
+		err = fmt.Errorf("unknown metric type=%s", metric.MType)
+	}
+
+	return err
 }
 
 func (s *Store) Initialize(ctx context.Context, app config.AppConfig) error {
+	if s.Schema == "" {
+		s.Schema = Schema
+	}
 	var err error
-	if s.Conn, err = ConnectToDB(app.DatabaseDSN); err != nil {
+	if s.Pool, err = ConnectToDB(ctx, app.DatabaseDSN, s.Schema, app.DatabasePool); err != nil {
 		return err
 	}
 
-	if err = Bootstrap(ctx, s.Conn); err != nil {
+	// схема приводится к последней версии миграций, ошибка миграции останавливает запуск
+	if err = s.migrate(ctx); err != nil {
+		s.Pool.Close()
 		return err
 	}
 
-	// нужно для грейсфула, где требуется вызов pg.DB.Conn.Close()
-	DB = s
+	// нужно для грейсфула, где требуется вызов pg.DB.Close()
+	if s.Schema == Schema {
+		DB = s
+	}
+
+	return nil
+}
+
+// NewTenantStore подключается к хранилищу тенанта: его таблицы находятся в отдельной схеме metrics_<tenant>.
+func NewTenantStore(ctx context.Context, dsn, tenant string, pool config.PoolConfig) (*Store, error) {
+	s := &Store{Schema: Schema + "_" + tenant}
+	var err error
+	if s.Pool, err = OpenPool(ctx, dsn, s.Schema, pool); err != nil {
+		return nil, err
+	}
+	if err = s.migrate(ctx); err != nil {
+		s.Pool.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// migrate приводит схему хранилища к последней версии. Миграции работают через database/sql
+// поверх пула хранилища, закрытие *sql.DB пул не закрывает.
+func (s *Store) migrate(ctx context.Context) error {
+	db := stdlib.OpenDBFromPool(s.Pool)
+	defer db.Close()
+
+	return Migrate(ctx, db, s.Schema, Latest)
+}
+
+// Tenants возвращает тенантов, у которых уже есть схема metrics_<tenant>.
+func (s *Store) Tenants(ctx context.Context) ([]string, error) {
+	db := stdlib.OpenDBFromPool(s.Pool)
+	defer db.Close()
+
+	schemas, err := Schemas(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]string, 0, len(schemas))
+	for _, schema := range schemas {
+		if name, ok := strings.CutPrefix(schema, Schema+"_"); ok {
+			res = append(res, name)
+		}
+	}
+
+	return res, nil
+}
 
+// Close закрывает соединения с СУБД.
+func (s *Store) Close() error {
+	s.Pool.Close()
 	return nil
 }
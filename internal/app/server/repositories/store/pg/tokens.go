@@ -0,0 +1,87 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/auth"
+)
+
+// TokenStore реализует интерфейс auth.TokenStore в таблице metrics.tokens.
+type TokenStore struct {
+	Pool *pgxpool.Pool
+}
+
+// NewTokenStore возвращает хранилище токенов в PostgreSQL.
+func NewTokenStore(pool *pgxpool.Pool) *TokenStore {
+	return &TokenStore{Pool: pool}
+}
+
+// List возвращает токены в порядке выдачи.
+func (s *TokenStore) List(ctx context.Context) ([]auth.Token, error) {
+	rows, err := s.Pool.Query(ctx, `
+		SELECT id, name, hash, scopes, prefix, tenant, created_at FROM metrics.tokens ORDER BY created_at, id
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	res := []auth.Token{}
+	for rows.Next() {
+		var t auth.Token
+		var scopes string
+		if err = rows.Scan(&t.ID, &t.Name, &t.Hash, &scopes, &t.Prefix, &t.Tenant, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		t.Scopes = strings.Split(scopes, ",")
+		res = append(res, t)
+	}
+
+	return res, rows.Err()
+}
+
+// Get возвращает токен по идентификатору.
+func (s *TokenStore) Get(ctx context.Context, id string) (auth.Token, error) {
+	t := auth.Token{ID: id}
+	var scopes string
+	err := s.Pool.QueryRow(ctx, `
+		SELECT name, hash, scopes, prefix, tenant, created_at FROM metrics.tokens WHERE id = $1
+	`, id).Scan(&t.Name, &t.Hash, &scopes, &t.Prefix, &t.Tenant, &t.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return t, auth.ErrTokenNotFound
+	}
+	if err != nil {
+		return t, err
+	}
+	t.Scopes = strings.Split(scopes, ",")
+
+	return t, nil
+}
+
+// Add сохраняет новый токен.
+func (s *TokenStore) Add(ctx context.Context, t auth.Token) error {
+	_, err := s.Pool.Exec(ctx, `
+		INSERT INTO metrics.tokens (id, name, hash, scopes, prefix, tenant, created_at) VALUES($1, $2, $3, $4, $5, $6, $7)
+	`, t.ID, t.Name, t.Hash, strings.Join(t.Scopes, ","), t.Prefix, t.Tenant, t.CreatedAt)
+
+	return err
+}
+
+// Revoke удаляет токен.
+func (s *TokenStore) Revoke(ctx context.Context, id string) error {
+	res, err := s.Pool.Exec(ctx, `DELETE FROM metrics.tokens WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return auth.ErrTokenNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package wal
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// lockDir создает файл lockFile в каталоге хранилища. Блокировка файлов на этой платформе
+// не поддерживается, поэтому каталог не защищен от открытия другим процессом.
+func lockDir(dir string) (*os.File, error) {
+	return os.OpenFile(filepath.Join(dir, lockFile), os.O_RDWR|os.O_CREATE, 0o600)
+}
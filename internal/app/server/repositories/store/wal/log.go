@@ -0,0 +1,145 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/models"
+)
+
+// ErrCorrupted журнал поврежден не в последней записи, восстановить хранилище нельзя.
+var ErrCorrupted = errors.New("wal corrupted")
+
+// Операции журнала.
+const (
+	opUpdate        = "update"         // метрики применяются как в UpdateBatchMetrics
+	opDelete        = "delete"         // удаление метрики
+	opReset         = "reset"          // сброс счетчика
+	opDeleteMetrics = "delete_metrics" // удаление метрик по фильтру
+)
+
+// record запись журнала - одна операция, изменяющая хранилище.
+type record struct {
+	Seq     uint64           `json:"seq"`
+	Op      string           `json:"op"`
+	Metrics []models.Metrics `json:"metrics,omitempty"`
+	// BatchID и BatchTime идентификатор батча и время его применения: после восстановления
+	// повтор батча отклоняется, как и до сбоя.
+	BatchID   string               `json:"batch_id,omitempty"`
+	BatchTime *time.Time           `json:"batch_time,omitempty"`
+	Type      string               `json:"type,omitempty"`
+	Name      string               `json:"name,omitempty"`
+	Filter    *models.MetricFilter `json:"filter,omitempty"`
+}
+
+// Запись в файле журнала: длина данных и CRC-32C данных по 4 байта big-endian, затем данные в JSON.
+const headerSize = 8
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// encode кодирует запись для добавления в журнал.
+func encode(rec record) ([]byte, error) {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, headerSize+len(payload))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.Checksum(payload, castagnoli))
+	copy(buf[headerSize:], payload)
+
+	return buf, nil
+}
+
+// replay читает записи журнала по порядку и передает их в apply. Возвращает размер целой части журнала.
+// Последняя запись, оборванная сбоем во время записи, ошибкой не считается: она не попадает в размер
+// и должна быть отрезана. Поврежденная запись, за которой есть другие, - ошибка ErrCorrupted.
+func replay(f *os.File, apply func(rec record) error) (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+	r := bufio.NewReader(io.NewSectionReader(f, 0, size))
+	header := make([]byte, headerSize)
+
+	var offset int64
+	for offset < size {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return offset, nil
+		}
+		length := int64(binary.BigEndian.Uint32(header[0:4]))
+		end := offset + headerSize + length
+		if end > size {
+			return offset, nil
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return 0, err
+		}
+
+		var rec record
+		if crc32.Checksum(payload, castagnoli) != binary.BigEndian.Uint32(header[4:8]) {
+			err = errors.New("checksum mismatch")
+		} else {
+			err = json.Unmarshal(payload, &rec)
+		}
+		if err != nil {
+			if end == size {
+				return offset, nil
+			}
+			return 0, fmt.Errorf("%w: record at offset %d: %v", ErrCorrupted, offset, err)
+		}
+
+		if err := apply(rec); err != nil {
+			return 0, fmt.Errorf("failed to replay record %d: %w", rec.Seq, err)
+		}
+		offset = end
+	}
+
+	return offset, nil
+}
+
+// writeFile атомарно заменяет файл: данные пишутся во временный файл, сбрасываются на диск
+// и переименовываются, поэтому при сбое остается либо старый, либо новый файл целиком.
+func writeFile(name string, data []byte) error {
+	tmp := name + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		return err
+	}
+
+	return syncDir(filepath.Dir(name))
+}
+
+// syncDir сбрасывает на диск каталог, чтобы переименование файла пережило сбой.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
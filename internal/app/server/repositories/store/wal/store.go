@@ -0,0 +1,530 @@
+// Package wal обеспечивает хранение данных в файлах: метрики хранятся в памяти, а каждое изменение
+// до применения дописывается в журнал (write-ahead log). Журнал периодически сворачивается в снимок.
+// При запуске хранилище восстанавливается из последнего снимка и записей журнала после него.
+package wal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/config"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/logger"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/models"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories/store"
+)
+
+const (
+	logFile      = "wal.log"
+	snapshotFile = "snapshot.json"
+	tenantsDir   = "tenants"
+	lockFile     = "LOCK"
+)
+
+// readOnlyAttempts сколько раз хранилище только для чтения перечитывается, если во время
+// восстановления процесс-владелец каталога сохранил новый снимок и очистил журнал.
+const readOnlyAttempts = 3
+
+var (
+	// ErrClosed хранилище закрыто.
+	ErrClosed = errors.New("wal store closed")
+	// ErrReadOnly хранилище открыто только для чтения.
+	ErrReadOnly = errors.New("wal store is read-only")
+	// ErrLocked каталог хранилища открыт другим процессом.
+	ErrLocked = errors.New("wal dir is locked by another process")
+)
+
+// snapshot снимок хранилища, в который вошли записи журнала с номером до Seq включительно.
+type snapshot struct {
+	Seq       uint64
+	Counter   map[string]store.Counter
+	Gauge     map[string]store.Gauge
+	Histogram map[string]models.Histogram
+	// Batches идентификаторы примененных батчей со временем применения.
+	Batches map[string]time.Time `json:",omitempty"`
+}
+
+// Store хранилище метрик в памяти с журналом изменений на диске.
+// Чтение идет из памяти, изменение сначала записывается в журнал и только затем применяется.
+type Store struct {
+	*store.MemStorage
+
+	mu       sync.Mutex // упорядочивает записи журнала и изменения в памяти
+	dir      string
+	cfg      config.WALConfig
+	log      *os.File // nil - хранилище закрыто
+	lock     *os.File // файл блокировки каталога, nil - хранилище только для чтения
+	readOnly bool
+	size     int64  // размер журнала
+	seq      uint64 // номер последней записи
+	records  int    // записей в журнале после снимка
+	dirty    bool   // есть записи, не сброшенные на диск
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// Open открывает хранилище в каталоге dir и восстанавливает его из снимка и журнала.
+// Каталог блокируется до Close: второй процесс получит ErrLocked.
+func Open(dir string, cfg config.WALConfig) (*Store, error) {
+	s := &Store{}
+	if err := s.open(dir, cfg); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// OpenReadOnly восстанавливает хранилище из снимка и журнала в каталоге dir, не блокируя каталог
+// и ничего в нем не изменяя: оборванная запись не отрезается, снимок не сохраняется, а изменения
+// возвращают ErrReadOnly. Так можно читать каталог работающего сервера.
+func OpenReadOnly(dir string) (*Store, error) {
+	s := &Store{dir: dir, readOnly: true}
+	for attempt := 0; attempt < readOnlyAttempts; attempt++ {
+		seq, err := s.restoreReadOnly()
+		if err != nil {
+			return nil, err
+		}
+		// журнал мог быть очищен после нового снимка во время чтения: тогда читаем заново
+		snap, err := readSnapshot(dir)
+		if err != nil {
+			return nil, err
+		}
+		if snap.Seq == seq {
+			return s, nil
+		}
+	}
+
+	return nil, fmt.Errorf("wal in dir=%s changed during %d attempts to read it", dir, readOnlyAttempts)
+}
+
+// restoreReadOnly загружает снимок и записи журнала после него. Возвращает номер загруженного снимка.
+func (s *Store) restoreReadOnly() (uint64, error) {
+	if _, err := os.Stat(s.dir); err != nil {
+		return 0, err
+	}
+	s.MemStorage = store.NewMemStorage()
+	s.seq, s.records = 0, 0
+	if err := s.loadSnapshot(); err != nil {
+		return 0, err
+	}
+	seq := s.seq
+
+	f, err := os.Open(filepath.Join(s.dir, logFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return seq, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	_, err = replay(f, func(rec record) error {
+		if rec.Seq <= s.seq {
+			return nil
+		}
+		s.seq = rec.Seq
+		s.records++
+		return s.apply(rec)
+	})
+
+	return seq, err
+}
+
+// Initialize открывает хранилище в каталоге из настройки wal.dir.
+func (s *Store) Initialize(_ context.Context, app config.AppConfig) error {
+	return s.open(app.WAL.Dir, app.WAL)
+}
+
+// TenantDir возвращает каталог хранилища тенанта внутри каталога dir.
+func TenantDir(dir, tenant string) string {
+	return filepath.Join(dir, tenantsDir, tenant)
+}
+
+// Tenants возвращает тенантов, у которых есть хранилище внутри каталога dir.
+func Tenants(dir string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(dir, tenantsDir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var res []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			res = append(res, entry.Name())
+		}
+	}
+	return res, nil
+}
+
+func (s *Store) open(dir string, cfg config.WALConfig) error {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return err
+	}
+	lock, err := lockDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to lock wal dir=%s: %w", dir, err)
+	}
+	s.dir, s.cfg = dir, cfg
+	s.MemStorage = store.NewMemStorage()
+	s.seq, s.records = 0, 0
+
+	if err := s.loadSnapshot(); err != nil {
+		lock.Close()
+		return err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, logFile), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		lock.Close()
+		return err
+	}
+	size, err := replay(f, func(rec record) error {
+		// запись уже вошла в снимок: сбой случился между сохранением снимка и очисткой журнала
+		if rec.Seq <= s.seq {
+			return nil
+		}
+		s.seq = rec.Seq
+		s.records++
+		return s.apply(rec)
+	})
+	if err == nil {
+		err = s.truncateTorn(f, size)
+	}
+	if err != nil {
+		f.Close()
+		lock.Close()
+		return err
+	}
+	s.log, s.lock, s.size = f, lock, size
+	logger.Log.Infof("wal store restored from dir=%s: %d records after snapshot", dir, s.records)
+
+	s.done = make(chan struct{})
+	if cfg.Fsync == config.FsyncInterval && cfg.FsyncInterval > 0 || cfg.SnapshotInterval > 0 {
+		s.wg.Add(1)
+		go s.run()
+	}
+
+	return nil
+}
+
+// readSnapshot читает последний снимок в каталоге dir. Без снимка - пустой снимок.
+func readSnapshot(dir string) (snapshot, error) {
+	var snap snapshot
+	data, err := os.ReadFile(filepath.Join(dir, snapshotFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return snap, nil
+	}
+	if err != nil {
+		return snap, err
+	}
+	err = json.Unmarshal(data, &snap)
+
+	return snap, err
+}
+
+// loadSnapshot загружает последний снимок, если он есть.
+func (s *Store) loadSnapshot() error {
+	snap, err := readSnapshot(s.dir)
+	if err != nil {
+		return err
+	}
+	if snap.Counter != nil {
+		s.Counter = snap.Counter
+	}
+	if snap.Gauge != nil {
+		s.Gauge = snap.Gauge
+	}
+	if snap.Histogram != nil {
+		s.Histogram = snap.Histogram
+	}
+	s.RestoreBatches(snap.Batches)
+	s.seq = snap.Seq
+
+	return nil
+}
+
+// truncateTorn отрезает оборванную последнюю запись, чтобы новые записи не оказались за ней.
+func (s *Store) truncateTorn(f *os.File, size int64) error {
+	info, err := f.Stat()
+	if err != nil || info.Size() == size {
+		return err
+	}
+	logger.Log.Warnf("wal in dir=%s has a torn record at offset %d, %d bytes discarded", s.dir, size, info.Size()-size)
+	if err := f.Truncate(size); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// apply применяет запись журнала к хранилищу в памяти при восстановлении.
+func (s *Store) apply(rec record) error {
+	ctx := context.Background()
+	var err error
+	switch rec.Op {
+	case opUpdate:
+		_, err = s.MemStorage.UpdateBatchMetrics(ctx, models.Batch{Metrics: rec.Metrics})
+		if err == nil && rec.BatchID != "" && rec.BatchTime != nil {
+			s.RestoreBatches(map[string]time.Time{rec.BatchID: *rec.BatchTime})
+		}
+	case opDelete:
+		err = s.MemStorage.DeleteMetric(ctx, rec.Type, rec.Name)
+	case opReset:
+		err = s.MemStorage.ResetCounter(ctx, rec.Name)
+	case opDeleteMetrics:
+		if rec.Filter != nil {
+			_, err = s.MemStorage.DeleteMetrics(ctx, *rec.Filter)
+		}
+	default:
+		err = errors.New("unknown operation " + rec.Op)
+	}
+	// удаление отсутствующей метрики тоже пишется в журнал и ничего не меняет
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// append дописывает запись в журнал. Вызывается под s.mu до изменения хранилища в памяти.
+func (s *Store) append(rec record) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	if s.log == nil {
+		return ErrClosed
+	}
+	rec.Seq = s.seq + 1
+	data, err := encode(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := s.log.Write(data); err != nil {
+		// недописанная запись отрезается, иначе следующие записи окажутся за поврежденной
+		return errors.Join(err, s.log.Truncate(s.size))
+	}
+	s.size += int64(len(data))
+	s.seq = rec.Seq
+	s.records++
+
+	if s.cfg.Fsync == config.FsyncAlways {
+		return s.log.Sync()
+	}
+	s.dirty = true
+
+	return nil
+}
+
+// compact сохраняет снимок, если журнал вырос до snapshot_records записей. Вызывается под s.mu.
+func (s *Store) compact() {
+	if s.cfg.SnapshotRecords <= 0 || s.records < s.cfg.SnapshotRecords {
+		return
+	}
+	if err := s.snapshot(); err != nil {
+		logger.Log.Errorf("failed to save wal snapshot in dir=%s: %v", s.dir, err)
+	}
+}
+
+// snapshot сохраняет снимок хранилища и очищает журнал. Вызывается под s.mu: пока снимок
+// сохраняется, изменения ждут, а чтение продолжается.
+func (s *Store) snapshot() error {
+	data, err := json.Marshal(snapshot{
+		Seq:       s.seq,
+		Counter:   s.Counter,
+		Gauge:     s.Gauge,
+		Histogram: s.Histogram,
+		Batches:   s.AppliedBatches(),
+	})
+	if err != nil {
+		return err
+	}
+	if err := writeFile(filepath.Join(s.dir, snapshotFile), data); err != nil {
+		return err
+	}
+	// при сбое до очистки журнала его записи пропускаются при восстановлении по номеру
+	if err := s.log.Truncate(0); err != nil {
+		return err
+	}
+	s.size, s.records, s.dirty = 0, 0, false
+
+	return s.log.Sync()
+}
+
+// run сбрасывает журнал на диск в режиме interval и сохраняет снимки по расписанию до закрытия хранилища.
+func (s *Store) run() {
+	defer s.wg.Done()
+
+	var fsync, snap <-chan time.Time
+	if s.cfg.Fsync == config.FsyncInterval && s.cfg.FsyncInterval > 0 {
+		ticker := time.NewTicker(time.Duration(s.cfg.FsyncInterval) * time.Second)
+		defer ticker.Stop()
+		fsync = ticker.C
+	}
+	if s.cfg.SnapshotInterval > 0 {
+		ticker := time.NewTicker(time.Duration(s.cfg.SnapshotInterval) * time.Second)
+		defer ticker.Stop()
+		snap = ticker.C
+	}
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-fsync:
+			s.mu.Lock()
+			if s.log != nil && s.dirty {
+				if err := s.log.Sync(); err != nil {
+					logger.Log.Errorf("failed to sync wal in dir=%s: %v", s.dir, err)
+				} else {
+					s.dirty = false
+				}
+			}
+			s.mu.Unlock()
+		case <-snap:
+			s.mu.Lock()
+			if s.log != nil && s.records > 0 {
+				if err := s.snapshot(); err != nil {
+					logger.Log.Errorf("failed to save wal snapshot in dir=%s: %v", s.dir, err)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// Close сохраняет снимок, закрывает журнал и снимает блокировку каталога.
+// Хранилище только для чтения ничего не сохраняет.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	if s.log == nil {
+		s.mu.Unlock()
+		return nil
+	}
+	close(s.done)
+	var err error
+	if s.records > 0 {
+		err = s.snapshot()
+	}
+	err = errors.Join(err, s.log.Close(), s.lock.Close())
+	s.log, s.lock = nil, nil
+	s.mu.Unlock()
+
+	s.wg.Wait()
+
+	return err
+}
+
+// UpdateCounter обновляет поле Counter.
+func (s *Store) UpdateCounter(ctx context.Context, name string, value int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.append(record{Op: opUpdate, Metrics: []models.Metrics{{ID: name, MType: "counter", Delta: &value}}}); err != nil {
+		return 0, err
+	}
+	defer s.compact()
+
+	return s.MemStorage.UpdateCounter(ctx, name, value)
+}
+
+// UpdateGauge обновляет поле Gauge.
+func (s *Store) UpdateGauge(ctx context.Context, name string, value float64) (float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.append(record{Op: opUpdate, Metrics: []models.Metrics{{ID: name, MType: "gauge", Value: &value}}}); err != nil {
+		return 0, err
+	}
+	defer s.compact()
+
+	return s.MemStorage.UpdateGauge(ctx, name, value)
+}
+
+// UpdateHistogram объединяет полученное распределение с накопленным.
+func (s *Store) UpdateHistogram(ctx context.Context, name string, value models.Histogram) (models.Histogram, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.append(record{Op: opUpdate, Metrics: []models.Metrics{{ID: name, MType: "histogram", Histogram: &value}}}); err != nil {
+		return models.Histogram{}, err
+	}
+	defer s.compact()
+
+	return s.MemStorage.UpdateHistogram(ctx, name, value)
+}
+
+// UpdateBatchMetrics применяет батч одной записью журнала, поэтому после сбоя батч восстанавливается целиком
+// или не восстанавливается вовсе. Идентификатор батча тоже пишется в журнал, поэтому повтор батча
+// отклоняется и после сбоя. Повторный батч в журнал не пишется.
+func (s *Store) UpdateBatchMetrics(ctx context.Context, batch models.Batch) ([]error, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if batch.ID != "" && s.AppliedBatch(batch.ID) {
+		return nil, repositories.ErrDuplicateBatch
+	}
+	rec := record{Op: opUpdate, Metrics: batch.Metrics}
+	if batch.ID != "" {
+		now := time.Now()
+		rec.BatchID, rec.BatchTime = batch.ID, &now
+	}
+	if err := s.append(rec); err != nil {
+		return nil, err
+	}
+	defer s.compact()
+
+	return s.MemStorage.UpdateBatchMetrics(ctx, batch)
+}
+
+// DeleteMetric удаляет метрику заданного типа.
+func (s *Store) DeleteMetric(ctx context.Context, mType, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.append(record{Op: opDelete, Type: mType, Name: name}); err != nil {
+		return err
+	}
+	defer s.compact()
+
+	return s.MemStorage.DeleteMetric(ctx, mType, name)
+}
+
+// ResetCounter обнуляет счетчик Counter.
+func (s *Store) ResetCounter(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.append(record{Op: opReset, Name: name}); err != nil {
+		return err
+	}
+	defer s.compact()
+
+	return s.MemStorage.ResetCounter(ctx, name)
+}
+
+// DeleteMetrics удаляет метрики, отобранные по типу и имени, и возвращает их количество.
+func (s *Store) DeleteMetrics(ctx context.Context, filter models.MetricFilter) (int64, error) {
+	// некорректное выражение не должно попасть в журнал, иначе хранилище не восстановится
+	if filter.NameRegex != "" {
+		if _, err := regexp.Compile(filter.NameRegex); err != nil {
+			return 0, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.append(record{Op: opDeleteMetrics, Filter: &filter}); err != nil {
+		return 0, err
+	}
+	defer s.compact()
+
+	return s.MemStorage.DeleteMetrics(ctx, filter)
+}
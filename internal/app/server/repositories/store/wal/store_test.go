@@ -0,0 +1,308 @@
+package wal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/config"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/models"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories"
+)
+
+// без фоновых fsync и снимков: сбой имитируется повторным открытием без Close
+var noBackground = config.WALConfig{Fsync: config.FsyncAlways}
+
+// crash имитирует сбой процесса: файлы закрываются, как при его завершении, без снимка.
+func crash(t *testing.T, s *Store) {
+	t.Helper()
+	require.NoError(t, s.log.Close())
+	require.NoError(t, s.lock.Close())
+	s.log, s.lock = nil, nil
+}
+
+func TestStoreRecover(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	s, err := Open(dir, noBackground)
+	require.NoError(t, err)
+	_, err = s.UpdateCounter(ctx, "PollCount", 2)
+	require.NoError(t, err)
+	_, err = s.UpdateCounter(ctx, "PollCount", 3)
+	require.NoError(t, err)
+	_, err = s.UpdateGauge(ctx, "Alloc", 1.5)
+	require.NoError(t, err)
+	_, err = s.UpdateHistogram(ctx, "Latency", models.Histogram{Bounds: []float64{1}, Counts: []uint64{1, 0}, Sum: 0.5, Count: 1})
+	require.NoError(t, err)
+	_, err = s.UpdateCounter(ctx, "Deleted", 1)
+	require.NoError(t, err)
+	require.NoError(t, s.DeleteMetric(ctx, "counter", "Deleted"))
+	assert.ErrorIs(t, s.DeleteMetric(ctx, "gauge", "Missing"), repositories.ErrNotFound)
+	_, err = s.UpdateCounter(ctx, "Reset", 7)
+	require.NoError(t, err)
+	require.NoError(t, s.ResetCounter(ctx, "Reset"))
+	delta, value := int64(10), 2.5
+	_, err = s.UpdateBatchMetrics(ctx, models.Batch{ID: "b1", Metrics: []models.Metrics{
+		{ID: "PollCount", MType: "counter", Delta: &delta},
+		{ID: "tmp.a", MType: "gauge", Value: &value},
+		{ID: "tmp.b", MType: "gauge", Value: &value},
+	}})
+	require.NoError(t, err)
+	_, err = s.UpdateBatchMetrics(ctx, models.Batch{ID: "b1", Metrics: []models.Metrics{{ID: "PollCount", MType: "counter", Delta: &delta}}})
+	assert.ErrorIs(t, err, repositories.ErrDuplicateBatch)
+	deleted, err := s.DeleteMetrics(ctx, models.MetricFilter{Types: []string{"gauge"}, Prefix: "tmp."})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+	_, err = s.DeleteMetrics(ctx, models.MetricFilter{NameRegex: "("})
+	assert.Error(t, err)
+
+	// хранилище не закрыто, снимка нет: все восстанавливается из журнала
+	crash(t, s)
+	restored, err := Open(dir, noBackground)
+	require.NoError(t, err)
+	defer restored.Close()
+	assertRestored(t, restored)
+}
+
+func assertRestored(t *testing.T, s *Store) {
+	t.Helper()
+	ctx := context.Background()
+
+	counter, err := s.GetCounter(ctx, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(15), counter)
+	gauge, err := s.GetGauge(ctx, "Alloc")
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, gauge)
+	histogram, err := s.GetHistogram(ctx, "Latency")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), histogram.Count)
+	_, err = s.GetCounter(ctx, "Deleted")
+	assert.Error(t, err)
+	reset, err := s.GetCounter(ctx, "Reset")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), reset)
+	_, err = s.GetGauge(ctx, "tmp.a")
+	assert.Error(t, err)
+	// батч, повторенный агентом после сбоя, не применяется второй раз
+	assert.True(t, s.AppliedBatch("b1"))
+}
+
+func TestStoreTornRecord(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	s, err := Open(dir, noBackground)
+	require.NoError(t, err)
+	_, err = s.UpdateCounter(ctx, "PollCount", 1)
+	require.NoError(t, err)
+	_, err = s.UpdateCounter(ctx, "PollCount", 2)
+	require.NoError(t, err)
+
+	name := filepath.Join(dir, logFile)
+	info, err := os.Stat(name)
+	require.NoError(t, err)
+	// сбой во время записи: от последней записи на диске осталась только часть
+	data, err := encode(record{Seq: 3, Op: opReset, Name: "PollCount"})
+	require.NoError(t, err)
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0o600)
+	require.NoError(t, err)
+	_, err = f.Write(data[:len(data)-3])
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	crash(t, s)
+	restored, err := Open(dir, noBackground)
+	require.NoError(t, err)
+	counter, err := restored.GetCounter(ctx, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), counter)
+
+	// оборванная запись отрезана, новые записи восстанавливаются
+	torn, err := os.Stat(name)
+	require.NoError(t, err)
+	assert.Equal(t, info.Size(), torn.Size())
+	_, err = restored.UpdateCounter(ctx, "PollCount", 4)
+	require.NoError(t, err)
+
+	crash(t, restored)
+	again, err := Open(dir, noBackground)
+	require.NoError(t, err)
+	counter, err = again.GetCounter(ctx, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), counter)
+}
+
+func TestStoreCorrupted(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	s, err := Open(dir, noBackground)
+	require.NoError(t, err)
+	_, err = s.UpdateGauge(ctx, "Alloc", 1)
+	require.NoError(t, err)
+	_, err = s.UpdateGauge(ctx, "Alloc", 2)
+	require.NoError(t, err)
+
+	// поврежденная запись в середине журнала не может быть последствием сбоя при записи
+	name := filepath.Join(dir, logFile)
+	data, err := os.ReadFile(name)
+	require.NoError(t, err)
+	data[headerSize+1] ^= 0xff
+	require.NoError(t, os.WriteFile(name, data, 0o600))
+
+	crash(t, s)
+	_, err = Open(dir, noBackground)
+	assert.ErrorIs(t, err, ErrCorrupted)
+}
+
+func TestStoreLocked(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Open(dir, noBackground)
+	require.NoError(t, err)
+	// каталог открытого хранилища не может открыть второй владелец
+	_, err = Open(dir, noBackground)
+	assert.ErrorIs(t, err, ErrLocked)
+
+	require.NoError(t, s.Close())
+	again, err := Open(dir, noBackground)
+	require.NoError(t, err)
+	require.NoError(t, again.Close())
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	s, err := Open(dir, noBackground)
+	require.NoError(t, err)
+	defer s.Close()
+	_, err = s.UpdateCounter(ctx, "PollCount", 2)
+	require.NoError(t, err)
+	require.NoError(t, s.snapshot())
+	_, err = s.UpdateCounter(ctx, "PollCount", 3)
+	require.NoError(t, err)
+	// оборванная запись в журнале работающего хранилища
+	data, err := encode(record{Seq: s.seq + 1, Op: opReset, Name: "PollCount"})
+	require.NoError(t, err)
+	_, err = s.log.Write(data[:len(data)-3])
+	require.NoError(t, err)
+	info, err := os.Stat(filepath.Join(dir, logFile))
+	require.NoError(t, err)
+
+	// каталог открыт владельцем, но читается из снимка и журнала без изменений
+	ro, err := OpenReadOnly(dir)
+	require.NoError(t, err)
+	counter, err := ro.GetCounter(ctx, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), counter)
+	_, err = ro.UpdateCounter(ctx, "PollCount", 1)
+	assert.ErrorIs(t, err, ErrReadOnly)
+	require.NoError(t, ro.Close())
+
+	torn, err := os.Stat(filepath.Join(dir, logFile))
+	require.NoError(t, err)
+	assert.Equal(t, info.Size(), torn.Size())
+
+	_, err = OpenReadOnly(filepath.Join(dir, "missing"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestStoreSnapshot(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	cfg := config.WALConfig{Fsync: config.FsyncNever, SnapshotRecords: 3}
+
+	s, err := Open(dir, cfg)
+	require.NoError(t, err)
+	_, err = s.UpdateCounter(ctx, "PollCount", 1)
+	require.NoError(t, err)
+	_, err = s.UpdateCounter(ctx, "PollCount", 2)
+	require.NoError(t, err)
+	name := filepath.Join(dir, logFile)
+	beforeSnapshot, err := os.ReadFile(name)
+	require.NoError(t, err)
+	_, err = s.UpdateCounter(ctx, "PollCount", 3)
+	require.NoError(t, err)
+
+	// журнал свернут в снимок
+	info, err := os.Stat(name)
+	require.NoError(t, err)
+	assert.Zero(t, info.Size())
+	_, err = s.UpdateCounter(ctx, "PollCount", 4)
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+	_, err = s.UpdateCounter(ctx, "PollCount", 1)
+	assert.ErrorIs(t, err, ErrClosed)
+
+	restored, err := Open(dir, cfg)
+	require.NoError(t, err)
+	counter, err := restored.GetCounter(ctx, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), counter)
+	require.NoError(t, restored.Close())
+
+	// сбой между сохранением снимка и очисткой журнала: записи из снимка не применяются повторно
+	require.NoError(t, os.WriteFile(name, beforeSnapshot, 0o600))
+	restored, err = Open(dir, cfg)
+	require.NoError(t, err)
+	defer restored.Close()
+	counter, err = restored.GetCounter(ctx, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), counter)
+}
+
+func TestStoreBatchRetry(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	delta := int64(5)
+	batch := models.Batch{ID: "b1", Metrics: []models.Metrics{{ID: "PollCount", MType: "counter", Delta: &delta}}}
+	assertRetry := func(s *Store) {
+		t.Helper()
+		_, err := s.UpdateBatchMetrics(ctx, batch)
+		assert.ErrorIs(t, err, repositories.ErrDuplicateBatch)
+		counter, err := s.GetCounter(ctx, "PollCount")
+		require.NoError(t, err)
+		assert.Equal(t, int64(5), counter)
+	}
+
+	s, err := Open(dir, noBackground)
+	require.NoError(t, err)
+	_, err = s.UpdateBatchMetrics(ctx, batch)
+	require.NoError(t, err)
+
+	// идентификатор восстанавливается из журнала
+	crash(t, s)
+	s, err = Open(dir, noBackground)
+	require.NoError(t, err)
+	assertRetry(s)
+
+	// и из снимка, в который свернут журнал
+	require.NoError(t, s.Close())
+	s, err = Open(dir, noBackground)
+	require.NoError(t, err)
+	defer s.Close()
+	assertRetry(s)
+	assert.Zero(t, s.records)
+}
+
+func TestTenants(t *testing.T) {
+	dir := t.TempDir()
+	tenants, err := Tenants(dir)
+	require.NoError(t, err)
+	assert.Empty(t, tenants)
+
+	s, err := Open(TenantDir(dir, "team_a"), noBackground)
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	tenants, err = Tenants(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"team_a"}, tenants)
+}
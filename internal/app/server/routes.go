@@ -2,6 +2,7 @@ package server
 
 import (
 	"github.com/go-chi/chi/v5"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/auth"
 	"github.com/webkimru/go-yandex-metrics/internal/app/server/handlers"
 	"github.com/webkimru/go-yandex-metrics/internal/app/server/middleware"
 	"net/http"
@@ -12,21 +13,55 @@ func Routes() http.Handler {
 	r := chi.NewRouter()
 	// вариант подвключения middleware
 	r.Use(middleware.TrustedSubnet)
+	r.Use(middleware.ClientCert)
 	r.Use(middleware.WithLogging)
+	r.Use(middleware.RateLimit)
+	r.Use(middleware.BodyLimit)
+	r.Use(middleware.Tenant)
 	r.Use(middleware.WithSign)
 	r.Use(middleware.Gzip)
 	// text/plain
 	r.Group(func(r chi.Router) {
 		r.Use(middleware.TextPlain)
+		r.With(middleware.Authorize(auth.ScopeWrite)).Post("/update/{metric}/{name}/{value}", handlers.Repo.PostMetrics)
+		r.With(middleware.Authorize(auth.ScopeRead)).Get("/value/{metric}/{name}", handlers.Repo.GetMetric)
+	})
+	// дашборд
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.Authorize(auth.ScopeRead))
 		r.Get("/", handlers.Repo.Default)
-		r.Post("/update/{metric}/{name}/{value}", handlers.Repo.PostMetrics)
-		r.Get("/value/{metric}/{name}", handlers.Repo.GetMetric)
+		r.Get("/agents", handlers.Repo.AgentsPage)
+		r.Get("/dashboard/events", handlers.Repo.DashboardEvents)
+		r.Get("/dashboard/{metric}/{name}", handlers.Repo.MetricPage)
+		r.Get("/dashboard/{metric}/{name}/chart.svg", handlers.Repo.MetricChart)
 	})
+	r.Get("/dashboard/login", handlers.Repo.LoginPage)
+	r.Handle("/static/*", handlers.Static())
 	// application/json
 	r.Group(func(r chi.Router) {
-		r.With(middleware.Decrypt).Post("/updates/", handlers.Repo.PostBatchMetrics)
-		r.Post("/update/", handlers.Repo.PostMetrics)
-		r.Post("/value/", handlers.Repo.GetMetric)
+		r.With(middleware.Authorize(auth.ScopeWrite), middleware.Decrypt).Post("/updates/", handlers.Repo.PostBatchMetrics)
+		r.With(middleware.Authorize(auth.ScopeWrite)).Post("/update/", handlers.Repo.PostMetrics)
+		r.With(middleware.Authorize(auth.ScopeRead)).Post("/value/", handlers.Repo.GetMetric)
+	})
+	// REST API v1
+	r.Route("/api/v1", func(r chi.Router) {
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.Authorize(auth.ScopeRead))
+			r.Get("/metrics", handlers.Repo.ListMetrics)
+			r.Get("/alerts", handlers.Repo.ListAlerts)
+			r.Get("/agents", handlers.Repo.ListAgents)
+			r.Get("/stream", handlers.Repo.Stream)
+		})
+		// удаление и сброс метрик, выдача и отзыв токенов доступны только с административным ключом или токеном admin
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.Admin)
+			r.Delete("/metrics", handlers.Repo.DeleteMetrics)
+			r.Delete("/metrics/{metric}/{name}", handlers.Repo.DeleteMetric)
+			r.Post("/metrics/counter/{name}/reset", handlers.Repo.ResetCounter)
+			r.Get("/tokens", handlers.Repo.ListTokens)
+			r.Post("/tokens", handlers.Repo.IssueToken)
+			r.Delete("/tokens/{id}", handlers.Repo.RevokeToken)
+		})
 	})
 	// ping PostgreSQL
 	r.Group(func(r chi.Router) {
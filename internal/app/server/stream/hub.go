@@ -0,0 +1,193 @@
+// Package stream рассылает принятые обновления метрик подписчикам потока /api/v1/stream.
+package stream
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/models"
+)
+
+// subscriberBuffer сколько событий может накопить подписчик, прежде чем будет отключен.
+const subscriberBuffer = 256
+
+// EventID идентификатор события: эпоха процесса и номер события в потоке тенанта.
+// Эпоха меняется при каждом запуске сервера, поэтому клиент, возобновляющий поток после перезапуска,
+// узнает о разрыве, хотя номера событий начались заново. Нулевое значение - события нет.
+type EventID struct {
+	Epoch string
+	Seq   uint64
+}
+
+// String возвращает идентификатор в виде <эпоха>-<номер>, как он передается клиенту.
+func (id EventID) String() string {
+	return id.Epoch + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+// MarshalText кодирует идентификатор в JSON строкой.
+func (id EventID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// ParseEventID разбирает идентификатор события, присланный клиентом. Число без эпохи - идентификатор
+// прежнего формата: он принимается как идентификатор другой эпохи.
+func ParseEventID(s string) (EventID, error) {
+	epoch, seq, ok := strings.Cut(s, "-")
+	if !ok {
+		epoch, seq = "", s
+	}
+	n, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil || ok && epoch == "" {
+		return EventID{}, fmt.Errorf("invalid event id=%q", s)
+	}
+
+	return EventID{Epoch: epoch, Seq: n}, nil
+}
+
+// Event принятое обновление метрики. Для метрики указывается значение после обновления.
+type Event struct {
+	ID     EventID        `json:"id"`
+	Time   time.Time      `json:"time"`
+	Metric models.Metrics `json:"metric"`
+	// Tenant тенант метрики: подписчику отдаются только события его тенанта.
+	Tenant string `json:"-"`
+}
+
+// Subscription подписка на события тенанта.
+type Subscription struct {
+	// Backlog события после запрошенного идентификатора, оставшиеся в буфере.
+	Backlog []Event
+	// Gap часть событий после запрошенного идентификатора уже вытеснена из буфера
+	// или была опубликована до перезапуска сервера.
+	Gap bool
+	// Events новые события. Канал закрывается, если подписчик не успевает их читать:
+	// клиент переподключается с последним полученным идентификатором.
+	Events <-chan Event
+
+	hub *Hub
+	ch  chan Event
+}
+
+// Close отменяет подписку.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	if _, ok := s.hub.subs[s.ch]; ok {
+		delete(s.hub.subs, s.ch)
+		close(s.ch)
+	}
+}
+
+// ring кольцевой буфер последних событий тенанта.
+type ring struct {
+	buffer []Event
+	start  int    // индекс самого старого события в buffer
+	lastID uint64 // номер последнего события тенанта
+}
+
+// after возвращает события буфера с номером больше seq.
+func (r *ring) after(seq uint64) []Event {
+	var res []Event
+	for i := 0; i < len(r.buffer); i++ {
+		event := r.buffer[(r.start+i)%len(r.buffer)]
+		if event.ID.Seq > seq {
+			res = append(res, event)
+		}
+	}
+	return res
+}
+
+// Hub раздает события подписчикам и хранит size последних событий каждого тенанта для возобновления потока:
+// занятый тенант не вытесняет события остальных.
+type Hub struct {
+	mu    sync.Mutex
+	size  int
+	epoch string
+	rings map[string]*ring
+	subs  map[chan Event]string // канал подписчика -> тенант
+}
+
+// NewHub конструктор типа Hub.
+func NewHub(size int) *Hub {
+	return &Hub{
+		size:  size,
+		epoch: strconv.FormatInt(time.Now().UnixNano(), 36),
+		rings: make(map[string]*ring),
+		subs:  make(map[chan Event]string),
+	}
+}
+
+// Publish рассылает обновления метрик тенанта его подписчикам.
+func (h *Hub) Publish(tenant string, metrics ...models.Metrics) {
+	if len(metrics) == 0 {
+		return
+	}
+	now := time.Now()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r, ok := h.rings[tenant]
+	if !ok {
+		r = &ring{buffer: make([]Event, 0, h.size)}
+		h.rings[tenant] = r
+	}
+	for _, m := range metrics {
+		r.lastID++
+		event := Event{ID: EventID{Epoch: h.epoch, Seq: r.lastID}, Time: now, Metric: m, Tenant: tenant}
+		if len(r.buffer) < h.size {
+			r.buffer = append(r.buffer, event)
+		} else if h.size > 0 {
+			r.buffer[r.start] = event
+			r.start = (r.start + 1) % h.size
+		}
+		for ch, name := range h.subs {
+			if name != tenant {
+				continue
+			}
+			select {
+			case ch <- event:
+			default:
+				// медленный подписчик отключается и возобновит поток с последнего события
+				delete(h.subs, ch)
+				close(ch)
+			}
+		}
+	}
+}
+
+// HasSubscribers сообщает, есть ли подписчики.
+func (h *Hub) HasSubscribers() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs) > 0
+}
+
+// Subscribe подписывает на события тенанта после события с идентификатором after.
+// Нулевой after - только новые события. Идентификатор другой эпохи или еще не выданный означает,
+// что сервер перезапускался: клиенту отдается весь буфер тенанта и сообщается о разрыве.
+func (h *Hub) Subscribe(tenant string, after EventID) *Subscription {
+	ch := make(chan Event, subscriberBuffer)
+	sub := &Subscription{Events: ch, hub: h, ch: ch}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r, ok := h.rings[tenant]
+	if !ok {
+		r = &ring{}
+	}
+	switch {
+	case after == EventID{}:
+	case after.Epoch != h.epoch || after.Seq > r.lastID:
+		sub.Backlog = r.after(0)
+		sub.Gap = true
+	case after.Seq < r.lastID:
+		sub.Backlog = r.after(after.Seq)
+		sub.Gap = len(sub.Backlog) > 0 && sub.Backlog[0].ID.Seq > after.Seq+1
+	}
+	h.subs[ch] = tenant
+
+	return sub
+}
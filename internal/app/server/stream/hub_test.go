@@ -0,0 +1,129 @@
+package stream
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/models"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/tenant"
+)
+
+func gauge(id string, value float64) models.Metrics {
+	return models.Metrics{ID: id, MType: "gauge", Value: &value}
+}
+
+func ids(events []Event) []uint64 {
+	var res []uint64
+	for _, e := range events {
+		res = append(res, e.ID.Seq)
+	}
+	return res
+}
+
+func TestHubSubscribe(t *testing.T) {
+	h := NewHub(3)
+	sub := h.Subscribe(tenant.Default, EventID{})
+	defer sub.Close()
+	assert.True(t, h.HasSubscribers())
+
+	h.Publish(tenant.Default, gauge("a", 1), gauge("b", 2))
+	event := <-sub.Events
+	assert.Equal(t, EventID{Epoch: h.epoch, Seq: 1}, event.ID)
+	assert.Equal(t, "a", event.Metric.ID)
+	event = <-sub.Events
+	assert.Equal(t, uint64(2), event.ID.Seq)
+
+	sub.Close()
+	assert.False(t, h.HasSubscribers())
+	// повторное закрытие безопасно
+	sub.Close()
+}
+
+func TestHubResume(t *testing.T) {
+	h := NewHub(3)
+	for i := 0; i < 5; i++ {
+		h.Publish(tenant.Default, gauge("a", float64(i)))
+	}
+	after := func(seq uint64) EventID { return EventID{Epoch: h.epoch, Seq: seq} }
+
+	// события после 3 еще в буфере
+	sub := h.Subscribe(tenant.Default, after(3))
+	assert.Equal(t, []uint64{4, 5}, ids(sub.Backlog))
+	assert.False(t, sub.Gap)
+	sub.Close()
+
+	// событие 2 вытеснено из буфера
+	sub = h.Subscribe(tenant.Default, after(1))
+	assert.Equal(t, []uint64{3, 4, 5}, ids(sub.Backlog))
+	assert.True(t, sub.Gap)
+	sub.Close()
+
+	// клиент получил все события
+	sub = h.Subscribe(tenant.Default, after(5))
+	assert.Empty(t, sub.Backlog)
+	assert.False(t, sub.Gap)
+	sub.Close()
+
+	// идентификатор другой эпохи или еще не выданный: сервер перезапускался, отдается весь буфер
+	for _, id := range []EventID{{Epoch: "previous", Seq: 4}, {Seq: 4}, after(9)} {
+		sub = h.Subscribe(tenant.Default, id)
+		assert.Equal(t, []uint64{3, 4, 5}, ids(sub.Backlog), id)
+		assert.True(t, sub.Gap, id)
+		sub.Close()
+	}
+}
+
+func TestHubTenants(t *testing.T) {
+	h := NewHub(2)
+	sub := h.Subscribe("team_a", EventID{})
+	defer sub.Close()
+
+	h.Publish("team_a", gauge("a", 1))
+	// занятый тенант не вытесняет события другого и не попадает к его подписчикам
+	for i := 0; i < 5; i++ {
+		h.Publish(tenant.Default, gauge("b", float64(i)))
+	}
+	event := <-sub.Events
+	assert.Equal(t, "a", event.Metric.ID)
+	assert.Equal(t, uint64(1), event.ID.Seq)
+	assert.Empty(t, sub.Events)
+
+	resumed := h.Subscribe("team_a", EventID{Epoch: h.epoch, Seq: 1})
+	defer resumed.Close()
+	assert.Empty(t, resumed.Backlog)
+	assert.False(t, resumed.Gap)
+}
+
+func TestParseEventID(t *testing.T) {
+	id, err := ParseEventID("k3x-42")
+	require.NoError(t, err)
+	assert.Equal(t, EventID{Epoch: "k3x", Seq: 42}, id)
+	assert.Equal(t, "k3x-42", id.String())
+
+	// идентификатор прежнего формата - без эпохи
+	id, err = ParseEventID("7")
+	require.NoError(t, err)
+	assert.Equal(t, EventID{Seq: 7}, id)
+
+	for _, s := range []string{"abc", "-1", "k3x-", "k3x-x"} {
+		_, err = ParseEventID(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestHubSlowSubscriber(t *testing.T) {
+	h := NewHub(10)
+	sub := h.Subscribe(tenant.Default, EventID{})
+	for i := 0; i <= subscriberBuffer; i++ {
+		h.Publish(tenant.Default, gauge("a", float64(i)))
+	}
+
+	var received int
+	for range sub.Events {
+		received++
+	}
+	require.Equal(t, subscriberBuffer, received)
+	assert.False(t, h.HasSubscribers())
+	sub.Close()
+}
@@ -0,0 +1,110 @@
+package stream
+
+import (
+	"context"
+	"errors"
+
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/models"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/tenant"
+)
+
+// Store хранилище, публикующее принятые обновления метрик в Hub с тенантом из контекста запроса.
+// Остальные методы передаются исходному хранилищу.
+type Store struct {
+	repositories.StoreRepository
+	hub *Hub
+}
+
+// NewStore оборачивает хранилище публикацией обновлений.
+func NewStore(store repositories.StoreRepository, hub *Hub) *Store {
+	return &Store{StoreRepository: store, hub: hub}
+}
+
+// UpdateCounter обновляет счетчик и публикует его новое значение.
+func (s *Store) UpdateCounter(ctx context.Context, name string, value int64) (int64, error) {
+	res, err := s.StoreRepository.UpdateCounter(ctx, name, value)
+	if err == nil {
+		s.hub.Publish(tenant.FromContext(ctx), models.Metrics{ID: name, MType: "counter", Delta: &res})
+	}
+	return res, err
+}
+
+// UpdateGauge обновляет метрику gauge и публикует ее новое значение.
+func (s *Store) UpdateGauge(ctx context.Context, name string, value float64) (float64, error) {
+	res, err := s.StoreRepository.UpdateGauge(ctx, name, value)
+	if err == nil {
+		s.hub.Publish(tenant.FromContext(ctx), models.Metrics{ID: name, MType: "gauge", Value: &res})
+	}
+	return res, err
+}
+
+// UpdateHistogram обновляет гистограмму и публикует объединенное распределение.
+func (s *Store) UpdateHistogram(ctx context.Context, name string, value models.Histogram) (models.Histogram, error) {
+	res, err := s.StoreRepository.UpdateHistogram(ctx, name, value)
+	if err == nil {
+		s.hub.Publish(tenant.FromContext(ctx), models.Metrics{ID: name, MType: "histogram", Histogram: &res})
+	}
+	return res, err
+}
+
+// UpdateBatchMetrics применяет батч и публикует значения примененных метрик.
+// Повтор уже примененного батча не публикуется. Обновления публикуются и без подписчиков:
+// они попадают в буфер Hub, и клиент, переподключившийся с Last-Event-ID, их получит.
+func (s *Store) UpdateBatchMetrics(ctx context.Context, batch models.Batch) ([]error, error) {
+	errs, err := s.StoreRepository.UpdateBatchMetrics(ctx, batch)
+	if err != nil {
+		return errs, err
+	}
+
+	// метрика может встречаться в батче несколько раз, публикуем итоговое значение один раз
+	seen := make(map[[2]string]bool, len(batch.Metrics))
+	var updated []models.Metrics
+	for i, m := range batch.Metrics {
+		key := [2]string{m.MType, m.ID}
+		if (i < len(errs) && errs[i] != nil) || seen[key] {
+			continue
+		}
+		seen[key] = true
+		metric, gerr := s.get(ctx, m.MType, m.ID)
+		if gerr != nil {
+			continue
+		}
+		updated = append(updated, metric)
+	}
+	s.hub.Publish(tenant.FromContext(ctx), updated...)
+
+	return errs, nil
+}
+
+// ResetCounter обнуляет счетчик и публикует нулевое значение.
+func (s *Store) ResetCounter(ctx context.Context, name string) error {
+	err := s.StoreRepository.ResetCounter(ctx, name)
+	if err == nil {
+		var zero int64
+		s.hub.Publish(tenant.FromContext(ctx), models.Metrics{ID: name, MType: "counter", Delta: &zero})
+	}
+	return err
+}
+
+// get читает текущее значение метрики геттерами хранилища: они читают под блокировкой хранилища
+// и возвращают копию, поэтому параллельные обновления не мешают. Значение может уже включать
+// изменения батча, примененного следом.
+func (s *Store) get(ctx context.Context, mType, name string) (models.Metrics, error) {
+	metric := models.Metrics{ID: name, MType: mType}
+	switch mType {
+	case "counter":
+		delta, err := s.StoreRepository.GetCounter(ctx, name)
+		metric.Delta = &delta
+		return metric, err
+	case "gauge":
+		value, err := s.StoreRepository.GetGauge(ctx, name)
+		metric.Value = &value
+		return metric, err
+	case "histogram":
+		histogram, err := s.StoreRepository.GetHistogram(ctx, name)
+		metric.Histogram = &histogram
+		return metric, err
+	}
+	return metric, errors.New("unknown metric type")
+}
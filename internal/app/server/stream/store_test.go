@@ -0,0 +1,103 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/models"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories/store"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/tenant"
+)
+
+func TestStorePublish(t *testing.T) {
+	ctx := context.Background()
+	h := NewHub(100)
+	s := NewStore(store.NewMemStorage(), h)
+	sub := h.Subscribe(tenant.Default, EventID{})
+	defer sub.Close()
+
+	_, err := s.UpdateCounter(ctx, "PollCount", 2)
+	require.NoError(t, err)
+	_, err = s.UpdateCounter(ctx, "PollCount", 3)
+	require.NoError(t, err)
+	// публикуется значение после обновления
+	assert.Equal(t, int64(2), *(<-sub.Events).Metric.Delta)
+	assert.Equal(t, int64(5), *(<-sub.Events).Metric.Delta)
+
+	delta, value := int64(1), 1.5
+	batch := models.Batch{ID: "batch-1", Metrics: []models.Metrics{
+		{ID: "PollCount", MType: "counter", Delta: &delta},
+		{ID: "Alloc", MType: "gauge", Value: &value},
+		{ID: "PollCount", MType: "counter", Delta: &delta},
+	}}
+	_, err = s.UpdateBatchMetrics(ctx, batch)
+	require.NoError(t, err)
+	event := <-sub.Events
+	assert.Equal(t, "PollCount", event.Metric.ID)
+	assert.Equal(t, int64(7), *event.Metric.Delta)
+	event = <-sub.Events
+	assert.Equal(t, "Alloc", event.Metric.ID)
+
+	// повтор батча не публикуется
+	_, err = s.UpdateBatchMetrics(ctx, batch)
+	assert.True(t, errors.Is(err, repositories.ErrDuplicateBatch))
+
+	require.NoError(t, s.ResetCounter(ctx, "PollCount"))
+	event = <-sub.Events
+	assert.Equal(t, int64(0), *event.Metric.Delta)
+	assert.Equal(t, uint64(5), event.ID.Seq)
+	assert.Empty(t, sub.Events)
+
+	// обновления батча без подписчиков попадают в буфер и отдаются при возобновлении потока
+	sub.Close()
+	_, err = s.UpdateBatchMetrics(ctx, models.Batch{ID: "batch-2", Metrics: []models.Metrics{
+		{ID: "Alloc", MType: "gauge", Value: &value},
+	}})
+	require.NoError(t, err)
+	resumed := h.Subscribe(tenant.Default, event.ID)
+	defer resumed.Close()
+	require.Len(t, resumed.Backlog, 1)
+	assert.Equal(t, "Alloc", resumed.Backlog[0].Metric.ID)
+	assert.False(t, resumed.Gap)
+}
+
+// TestStoreConcurrentBatches публикует значения параллельных батчей. Гонку ловит go test -race.
+func TestStoreConcurrentBatches(t *testing.T) {
+	ctx := context.Background()
+	h := NewHub(1000)
+	s := NewStore(store.NewMemStorage(), h)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				delta, value := int64(1), float64(i)
+				_, err := s.UpdateBatchMetrics(ctx, models.Batch{Metrics: []models.Metrics{
+					{ID: "PollCount", MType: "counter", Delta: &delta},
+					{ID: "Alloc", MType: "gauge", Value: &value},
+				}})
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	sub := h.Subscribe(tenant.Default, EventID{Epoch: h.epoch, Seq: 1})
+	defer sub.Close()
+	require.Len(t, sub.Backlog, 799)
+	// события параллельных батчей могут публиковаться не по порядку, но итог опубликован
+	var total int64
+	for _, event := range sub.Backlog {
+		if event.Metric.ID == "PollCount" && *event.Metric.Delta > total {
+			total = *event.Metric.Delta
+		}
+	}
+	assert.Equal(t, int64(400), total)
+}
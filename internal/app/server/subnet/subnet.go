@@ -0,0 +1,143 @@
+// Package subnet решает, допускать ли клиента по его адресу, и определяет адрес клиента
+// за доверенными прокси.
+package subnet
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// Policy списки разрешенных и запрещенных подсетей IPv4 и IPv6 и доверенных прокси.
+type Policy struct {
+	allow   []netip.Prefix // пустой - разрешены все адреса, кроме запрещенных
+	deny    []netip.Prefix
+	proxies []netip.Prefix
+}
+
+// New разбирает подсети. Адрес без маски считается подсетью из одного адреса.
+func New(allow, deny, proxies []string) (*Policy, error) {
+	var (
+		p   Policy
+		err error
+	)
+	if p.allow, err = parse(allow); err != nil {
+		return nil, err
+	}
+	if p.deny, err = parse(deny); err != nil {
+		return nil, err
+	}
+	if p.proxies, err = parse(proxies); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Split разбирает список подсетей через запятую.
+func Split(s string) []string {
+	var res []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			res = append(res, item)
+		}
+	}
+	return res
+}
+
+func parse(items []string) ([]netip.Prefix, error) {
+	res := make([]netip.Prefix, 0, len(items))
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if !strings.Contains(item, "/") {
+			addr, err := netip.ParseAddr(item)
+			if err != nil {
+				return nil, fmt.Errorf("invalid subnet %q: %w", item, err)
+			}
+			res = append(res, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid subnet %q: %w", item, err)
+		}
+		res = append(res, prefix.Masked())
+	}
+	return res, nil
+}
+
+func contains(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed сообщает, допускается ли клиент: адрес не входит в запрещенные подсети
+// и входит в разрешенные, если они заданы. Неизвестный адрес допускается только без разрешенных подсетей
+// и без запрещенных.
+func (p *Policy) Allowed(addr netip.Addr) bool {
+	if p == nil {
+		return true
+	}
+	if !addr.IsValid() {
+		return len(p.allow) == 0 && len(p.deny) == 0
+	}
+	addr = addr.Unmap()
+	if contains(p.deny, addr) {
+		return false
+	}
+	return len(p.allow) == 0 || contains(p.allow, addr)
+}
+
+// ClientIP возвращает адрес клиента. remoteAddr - адрес соединения; forwardedFor и realIP - значения
+// X-Forwarded-For и X-Real-IP, они учитываются только для соединения от доверенного прокси.
+// В X-Forwarded-For клиентом считается первый справа адрес, не принадлежащий доверенным прокси.
+func (p *Policy) ClientIP(remoteAddr string, forwardedFor []string, realIP string) netip.Addr {
+	remote := parseAddr(remoteAddr)
+	if p == nil || !remote.IsValid() || !contains(p.proxies, remote) {
+		return remote
+	}
+
+	var hops []string
+	for _, header := range forwardedFor {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	client := remote
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr := parseAddr(strings.TrimSpace(hops[i]))
+		if !addr.IsValid() {
+			// подделанный или испорченный адрес: дальше цепочке верить нельзя
+			return client
+		}
+		client = addr
+		if !contains(p.proxies, addr) {
+			return client
+		}
+	}
+	if len(hops) == 0 && realIP != "" {
+		if addr := parseAddr(realIP); addr.IsValid() {
+			return addr
+		}
+	}
+	return client
+}
+
+// parseAddr разбирает адрес с портом или без, в том числе IPv6 в квадратных скобках.
+func parseAddr(s string) netip.Addr {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	// зона IPv6 (fe80::1%eth0) к подсетям не относится
+	if i := strings.IndexByte(s, '%'); i >= 0 {
+		s = s[:i]
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap()
+}
@@ -0,0 +1,56 @@
+package subnet
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientIP(t *testing.T) {
+	p, err := New(nil, nil, []string{"10.0.0.0/8", "fd00::/8"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		realIP       string
+		want         string
+	}{
+		{"connection address", "192.168.0.1:1234", nil, "", "192.168.0.1"},
+		{"headers from untrusted client", "192.168.0.1:1234", []string{"1.1.1.1"}, "2.2.2.2", "192.168.0.1"},
+		{"X-Real-IP from proxy", "10.0.0.1:1234", nil, "2.2.2.2", "2.2.2.2"},
+		{"X-Forwarded-For is preferred", "10.0.0.1:1234", []string{"1.1.1.1"}, "2.2.2.2", "1.1.1.1"},
+		{"first untrusted hop from the right", "10.0.0.1:1234", []string{"6.6.6.6, 1.1.1.1", "10.0.0.2"}, "", "1.1.1.1"},
+		{"all hops are proxies", "10.0.0.1:1234", []string{"10.0.0.3, 10.0.0.2"}, "", "10.0.0.3"},
+		{"broken hop", "10.0.0.1:1234", []string{"1.1.1.1, unknown"}, "", "10.0.0.1"},
+		{"ipv6 proxy", "[fd00::1]:1234", []string{"2001:db8::1"}, "", "2001:db8::1"},
+		{"ipv4-mapped ipv6", "[::ffff:192.168.0.1]:1234", nil, "", "192.168.0.1"},
+		{"without port", "192.168.0.1", nil, "", "192.168.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, netip.MustParseAddr(tt.want), p.ClientIP(tt.remoteAddr, tt.forwardedFor, tt.realIP))
+		})
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	p, err := New(Split("10.0.0.0/8, 2001:db8::/32"), []string{"10.0.1.1"}, nil)
+	require.NoError(t, err)
+
+	assert.True(t, p.Allowed(netip.MustParseAddr("10.0.0.1")))
+	assert.True(t, p.Allowed(netip.MustParseAddr("::ffff:10.0.0.1")))
+	assert.True(t, p.Allowed(netip.MustParseAddr("2001:db8::1")))
+	assert.False(t, p.Allowed(netip.MustParseAddr("10.0.1.1")))
+	assert.False(t, p.Allowed(netip.MustParseAddr("192.168.0.1")))
+	assert.False(t, p.Allowed(netip.Addr{}))
+
+	var empty *Policy
+	assert.True(t, empty.Allowed(netip.MustParseAddr("192.168.0.1")))
+
+	_, err = New([]string{"10.0.0.0/33"}, nil, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,440 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/config"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/models"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/ratelimit"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories"
+)
+
+// Factory создает хранилище тенанта при первом обращении к нему.
+type Factory func(ctx context.Context, name string) (repositories.StoreRepository, error)
+
+// key метрика тенанта: тип и имя.
+type key struct {
+	mType string
+	id    string
+}
+
+// space хранилище тенанта и его метрики для проверки ограничения MaxSeries.
+type space struct {
+	repositories.StoreRepository
+	mu     sync.Mutex
+	series map[key]struct{} // nil - еще не загружены из хранилища
+}
+
+// Store направляет вызовы хранилищу тенанта из контекста запроса.
+// Хранилище тенанта Default передается при создании, остальные создает Factory, но не больше MaxTenants.
+type Store struct {
+	factory Factory
+	config  config.TenantsConfig
+
+	mu       sync.Mutex
+	spaces   map[string]*space
+	known    map[string]bool // тенанты, которых можно выбрать заголовком: разрешенные и созданные заранее
+	limiters map[string]*ratelimit.Limiter
+}
+
+// NewStore конструктор типа Store.
+func NewStore(def repositories.StoreRepository, factory Factory, cfg config.TenantsConfig) *Store {
+	s := &Store{
+		factory:  factory,
+		config:   cfg,
+		spaces:   map[string]*space{Default: {StoreRepository: def}},
+		known:    map[string]bool{Default: true},
+		limiters: make(map[string]*ratelimit.Limiter),
+	}
+	s.Provision(cfg.Allowed...)
+
+	return s
+}
+
+// Provision разрешает выбирать тенантов заголовком, например тенантов, чьи хранилища уже есть
+// в PostgreSQL. Хранилище создается при первом обращении.
+func (s *Store) Provision(names ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, name := range names {
+		s.known[name] = true
+	}
+}
+
+// Resolve выбирает тенанта запроса как tenant.Resolve. Тенант из заголовка без токена тенанта
+// должен быть разрешен или создан заранее: клиент без токена тенанта не может создавать тенантов.
+func (s *Store) Resolve(requested, token string) (string, error) {
+	name, err := Resolve(requested, token)
+	if err != nil || token != "" {
+		return name, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.known[name] {
+		return "", fmt.Errorf("%w=%s", ErrUnknownTenant, name)
+	}
+
+	return name, nil
+}
+
+// Add добавляет готовое хранилище тенанта, например восстановленное из файла.
+func (s *Store) Add(name string, store repositories.StoreRepository) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.spaces[name] = &space{StoreRepository: store}
+	s.known[name] = true
+}
+
+// Tenants возвращает тенантов, к которым обращались с момента запуска, по имени.
+func (s *Store) Tenants() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := make([]string, 0, len(s.spaces))
+	for name := range s.spaces {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+
+	return res
+}
+
+// Limits возвращает ограничения тенанта.
+func (s *Store) Limits(name string) config.TenantLimits {
+	if limits, ok := s.config.Overrides[name]; ok {
+		return limits
+	}
+	return s.config.Limits
+}
+
+// Allow сообщает, укладывается ли запрос тенанта в ограничение частоты запросов.
+// Если нет, возвращает время до следующего разрешенного запроса.
+func (s *Store) Allow(name string, now time.Time) (bool, time.Duration) {
+	s.mu.Lock()
+	limiter, ok := s.limiters[name]
+	if !ok {
+		limits := s.Limits(name)
+		limiter = ratelimit.New(limits.Rate, limits.Burst)
+		s.limiters[name] = limiter
+	}
+	s.mu.Unlock()
+
+	return limiter.Allow(name, now)
+}
+
+// space возвращает хранилище тенанта из контекста, создавая его при первом обращении.
+func (s *Store) space(ctx context.Context) (*space, error) {
+	name := FromContext(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sp, ok := s.spaces[name]; ok {
+		return sp, nil
+	}
+	if err := Validate(name); err != nil {
+		return nil, err
+	}
+	// у каждого тенанта свое хранилище, в PostgreSQL - своя схема и свой пул соединений
+	if s.config.MaxTenants > 0 && len(s.spaces)-1 >= s.config.MaxTenants {
+		return nil, ErrTooManyTenants
+	}
+	store, err := s.factory(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	sp := &space{StoreRepository: store}
+	s.spaces[name] = sp
+	s.known[name] = true
+
+	return sp, nil
+}
+
+// reserve учитывает новые метрики тенанта, если они укладываются в ограничение MaxSeries,
+// и возвращает добавленные.
+func (s *Store) reserve(ctx context.Context, sp *space, keys []key) ([]key, error) {
+	limit := s.Limits(FromContext(ctx)).MaxSeries
+	if limit <= 0 {
+		return nil, nil
+	}
+
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	if sp.series == nil {
+		metrics, err := sp.ListMetrics(ctx, models.ListOptions{SortBy: models.SortByName})
+		if err != nil {
+			return nil, err
+		}
+		sp.series = make(map[key]struct{}, len(metrics))
+		for _, m := range metrics {
+			sp.series[key{mType: m.MType, id: m.ID}] = struct{}{}
+		}
+	}
+
+	var added []key
+	for _, k := range keys {
+		if _, ok := sp.series[k]; ok || contains(added, k) {
+			continue
+		}
+		added = append(added, k)
+	}
+	if len(sp.series)+len(added) > limit {
+		return nil, ErrSeriesLimit
+	}
+	for _, k := range added {
+		sp.series[k] = struct{}{}
+	}
+
+	return added, nil
+}
+
+// release забывает учтенные метрики, которые не удалось записать.
+func (sp *space) release(keys ...key) {
+	if len(keys) == 0 {
+		return
+	}
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	for _, k := range keys {
+		delete(sp.series, k)
+	}
+}
+
+// Initialize хранилища тенантов инициализируются при создании.
+func (s *Store) Initialize(_ context.Context, _ config.AppConfig) error {
+	return nil
+}
+
+// UpdateCounter обновляет счетчик в хранилище тенанта.
+func (s *Store) UpdateCounter(ctx context.Context, name string, value int64) (int64, error) {
+	sp, added, err := s.prepare(ctx, key{mType: "counter", id: name})
+	if err != nil {
+		return 0, err
+	}
+	res, err := sp.UpdateCounter(ctx, name, value)
+	if err != nil {
+		sp.release(added...)
+	}
+	return res, err
+}
+
+// UpdateGauge обновляет метрику gauge в хранилище тенанта.
+func (s *Store) UpdateGauge(ctx context.Context, name string, value float64) (float64, error) {
+	sp, added, err := s.prepare(ctx, key{mType: "gauge", id: name})
+	if err != nil {
+		return 0, err
+	}
+	res, err := sp.UpdateGauge(ctx, name, value)
+	if err != nil {
+		sp.release(added...)
+	}
+	return res, err
+}
+
+// UpdateHistogram обновляет гистограмму в хранилище тенанта.
+func (s *Store) UpdateHistogram(ctx context.Context, name string, value models.Histogram) (models.Histogram, error) {
+	sp, added, err := s.prepare(ctx, key{mType: "histogram", id: name})
+	if err != nil {
+		return models.Histogram{}, err
+	}
+	res, err := sp.UpdateHistogram(ctx, name, value)
+	if err != nil {
+		sp.release(added...)
+	}
+	return res, err
+}
+
+// UpdateBatchMetrics применяет батч в хранилище тенанта. Батч, в котором новых метрик больше,
+// чем позволяет ограничение MaxSeries, отклоняется целиком.
+func (s *Store) UpdateBatchMetrics(ctx context.Context, batch models.Batch) ([]error, error) {
+	keys := make([]key, 0, len(batch.Metrics))
+	for _, m := range batch.Metrics {
+		keys = append(keys, key{mType: m.MType, id: m.ID})
+	}
+	sp, added, err := s.prepare(ctx, keys...)
+	if err != nil {
+		return nil, err
+	}
+
+	errs, err := sp.UpdateBatchMetrics(ctx, batch)
+	if err != nil {
+		sp.release(added...)
+		return errs, err
+	}
+	// метрики, все вхождения которых отклонены, не появились в хранилище
+	applied := make(map[key]bool, len(keys))
+	for i, k := range keys {
+		if i >= len(errs) || errs[i] == nil {
+			applied[k] = true
+		}
+	}
+	var rejected []key
+	for _, k := range added {
+		if !applied[k] {
+			rejected = append(rejected, k)
+		}
+	}
+	sp.release(rejected...)
+
+	return errs, nil
+}
+
+// prepare возвращает хранилище тенанта и учитывает новые метрики.
+func (s *Store) prepare(ctx context.Context, keys ...key) (*space, []key, error) {
+	sp, err := s.space(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	added, err := s.reserve(ctx, sp, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sp, added, nil
+}
+
+// GetCounter возвращает счетчик из хранилища тенанта.
+func (s *Store) GetCounter(ctx context.Context, metric string) (int64, error) {
+	sp, err := s.space(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return sp.GetCounter(ctx, metric)
+}
+
+// GetGauge возвращает метрику gauge из хранилища тенанта.
+func (s *Store) GetGauge(ctx context.Context, metric string) (float64, error) {
+	sp, err := s.space(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return sp.GetGauge(ctx, metric)
+}
+
+// GetHistogram возвращает гистограмму из хранилища тенанта.
+func (s *Store) GetHistogram(ctx context.Context, metric string) (models.Histogram, error) {
+	sp, err := s.space(ctx)
+	if err != nil {
+		return models.Histogram{}, err
+	}
+	return sp.GetHistogram(ctx, metric)
+}
+
+// GetAllMetrics возвращает метрики всех тенантов для сохранения в файл:
+// метрики тенанта Default - как без изоляции тенантов, остальных - в ключе tenants.
+func (s *Store) GetAllMetrics(ctx context.Context) (map[string]interface{}, error) {
+	s.mu.Lock()
+	spaces := make(map[string]*space, len(s.spaces))
+	for name, sp := range s.spaces {
+		spaces[name] = sp
+	}
+	s.mu.Unlock()
+
+	all, err := spaces[Default].GetAllMetrics(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tenants := make(map[string]interface{}, len(spaces)-1)
+	for name, sp := range spaces {
+		if name == Default {
+			continue
+		}
+		if tenants[name], err = sp.GetAllMetrics(WithTenant(ctx, name)); err != nil {
+			return nil, err
+		}
+	}
+	if len(tenants) > 0 {
+		all["tenants"] = tenants
+	}
+
+	return all, nil
+}
+
+// ListMetrics возвращает метрики тенанта.
+func (s *Store) ListMetrics(ctx context.Context, opts models.ListOptions) ([]models.Metrics, error) {
+	sp, err := s.space(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return sp.ListMetrics(ctx, opts)
+}
+
+// DeleteMetric удаляет метрику тенанта.
+func (s *Store) DeleteMetric(ctx context.Context, mType, name string) error {
+	sp, err := s.space(ctx)
+	if err != nil {
+		return err
+	}
+	if err = sp.DeleteMetric(ctx, mType, name); err != nil {
+		return err
+	}
+	sp.release(key{mType: mType, id: name})
+
+	return nil
+}
+
+// ResetCounter обнуляет счетчик тенанта.
+func (s *Store) ResetCounter(ctx context.Context, name string) error {
+	sp, err := s.space(ctx)
+	if err != nil {
+		return err
+	}
+	return sp.ResetCounter(ctx, name)
+}
+
+// DeleteMetrics удаляет метрики тенанта по шаблону.
+func (s *Store) DeleteMetrics(ctx context.Context, filter models.MetricFilter) (int64, error) {
+	sp, err := s.space(ctx)
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := sp.DeleteMetrics(ctx, filter)
+	if deleted > 0 || err != nil {
+		// учтенные метрики перечитываются из хранилища при следующей записи
+		sp.mu.Lock()
+		sp.series = nil
+		sp.mu.Unlock()
+	}
+
+	return deleted, err
+}
+
+// Close закрывает хранилища тенантов, созданные Factory, например соединения со схемами PostgreSQL.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var errs []error
+	for name, sp := range s.spaces {
+		if closer, ok := sp.StoreRepository.(io.Closer); ok && name != Default {
+			errs = append(errs, closer.Close())
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func contains(keys []key, k key) bool {
+	for _, v := range keys {
+		if v == k {
+			return true
+		}
+	}
+	return false
+}
+
+// IsLimit сообщает, вызвана ли ошибка ограничением тенанта или количества тенантов.
+func IsLimit(err error) bool {
+	return errors.Is(err, ErrSeriesLimit) || errors.Is(err, ErrTooManyTenants)
+}
@@ -0,0 +1,206 @@
+package tenant
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/config"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/models"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories"
+	"github.com/webkimru/go-yandex-metrics/internal/app/server/repositories/store"
+)
+
+func newStore(cfg config.TenantsConfig) *Store {
+	return NewStore(store.NewMemStorage(), func(context.Context, string) (repositories.StoreRepository, error) {
+		return store.NewMemStorage(), nil
+	}, cfg)
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name      string
+		requested string
+		token     string
+		want      string
+		wantErr   error
+	}{
+		{"without tenant", "", "", Default, nil},
+		{"header", "team_a", "", "team_a", nil},
+		{"token", "", "team_b", "team_b", nil},
+		{"token and same header", "team_b", "team_b", "team_b", nil},
+		{"token and another header", "team_a", "team_b", "", ErrForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Resolve(tt.requested, tt.token)
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err := Resolve("Team A", "")
+	assert.Error(t, err)
+	_, err = Resolve("../metrics", "")
+	assert.Error(t, err)
+}
+
+func TestStoreResolve(t *testing.T) {
+	s := newStore(config.TenantsConfig{Enabled: true, Allowed: []string{"team_a"}})
+	s.Provision("team_b")
+
+	for requested, want := range map[string]string{"": Default, Default: Default, "team_a": "team_a", "team_b": "team_b"} {
+		got, err := s.Resolve(requested, "")
+		require.NoError(t, err, requested)
+		assert.Equal(t, want, got)
+	}
+
+	// тенант без токена тенанта не создается по заголовку
+	_, err := s.Resolve("team_c", "")
+	assert.ErrorIs(t, err, ErrUnknownTenant)
+	// тенант токена выдан администратором
+	got, err := s.Resolve("", "team_c")
+	require.NoError(t, err)
+	assert.Equal(t, "team_c", got)
+	_, err = s.UpdateCounter(WithTenant(context.Background(), "team_c"), "PollCount", 1)
+	require.NoError(t, err)
+	// созданный тенант можно выбрать заголовком
+	got, err = s.Resolve("team_c", "")
+	require.NoError(t, err)
+	assert.Equal(t, "team_c", got)
+}
+
+func TestStoreMaxTenants(t *testing.T) {
+	s := newStore(config.TenantsConfig{Enabled: true, MaxTenants: 2})
+	ctx := context.Background()
+
+	for _, name := range []string{"team_a", "team_b"} {
+		_, err := s.UpdateCounter(WithTenant(ctx, name), "PollCount", 1)
+		require.NoError(t, err)
+	}
+	_, err := s.UpdateCounter(WithTenant(ctx, "team_c"), "PollCount", 1)
+	assert.ErrorIs(t, err, ErrTooManyTenants)
+	assert.True(t, IsLimit(err))
+
+	// существующие тенанты и тенант по умолчанию работают
+	_, err = s.UpdateCounter(WithTenant(ctx, "team_a"), "PollCount", 1)
+	require.NoError(t, err)
+	_, err = s.UpdateCounter(ctx, "PollCount", 1)
+	require.NoError(t, err)
+}
+
+func TestStoreIsolation(t *testing.T) {
+	s := newStore(config.TenantsConfig{Enabled: true})
+	ctx := context.Background()
+	teamA := WithTenant(ctx, "team_a")
+
+	_, err := s.UpdateCounter(ctx, "PollCount", 1)
+	require.NoError(t, err)
+	_, err = s.UpdateCounter(teamA, "PollCount", 5)
+	require.NoError(t, err)
+	_, err = s.UpdateGauge(teamA, "Alloc", 1.5)
+	require.NoError(t, err)
+
+	delta, err := s.GetCounter(ctx, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), delta)
+	delta, err = s.GetCounter(teamA, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), delta)
+	_, err = s.GetGauge(ctx, "Alloc")
+	assert.Error(t, err)
+
+	list, err := s.ListMetrics(teamA, models.ListOptions{SortBy: models.SortByName})
+	require.NoError(t, err)
+	assert.Len(t, list, 2)
+	assert.Equal(t, []string{Default, "team_a"}, s.Tenants())
+
+	// удаление метрик тенанта не затрагивает остальных
+	deleted, err := s.DeleteMetrics(teamA, models.MetricFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+	_, err = s.GetCounter(ctx, "PollCount")
+	assert.NoError(t, err)
+
+	// метрики остальных тенантов сохраняются в файл отдельно
+	_, err = s.UpdateGauge(teamA, "Alloc", 2)
+	require.NoError(t, err)
+	all, err := s.GetAllMetrics(ctx)
+	require.NoError(t, err)
+	require.Contains(t, all, "tenants")
+	assert.Contains(t, all["tenants"], "team_a")
+}
+
+func TestStoreSeriesLimit(t *testing.T) {
+	s := newStore(config.TenantsConfig{
+		Enabled:   true,
+		Limits:    config.TenantLimits{MaxSeries: 2},
+		Overrides: map[string]config.TenantLimits{"big": {MaxSeries: 10}},
+	})
+	ctx := WithTenant(context.Background(), "small")
+
+	_, err := s.UpdateGauge(ctx, "a", 1)
+	require.NoError(t, err)
+	_, err = s.UpdateGauge(ctx, "b", 1)
+	require.NoError(t, err)
+	// обновление существующей метрики не упирается в ограничение
+	_, err = s.UpdateGauge(ctx, "a", 2)
+	require.NoError(t, err)
+	_, err = s.UpdateGauge(ctx, "c", 1)
+	assert.ErrorIs(t, err, ErrSeriesLimit)
+	assert.True(t, IsLimit(err))
+
+	// батч с новыми метриками сверх ограничения отклоняется целиком
+	value := 1.0
+	_, err = s.UpdateBatchMetrics(ctx, models.Batch{Metrics: []models.Metrics{
+		{ID: "a", MType: "gauge", Value: &value},
+		{ID: "c", MType: "gauge", Value: &value},
+	}})
+	assert.ErrorIs(t, err, ErrSeriesLimit)
+	_, err = s.GetGauge(ctx, "a")
+	require.NoError(t, err)
+
+	// после удаления метрики место освобождается
+	require.NoError(t, s.DeleteMetric(ctx, "gauge", "b"))
+	_, err = s.UpdateGauge(ctx, "c", 1)
+	require.NoError(t, err)
+
+	big := WithTenant(context.Background(), "big")
+	for _, id := range []string{"a", "b", "c"} {
+		_, err = s.UpdateGauge(big, id, 1)
+		require.NoError(t, err)
+	}
+}
+
+func TestStoreAllow(t *testing.T) {
+	s := newStore(config.TenantsConfig{
+		Enabled:   true,
+		Limits:    config.TenantLimits{Rate: 2, Burst: 3},
+		Overrides: map[string]config.TenantLimits{"free": {}},
+	})
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		ok, _ := s.Allow("team_a", now)
+		assert.True(t, ok, i)
+	}
+	ok, wait := s.Allow("team_a", now)
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+	// другой тенант расходует свою корзину
+	ok, _ = s.Allow("team_b", now)
+	assert.True(t, ok)
+	// за полсекунды при rate=2 восстанавливается один запрос
+	ok, _ = s.Allow("team_a", now.Add(500*time.Millisecond))
+	assert.True(t, ok)
+	ok, _ = s.Allow("team_a", now.Add(500*time.Millisecond))
+	assert.False(t, ok)
+
+	// без ограничения rate запросы не ограничиваются
+	for i := 0; i < 100; i++ {
+		ok, _ = s.Allow("free", now)
+		assert.True(t, ok)
+	}
+}
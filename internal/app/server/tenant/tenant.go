@@ -0,0 +1,70 @@
+// Package tenant разделяет метрики нескольких команд на одном сервере:
+// у каждого тенанта свое хранилище и свои ограничения на количество метрик и частоту запросов.
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+const (
+	// Default тенант запросов без тенанта: его метрики хранятся там же, где и без изоляции тенантов.
+	Default = "default"
+	// HeaderTenantID заголовок с именем тенанта.
+	HeaderTenantID = "X-Tenant-ID"
+	// MetadataTenantID ключ metadata gRPC с именем тенанта.
+	MetadataTenantID = "x-tenant-id"
+)
+
+var (
+	// ErrSeriesLimit тенант хранит максимально допустимое количество метрик.
+	ErrSeriesLimit = errors.New("tenant series limit exceeded")
+	// ErrForbidden токен выдан для другого тенанта.
+	ErrForbidden = errors.New("token belongs to another tenant")
+	// ErrUnknownTenant тенант из заголовка не разрешен и не создан заранее.
+	ErrUnknownTenant = errors.New("unknown tenant")
+	// ErrTooManyTenants создано максимально допустимое количество тенантов.
+	ErrTooManyTenants = errors.New("tenants limit exceeded")
+)
+
+// namePattern допустимое имя тенанта: оно входит в имя схемы PostgreSQL.
+var namePattern = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+
+// Validate проверяет имя тенанта.
+func Validate(name string) error {
+	if !namePattern.MatchString(name) {
+		return fmt.Errorf("invalid tenant=%q: up to 32 characters [a-z0-9_]", name)
+	}
+	return nil
+}
+
+// Resolve выбирает тенанта запроса: тенант токена, иначе запрошенный, иначе Default.
+// Токен, выданный для тенанта, не может обращаться к другому тенанту.
+func Resolve(requested, token string) (string, error) {
+	switch {
+	case token != "" && requested != "" && requested != token:
+		return "", ErrForbidden
+	case token != "":
+		return token, nil
+	case requested != "":
+		return requested, Validate(requested)
+	}
+	return Default, nil
+}
+
+type tenantKey struct{}
+
+// WithTenant сохраняет тенанта в контексте запроса.
+func WithTenant(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, name)
+}
+
+// FromContext возвращает тенанта запроса, Default - для запросов без тенанта и фоновых задач.
+func FromContext(ctx context.Context) string {
+	if name, ok := ctx.Value(tenantKey{}).(string); ok && name != "" {
+		return name
+	}
+	return Default
+}
@@ -22,7 +22,12 @@ const _ = grpc.SupportPackageIsVersion7
 //
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type MetricsClient interface {
+	// с включенной аутентификацией требует metadata authorization: Bearer <token> с областью действия write
 	UpdateBatchMetrics(ctx context.Context, in *RequestMetricBatch, opts ...grpc.CallOption) (*ResponseMetric, error)
+	// административные методы, требуют metadata authorization: Bearer <admin key> или токен admin
+	DeleteMetric(ctx context.Context, in *DeleteMetricRequest, opts ...grpc.CallOption) (*ResponseMetric, error)
+	ResetCounter(ctx context.Context, in *ResetCounterRequest, opts ...grpc.CallOption) (*ResponseMetric, error)
+	DeleteMetrics(ctx context.Context, in *DeleteMetricsRequest, opts ...grpc.CallOption) (*DeleteMetricsResponse, error)
 }
 
 type metricsClient struct {
@@ -42,11 +47,43 @@ func (c *metricsClient) UpdateBatchMetrics(ctx context.Context, in *RequestMetri
 	return out, nil
 }
 
+func (c *metricsClient) DeleteMetric(ctx context.Context, in *DeleteMetricRequest, opts ...grpc.CallOption) (*ResponseMetric, error) {
+	out := new(ResponseMetric)
+	err := c.cc.Invoke(ctx, "/metrics.Metrics/DeleteMetric", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricsClient) ResetCounter(ctx context.Context, in *ResetCounterRequest, opts ...grpc.CallOption) (*ResponseMetric, error) {
+	out := new(ResponseMetric)
+	err := c.cc.Invoke(ctx, "/metrics.Metrics/ResetCounter", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricsClient) DeleteMetrics(ctx context.Context, in *DeleteMetricsRequest, opts ...grpc.CallOption) (*DeleteMetricsResponse, error) {
+	out := new(DeleteMetricsResponse)
+	err := c.cc.Invoke(ctx, "/metrics.Metrics/DeleteMetrics", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // MetricsServer is the server API for Metrics service.
 // All implementations must embed UnimplementedMetricsServer
 // for forward compatibility
 type MetricsServer interface {
+	// с включенной аутентификацией требует metadata authorization: Bearer <token> с областью действия write
 	UpdateBatchMetrics(context.Context, *RequestMetricBatch) (*ResponseMetric, error)
+	// административные методы, требуют metadata authorization: Bearer <admin key> или токен admin
+	DeleteMetric(context.Context, *DeleteMetricRequest) (*ResponseMetric, error)
+	ResetCounter(context.Context, *ResetCounterRequest) (*ResponseMetric, error)
+	DeleteMetrics(context.Context, *DeleteMetricsRequest) (*DeleteMetricsResponse, error)
 	mustEmbedUnimplementedMetricsServer()
 }
 
@@ -57,6 +94,15 @@ type UnimplementedMetricsServer struct {
 func (UnimplementedMetricsServer) UpdateBatchMetrics(context.Context, *RequestMetricBatch) (*ResponseMetric, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method UpdateBatchMetrics not implemented")
 }
+func (UnimplementedMetricsServer) DeleteMetric(context.Context, *DeleteMetricRequest) (*ResponseMetric, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteMetric not implemented")
+}
+func (UnimplementedMetricsServer) ResetCounter(context.Context, *ResetCounterRequest) (*ResponseMetric, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ResetCounter not implemented")
+}
+func (UnimplementedMetricsServer) DeleteMetrics(context.Context, *DeleteMetricsRequest) (*DeleteMetricsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteMetrics not implemented")
+}
 func (UnimplementedMetricsServer) mustEmbedUnimplementedMetricsServer() {}
 
 // UnsafeMetricsServer may be embedded to opt out of forward compatibility for this service.
@@ -88,6 +134,60 @@ func _Metrics_UpdateBatchMetrics_Handler(srv interface{}, ctx context.Context, d
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Metrics_DeleteMetric_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteMetricRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsServer).DeleteMetric(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/metrics.Metrics/DeleteMetric",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsServer).DeleteMetric(ctx, req.(*DeleteMetricRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Metrics_ResetCounter_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ResetCounterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsServer).ResetCounter(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/metrics.Metrics/ResetCounter",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsServer).ResetCounter(ctx, req.(*ResetCounterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Metrics_DeleteMetrics_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteMetricsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsServer).DeleteMetrics(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/metrics.Metrics/DeleteMetrics",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsServer).DeleteMetrics(ctx, req.(*DeleteMetricsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Metrics_ServiceDesc is the grpc.ServiceDesc for Metrics service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -99,6 +199,18 @@ var Metrics_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "UpdateBatchMetrics",
 			Handler:    _Metrics_UpdateBatchMetrics_Handler,
 		},
+		{
+			MethodName: "DeleteMetric",
+			Handler:    _Metrics_DeleteMetric_Handler,
+		},
+		{
+			MethodName: "ResetCounter",
+			Handler:    _Metrics_ResetCounter_Handler,
+		},
+		{
+			MethodName: "DeleteMetrics",
+			Handler:    _Metrics_DeleteMetrics_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "metrics.proto",
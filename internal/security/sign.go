@@ -0,0 +1,107 @@
+package security
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Заголовки подписи запроса и ответа.
+const (
+	// HeaderSignature подпись HMAC-SHA256 в hex.
+	HeaderSignature = "HashSHA256"
+	// HeaderKeyID идентификатор ключа подписи, пустой - ключ KEY.
+	HeaderKeyID = "KeyID"
+	// HeaderTimestamp время подписи запроса в секундах Unix.
+	HeaderTimestamp = "X-Timestamp"
+	// HeaderNonce одноразовое случайное значение запроса.
+	HeaderNonce = "X-Nonce"
+)
+
+// SignRequest подписывает запрос: метод, путь с параметрами, sha256 тела, время и nonce.
+// Подпись не подходит к запросу с другим адресом, телом или временем, а повтор отсекается по nonce.
+func SignRequest(key []byte, method, uri string, body []byte, timestamp, nonce string) string {
+	sum := sha256.Sum256(body)
+	h := hmac.New(sha256.New, key)
+	for _, part := range []string{method, uri, hex.EncodeToString(sum[:]), timestamp, nonce} {
+		h.Write([]byte(part))
+		h.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// SignBody подписывает тело ответа.
+func SignBody(key, body []byte) string {
+	h := hmac.New(sha256.New, key)
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// CheckSignature сравнивает подпись с ожидаемой за постоянное время.
+func CheckSignature(sign, expected string) bool {
+	got, err := hex.DecodeString(sign)
+	if err != nil {
+		return false
+	}
+	want, err := hex.DecodeString(expected)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(got, want)
+}
+
+// Timestamp возвращает время подписи для заголовка X-Timestamp.
+func Timestamp(now time.Time) string {
+	return strconv.FormatInt(now.Unix(), 10)
+}
+
+// NewNonce возвращает случайное значение для заголовка X-Nonce.
+func NewNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// NonceCache помнит nonce подписанных запросов, пока их время подписи не выйдет за допустимое окно.
+type NonceCache struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	nonces    map[string]time.Time // nonce -> когда его можно забыть
+	lastSweep time.Time
+}
+
+// NewNonceCache создает NonceCache. ttl должен быть не меньше окна, в котором принимаются запросы.
+func NewNonceCache(ttl time.Duration) *NonceCache {
+	return &NonceCache{
+		ttl:    ttl,
+		nonces: make(map[string]time.Time),
+	}
+}
+
+// Use запоминает nonce. Возвращает false, если nonce уже использовался.
+func (c *NonceCache) Use(nonce string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.Sub(c.lastSweep) >= time.Second {
+		c.lastSweep = now
+		for n, expires := range c.nonces {
+			if !now.Before(expires) {
+				delete(c.nonces, n)
+			}
+		}
+	}
+	if expires, ok := c.nonces[nonce]; ok && now.Before(expires) {
+		return false
+	}
+	c.nonces[nonce] = now.Add(c.ttl)
+
+	return true
+}
@@ -0,0 +1,20 @@
+package security
+
+import (
+	"crypto/subtle"
+	"strings"
+)
+
+// CheckBearer сравнивает токен из заголовка вида "Bearer <token>" с ожидаемым за постоянное время.
+// Пустой ожидаемый токен не принимает ни одного запроса.
+func CheckBearer(authorization, token string) bool {
+	if token == "" {
+		return false
+	}
+	got, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}